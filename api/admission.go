@@ -0,0 +1,197 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	mb2bytes = 1 << 20
+	// time to wait before retrying if it can not be estimated, e.g. when
+	// there are too many concurrent queries from the caller.
+	defaultRetryAfter = time.Second
+	// interval to evict idle callers, as callers are named by clients.
+	evictIdleCallersInterval = time.Minute
+)
+
+// AdmissionController limits queries from each caller by the number of
+// concurrent queries, queries per second and device memory reserved.
+type AdmissionController struct {
+	sync.Mutex
+	// limits by lower case caller name, as keys of config are case insensitive.
+	limits       map[string]common.CallerLimitConfig
+	defaultLimit common.CallerLimitConfig
+	callers      map[string]*callerAdmission
+	lastEviction time.Time
+}
+
+// callerAdmission stores the queries admitted for a caller.
+type callerAdmission struct {
+	limit          common.CallerLimitConfig
+	runningQueries int
+	deviceMemory   int
+	// token bucket for queries per second, up to one second of queries can
+	// be admitted at once.
+	tokens     float64
+	lastRefill time.Time
+}
+
+// NewAdmissionController creates an AdmissionController with the caller
+// limits in config, nil if there is no limit.
+func NewAdmissionController(cfg common.QueryConfig) *AdmissionController {
+	if len(cfg.CallerLimits) == 0 && cfg.DefaultCallerLimit == (common.CallerLimitConfig{}) {
+		return nil
+	}
+
+	controller := &AdmissionController{
+		limits:       make(map[string]common.CallerLimitConfig, len(cfg.CallerLimits)),
+		defaultLimit: cfg.DefaultCallerLimit,
+		callers:      make(map[string]*callerAdmission),
+	}
+	for caller, limit := range cfg.CallerLimits {
+		controller.limits[strings.ToLower(caller)] = limit
+	}
+	return controller
+}
+
+// getCaller returns the admission of the caller, c must be locked.
+func (c *AdmissionController) getCaller(caller string) *callerAdmission {
+	caller = strings.ToLower(caller)
+	admission := c.callers[caller]
+	if admission == nil {
+		c.evictIdleCallers()
+		limit, ok := c.limits[caller]
+		if !ok {
+			limit = c.defaultLimit
+		}
+		admission = &callerAdmission{
+			limit:      limit,
+			tokens:     math.Max(limit.MaxQueriesPerSecond, 1),
+			lastRefill: utils.Now(),
+		}
+		c.callers[caller] = admission
+	}
+	return admission
+}
+
+// evictIdleCallers removes idle callers at most once per
+// evictIdleCallersInterval, c must be locked.
+func (c *AdmissionController) evictIdleCallers() {
+	now := utils.Now()
+	if now.Sub(c.lastEviction) < evictIdleCallersInterval {
+		return
+	}
+	c.lastEviction = now
+	for caller, admission := range c.callers {
+		if admission.isIdle(now) {
+			delete(c.callers, caller)
+		}
+	}
+}
+
+// isIdle returns whether the caller has no running query, no reserved device
+// memory and full tokens, so that it's the same as a new caller.
+func (admission *callerAdmission) isIdle(now time.Time) bool {
+	if admission.runningQueries > 0 || admission.deviceMemory > 0 {
+		return false
+	}
+	limit := admission.limit
+	if limit.MaxQueriesPerSecond <= 0 {
+		return true
+	}
+	tokens := admission.tokens + now.Sub(admission.lastRefill).Seconds()*limit.MaxQueriesPerSecond
+	return tokens >= math.Max(limit.MaxQueriesPerSecond, 1)
+}
+
+// Admit admits numQueries queries from the caller to run. If the caller is
+// over its limits, it returns false and the time to wait before retrying.
+// Admitted queries must be finished by Finish.
+func (c *AdmissionController) Admit(caller string, numQueries int) (bool, time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	admission := c.getCaller(caller)
+	limit := admission.limit
+
+	// A request with more queries than the limit is admitted if no other
+	// query is running.
+	if limit.MaxConcurrentQueries > 0 && admission.runningQueries > 0 &&
+		admission.runningQueries+numQueries > limit.MaxConcurrentQueries {
+		return false, defaultRetryAfter
+	}
+
+	if limit.MaxQueriesPerSecond > 0 {
+		now := utils.Now()
+		capacity := math.Max(limit.MaxQueriesPerSecond, 1)
+		admission.tokens = math.Min(capacity,
+			admission.tokens+now.Sub(admission.lastRefill).Seconds()*limit.MaxQueriesPerSecond)
+		admission.lastRefill = now
+		// Queries more than the capacity are admitted with full tokens, later
+		// queries wait till tokens are paid back.
+		required := math.Min(float64(numQueries), capacity)
+		if admission.tokens < required {
+			return false, time.Duration((required - admission.tokens) / limit.MaxQueriesPerSecond * float64(time.Second))
+		}
+		admission.tokens -= float64(numQueries)
+	}
+
+	admission.runningQueries += numQueries
+	return true, 0
+}
+
+// Finish finishes numQueries queries admitted for the caller.
+func (c *AdmissionController) Finish(caller string, numQueries int) {
+	c.Lock()
+	defer c.Unlock()
+	c.getCaller(caller).runningQueries -= numQueries
+}
+
+// ReserveDeviceMemory reserves device memory in bytes for a query from the
+// caller, returns false if the caller would be over its limit. Reserved memory
+// must be released by ReleaseDeviceMemory.
+func (c *AdmissionController) ReserveDeviceMemory(caller string, bytes int) bool {
+	c.Lock()
+	defer c.Unlock()
+	admission := c.getCaller(caller)
+	if maxBytes := admission.limit.MaxDeviceMemoryInMB * mb2bytes; maxBytes > 0 && admission.deviceMemory+bytes > maxBytes {
+		return false
+	}
+	admission.deviceMemory += bytes
+	return true
+}
+
+// ExceedsDeviceMemoryLimit returns whether a query from the caller requiring
+// the device memory in bytes is over the limit of the caller by itself, so
+// that it can not be admitted even after other queries finish.
+func (c *AdmissionController) ExceedsDeviceMemoryLimit(caller string, bytes int) bool {
+	c.Lock()
+	defer c.Unlock()
+	maxBytes := c.getCaller(caller).limit.MaxDeviceMemoryInMB * mb2bytes
+	return maxBytes > 0 && bytes > maxBytes
+}
+
+// ReleaseDeviceMemory releases device memory in bytes reserved for a query
+// from the caller.
+func (c *AdmissionController) ReleaseDeviceMemory(caller string, bytes int) {
+	c.Lock()
+	defer c.Unlock()
+	c.getCaller(caller).deviceMemory -= bytes
+}
@@ -0,0 +1,158 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("AdmissionController", func() {
+	now := time.Unix(100, 0)
+
+	ginkgo.BeforeEach(func() {
+		utils.SetClockImplementation(func() time.Time {
+			return now
+		})
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	ginkgo.It("is disabled without limits", func() {
+		Ω(NewAdmissionController(common.QueryConfig{})).Should(BeNil())
+	})
+
+	ginkgo.It("limits concurrent queries", func() {
+		controller := NewAdmissionController(common.QueryConfig{
+			CallerLimits: map[string]common.CallerLimitConfig{
+				"batch": {MaxConcurrentQueries: 2},
+			},
+		})
+
+		// Callers without their own limits are not limited.
+		admitted, _ := controller.Admit("dashboard", 10)
+		Ω(admitted).Should(BeTrue())
+
+		admitted, _ = controller.Admit("Batch", 1)
+		Ω(admitted).Should(BeTrue())
+		admitted, _ = controller.Admit("batch", 1)
+		Ω(admitted).Should(BeTrue())
+		admitted, retryAfter := controller.Admit("batch", 1)
+		Ω(admitted).Should(BeFalse())
+		Ω(retryAfter).Should(Equal(defaultRetryAfter))
+
+		controller.Finish("batch", 2)
+		// More queries than the limit are admitted if no other query is running.
+		admitted, _ = controller.Admit("batch", 3)
+		Ω(admitted).Should(BeTrue())
+	})
+
+	ginkgo.It("limits queries per second", func() {
+		controller := NewAdmissionController(common.QueryConfig{
+			DefaultCallerLimit: common.CallerLimitConfig{MaxQueriesPerSecond: 2},
+		})
+
+		admitted, _ := controller.Admit("batch", 1)
+		Ω(admitted).Should(BeTrue())
+		admitted, _ = controller.Admit("batch", 1)
+		Ω(admitted).Should(BeTrue())
+		admitted, retryAfter := controller.Admit("batch", 1)
+		Ω(admitted).Should(BeFalse())
+		Ω(retryAfter).Should(Equal(500 * time.Millisecond))
+
+		// Other callers are limited separately.
+		admitted, _ = controller.Admit("dashboard", 1)
+		Ω(admitted).Should(BeTrue())
+
+		now = now.Add(time.Second)
+		admitted, _ = controller.Admit("batch", 5)
+		Ω(admitted).Should(BeTrue())
+		admitted, retryAfter = controller.Admit("batch", 1)
+		Ω(admitted).Should(BeFalse())
+		Ω(retryAfter).Should(Equal(2 * time.Second))
+	})
+
+	ginkgo.It("limits device memory", func() {
+		controller := NewAdmissionController(common.QueryConfig{
+			DefaultCallerLimit: common.CallerLimitConfig{MaxDeviceMemoryInMB: 1},
+		})
+
+		Ω(controller.ReserveDeviceMemory("batch", mb2bytes/2)).Should(BeTrue())
+		Ω(controller.ReserveDeviceMemory("batch", mb2bytes/2)).Should(BeTrue())
+		Ω(controller.ReserveDeviceMemory("batch", 1)).Should(BeFalse())
+		controller.ReleaseDeviceMemory("batch", mb2bytes/2)
+		Ω(controller.ReserveDeviceMemory("batch", 1)).Should(BeTrue())
+
+		// queries over the limit by themselves are never admitted.
+		Ω(controller.ExceedsDeviceMemoryLimit("batch", mb2bytes)).Should(BeFalse())
+		Ω(controller.ExceedsDeviceMemoryLimit("batch", mb2bytes+1)).Should(BeTrue())
+		unlimited := NewAdmissionController(common.QueryConfig{
+			DefaultCallerLimit: common.CallerLimitConfig{MaxQueriesPerSecond: 1},
+		})
+		Ω(unlimited.ExceedsDeviceMemoryLimit("batch", mb2bytes+1)).Should(BeFalse())
+	})
+
+	ginkgo.It("evicts idle callers", func() {
+		controller := NewAdmissionController(common.QueryConfig{
+			DefaultCallerLimit: common.CallerLimitConfig{MaxQueriesPerSecond: 1},
+		})
+
+		admitted, _ := controller.Admit("running", 1)
+		Ω(admitted).Should(BeTrue())
+		Ω(controller.ReserveDeviceMemory("reserved", 1)).Should(BeTrue())
+		for _, caller := range []string{"finished", "throttled"} {
+			admitted, _ = controller.Admit(caller, 1)
+			Ω(admitted).Should(BeTrue())
+			controller.Finish(caller, 1)
+		}
+		Ω(controller.callers).Should(HaveLen(4))
+
+		// idle callers are not evicted before the interval.
+		now = now.Add(evictIdleCallersInterval / 2)
+		controller.Admit("new", 1)
+		controller.Finish("new", 1)
+		Ω(controller.callers).Should(HaveLen(5))
+
+		// callers with running queries, reserved device memory or not yet
+		// refilled tokens are kept.
+		now = now.Add(evictIdleCallersInterval/2 - 500*time.Millisecond)
+		admitted, _ = controller.Admit("throttled", 1)
+		Ω(admitted).Should(BeTrue())
+		controller.Finish("throttled", 1)
+		now = now.Add(500 * time.Millisecond)
+		Ω(controller.ReserveDeviceMemory("another", 1)).Should(BeTrue())
+		Ω(controller.callers).Should(HaveLen(4))
+		Ω(controller.callers).Should(HaveKey("running"))
+		Ω(controller.callers).Should(HaveKey("reserved"))
+		Ω(controller.callers).Should(HaveKey("another"))
+		Ω(controller.callers).Should(HaveKey("throttled"))
+	})
+
+	ginkgo.It("RespondWithTooManyRequests should work", func() {
+		w := httptest.NewRecorder()
+		RespondWithTooManyRequests(w, 1500*time.Millisecond)
+		Ω(w.Code).Should(Equal(http.StatusTooManyRequests))
+		Ω(w.Header().Get("Retry-After")).Should(Equal("2"))
+		Ω(w.Body.String()).Should(ContainSubstring(ErrMsgTooManyQueries))
+	})
+})
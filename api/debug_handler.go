@@ -56,6 +56,7 @@ func (handler *DebugHandler) Register(router *mux.Router) {
 	router.HandleFunc("/jobs/{jobType}", handler.ShowJobStatus).Methods(http.MethodGet)
 	router.HandleFunc("/devices", handler.ShowDeviceStatus).Methods(http.MethodGet)
 	router.HandleFunc("/host-memory", handler.ShowHostMemory).Methods(http.MethodGet)
+	router.HandleFunc("/queries", handler.ShowQueries).Methods(http.MethodGet)
 	router.HandleFunc("/{table}/{shard}", handler.ShowShardMeta).Methods(http.MethodGet)
 	router.HandleFunc("/{table}/{shard}/archive", handler.Archive).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}/backfill", handler.Backfill).Methods(http.MethodPost)
@@ -646,6 +647,15 @@ func (handler *DebugHandler) ShowDeviceStatus(w http.ResponseWriter, r *http.Req
 	return
 }
 
+// ShowQueries shows recently executed queries from the most recent to the oldest.
+func (handler *DebugHandler) ShowQueries(w http.ResponseWriter, r *http.Request) {
+	entries := []QueryHistoryEntry{}
+	if queryHistory := handler.queryHandler.GetQueryHistory(); queryHistory != nil {
+		entries = queryHistory.List()
+	}
+	RespondWithJSONObject(w, entries)
+}
+
 // ShowHostMemory shows the current host memory usage
 func (handler *DebugHandler) ShowHostMemory(w http.ResponseWriter, r *http.Request) {
 	memoryUsageByTableShard, err := handler.memStore.GetMemoryUsageDetails()
@@ -224,9 +224,10 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 			"OpenVectorPartyFileForWrite", mock.Anything,
 			mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(writer, nil)
 
-		queryHandler := NewQueryHandler(memStore, common.QueryConfig{
+		queryHandler := NewQueryHandler(memStore, nil, common.QueryConfig{
 			DeviceMemoryUtilization: 0.9,
 			DeviceChoosingTimeout:   5,
+			QueryHistorySize:        10,
 		})
 
 		healthCheckHandler := NewHealthCheckHandler()
@@ -886,6 +887,40 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		Ω(string(bs)).Should(ContainSubstring("Failed to get shard"))
 	})
 
+	ginkgo.It("ShowQueries should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		resp, err := http.Get(fmt.Sprintf("http://%s/debug/queries", hostPort))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(string(bs)).Should(MatchJSON(`[]`))
+
+		debugHandler.queryHandler.GetQueryHistory().Add(QueryHistoryEntry{
+			Caller:     "test",
+			Table:      "trips",
+			StartTime:  time.Unix(0, 0).UTC(),
+			Duration:   1.5,
+			StatusCode: http.StatusBadRequest,
+			Error:      "test error",
+		})
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/queries", hostPort))
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(string(bs)).Should(MatchJSON(`[
+			{
+				"caller": "test",
+				"table": "trips",
+				"startTime": "1970-01-01T00:00:00Z",
+				"duration": 1.5,
+				"statusCode": 400,
+				"error": "test error"
+			}
+		]`))
+	})
+
 	ginkgo.It("ShowDeviceStatus should work", func() {
 		expectedStatus := string(`
 			{
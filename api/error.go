@@ -33,6 +33,24 @@ var (
 	ErrMsgNonExistentColumn = "Bad request: column does not exist"
 	// ErrMsgDeletedColumn represents error message for column is already deleted
 	ErrMsgDeletedColumn = "Bad request: column is already deleted"
+	// ErrMsgExplainMixedWithQueries represents error message for EXPLAIN statements mixed with queries.
+	ErrMsgExplainMixedWithQueries = "Bad request: EXPLAIN can not be mixed with queries in one request"
+	// ErrMsgSchemaChangeMixedWithQueries represents error message for schema change statements mixed with queries.
+	ErrMsgSchemaChangeMixedWithQueries = "Bad request: schema change statements can not be mixed with queries in one request"
+	// ErrMsgSchemaChangeNotAllowed represents error message for schema change statements when schema is read only.
+	ErrMsgSchemaChangeNotAllowed = "Bad request: schema can not be changed through SQL"
+	// ErrMsgMetadataMixedWithQueries represents error message for metadata statements mixed with queries.
+	ErrMsgMetadataMixedWithQueries = "Bad request: SHOW and DESCRIBE statements can not be mixed with queries in one request"
+	// ErrMsgMetadataInHyperLogLog represents error message for metadata statements requesting application/hll.
+	ErrMsgMetadataInHyperLogLog = "Bad request: SHOW and DESCRIBE statements do not support application/hll"
+	// ErrMsgTooManyQueries represents error message for queries over limits of the caller.
+	ErrMsgTooManyQueries = "Too many requests: query limits of the caller are exceeded"
+	// ErrMsgMultipleQueriesInArrowStream represents error message for requests of multiple queries accepting
+	// application/vnd.apache.arrow.stream.
+	ErrMsgMultipleQueriesInArrowStream = "Bad request: application/vnd.apache.arrow.stream supports only one query per request"
+	// ErrMsgDeviceMemoryLimitExceeded represents error message for queries requiring more device memory than the
+	// limit of the caller.
+	ErrMsgDeviceMemoryLimitExceeded = "Bad request: device memory required by the query exceeds the limit of the caller"
 	// ErrMsgNotImplemented represents error message for method not implemented.
 	ErrMsgNotImplemented = "Not implemented"
 	// ErrMsgFailedToJSONMarshalResponseBody respresents error message for failure to marshal
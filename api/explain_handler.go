@@ -0,0 +1,78 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/uber/aresdb/query"
+	"github.com/uber/aresdb/utils"
+)
+
+// HandleExplain swagger:route POST /query/explain explainQuery
+// explain AQL queries without executing them
+//
+// Consumes:
+//    - application/json
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: explainResponse
+func (handler *QueryHandler) HandleExplain(w http.ResponseWriter, r *http.Request) {
+	aqlRequest := AQLRequest{Device: -1}
+
+	if err := ReadRequest(r, &aqlRequest); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	if aqlRequest.Query != "" {
+		// Override from query parameter
+		if err := json.Unmarshal([]byte(aqlRequest.Query), &aqlRequest.Body); err != nil {
+			RespondWithBadRequest(w, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: ErrMsgFailedToUnmarshalRequest,
+				Cause:   err,
+			})
+			return
+		}
+	}
+
+	if aqlRequest.Body.Queries == nil {
+		RespondWithBadRequest(w, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: ErrMsgMissingParameter,
+		})
+		return
+	}
+
+	handler.explainQueries(aqlRequest, w)
+}
+
+// explainQueries compiles queries of the request and responds with their
+// plans. Errors of each query are reported in its own plan.
+func (handler *QueryHandler) explainQueries(aqlRequest AQLRequest, w http.ResponseWriter) {
+	var response ExplainResponse
+	response.Body.Plans = make([]*query.QueryPlan, len(aqlRequest.Body.Queries))
+	for i, aqlQuery := range aqlRequest.Body.Queries {
+		qc := aqlQuery.Compile(handler.memStore, aqlRequest.Accept == ContentTypeHyperLogLog)
+		response.Body.Plans[i] = qc.Explain(handler.memStore)
+	}
+	RespondWithJSONObject(w, response.Body)
+}
@@ -0,0 +1,157 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/memstore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("QueryHandler explain", func() {
+	var testServer *httptest.Server
+	var testSchema = memstore.NewTableSchema(&metaCom.Table{
+		Name:        "trips",
+		IsFactTable: false,
+		Columns: []metaCom.Column{
+			{
+				Name: "request_at",
+				Type: "Uint32",
+			},
+			{
+				Name: "fare_total",
+				Type: "Flaot",
+			},
+			{
+				Name: "city_id",
+				Type: "Uint16",
+			},
+			{
+				Name: "status",
+				Type: "SmallEnum",
+			},
+		},
+		Config: metaCom.TableConfig{
+			BatchSize: 10,
+		},
+	})
+
+	ginkgo.BeforeEach(func() {
+		memStore := CreateMemStore(testSchema, 0, nil, CreateMockDiskStore())
+		queryHandler := NewQueryHandler(memStore, nil, common.QueryConfig{
+			DeviceMemoryUtilization: 1.0,
+		})
+		testRouter := mux.NewRouter()
+		testRouter.HandleFunc("/explain", queryHandler.HandleExplain).Methods(http.MethodGet, http.MethodPost)
+		testRouter.HandleFunc("/sql", queryHandler.HandleSQL).Methods(http.MethodGet, http.MethodPost)
+		testServer = httptest.NewUnstartedServer(WithPanicHandling(testRouter))
+		testServer.Start()
+	})
+
+	ginkgo.AfterEach(func() {
+		testServer.Close()
+	})
+
+	// explain posts the request to path and returns the status code and plans.
+	explain := func(path, request string) (int, []map[string]interface{}) {
+		resp, err := http.Post(fmt.Sprintf("http://%s/%s", testServer.Listener.Addr().String(), path),
+			"application/json", bytes.NewBuffer([]byte(request)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		var response struct {
+			Plans []map[string]interface{} `json:"plans"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			Ω(json.Unmarshal(bs, &response)).Should(BeNil())
+		}
+		return resp.StatusCode, response.Plans
+	}
+
+	ginkgo.It("HandleExplain should return plans of queries", func() {
+		statusCode, plans := explain("explain", `
+			{
+			  "queries": [
+				{
+				  "measures": [{"sqlExpression": "count(*)"}],
+				  "rowFilters": ["trips.status!='ACTIVE'"],
+				  "table": "trips",
+				  "timeFilter": {"column": "trips.request_at", "from": "-6d"},
+				  "dimensions": [{"sqlExpression": "trips.request_at", "timeBucketizer": "day", "timeUnit": "second"}]
+				},
+				{
+				  "measures": [{"sqlExpression": "count(*)"}],
+				  "table": "unknown_table"
+				}
+			  ]
+			}
+		`)
+		Ω(statusCode).Should(Equal(http.StatusOK))
+		Ω(plans).Should(HaveLen(2))
+
+		plan := plans[0]
+		Ω(plan["error"]).Should(BeNil())
+		Ω(plan["filters"]).Should(HaveLen(1))
+		Ω(plan["dimensions"]).Should(HaveLen(1))
+		Ω(plan["measures"]).Should(HaveLen(1))
+		Ω(plan["timeFilter"]).Should(HaveKeyWithValue("column", "trips.request_at"))
+		Ω(plan["timeFilter"]).Should(HaveKey("from"))
+		Ω(plan["columns"]).Should(HaveKey("trips"))
+		Ω(plan["columns"].(map[string]interface{})["trips"]).Should(ContainElement("status"))
+		Ω(plan["columns"].(map[string]interface{})["trips"]).Should(ContainElement("request_at"))
+		Ω(plan).Should(HaveKey("deviceMemoryRequirement"))
+
+		Ω(plans[1]["error"]).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("HandleExplain should fail requests without queries", func() {
+		statusCode, _ := explain("explain", `{}`)
+		Ω(statusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("HandleSQL should return plans of EXPLAIN statements", func() {
+		statusCode, plans := explain("sql", `
+			{
+			  "queries": [
+				"EXPLAIN SELECT count(*) AS value FROM trips WHERE status='completed' AND aql_time_filter(request_at, \"24 hours ago\", \"this quarter-hour\", America/New_York) GROUP BY aql_time_bucket_hour(request_at, \"\", America/New_York)"
+			  ]
+			}
+		`)
+		Ω(statusCode).Should(Equal(http.StatusOK))
+		Ω(plans).Should(HaveLen(1))
+		Ω(plans[0]["error"]).Should(BeNil())
+		Ω(plans[0]["dimensions"]).Should(HaveLen(1))
+
+		statusCode, _ = explain("sql", `
+			{
+			  "queries": [
+				"EXPLAIN SELECT count(*) FROM trips",
+				"SELECT count(*) FROM trips"
+			  ]
+			}
+		`)
+		Ω(statusCode).Should(Equal(http.StatusBadRequest))
+	})
+})
@@ -15,10 +15,16 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/uber/aresdb/memstore"
+	"github.com/uber/aresdb/metastore"
 	"github.com/uber/aresdb/query"
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/utils"
@@ -33,14 +39,47 @@ import (
 type QueryHandler struct {
 	memStore      memstore.MemStore
 	deviceManager *query.DeviceManager
+	// schema changes of SQL statements go to schemaMutator, nil if the schema
+	// can not be changed through SQL.
+	schemaMutator metastore.TableSchemaMutator
+	// max number of queries of one request executed concurrently.
+	maxConcurrentQueries int
+	// default timeout in seconds for executing a query.
+	queryTimeout int
+	// cache for results of queries over archived data, nil if disabled.
+	resultCache *query.ResultCache
+	// recently executed queries, nil if disabled.
+	queryHistory *QueryHistory
+	// queries running longer than this are logged, disabled if not positive.
+	slowQueryThreshold time.Duration
+	// limits of queries from each caller, nil if there is no limit.
+	admissionController *AdmissionController
 }
 
 // NewQueryHandler creates a new QueryHandler.
-func NewQueryHandler(memStore memstore.MemStore, cfg common.QueryConfig) *QueryHandler {
-	return &QueryHandler{
-		memStore:      memStore,
-		deviceManager: query.NewDeviceManager(cfg),
+func NewQueryHandler(memStore memstore.MemStore, schemaMutator metastore.TableSchemaMutator, cfg common.QueryConfig) *QueryHandler {
+	handler := &QueryHandler{
+		memStore:             memStore,
+		schemaMutator:        schemaMutator,
+		deviceManager:        query.NewDeviceManager(cfg),
+		maxConcurrentQueries: cfg.MaxConcurrentQueries,
+		queryTimeout:         cfg.QueryTimeout,
+		slowQueryThreshold:   time.Duration(cfg.SlowQueryThresholdInMilliseconds) * time.Millisecond,
+		admissionController:  NewAdmissionController(cfg),
 	}
+	if cfg.ResultCacheSize > 0 {
+		handler.resultCache = query.NewResultCache(cfg.ResultCacheSize)
+		memStore.AddArchiveChangeListener(handler.resultCache.Invalidate)
+	}
+	if cfg.QueryHistorySize > 0 {
+		handler.queryHistory = NewQueryHistory(cfg.QueryHistorySize)
+	}
+	return handler
+}
+
+// GetQueryHistory returns the recently executed queries, nil if disabled.
+func (handler *QueryHandler) GetQueryHistory() *QueryHistory {
+	return handler.queryHistory
 }
 
 // GetDeviceManager returns the device manager of query handler.
@@ -52,6 +91,7 @@ func (handler *QueryHandler) GetDeviceManager() *query.DeviceManager {
 func (handler *QueryHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
 	router.HandleFunc("/aql", utils.ApplyHTTPWrappers(handler.HandleAQL, wrappers)).Methods(http.MethodGet, http.MethodPost)
 	router.HandleFunc("/sql", utils.ApplyHTTPWrappers(handler.HandleSQL, wrappers)).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/explain", utils.ApplyHTTPWrappers(handler.HandleExplain, wrappers)).Methods(http.MethodGet, http.MethodPost)
 }
 
 // HandleAQL swagger:route POST /query/aql queryAQL
@@ -63,6 +103,9 @@ func (handler *QueryHandler) Register(router *mux.Router, wrappers ...utils.HTTP
 //
 // Produces:
 //    - application/json
+//    - application/flat-json
+//    - text/csv
+//    - application/vnd.apache.arrow.stream
 //
 // Responses:
 //    default: errorResponse
@@ -137,37 +180,168 @@ func (handler *QueryHandler) handleAQLInternal(aqlRequest AQLRequest, w http.Res
 		return
 	}
 
-	returnHLL := aqlRequest.Accept == ContentTypeHyperLogLog
-	requestResponseWriter := getReponseWriter(returnHLL, len(aqlRequest.Body.Queries))
+	// An Arrow IPC stream has a single schema, which holds results of one query.
+	if aqlRequest.Accept == ContentTypeArrowStream && len(aqlRequest.Body.Queries) > 1 {
+		statusCode = http.StatusBadRequest
+		RespondWithBadRequest(w, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: ErrMsgMultipleQueriesInArrowStream,
+		})
+		return
+	}
+
+	if aqlRequest.QueryTimeout <= 0 {
+		aqlRequest.QueryTimeout = handler.queryTimeout
+	}
+
+	if handler.admissionController != nil {
+		numQueries := len(aqlRequest.Body.Queries)
+		if admitted, retryAfter := handler.admissionController.Admit(aqlRequest.Origin, numQueries); !admitted {
+			statusCode = http.StatusTooManyRequests
+			RespondWithTooManyRequests(w, retryAfter)
+			utils.GetRootReporter().GetChildCounter(map[string]string{
+				"caller": aqlRequest.Origin,
+			}, utils.QueryRejected).Inc(1)
+			return
+		}
+		defer handler.admissionController.Finish(aqlRequest.Origin, numQueries)
+	}
+
+	requestResponseWriter := getReponseWriter(aqlRequest.Accept, len(aqlRequest.Body.Queries))
 
 	queryTimer := utils.GetRootReporter().GetTimer(utils.QueryLatency)
 	start := utils.Now()
-	var qc *query.AQLQueryContext
+	var statusCodes []int
+	var durations []time.Duration
+	qcs, statusCodes, durations = handler.executeQueries(r.Context(), aqlRequest)
+	// Results are reported in request order.
 	for i, aqlQuery := range aqlRequest.Body.Queries {
-		qc, statusCode = handleQuery(handler.memStore, handler.deviceManager, aqlRequest, aqlQuery)
+		qc := qcs[i]
 		if aqlRequest.Verbose > 0 {
 			requestResponseWriter.ReportQueryContext(qc)
 		}
 		if qc.Error != nil {
-			requestResponseWriter.ReportError(i, aqlQuery.Table, qc.Error, statusCode)
+			requestResponseWriter.ReportError(i, aqlQuery.Table, qc.Error, statusCodes[i])
 		} else {
 			requestResponseWriter.ReportResult(i, qc)
+			if aqlRequest.Stats > 0 {
+				requestResponseWriter.ReportStats(i, qc)
+			}
+			if handler.resultCache != nil {
+				handler.resultCache.Put(qc)
+			}
 			qc.ReleaseHostResultsBuffers()
 			utils.GetRootReporter().GetChildCounter(map[string]string{
 				"table": aqlQuery.Table,
 			}, utils.QuerySucceeded).Inc(1)
 		}
-
-		qcs = append(qcs, qc)
+		handler.recordQuery(aqlRequest, aqlQuery, qc, statusCodes[i], start, durations[i])
 	}
 	duration = utils.Now().Sub(start)
 	queryTimer.Record(duration)
+	for _, code := range statusCodes {
+		// Some queries are over the device memory limit of the caller.
+		if code == http.StatusTooManyRequests {
+			setRetryAfter(w, defaultRetryAfter)
+			break
+		}
+	}
 	requestResponseWriter.Respond(w)
 	statusCode = requestResponseWriter.GetStatusCode()
 	return
 }
 
-func handleQuery(memStore memstore.MemStore, deviceManager *query.DeviceManager, aqlRequest AQLRequest, aqlQuery query.AQLQuery) (qc *query.AQLQueryContext, statusCode int) {
+// executeQueries compiles and executes all queries of the request and returns
+// their contexts, status codes and durations in request order. Up to
+// maxConcurrentQueries queries are executed concurrently, which are dispatched
+// to devices by the device manager. Errors of each query are kept in its own
+// context.
+func (handler *QueryHandler) executeQueries(ctx context.Context, aqlRequest AQLRequest) ([]*query.AQLQueryContext, []int, []time.Duration) {
+	queries := aqlRequest.Body.Queries
+	qcs := make([]*query.AQLQueryContext, len(queries))
+	statusCodes := make([]int, len(queries))
+	durations := make([]time.Duration, len(queries))
+
+	if handler.maxConcurrentQueries <= 1 || len(queries) <= 1 {
+		for i, aqlQuery := range queries {
+			start := utils.Now()
+			qcs[i], statusCodes[i] = handler.handleQuery(ctx, aqlRequest, aqlQuery)
+			durations[i] = utils.Now().Sub(start)
+		}
+		return qcs, statusCodes, durations
+	}
+
+	semaphore := make(chan struct{}, handler.maxConcurrentQueries)
+	var wg sync.WaitGroup
+	for i, aqlQuery := range queries {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, aqlQuery query.AQLQuery) {
+			start := utils.Now()
+			defer func() {
+				// Panics out of the request goroutine are not recovered by the
+				// http panic handler, report them as errors of the query.
+				if r := recover(); r != nil {
+					qcs[i] = &query.AQLQueryContext{
+						Query: &aqlQuery,
+						Error: utils.StackError(nil, "Panic happens when handling query %v", r),
+					}
+					statusCodes[i] = http.StatusInternalServerError
+				}
+				durations[i] = utils.Now().Sub(start)
+				<-semaphore
+				wg.Done()
+			}()
+			qcs[i], statusCodes[i] = handler.handleQuery(ctx, aqlRequest, aqlQuery)
+		}(i, aqlQuery)
+	}
+	wg.Wait()
+	return qcs, statusCodes, durations
+}
+
+// recordQuery adds the reported query to query history and logs it if it's
+// slow. duration is the time executing the query, time postprocessing its
+// results is added.
+func (handler *QueryHandler) recordQuery(aqlRequest AQLRequest, aqlQuery query.AQLQuery,
+	qc *query.AQLQueryContext, statusCode int, start time.Time, duration time.Duration) {
+	duration += time.Duration(qc.Stats.PostprocessTime * float64(time.Millisecond))
+	var errStr string
+	if qc.Error == nil {
+		statusCode = http.StatusOK
+	} else {
+		errStr = qc.Error.Error()
+		// Errors happened during postprocessing.
+		if statusCode < http.StatusBadRequest {
+			statusCode = http.StatusInternalServerError
+		}
+	}
+
+	if handler.queryHistory != nil {
+		handler.queryHistory.Add(QueryHistoryEntry{
+			Caller:     aqlRequest.Origin,
+			Table:      aqlQuery.Table,
+			StartTime:  start,
+			Duration:   duration.Seconds() * 1000,
+			StatusCode: statusCode,
+			Error:      errStr,
+		})
+	}
+
+	if handler.slowQueryThreshold > 0 && duration >= handler.slowQueryThreshold {
+		utils.GetQueryLogger().With(
+			"caller", aqlRequest.Origin,
+			"table", aqlQuery.Table,
+			"query", aqlQuery,
+			"duration", duration,
+			"statusCode", statusCode,
+			"error", errStr,
+			"stats", qc.Stats,
+		).Warn("Slow query")
+	}
+}
+
+func (handler *QueryHandler) handleQuery(ctx context.Context, aqlRequest AQLRequest, aqlQuery query.AQLQuery) (qc *query.AQLQueryContext, statusCode int) {
+	memStore, deviceManager := handler.memStore, handler.deviceManager
 	qc = aqlQuery.Compile(memStore, aqlRequest.Accept == ContentTypeHyperLogLog)
 
 	for tableName := range qc.TableSchemaByName {
@@ -187,6 +361,63 @@ func handleQuery(memStore memstore.MemStore, deviceManager *query.DeviceManager,
 		return
 	}
 
+	// Results of queries over archived data are served from the cache.
+	if handler.resultCache != nil && !qc.Debug {
+		if results, found := handler.resultCache.Get(qc, memStore); found {
+			qc.Results = results
+			qc.Stats.ResultCacheHit = true
+			utils.GetRootReporter().GetChildCounter(map[string]string{
+				"table": aqlQuery.Table,
+			}, utils.QueryResultCacheHit).Inc(1)
+			return
+		}
+	}
+
+	// The compare query is executed first, its results are merged into results
+	// of this query during postprocessing. Both queries run under the same
+	// deadline, so the timeout is not applied to the compare query again.
+	var cancel context.CancelFunc
+	if qc.CompareQuery != nil {
+		if aqlRequest.QueryTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(aqlRequest.QueryTimeout)*time.Second)
+			defer cancel()
+		}
+		compareRequest := aqlRequest
+		compareRequest.QueryTimeout = 0
+		qc.CompareContext, statusCode = handler.handleQuery(ctx, compareRequest, *qc.CompareQuery)
+		if qc.CompareContext.Error != nil {
+			qc.Error = utils.StackError(qc.CompareContext.Error, "failed to process compare query")
+			return
+		}
+		defer func() {
+			if qc.Error != nil {
+				qc.CompareContext.ReleaseHostResultsBuffers()
+			}
+		}()
+	}
+
+	// Queries over the device memory limit of the caller are rejected before
+	// waiting for a device. Queries requiring more than the limit by themselves
+	// are bad requests as retrying does not help.
+	if handler.admissionController != nil {
+		memoryRequired := qc.EstimateDeviceMemory(memStore)
+		if qc.Error == nil {
+			if handler.admissionController.ExceedsDeviceMemoryLimit(aqlRequest.Origin, memoryRequired) {
+				qc.Error = utils.StackError(nil, "%s: %d bytes of device memory required by the query",
+					ErrMsgDeviceMemoryLimitExceeded, memoryRequired)
+				statusCode = http.StatusBadRequest
+				return
+			}
+			if !handler.admissionController.ReserveDeviceMemory(aqlRequest.Origin, memoryRequired) {
+				qc.Error = utils.StackError(nil, "%s: %d bytes of device memory required by the query",
+					ErrMsgTooManyQueries, memoryRequired)
+				statusCode = http.StatusTooManyRequests
+				return
+			}
+			defer handler.admissionController.ReleaseDeviceMemory(aqlRequest.Origin, memoryRequired)
+		}
+	}
+
 	deviceChoosingTimeout := -1
 	if aqlRequest.DeviceChoosingTimeout > 0 {
 		deviceChoosingTimeout = aqlRequest.DeviceChoosingTimeout
@@ -201,9 +432,29 @@ func handleQuery(memStore memstore.MemStore, deviceManager *query.DeviceManager,
 		return
 	}
 	defer deviceManager.ReleaseReservedMemory(qc.Device, qc.Query)
+	// The query is stopped once the request is cancelled or it runs longer than
+	// the timeout.
+	if aqlRequest.QueryTimeout > 0 && cancel == nil {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(aqlRequest.QueryTimeout)*time.Second)
+		defer cancel()
+	}
+	qc.Context = ctx
 	// Execute.
 	qc.ProcessQuery(memStore)
-	if qc.Error != nil {
+	if qc.IsCanceled() {
+		utils.GetQueryLogger().With(
+			"error", qc.Error,
+			"query", aqlQuery,
+		).Info("Query is cancelled by the client")
+		statusCode = StatusClientClosedRequest
+	} else if qc.IsTimeout() {
+		utils.GetQueryLogger().With(
+			"error", qc.Error,
+			"query", aqlQuery,
+			"timeout", aqlRequest.QueryTimeout,
+		).Warn("Query exceeds the timeout")
+		statusCode = http.StatusGatewayTimeout
+	} else if qc.Error != nil {
 		utils.GetQueryLogger().With(
 			"error", qc.Error,
 			"query", aqlQuery,
@@ -219,9 +470,17 @@ func handleQuery(memStore memstore.MemStore, deviceManager *query.DeviceManager,
 	return
 }
 
-func getReponseWriter(returnHLL bool, nQueries int) QueryResponseWriter {
-	if returnHLL {
+// getReponseWriter returns the QueryResponseWriter for the accepted content type.
+func getReponseWriter(accept string, nQueries int) QueryResponseWriter {
+	switch accept {
+	case ContentTypeHyperLogLog:
 		return NewHLLQueryResponseWriter()
+	case ContentTypeFlatJSON:
+		return NewFlatJSONQueryResponseWriter(nQueries)
+	case ContentTypeCSV:
+		return NewCSVQueryResponseWriter(nQueries)
+	case ContentTypeArrowStream:
+		return NewArrowQueryResponseWriter()
 	}
 	return NewJSONQueryResponseWriter(nQueries)
 }
@@ -231,6 +490,7 @@ type QueryResponseWriter interface {
 	ReportError(queryIndex int, table string, err error, statusCode int)
 	ReportQueryContext(*query.AQLQueryContext)
 	ReportResult(int, *query.AQLQueryContext)
+	ReportStats(int, *query.AQLQueryContext)
 	Respond(w http.ResponseWriter)
 	GetStatusCode() int
 }
@@ -273,13 +533,25 @@ func (w *JSONQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext)
 
 // ReportResult writes the query result to the response.
 func (w *JSONQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
-	qc.Results = qc.Postprocess()
+	// Results are already set if they are served from the result cache.
+	if qc.Results == nil {
+		qc.Results = qc.Postprocess()
+	}
 	if qc.Error != nil {
 		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
 	}
 	w.response.Results[queryIndex] = qc.Results
 }
 
+// ReportStats writes the execution stats of the query to the response.
+func (w *JSONQueryResponseWriter) ReportStats(queryIndex int, qc *query.AQLQueryContext) {
+	if w.response.Stats == nil {
+		w.response.Stats = make([]*query.QueryStats, len(w.response.Results))
+	}
+	stats := qc.Stats
+	w.response.Stats[queryIndex] = &stats
+}
+
 // Respond writes the final response into ResponseWriter.
 func (w *JSONQueryResponseWriter) Respond(rw http.ResponseWriter) {
 	RespondJSONObjectWithCode(rw, w.statusCode, w.response)
@@ -321,9 +593,18 @@ func (w *HLLQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
 
 // ReportResult writes the query result to the response.
 func (w *HLLQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	if qc.OOPK.IsPercentile() {
+		w.response.WritePercentileResult(qc.HLLQueryResult)
+		return
+	}
 	w.response.WriteResult(qc.HLLQueryResult)
 }
 
+// ReportStats writes the execution stats of the query to the response. Like query context, stats are not
+// stored in application/hll response.
+func (w *HLLQueryResponseWriter) ReportStats(queryIndex int, qc *query.AQLQueryContext) {
+}
+
 // Respond writes the final response into ResponseWriter.
 func (w *HLLQueryResponseWriter) Respond(rw http.ResponseWriter) {
 	rw.Header().Set("Content-Type", ContentTypeHyperLogLog)
@@ -334,3 +615,226 @@ func (w *HLLQueryResponseWriter) Respond(rw http.ResponseWriter) {
 func (w *HLLQueryResponseWriter) GetStatusCode() int {
 	return w.statusCode
 }
+
+// FlatJSONQueryResponseWriter writes query results as json of flat results. See query.FlatResult for the format.
+type FlatJSONQueryResponseWriter struct {
+	response   query.FlatAQLResponse
+	statusCode int
+}
+
+// NewFlatJSONQueryResponseWriter creates a new FlatJSONQueryResponseWriter.
+func NewFlatJSONQueryResponseWriter(nQueries int) QueryResponseWriter {
+	return &FlatJSONQueryResponseWriter{
+		response: query.FlatAQLResponse{
+			Results: make([]*query.FlatResult, nQueries),
+		},
+		statusCode: http.StatusOK,
+	}
+}
+
+// ReportError writes the error of the query to the response.
+func (w *FlatJSONQueryResponseWriter) ReportError(queryIndex int, table string, err error, statusCode int) {
+	if statusCode > w.statusCode {
+		w.statusCode = statusCode
+	}
+	if w.response.Errors == nil {
+		w.response.Errors = make([]error, len(w.response.Results))
+	}
+	w.response.Errors[queryIndex] = err
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": table,
+	}, utils.QueryFailed).Inc(1)
+}
+
+// ReportQueryContext writes the query context to the response.
+func (w *FlatJSONQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
+	w.response.QueryContext = append(w.response.QueryContext, qc)
+}
+
+// ReportResult writes the flattened query result to the response.
+func (w *FlatJSONQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	result := flattenResults(qc)
+	if qc.Error != nil {
+		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
+		return
+	}
+	w.response.Results[queryIndex] = result
+}
+
+// ReportStats writes the execution stats of the query to the response.
+func (w *FlatJSONQueryResponseWriter) ReportStats(queryIndex int, qc *query.AQLQueryContext) {
+	if w.response.Stats == nil {
+		w.response.Stats = make([]*query.QueryStats, len(w.response.Results))
+	}
+	stats := qc.Stats
+	w.response.Stats[queryIndex] = &stats
+}
+
+// Respond writes the final response into ResponseWriter.
+func (w *FlatJSONQueryResponseWriter) Respond(rw http.ResponseWriter) {
+	RespondJSONObjectWithCode(rw, w.statusCode, w.response)
+}
+
+// GetStatusCode returns the status code written into response.
+func (w *FlatJSONQueryResponseWriter) GetStatusCode() int {
+	return w.statusCode
+}
+
+// CSVQueryResponseWriter writes query results as text/csv. Each query result is written as a header line
+// followed by rows of the flat result, NULL values are empty fields. Results of multiple queries are separated
+// by an empty line, a failed query is written as an "error" header line followed by the error message.
+type CSVQueryResponseWriter struct {
+	results    []*query.FlatResult
+	statusCode int
+}
+
+// NewCSVQueryResponseWriter creates a new CSVQueryResponseWriter.
+func NewCSVQueryResponseWriter(nQueries int) QueryResponseWriter {
+	return &CSVQueryResponseWriter{
+		results:    make([]*query.FlatResult, nQueries),
+		statusCode: http.StatusOK,
+	}
+}
+
+// ReportError writes the error of the query to the response.
+func (w *CSVQueryResponseWriter) ReportError(queryIndex int, table string, err error, statusCode int) {
+	if statusCode > w.statusCode {
+		w.statusCode = statusCode
+	}
+	w.results[queryIndex] = &query.FlatResult{
+		Headers: []string{"error"},
+		Rows:    [][]interface{}{{err.Error()}},
+	}
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": table,
+	}, utils.QueryFailed).Inc(1)
+}
+
+// ReportQueryContext writes the query context to the response. Query context can not be represented in csv
+// so it's ignored.
+func (w *CSVQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
+}
+
+// ReportResult writes the flattened query result to the response.
+func (w *CSVQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	result := flattenResults(qc)
+	if qc.Error != nil {
+		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
+		return
+	}
+	w.results[queryIndex] = result
+}
+
+// ReportStats writes the execution stats of the query to the response. Like query context, stats are not
+// stored in text/csv response.
+func (w *CSVQueryResponseWriter) ReportStats(queryIndex int, qc *query.AQLQueryContext) {
+}
+
+// Respond writes the final response into ResponseWriter. Rows are written to ResponseWriter as they are
+// formatted.
+func (w *CSVQueryResponseWriter) Respond(rw http.ResponseWriter) {
+	setCommonHeaders(rw)
+	rw.Header().Set("Content-Type", ContentTypeCSV)
+	rw.WriteHeader(w.statusCode)
+
+	csvWriter := csv.NewWriter(rw)
+	for i, result := range w.results {
+		if i > 0 {
+			csvWriter.Write(nil)
+		}
+		if result == nil {
+			continue
+		}
+		csvWriter.Write(result.Headers)
+		for _, row := range result.Rows {
+			record := make([]string, len(row))
+			for j, value := range row {
+				record[j] = query.FormatFlatValue(value)
+			}
+			csvWriter.Write(record)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// GetStatusCode returns the status code written into response.
+func (w *CSVQueryResponseWriter) GetStatusCode() int {
+	return w.statusCode
+}
+
+// ArrowQueryResponseWriter writes the query result as an Apache Arrow IPC stream. As an Arrow stream has a single
+// schema, requests of this content type have only one query, see handleAQLInternal. See
+// query.AQLQueryContext.WriteArrowResults for the format of results. The stream of a failed query has no fields and
+// no record batch, the error message is in the custom metadata of its schema with key "error".
+type ArrowQueryResponseWriter struct {
+	stream     bytes.Buffer
+	statusCode int
+}
+
+// NewArrowQueryResponseWriter creates a new ArrowQueryResponseWriter.
+func NewArrowQueryResponseWriter() QueryResponseWriter {
+	return &ArrowQueryResponseWriter{
+		statusCode: http.StatusOK,
+	}
+}
+
+// ReportError writes the error of the query to the response.
+func (w *ArrowQueryResponseWriter) ReportError(queryIndex int, table string, err error, statusCode int) {
+	if statusCode > w.statusCode {
+		w.statusCode = statusCode
+	}
+	// Discard the partially written stream if any.
+	w.stream.Reset()
+	query.WriteArrowError(&w.stream, err)
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": table,
+	}, utils.QueryFailed).Inc(1)
+}
+
+// ReportQueryContext writes the query context to the response. Like application/hll, query context is not
+// stored in arrow response.
+func (w *ArrowQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
+}
+
+// ReportResult writes the query result to the response as an arrow stream.
+func (w *ArrowQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	// Results are already set if they are served from the result cache.
+	if qc.Results == nil {
+		qc.Results = qc.Postprocess()
+	}
+	if qc.Error == nil {
+		qc.Error = qc.WriteArrowResults(&w.stream)
+	}
+	if qc.Error != nil {
+		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
+	}
+}
+
+// ReportStats writes the execution stats of the query to the response. Like query context, stats are not
+// stored in arrow response.
+func (w *ArrowQueryResponseWriter) ReportStats(queryIndex int, qc *query.AQLQueryContext) {
+}
+
+// Respond writes the final response into ResponseWriter.
+func (w *ArrowQueryResponseWriter) Respond(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", ContentTypeArrowStream)
+	RespondBytesWithCode(rw, w.statusCode, w.stream.Bytes())
+}
+
+// GetStatusCode returns the status code written into response.
+func (w *ArrowQueryResponseWriter) GetStatusCode() int {
+	return w.statusCode
+}
+
+// flattenResults postprocesses results of the query if not yet and returns the flattened results. Errors are
+// set to qc.Error.
+func flattenResults(qc *query.AQLQueryContext) *query.FlatResult {
+	// Results are already set if they are served from the result cache.
+	if qc.Results == nil {
+		qc.Results = qc.Postprocess()
+	}
+	if qc.Error != nil {
+		return nil
+	}
+	return qc.FlattenResults()
+}
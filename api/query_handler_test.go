@@ -26,12 +26,15 @@ import (
 	metaCom "github.com/uber/aresdb/metastore/common"
 
 	"encoding/json"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
 	"github.com/gorilla/mux"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
 	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
 )
 
 var _ = ginkgo.Describe("QueryHandler", func() {
@@ -65,7 +68,7 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 	var memStore *memMocks.MemStore
 	ginkgo.BeforeEach(func() {
 		memStore = CreateMemStore(testSchema, 0, nil, CreateMockDiskStore())
-		queryHandler := NewQueryHandler(memStore, common.QueryConfig{
+		queryHandler := NewQueryHandler(memStore, nil, common.QueryConfig{
 			DeviceMemoryUtilization: 1.0,
 		})
 		testRouter := mux.NewRouter()
@@ -121,6 +124,63 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
 	})
 
+	ginkgo.It("HandleAQL should return stats when requested", func() {
+		var response query.AQLResponse
+		hostPort := testServer.Listener.Addr().String()
+		query := `
+			{
+			  "queries": [
+				{
+				  "measures": [
+					{
+					  "sqlExpression": "count(*)"
+					}
+				  ],
+				  "table": "trips"
+				}
+			  ]
+			}
+		`
+		resp, err := http.Post(fmt.Sprintf("http://%s/aql?stats=1", hostPort), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(json.Unmarshal(bs, &response)).Should(BeNil())
+		Ω(response.Stats).Should(HaveLen(1))
+		Ω(response.Stats[0].Device).Should(BeNumerically(">=", 0))
+		Ω(response.Stats[0].ResultCacheHit).Should(BeFalse())
+	})
+
+	ginkgo.It("HandleAQL should reject requests over limits of the caller", func() {
+		queryHandler := NewQueryHandler(memStore, nil, common.QueryConfig{
+			DeviceMemoryUtilization: 1.0,
+			CallerLimits: map[string]common.CallerLimitConfig{
+				"batch": {MaxQueriesPerSecond: 0.5},
+			},
+		})
+		testRouter := mux.NewRouter()
+		testRouter.HandleFunc("/aql", queryHandler.HandleAQL).Methods(http.MethodGet, http.MethodPost)
+		server := httptest.NewServer(WithPanicHandling(testRouter))
+		defer server.Close()
+
+		post := func(caller string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/aql", server.Listener.Addr().String()),
+				bytes.NewBuffer([]byte(`{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips"}]}`)))
+			Ω(err).Should(BeNil())
+			req.Header.Set("Rpc-Caller", caller)
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			return resp
+		}
+
+		Ω(post("batch").StatusCode).Should(Equal(http.StatusOK))
+		resp := post("batch")
+		Ω(resp.StatusCode).Should(Equal(http.StatusTooManyRequests))
+		Ω(resp.Header.Get("Retry-After")).ShouldNot(BeEmpty())
+		Ω(post("dashboard").StatusCode).Should(Equal(http.StatusOK))
+	})
+
 	ginkgo.It("HandleAQL should fail on request that cannot be unmarshaled", func() {
 		hostPort := testServer.Listener.Addr().String()
 		resp, err := http.Post(fmt.Sprintf("http://%s/aql", hostPort), "application/json", bytes.NewBuffer([]byte{}))
@@ -192,6 +252,23 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(func() { rw.ReportQueryContext(nil) }).ShouldNot(Panic())
 	})
 
+	ginkgo.It("ReportStats should work", func() {
+		rw := NewJSONQueryResponseWriter(2)
+		qc := &query.AQLQueryContext{
+			Stats: query.QueryStats{Device: 1, LiveBatches: 2, RowsScanned: 10},
+		}
+		rw.ReportStats(1, qc)
+		qc.Stats.RowsScanned = 20
+
+		jsonRW := rw.(*JSONQueryResponseWriter)
+		Ω(jsonRW.response.Stats).Should(Equal([]*query.QueryStats{
+			nil, {Device: 1, LiveBatches: 2, RowsScanned: 10},
+		}))
+
+		rw = NewHLLQueryResponseWriter()
+		Ω(func() { rw.ReportStats(0, qc) }).ShouldNot(Panic())
+	})
+
 	ginkgo.It("ReportResult should work", func() {
 		rw := NewHLLQueryResponseWriter()
 		rw.ReportResult(0, &query.AQLQueryContext{HLLQueryResult: []byte{0, 0, 0, 0, 0, 0, 0, 0}})
@@ -201,14 +278,32 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
 	})
 
+	ginkgo.It("ReportResult should work for percentile", func() {
+		rw := NewHLLQueryResponseWriter()
+		rw.ReportResult(0, &query.AQLQueryContext{
+			OOPK: query.OOPKContext{
+				Measures: []query.OOPKMeasure{{IsPercentile: true, Percentile: 0.5}},
+			},
+			HLLQueryResult: []byte{0, 0, 0, 0, 0, 0, 0, 0},
+		})
+
+		hllRW := rw.(*HLLQueryResponseWriter)
+		Ω(hllRW.response.GetBytes()).Should(Equal([]byte{2, 1, 237, 172, 0, 0, 0, 0, 8, 0, 0,
+			0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
+	})
+
 	ginkgo.It("Report hll result in JsonResponseWriter should work", func() {
 		q := &query.AQLQuery{
 			Table: "trips",
 		}
 
 		oopk := query.OOPKContext{
-			// C.AGGR_HLL
-			AggregateType: 10,
+			Measures: []query.OOPKMeasure{
+				{
+					// C.AGGR_HLL
+					AggregateType: 10,
+				},
+			},
 		}
 
 		data, err := ioutil.ReadFile("../testing/data/query/hll")
@@ -254,6 +349,141 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(rw.(*JSONQueryResponseWriter).response.Errors[1]).Should(BeNil())
 	})
 
+	ginkgo.It("FlatJSONQueryResponseWriter should work", func() {
+		rw := getReponseWriter(ContentTypeFlatJSON, 2)
+		rw.ReportError(0, "trips", errors.New("test err"), http.StatusBadRequest)
+		rw.ReportResult(1, &query.AQLQueryContext{
+			Query: &query.AQLQuery{
+				Table:      "trips",
+				Dimensions: []query.Dimension{{Expr: "request_at", TimeUnit: "day"}, {Expr: "status"}},
+				Measures:   []query.Measure{{Expr: "count(*)"}},
+			},
+			Results: queryCom.AQLQueryResult{
+				"17000": map[string]interface{}{"NULL": 1.0, "completed": nil},
+			},
+		})
+		rw.ReportStats(1, &query.AQLQueryContext{Stats: query.QueryStats{Device: 1}})
+		Ω(rw.GetStatusCode()).Should(Equal(http.StatusBadRequest))
+
+		recorder := httptest.NewRecorder()
+		rw.Respond(recorder)
+		Ω(recorder.Code).Should(Equal(http.StatusBadRequest))
+		Ω(recorder.Body.String()).Should(MatchJSON(`{
+			"results": [
+				null,
+				{
+					"headers": ["request_at", "status", "count(*)"],
+					"rows": [[17000, null, 1], [17000, "completed", null]]
+				}
+			],
+			"errors": [{}, null],
+			"stats": [null, {"device": 1, "liveBatches": 0, "archiveBatches": 0, "liveBatchesSkipped": 0,
+				"archiveBatchesSkipped": 0, "archiveBatchesPrefiltered": 0, "rowsScanned": 0, "bytesTransferred": 0,
+				"compileTime": 0, "transferTime": 0, "kernelTime": 0, "postprocessTime": 0, "resultCacheHit": false}]
+		}`))
+	})
+
+	ginkgo.It("CSVQueryResponseWriter should work", func() {
+		rw := getReponseWriter(ContentTypeCSV, 2)
+		rw.ReportQueryContext(nil)
+		rw.ReportResult(0, &query.AQLQueryContext{
+			Query: &query.AQLQuery{
+				Table:      "trips",
+				Dimensions: []query.Dimension{{Expr: "request_at"}, {Expr: "city_id"}},
+			},
+			Results: queryCom.AQLQueryResult{
+				queryCom.HeadersKey: []string{"request_at", "city_id"},
+				queryCom.MatrixDataKey: [][]interface{}{
+					{"1500000000", "NULL"},
+					{"1500000001", "a,b"},
+				},
+			},
+		})
+		rw.ReportStats(0, &query.AQLQueryContext{})
+		rw.ReportError(1, "trips", errors.New("test err"), http.StatusBadRequest)
+		Ω(rw.GetStatusCode()).Should(Equal(http.StatusBadRequest))
+
+		recorder := httptest.NewRecorder()
+		rw.Respond(recorder)
+		Ω(recorder.Code).Should(Equal(http.StatusBadRequest))
+		Ω(recorder.Header().Get("Content-Type")).Should(Equal(ContentTypeCSV))
+		Ω(recorder.Body.String()).Should(Equal("request_at,city_id\n1500000000,\n1500000001,\"a,b\"\n\nerror\ntest err\n"))
+	})
+
+	ginkgo.It("ArrowQueryResponseWriter should work", func() {
+		qc := &query.AQLQueryContext{
+			Query: &query.AQLQuery{
+				Table:      "trips",
+				Dimensions: []query.Dimension{{Expr: "request_at", TimeUnit: "day"}},
+				Measures:   []query.Measure{{Expr: "count(*)"}},
+			},
+			Results: queryCom.AQLQueryResult{"17000": 1.0},
+		}
+		rw := getReponseWriter(ContentTypeArrowStream, 1)
+		rw.ReportQueryContext(qc)
+		rw.ReportResult(0, qc)
+		rw.ReportStats(0, qc)
+		Ω(qc.Error).Should(BeNil())
+
+		// the response is a plain arrow stream.
+		recorder := httptest.NewRecorder()
+		rw.Respond(recorder)
+		Ω(recorder.Code).Should(Equal(http.StatusOK))
+		Ω(recorder.Header().Get("Content-Type")).Should(Equal(ContentTypeArrowStream))
+		reader, err := ipc.NewReader(recorder.Body)
+		Ω(err).Should(BeNil())
+		Ω(reader.Schema().Fields()).Should(HaveLen(2))
+		Ω(reader.Schema().Field(0).Name).Should(Equal("request_at"))
+		Ω(reader.Schema().Field(1).Name).Should(Equal("count(*)"))
+		Ω(reader.Next()).Should(BeTrue())
+		record := reader.Record()
+		Ω(record.NumRows()).Should(BeEquivalentTo(1))
+		Ω(record.Column(0).(*array.Int64).Int64Values()).Should(Equal([]int64{17000}))
+		Ω(record.Column(1).(*array.Float64).Float64Values()).Should(Equal([]float64{1}))
+		Ω(reader.Next()).Should(BeFalse())
+		Ω(reader.Err()).Should(BeNil())
+		reader.Release()
+
+		// the error replaces results of the query.
+		rw = getReponseWriter(ContentTypeArrowStream, 1)
+		rw.ReportResult(0, qc)
+		rw.ReportError(0, "trips", errors.New("test err"), http.StatusBadRequest)
+		recorder = httptest.NewRecorder()
+		rw.Respond(recorder)
+		Ω(recorder.Code).Should(Equal(http.StatusBadRequest))
+		Ω(recorder.Header().Get("Content-Type")).Should(Equal(ContentTypeArrowStream))
+		reader, err = ipc.NewReader(recorder.Body)
+		Ω(err).Should(BeNil())
+		Ω(reader.Schema().Fields()).Should(BeEmpty())
+		metadata := reader.Schema().Metadata()
+		Ω(metadata.Keys()).Should(Equal([]string{"error"}))
+		Ω(metadata.Values()).Should(Equal([]string{"test err"}))
+		Ω(reader.Next()).Should(BeFalse())
+		Ω(reader.Err()).Should(BeNil())
+		reader.Release()
+	})
+
+	ginkgo.It("HandleAQL should fail arrow stream requests with multiple queries", func() {
+		hostPort := testServer.Listener.Addr().String()
+		query := `
+			{
+			  "queries": [
+				{"table": "trips", "measures": [{"sqlExpression": "count(*)"}]},
+				{"table": "trips", "measures": [{"sqlExpression": "count(*)"}]}
+			  ]
+			}
+		`
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/aql", hostPort), bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		req.Header.Set("Accept", ContentTypeArrowStream)
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(string(bs)).Should(ContainSubstring(ErrMsgMultipleQueriesInArrowStream))
+	})
+
 	ginkgo.It("Verbose should work", func() {
 		hostPort := testServer.Listener.Addr().String()
 		query := `
@@ -292,4 +522,45 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(string(bs)).Should(ContainSubstring("Unsigned"))
 		Ω(string(bs)).Should(ContainSubstring("allBatches"))
 	})
+
+	ginkgo.It("HandleAQL should execute queries concurrently and report in request order", func() {
+		queryHandler := NewQueryHandler(memStore, nil, common.QueryConfig{
+			DeviceMemoryUtilization: 1.0,
+			MaxConcurrentQueries:    2,
+		})
+		router := mux.NewRouter()
+		router.HandleFunc("/aql", queryHandler.HandleAQL).Methods(http.MethodGet, http.MethodPost)
+		server := httptest.NewServer(WithPanicHandling(router))
+		defer server.Close()
+
+		validQuery := `{
+			"measures": [{"sqlExpression": "count(*)"}],
+			"table": "trips",
+			"timeFilter": {"column": "trips.request_at", "from": "-6d"},
+			"dimensions": [{"sqlExpression": "trips.request_at", "timeBucketizer": "day", "timeUnit": "second"}]
+		}`
+		invalidQuery := `{
+			"measures": [{"sqlExpression": "count(*)"}],
+			"table": "unknown_table"
+		}`
+		query := fmt.Sprintf(`{"queries": [%s, %s, %s]}`, validQuery, invalidQuery, validQuery)
+		resp, err := http.Post(fmt.Sprintf("http://%s/aql", server.Listener.Addr().String()), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		var response struct {
+			Results []interface{} `json:"results"`
+			Errors  []interface{} `json:"errors"`
+		}
+		Ω(json.Unmarshal(bs, &response)).Should(BeNil())
+		Ω(response.Results).Should(HaveLen(3))
+		Ω(response.Errors).Should(HaveLen(3))
+		Ω(response.Errors[0]).Should(BeNil())
+		Ω(response.Errors[1]).ShouldNot(BeNil())
+		Ω(response.Errors[2]).Should(BeNil())
+		Ω(response.Results[0]).Should(Equal(map[string]interface{}{}))
+		Ω(response.Results[2]).Should(Equal(map[string]interface{}{}))
+	})
 })
@@ -0,0 +1,77 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// QueryHistoryEntry records an executed query.
+type QueryHistoryEntry struct {
+	// Rpc-Caller header of the request.
+	Caller string `json:"caller"`
+	Table  string `json:"table"`
+	// Start time of the request.
+	StartTime time.Time `json:"startTime"`
+	// Duration of executing and postprocessing the query in milliseconds.
+	Duration   float64 `json:"duration"`
+	StatusCode int     `json:"statusCode"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// QueryHistory is a ring buffer of recently executed queries.
+type QueryHistory struct {
+	sync.RWMutex
+	entries []QueryHistoryEntry
+	// index of the entry to be overwritten next.
+	next int
+	// whether entries are all filled.
+	full bool
+}
+
+// NewQueryHistory creates a QueryHistory keeping up to capacity queries.
+func NewQueryHistory(capacity int) *QueryHistory {
+	return &QueryHistory{
+		entries: make([]QueryHistoryEntry, capacity),
+	}
+}
+
+// Add adds the entry to history, overwriting the oldest entry if full.
+func (h *QueryHistory) Add(entry QueryHistoryEntry) {
+	h.Lock()
+	defer h.Unlock()
+	h.entries[h.next] = entry
+	h.next++
+	if h.next == len(h.entries) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// List returns entries in history from the most recent to the oldest.
+func (h *QueryHistory) List() []QueryHistoryEntry {
+	h.RLock()
+	defer h.RUnlock()
+	size := h.next
+	if h.full {
+		size = len(h.entries)
+	}
+	entries := make([]QueryHistoryEntry, size)
+	for i := range entries {
+		entries[i] = h.entries[(h.next-1-i+len(h.entries))%len(h.entries)]
+	}
+	return entries
+}
@@ -0,0 +1,67 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	"github.com/uber/aresdb/query"
+)
+
+var _ = ginkgo.Describe("QueryHistory", func() {
+	ginkgo.It("keeps the most recent queries", func() {
+		history := NewQueryHistory(3)
+		Ω(history.List()).Should(BeEmpty())
+
+		for _, table := range []string{"t1", "t2"} {
+			history.Add(QueryHistoryEntry{Table: table})
+		}
+		Ω(history.List()).Should(Equal([]QueryHistoryEntry{{Table: "t2"}, {Table: "t1"}}))
+
+		for _, table := range []string{"t3", "t4", "t5"} {
+			history.Add(QueryHistoryEntry{Table: table})
+		}
+		Ω(history.List()).Should(Equal([]QueryHistoryEntry{{Table: "t5"}, {Table: "t4"}, {Table: "t3"}}))
+	})
+
+	ginkgo.It("records queries reported by query handler", func() {
+		handler := NewQueryHandler(new(memMocks.MemStore), nil, common.QueryConfig{
+			QueryHistorySize:                 10,
+			SlowQueryThresholdInMilliseconds: 1,
+		})
+		start := time.Unix(0, 0)
+		aqlRequest := AQLRequest{Origin: "test"}
+
+		qc := &query.AQLQueryContext{}
+		qc.Stats.PostprocessTime = 1
+		handler.recordQuery(aqlRequest, query.AQLQuery{Table: "t1"}, qc, 0, start, time.Millisecond)
+		qc = &query.AQLQueryContext{Error: errors.New("compile error")}
+		handler.recordQuery(aqlRequest, query.AQLQuery{Table: "t2"}, qc, http.StatusBadRequest, start, time.Millisecond)
+		qc = &query.AQLQueryContext{Error: errors.New("postprocess error")}
+		handler.recordQuery(aqlRequest, query.AQLQuery{Table: "t3"}, qc, 0, start, time.Millisecond)
+
+		Ω(handler.GetQueryHistory().List()).Should(Equal([]QueryHistoryEntry{
+			{Caller: "test", Table: "t3", StartTime: start, Duration: 1, StatusCode: http.StatusInternalServerError, Error: "postprocess error"},
+			{Caller: "test", Table: "t2", StartTime: start, Duration: 1, StatusCode: http.StatusBadRequest, Error: "compile error"},
+			{Caller: "test", Table: "t1", StartTime: start, Duration: 2, StatusCode: http.StatusOK},
+		}))
+	})
+})
@@ -24,15 +24,18 @@ type RequestContext struct {
 	Verbose               int
 	Debug                 int
 	Profiling             string
+	Stats                 int
 	DeviceChoosingTimeout int
+	QueryTimeout          int
 	Accept                string
 	Origin                string
 }
 
 // AQLRequest represents AQL query request. Debug mode will
 // run **each batch** in synchronized mode and report time
-// for each step.
-// swagger:parameters queryAQL
+// for each step. Stats mode will return execution stats
+// of each query with results.
+// swagger:parameters queryAQL explainQuery
 type AQLRequest struct {
 	// in: query
 	Device int `query:"device,optional" json:"device"`
@@ -43,9 +46,13 @@ type AQLRequest struct {
 	// in: query
 	Profiling string `query:"profiling,optional" json:"profiling"`
 	// in: query
+	Stats int `query:"stats,optional" json:"stats"`
+	// in: query
 	Query string `query:"q,optional" json:"q"`
 	// in: query
 	DeviceChoosingTimeout int `query:"timeout,optional" json:"timeout"`
+	// in: query
+	QueryTimeout int `query:"queryTimeout,optional" json:"queryTimeout"`
 	// in: header
 	Accept string `header:"Accept,optional" json:"accept"`
 	// in: header
@@ -56,7 +63,8 @@ type AQLRequest struct {
 
 // SQLRequest represents SQL query request. Debug mode will
 // run **each batch** in synchronized mode and report time
-// for each step.
+// for each step. Stats mode will return execution stats
+// of each query with results.
 // swagger:parameters querySQL
 type SQLRequest struct {
 	// in: query
@@ -68,7 +76,11 @@ type SQLRequest struct {
 	// in: query
 	Profiling string `query:"profiling,optional" json:"profiling"`
 	// in: query
+	Stats int `query:"stats,optional" json:"stats"`
+	// in: query
 	DeviceChoosingTimeout int `query:"timeout,optional" json:"timeout"`
+	// in: query
+	QueryTimeout int `query:"queryTimeout,optional" json:"queryTimeout"`
 	// in: header
 	Accept string `header:"Accept,optional" json:"accept"`
 	// in: header
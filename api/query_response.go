@@ -24,3 +24,30 @@ type AQLResponse struct {
 	//in: body
 	Body query.AQLResponse
 }
+
+// ExplainResponse represents explainQuery response.
+// swagger:response explainResponse
+type ExplainResponse struct {
+	//in: body
+	Body struct {
+		Plans []*query.QueryPlan `json:"plans"`
+	}
+}
+
+// SchemaChangeResponse represents the response of schema change statements
+// of querySQL.
+// swagger:response schemaChangeResponse
+type SchemaChangeResponse struct {
+	//in: body
+	Body struct {
+		Results []SchemaChangeResult `json:"results"`
+	}
+}
+
+// SchemaChangeResult tells whether a schema change statement is applied.
+// Statements with IF [NOT] EXISTS are not applied if the table already exists
+// or does not exist.
+type SchemaChangeResult struct {
+	Statement string `json:"statement"`
+	Applied   bool   `json:"applied"`
+}
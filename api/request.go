@@ -148,6 +148,10 @@ func ReadRequest(r *http.Request, obj interface{}) error {
 	return nil
 }
 
+// StatusClientClosedRequest is the non standard status code for requests
+// closed by clients before the response is written.
+const StatusClientClosedRequest = 499
+
 // ContentType defines the type of http content-type.
 type ContentType string
 
@@ -158,4 +162,10 @@ const (
 	ContentTypeHyperLogLog = "application/hll"
 	// ContentTypeJSON defines the json content type.
 	ContentTypeJSON = "application/json"
+	// ContentTypeFlatJSON defines the flat json query result content type.
+	ContentTypeFlatJSON = "application/flat-json"
+	// ContentTypeCSV defines the csv query result content type.
+	ContentTypeCSV = "text/csv"
+	// ContentTypeArrowStream defines the Apache Arrow IPC stream query result content type.
+	ContentTypeArrowStream = "application/vnd.apache.arrow.stream"
 )
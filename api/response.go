@@ -16,7 +16,11 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/uber/aresdb/utils"
 )
@@ -135,3 +139,24 @@ func RespondWithBadRequest(w http.ResponseWriter, err error) {
 		Cause: err,
 	})
 }
+
+// RespondWithTooManyRequests responds with StatusTooManyRequests as code, and
+// the time to wait before retrying in Retry-After header.
+func RespondWithTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := setRetryAfter(w, retryAfter)
+	RespondWithError(w, utils.APIError{
+		Code:    http.StatusTooManyRequests,
+		Message: fmt.Sprintf("%s, retry after %d seconds", ErrMsgTooManyQueries, seconds),
+	})
+}
+
+// setRetryAfter sets Retry-After header to retryAfter rounded up to seconds,
+// and returns the seconds.
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) int {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return seconds
+}
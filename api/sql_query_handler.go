@@ -15,13 +15,17 @@
 package api
 
 import (
+	"github.com/uber/aresdb/metastore"
 	"github.com/uber/aresdb/query"
+	"github.com/uber/aresdb/query/sql/tree"
 	"github.com/uber/aresdb/utils"
 	"net/http"
 )
 
 // HandleSQL swagger:route POST /query/sql querySQL
-// query in SQL
+// query in SQL, or change schema by CREATE TABLE, DROP TABLE and
+// ALTER TABLE ADD/DROP COLUMN statements, or show tables and columns by SHOW
+// TABLES, SHOW COLUMNS, DESCRIBE and SHOW CREATE TABLE statements
 //
 // Consumes:
 //    - application/json
@@ -29,6 +33,9 @@ import (
 //
 // Produces:
 //    - application/json
+//    - application/flat-json
+//    - text/csv
+//    - application/vnd.apache.arrow.stream
 //
 // Responses:
 //    default: errorResponse
@@ -47,16 +54,33 @@ func (handler *QueryHandler) HandleSQL(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var aqlQueries []query.AQLQuery
+	// number of EXPLAIN statements, which can not be mixed with queries.
+	var numExplains int
+	// schema change statements, which can not be mixed with queries.
+	var schemaChanges []tree.IStatement
+	// metadata statements, which can not be mixed with queries.
+	var metadataStatements []tree.IStatement
 	if sqlRequest.Body.Queries != nil {
 		aqlQueries = make([]query.AQLQuery, len(sqlRequest.Body.Queries))
 		startTs := utils.Now()
 		for i, sqlQuery := range sqlRequest.Body.Queries {
-			parsedAQLQuery, err := query.Parse(sqlQuery, utils.GetLogger())
+			statement, err := query.ParseStatement(sqlQuery, utils.GetLogger())
 			if err != nil {
 				RespondWithBadRequest(w, err)
 				return
 			}
-			aqlQueries[i] = *parsedAQLQuery
+			if statement.DDL != nil {
+				schemaChanges = append(schemaChanges, statement.DDL)
+				continue
+			}
+			if statement.Metadata != nil {
+				metadataStatements = append(metadataStatements, statement.Metadata)
+				continue
+			}
+			if statement.Explain {
+				numExplains++
+			}
+			aqlQueries[i] = *statement.Query
 		}
 		sqlParseTimer := utils.GetRootReporter().GetTimer(utils.QuerySQLParsingLatency)
 		duration := utils.Now().Sub(startTs)
@@ -64,17 +88,102 @@ func (handler *QueryHandler) HandleSQL(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	if len(schemaChanges) > 0 {
+		if len(schemaChanges) < len(aqlQueries) {
+			RespondWithBadRequest(w, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: ErrMsgSchemaChangeMixedWithQueries,
+			})
+			return
+		}
+		handler.changeSchema(sqlRequest.Body.Queries, schemaChanges, w)
+		return
+	}
+
+	if len(metadataStatements) > 0 {
+		if len(metadataStatements) < len(aqlQueries) {
+			RespondWithBadRequest(w, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: ErrMsgMetadataMixedWithQueries,
+			})
+			return
+		}
+		handler.showMetadata(metadataStatements, sqlRequest.Accept, w)
+		return
+	}
+
 	aqlRequest := AQLRequest{
 		Device:                sqlRequest.Device,
 		Verbose:               sqlRequest.Verbose,
 		Debug:                 sqlRequest.Debug,
 		Profiling:             sqlRequest.Profiling,
+		Stats:                 sqlRequest.Stats,
 		DeviceChoosingTimeout: sqlRequest.DeviceChoosingTimeout,
+		QueryTimeout:          sqlRequest.QueryTimeout,
 		Accept:                sqlRequest.Accept,
 		Origin:                sqlRequest.Origin,
 		Body: query.AQLRequest{
 			Queries: aqlQueries,
 		},
 	}
+
+	if numExplains > 0 {
+		if numExplains < len(aqlQueries) {
+			RespondWithBadRequest(w, utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: ErrMsgExplainMixedWithQueries,
+			})
+			return
+		}
+		handler.explainQueries(aqlRequest, w)
+		return
+	}
 	handler.handleAQLInternal(aqlRequest, w, r)
 }
+
+// changeSchema applies schema change statements in order, statements after the
+// failed one are not applied. sqls are the statements as in the request.
+func (handler *QueryHandler) changeSchema(sqls []string, statements []tree.IStatement, w http.ResponseWriter) {
+	if handler.schemaMutator == nil {
+		RespondWithBadRequest(w, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: ErrMsgSchemaChangeNotAllowed,
+		})
+		return
+	}
+
+	var response SchemaChangeResponse
+	response.Body.Results = make([]SchemaChangeResult, len(statements))
+	for i, statement := range statements {
+		applied, err := query.ExecuteDDL(statement, handler.schemaMutator, metastore.NewTableSchameValidator())
+		if err != nil {
+			RespondWithBadRequest(w, err)
+			return
+		}
+		response.Body.Results[i] = SchemaChangeResult{Statement: sqls[i], Applied: applied}
+	}
+	RespondWithJSONObject(w, response.Body)
+}
+
+// showMetadata responds with results of metadata statements in the accepted
+// format of query results.
+func (handler *QueryHandler) showMetadata(statements []tree.IStatement, accept string, w http.ResponseWriter) {
+	if accept == ContentTypeHyperLogLog {
+		RespondWithBadRequest(w, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: ErrMsgMetadataInHyperLogLog,
+		})
+		return
+	}
+
+	responseWriter := getReponseWriter(accept, len(statements))
+	for i, statement := range statements {
+		qc := query.ExecuteMetadataStatement(statement, handler.memStore)
+		if qc.Error != nil {
+			responseWriter.ReportError(i, qc.Query.Table, qc.Error, http.StatusBadRequest)
+			continue
+		}
+		responseWriter.ReportResult(i, qc)
+	}
+	responseWriter.Respond(w)
+}
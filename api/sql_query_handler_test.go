@@ -24,6 +24,7 @@ import (
 	"github.com/uber/aresdb/memstore"
 	memMocks "github.com/uber/aresdb/memstore/mocks"
 	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
 
 	"github.com/gorilla/mux"
 	"github.com/onsi/ginkgo"
@@ -62,7 +63,7 @@ var _ = ginkgo.Describe("QueryHandler SQL", func() {
 	var memStore *memMocks.MemStore
 	ginkgo.BeforeEach(func() {
 		memStore = CreateMemStore(testSchema, 0, nil, CreateMockDiskStore())
-		queryHandler := NewQueryHandler(memStore, common.QueryConfig{
+		queryHandler := NewQueryHandler(memStore, nil, common.QueryConfig{
 			DeviceMemoryUtilization: 1.0,
 		})
 		testRouter := mux.NewRouter()
@@ -141,4 +142,64 @@ var _ = ginkgo.Describe("QueryHandler SQL", func() {
 		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
 		Ω(string(bs)).Should(ContainSubstring("Bad request: missing/invalid parameter"))
 	})
+
+	ginkgo.It("HandleSQL should change schema", func() {
+		hostPort := testServer.Listener.Addr().String()
+		query := `{"queries": ["ALTER TABLE trips DROP COLUMN status"]}`
+		resp, err := http.Post(fmt.Sprintf("http://%s/sql", hostPort), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(string(bs)).Should(ContainSubstring(ErrMsgSchemaChangeNotAllowed))
+
+		query = `{"queries": ["ALTER TABLE trips DROP COLUMN status", "SELECT count(*) FROM trips"]}`
+		resp, err = http.Post(fmt.Sprintf("http://%s/sql", hostPort), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(string(bs)).Should(ContainSubstring(ErrMsgSchemaChangeMixedWithQueries))
+
+		mutator := &metaMocks.TableSchemaMutator{}
+		mutator.On("ListTables").Return([]string{"trips"}, nil)
+		mutator.On("GetTable", "trips").Return(&metaCom.Table{
+			Name:              "trips",
+			Columns:           []metaCom.Column{{Name: "id", Type: metaCom.Int32}},
+			PrimaryKeyColumns: []int{0},
+		}, nil)
+		mutator.On("DeleteTable", "trips").Return(nil).Once()
+		queryHandler := NewQueryHandler(memStore, mutator, common.QueryConfig{
+			DeviceMemoryUtilization: 1.0,
+		})
+		query = `{"queries": ["DROP TABLE trips", "DROP TABLE IF EXISTS trips_v2"]}`
+		w := httptest.NewRecorder()
+		queryHandler.HandleSQL(w, httptest.NewRequest(http.MethodPost, "/sql", bytes.NewBuffer([]byte(query))))
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.String()).Should(MatchJSON(`{"results":[{"statement":"DROP TABLE trips","applied":true},` +
+			`{"statement":"DROP TABLE IF EXISTS trips_v2","applied":false}]}`))
+		mutator.AssertExpectations(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("HandleSQL should show tables and columns", func() {
+		hostPort := testServer.Listener.Addr().String()
+		query := `{"queries": ["SHOW TABLES", "DESCRIBE trips", "DESCRIBE unknown"]}`
+		resp, err := http.Post(fmt.Sprintf("http://%s/sql", hostPort), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(string(bs)).Should(ContainSubstring(`"results":[{"headers":["table"],"matrixData":[["trips"]]},` +
+			`{"headers":["column","type","primaryKey","enumCases"],"matrixData":[["request_at","Uint32","false",null],` +
+			`["fare_total","Flaot","false",null],["city_id","Uint16","false",null],["status","SmallEnum","false",0]]},null]`))
+		Ω(string(bs)).Should(ContainSubstring("unknown table unknown"))
+
+		query = `{"queries": ["SHOW TABLES", "SELECT count(*) FROM trips"]}`
+		resp, err = http.Post(fmt.Sprintf("http://%s/sql", hostPort), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(string(bs)).Should(ContainSubstring(ErrMsgMetadataMixedWithQueries))
+	})
 })
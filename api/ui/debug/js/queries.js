@@ -0,0 +1,46 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+function initQueryHistory() {
+    $.ajax({
+            url: "/dbg/queries",
+            success: function (body) {
+                renderQueryTable(body);
+            },
+            error: function (xhr) {
+                alert(xhr.responseText)
+            }
+        }
+    )
+}
+
+function renderQueryTable(data) {
+    $('#queries-table').DataTable({
+        paging: true,
+        pageLength: 50,
+        autoWidth: false,
+        order: [[0, "desc"]],
+        aoColumns: [
+            {title: "Start Time", data: "startTime"},
+            {title: "Caller", data: "caller"},
+            {title: "Table", data: "table"},
+            {title: "Duration (ms)", data: "duration", render: function (data) {
+                return data.toFixed(2);
+            }},
+            {title: "Status", data: "statusCode"},
+            {title: "Error", data: "error", defaultContent: ""}
+        ],
+        aaData: data
+    });
+}
@@ -156,8 +156,13 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	// create enum handler
 	enumHandler := api.NewEnumHandler(memStore, metaStore)
 
-	// create query hanlder.
-	queryHandler := api.NewQueryHandler(memStore, cfg.Query)
+	// create query hanlder, schema managed by controller can not be changed
+	// through SQL.
+	var schemaMutator metastore.TableSchemaMutator = metaStore
+	if cfg.Cluster.Enable {
+		schemaMutator = nil
+	}
+	queryHandler := api.NewQueryHandler(memStore, schemaMutator, cfg.Query)
 
 	// create health check handler.
 	healthCheckHandler := api.NewHealthCheckHandler()
@@ -31,6 +31,36 @@ type QueryConfig struct {
 	// timeout in seconds for choosing device
 	DeviceChoosingTimeout int            `yaml:"device_choosing_timeout"`
 	TimezoneTable         TimezoneConfig `yaml:"timezone_table"`
+	// default timeout in seconds for executing a query after a device is
+	// chosen, queries are not stopped if not positive
+	QueryTimeout int `yaml:"query_timeout"`
+	// max number of queries of one request executed concurrently,
+	// queries are executed one after another if not greater than 1
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+	// number of query results over archived data to cache,
+	// results are not cached if not positive
+	ResultCacheSize int `yaml:"result_cache_size"`
+	// number of recently executed queries kept for the debug port,
+	// no query is kept if not positive
+	QueryHistorySize int `yaml:"query_history_size"`
+	// queries running longer than this in milliseconds are logged with
+	// query text and stats, no query is logged if not positive
+	SlowQueryThresholdInMilliseconds int `yaml:"slow_query_threshold_in_milliseconds"`
+	// limits of queries from each caller identified by Rpc-Caller header
+	CallerLimits map[string]CallerLimitConfig `yaml:"caller_limits"`
+	// limits of queries from each caller not in CallerLimits
+	DefaultCallerLimit CallerLimitConfig `yaml:"default_caller_limit"`
+}
+
+// CallerLimitConfig is the limits of queries from a caller.
+type CallerLimitConfig struct {
+	// max number of queries executed concurrently, unlimited if not positive
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+	// max number of queries per second, unlimited if not positive
+	MaxQueriesPerSecond float64 `yaml:"max_queries_per_second"`
+	// max device memory in MB reserved by queries at the same time,
+	// unlimited if not positive
+	MaxDeviceMemoryInMB int `yaml:"max_device_memory_in_mb"`
 }
 
 // DiskStoreConfig is the static configuration for disk store.
@@ -273,6 +273,10 @@ func (m *memStoreImpl) Archive(table string, shardID int, cutoff uint32, reporte
 		return err
 	}
 
+	for day := range patchByDay {
+		m.notifyArchiveChange(table, shardID, int(day), int(day)+1)
+	}
+
 	// Wait for queries in other goroutines to prevent archiving from prematurely purging the old version.
 	oldVersion.Users.Wait()
 
@@ -71,8 +71,12 @@ func (m *memStoreImpl) Backfill(table string, shardID int, reporter BackfillJobD
 		return err
 	}
 
-	if err = shard.createNewArchiveStoreVersionForBackfill(
-		backfillPatches, reporter, jobKey); err != nil {
+	// Batches of some days may have been switched to new versions even if it fails.
+	err = shard.createNewArchiveStoreVersionForBackfill(backfillPatches, reporter, jobKey)
+	for day := range backfillPatches {
+		m.notifyArchiveChange(table, shardID, int(day), int(day)+1)
+	}
+	if err != nil {
 		return err
 	}
 
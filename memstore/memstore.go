@@ -64,10 +64,18 @@ type MemStore interface {
 	// Purge is the process to purge out of retention archive batches
 	Purge(table string, shardID, batchIDStart, batchIDEnd int, reporter PurgeJobDetailReporter) error
 
+	// AddArchiveChangeListener registers a listener to be called after archive batches of a table
+	// shard are changed by archiving, backfill or purge.
+	AddArchiveChangeListener(listener ArchiveChangeListener)
+
 	// Provide exclusive access to read/write data protected by MemStore.
 	utils.RWLocker
 }
 
+// ArchiveChangeListener is called with archive batches [batchIDStart, batchIDEnd) of
+// the table shard after they are changed.
+type ArchiveChangeListener func(table string, shardID, batchIDStart, batchIDEnd int)
+
 // memStoreImpl implements the MemStore interface.
 type memStoreImpl struct {
 	// memStoreImpl mutex is used to protect the TableShards and TableSchemas maps.
@@ -98,6 +106,9 @@ type memStoreImpl struct {
 
 	// each MemStore should only have one scheduler instance.
 	scheduler Scheduler
+
+	// listeners for changes of archive batches, protected by the mutex.
+	archiveChangeListeners []ArchiveChangeListener
 }
 
 func getTableShardKey(tableName string, shardID int) string {
@@ -227,6 +238,23 @@ func (m *memStoreImpl) GetScheduler() Scheduler {
 	return m.scheduler
 }
 
+// AddArchiveChangeListener registers a listener for changes of archive batches.
+func (m *memStoreImpl) AddArchiveChangeListener(listener ArchiveChangeListener) {
+	m.Lock()
+	defer m.Unlock()
+	m.archiveChangeListeners = append(m.archiveChangeListeners, listener)
+}
+
+// notifyArchiveChange calls listeners with the changed archive batches of the table shard.
+func (m *memStoreImpl) notifyArchiveChange(table string, shardID, batchIDStart, batchIDEnd int) {
+	m.RLock()
+	listeners := m.archiveChangeListeners
+	m.RUnlock()
+	for _, listener := range listeners {
+		listener(table, shardID, batchIDStart, batchIDEnd)
+	}
+}
+
 // TryEvictBatchColumn tries to evict a column from a given table/Shard/batchID.
 // Return values are the check for column is deleted or not and error.
 func (m *memStoreImpl) TryEvictBatchColumn(table string, shardID int, batchID int32, columnID int) (bool, error) {
@@ -23,6 +23,11 @@ type MemStore struct {
 	mock.Mock
 }
 
+// AddArchiveChangeListener provides a mock function with given fields: listener
+func (_m *MemStore) AddArchiveChangeListener(listener memstore.ArchiveChangeListener) {
+	_m.Called(listener)
+}
+
 // Archive provides a mock function with given fields: table, shardID, cutoff, reporter
 func (_m *MemStore) Archive(table string, shardID int, cutoff uint32, reporter memstore.ArchiveJobDetailReporter) error {
 	ret := _m.Called(table, shardID, cutoff, reporter)
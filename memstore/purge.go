@@ -61,6 +61,7 @@ func (m *memStoreImpl) Purge(tableName string, shardID, batchIDStart, batchIDEnd
 		}
 	}
 	currentVersion.Unlock()
+	m.notifyArchiveChange(tableName, shardID, batchIDStart, batchIDEnd)
 
 	// delete metadata of batches within range
 	err = shard.metaStore.PurgeArchiveBatches(tableName, shardID, batchIDStart, batchIDEnd)
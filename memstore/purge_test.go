@@ -122,4 +122,16 @@ var _ = ginkgo.Describe("Purge", func() {
 		Ω(jobDetail.Stage).Should(BeEquivalentTo("complete"))
 	})
 
+	ginkgo.It("purge should notify archive change listeners", func() {
+		var changes [][]interface{}
+		memStore.AddArchiveChangeListener(func(table string, shardID, batchIDStart, batchIDEnd int) {
+			changes = append(changes, []interface{}{table, shardID, batchIDStart, batchIDEnd})
+		})
+
+		diskStore.On("DeleteBatches", testTable, testShardID, 0, 2).
+			Return(1, nil).Once()
+		err := memStore.Purge(testTable, testShardID, 0, 2, func(key string, mutator PurgeJobDetailMutator) {})
+		Ω(err).Should(BeNil())
+		Ω(changes).Should(Equal([][]interface{}{{testTable, testShardID, 0, 2}}))
+	})
 })
@@ -51,7 +51,7 @@ int filter(TransformContext ctx, InputVector input,
            uint8_t *boolVector, uint32_t *baseCounts, uint32_t startCount);
 
 // reduce binds aggregate function type and data type from
-// aggFunc.
+// aggFunc. Only keys are reduced if inputValues is null.
 int reduce(DimensionColumnVector inputKeys, uint8_t *inputValues,
            DimensionColumnVector outputKeys, uint8_t *outputValues,
            int valueBytes, int length, AggregateFunction aggFunc,
@@ -31,6 +31,9 @@ type Dimension struct {
 	// designated time column from the main table is used as the expresssion.
 	Expr string `json:"sqlExpression"`
 	expr expr.Expr
+	// Reverse dict of string results when expr is a case expression, which is
+	// rewritten into conditional binary expressions during compilation.
+	enumReverseDict []string
 
 	// Decides how to bucketize a timestamp Dimension before grouping by.
 	// See https://github.com/uber/aresdb/wiki/aql#time_bucketizer
@@ -38,6 +41,11 @@ type Dimension struct {
 
 	TimeUnit string `json:"timeUnit,omitempty"`
 
+	// Generates every time bucket between from and to of the time filter for
+	// regular time bucketizers (e.g. 15m, hour, day), buckets without data are
+	// filled with null or 0 when FillGaps is "null" or "zero" respectively.
+	FillGaps string `json:"fillGaps,omitempty"`
+
 	// Bucketizes numeric dimensions for integers and floating point numbers.
 	NumericBucketizer NumericBucketizerDef `json:"numericBucketizer,omitempty"`
 }
@@ -68,12 +76,20 @@ type NumericBucketizerDef struct {
 type Measure struct {
 	// Alias/name of the measure, to be referenced by other (derived) measures.
 	Alias string `json:"alias,omitempty"`
-	// The SQL expression for computing the measure.
+	// The SQL expression for computing the measure. Besides a single aggregate
+	// function, it can be a derived measure combining aggregate functions and
+	// other measures by alias with arithmetic operators, e.g.
+	// sum(fare) / count(*) or completed / requested. Derived measures are
+	// computed after aggregation. A measure can also be a window function
+	// over the time dimension: cumsum(m), moving_avg(m, N buckets) or rate(m),
+	// computed within each series of the other dimensions.
 	Expr string `json:"sqlExpression"`
 	expr expr.Expr
 
 	// Row level filters to apply for this measure.
-	// The filters are ANDed togther.
+	// The filters are ANDed togther. Filters not shared by all measures only
+	// apply to values of this measure, which is not supported for min, max and
+	// percentile.
 	Filters []string `json:"rowFilters,omitempty"`
 	filters []expr.Expr
 }
@@ -108,7 +124,7 @@ type SortField struct {
 	// Name or alias of the field
 	Name string `json:"name"`
 
-	// Order the column, will be asc or desc
+	// Order the column, will be asc or desc, default is asc
 	Order string `json:"order"`
 }
 
@@ -123,6 +139,15 @@ type AQLQuery struct {
 	// Dimensions to group by on.
 	Dimensions []Dimension `json:"dimensions,omitempty"`
 
+	// Grouping sets to aggregate by in one query, each of which is a list of
+	// dimension names (alias or expression), e.g. [["city", "product"],
+	// ["city"], []] for ROLLUP(city, product). Dimensions not in a grouping set
+	// are rolled up and reported as "_total". All Dimensions are grouped by if
+	// not specified.
+	GroupingSets [][]string `json:"groupingSets,omitempty"`
+	// Whether each dimension is grouped by in each grouping set.
+	groupingSets [][]bool
+
 	// Measures/metrics to report.
 	Measures []Measure `json:"measures"`
 
@@ -130,9 +155,20 @@ type AQLQuery struct {
 	Filters []string `json:"rowFilters,omitempty"`
 	filters []expr.Expr
 
+	// Filters to apply on aggregated groups before results are returned, e.g.
+	// count(*) > 100. Measures are referenced by alias or expression.
+	// The filters are ANDed together.
+	HavingFilters []string `json:"havingFilters,omitempty"`
+	havingFilters []expr.Expr
+
 	// Syntax sugar for specifying a time based range filter.
 	TimeFilter TimeFilter `json:"timeFilter,omitempty"`
 
+	// Compares results with the same query over the time filter shifted by
+	// CompareTo, e.g. "-1 week" or "-1 day". Each measure value is reported as
+	// the current value, the previous value and the delta between them.
+	CompareTo string `json:"compareTo,omitempty"`
+
 	// Additional supporting dimensions, these dimensions will not be grouped by,
 	// but they may be referenced in Dimensions, Measures, SupportingDimensions and SupportingMeasures.
 	SupportingDimensions []Dimension `json:"supportingDimensions,omitempty"`
@@ -140,6 +176,20 @@ type AQLQuery struct {
 	// but they may be referenced in Measures and SupportingMeasures.
 	SupportingMeasures []Measure `json:"supportingMeasures,omitempty"`
 
+	// Measures aggregated by the query engine. Measures consisting of a single
+	// aggregate function are aggregated directly, aggregate functions used by
+	// derived measures are appended.
+	aggregateMeasures []*Measure
+	// VarRefs to aggregateMeasures of aggregate functions only referenced by
+	// having filters, which are not returned in results.
+	hiddenMeasures []*expr.VarRef
+	// Post aggregation expressions for computing Measures from the values of
+	// aggregateMeasures, nil if there is no derived measure.
+	measureExprs []expr.Expr
+	// Window functions applied to Measures after aggregation, nil if there is
+	// no window measure.
+	measureWindows []*measureWindow
+
 	// Timezone to use when converting timestamp to calendar time, specified as:
 	//   - -8:00
 	//   - GMT
@@ -154,9 +204,23 @@ type AQLQuery struct {
 	// This overrides "now" (in seconds)
 	Now int64 `json:"now,omitempty"`
 
-	// Limit is the max number of rows need to be return, and only used for non-aggregation
+	// Limit is the max number of rows need to be return. For aggregation queries,
+	// it's the max number of groups to return after sorting. Without sorts, which
+	// groups are returned is arbitrary.
 	Limit int `json:"limit,omitempty"`
 
+	// Offset is the number of rows to skip before returning rows of
+	// non-aggregation queries.
+	Offset int `json:"offset,omitempty"`
+
+	// ContinuationToken is the opaque token returned with results of the
+	// previous page of a non-aggregation query. Rows are returned from where
+	// the previous page stops, Offset is applied after that.
+	ContinuationToken string `json:"continuationToken,omitempty"`
+
+	// Sorts specifies the order of results. For aggregation queries, results
+	// can be sorted by any dimension or measure, and sorted results are returned
+	// as rows in the same format as non-aggregation queries.
 	Sorts []SortField `json:"sorts, omitempty" yaml:"sorts"`
 
 	// SQLQuery
@@ -173,8 +237,29 @@ type AQLResponse struct {
 	Results      []queryCom.AQLQueryResult `json:"results"`
 	Errors       []error                   `json:"errors,omitempty"`
 	QueryContext []*AQLQueryContext        `json:"context,omitempty"`
+	// Execution stats of each query, only returned when requested.
+	Stats []*QueryStats `json:"stats,omitempty"`
 }
 
 func (d Dimension) isTimeDimension() bool {
 	return d.TimeBucketizer != "" || d.TimeUnit != ""
 }
+
+// name returns the alias of the dimension if specified, otherwise the
+// dimension expression itself.
+func (d Dimension) name() string {
+	if d.Alias != "" {
+		return d.Alias
+	}
+	return d.Expr
+}
+
+// name returns the alias of the measure if specified, otherwise the
+// measure expression itself. It's used as the key of the measure in results
+// of queries with multiple measures.
+func (m Measure) name() string {
+	if m.Alias != "" {
+		return m.Alias
+	}
+	return m.Expr
+}
@@ -69,6 +69,7 @@ func (e *DummyBatchExecutorImpl) postExec(start time.Time) {
 type BatchExecutorImpl struct {
 	qc                  *AQLQueryContext
 	batchID             int32
+	shardID             int
 	isLastBatch         bool
 	customFilterFunc    customFilterExecutor
 	stream              unsafe.Pointer
@@ -83,6 +84,7 @@ func NewBatchExecutor(qc *AQLQueryContext, batchID int32, customFilterFunc custo
 			BatchExecutorImpl: &BatchExecutorImpl{
 				qc:               qc,
 				batchID:          batchID,
+				shardID:          qc.paging.shardID,
 				customFilterFunc: customFilterFunc,
 				stream:           stream,
 			},
@@ -167,8 +169,11 @@ func (e *BatchExecutorImpl) join() {
 func (e *BatchExecutorImpl) evalMeasures() {
 	// measure evaluation.
 	e.qc.doProfile(func() {
-		measureExprRootAction := e.qc.OOPK.currentBatch.makeWriteToMeasureVectorAction(e.qc.OOPK.AggregateType, e.qc.OOPK.MeasureBytes)
-		e.qc.OOPK.currentBatch.processExpression(e.qc.OOPK.Measure, nil, e.qc.TableScanners, e.qc.OOPK.foreignTables, e.stream, e.qc.Device, measureExprRootAction)
+		measureOffsets := getMeasureStartOffsets(e.qc.OOPK.Measures, e.qc.OOPK.currentBatch.resultCapacity)
+		for i, measure := range e.qc.OOPK.Measures {
+			measureExprRootAction := e.qc.OOPK.currentBatch.makeWriteToMeasureVectorAction(measure.AggregateType, measureOffsets[i], measure.MeasureBytes)
+			e.qc.OOPK.currentBatch.processExpression(measure.Measure, nil, e.qc.TableScanners, e.qc.OOPK.foreignTables, e.stream, e.qc.Device, measureExprRootAction)
+		}
 		e.qc.reportTimingForCurrentBatch(e.stream, &e.start, measureEvalTiming)
 	}, "measure", e.stream)
 }
@@ -198,7 +203,7 @@ func (e *BatchExecutorImpl) evalDimensions(prevResultSize int) {
 // project is to generate dimension and measure values
 func (e *BatchExecutorImpl) project() {
 	// Prepare for dimension and measure evaluation.
-	e.qc.OOPK.currentBatch.prepareForDimAndMeasureEval(e.qc.OOPK.DimRowBytes, e.qc.OOPK.MeasureBytes, e.qc.OOPK.NumDimsPerDimWidth, e.qc.OOPK.IsHLL(), e.stream)
+	e.qc.OOPK.currentBatch.prepareForDimAndMeasureEval(e.qc.OOPK.DimRowBytes, e.qc.OOPK.Measures, e.qc.OOPK.NumDimsPerDimWidth, e.qc.OOPK.IsHLL(), e.stream)
 
 	e.qc.reportTimingForCurrentBatch(e.stream, &e.start, prepareForDimAndMeasureTiming)
 
@@ -228,6 +233,14 @@ func (e *BatchExecutorImpl) reduce() {
 			e.qc.reportTimingForCurrentBatch(e.stream, &e.start, hllEvalTiming)
 		}, "hll", e.stream)
 	} else {
+		// percentile values of current batch are buffered before reduce.
+		if e.qc.OOPK.hasPercentile() {
+			e.qc.doProfile(func() {
+				e.qc.bufferPercentileValues(e.stream)
+				e.qc.reportTimingForCurrentBatch(e.stream, &e.start, percentileEvalTiming)
+			}, "percentile", e.stream)
+		}
+
 		// sort by key.
 		e.qc.doProfile(func() {
 			e.qc.OOPK.currentBatch.sortByKey(e.qc.OOPK.NumDimsPerDimWidth, e.stream, e.qc.Device)
@@ -236,7 +249,7 @@ func (e *BatchExecutorImpl) reduce() {
 
 		// reduce by key.
 		e.qc.doProfile(func() {
-			e.qc.OOPK.currentBatch.reduceByKey(e.qc.OOPK.NumDimsPerDimWidth, e.qc.OOPK.MeasureBytes, e.qc.OOPK.AggregateType, e.stream, e.qc.Device)
+			e.qc.OOPK.currentBatch.reduceByKey(e.qc.OOPK.NumDimsPerDimWidth, e.qc.OOPK.Measures, e.stream, e.qc.Device)
 			e.qc.reportTimingForCurrentBatch(e.stream, &e.start, reduceEvalTiming)
 		}, "reduce", e.stream)
 	}
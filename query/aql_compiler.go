@@ -18,6 +18,8 @@ package query
 import "C"
 
 import (
+	"bytes"
+	"regexp"
 	"sort"
 	"strings"
 	"unsafe"
@@ -55,6 +57,10 @@ const (
 	defaultTimezoneTableAlias = "__timezone_lookup"
 	geoShapeLimit             = 100
 	nonAggregationQueryLimit  = 1000
+	// maxFillGapsBuckets is the max number of time buckets to generate for filling gaps.
+	maxFillGapsBuckets = 10000
+	fillGapsWithNull   = "null"
+	fillGapsWithZero   = "zero"
 )
 
 // constants for call names.
@@ -75,12 +81,61 @@ const (
 	minCallName              = "min"
 	sumCallName              = "sum"
 	avgCallName              = "avg"
+	// percentile aggregation function estimates the percentile with t-digest sketches
+	percentileCallName = "percentile"
+	// if(cond, a, b) is rewritten to CASE WHEN cond THEN a ELSE b END
+	ifCallName = "if"
+	// coalesce(a, b, ...) returns the first non null argument
+	coalesceCallName = "coalesce"
+	// ifnull(a, b) is the same as coalesce(a, b)
+	ifNullCallName = "ifnull"
+	// starts_with and regexp_like match enum columns against the prefix or
+	// the regular expression
+	startsWithCallName = "starts_with"
+	regexpLikeCallName = "regexp_like"
+	// math functions
+	absCallName      = "abs"
+	ceilCallName     = "ceil"
+	sqrtCallName     = "sqrt"
+	lnCallName       = "ln"
+	log10CallName    = "log10"
+	powCallName      = "pow"
+	roundCallName    = "round"
+	greatestCallName = "greatest"
+	leastCallName    = "least"
+	// window functions applied to measures over the time dimension
+	cumsumCallName    = "cumsum"
+	movingAvgCallName = "moving_avg"
+	rateCallName      = "rate"
 )
 
+// mathCallOperators maps from math function names to their operators.
+var mathCallOperators = map[string]expr.Token{
+	absCallName:      expr.ABS,
+	ceilCallName:     expr.CEIL,
+	sqrtCallName:     expr.SQRT,
+	lnCallName:       expr.LN,
+	log10CallName:    expr.LOG10,
+	powCallName:      expr.POW,
+	roundCallName:    expr.ROUND,
+	greatestCallName: expr.GREATEST,
+	leastCallName:    expr.LEAST,
+}
+
 // Compile returns the compiled AQLQueryContext for data feeding and query
 // execution. Caller should check for AQLQueryContext.Error.
 func (q *AQLQuery) Compile(store memstore.MemStore, returnHLL bool) *AQLQueryContext {
-	qc := &AQLQueryContext{Query: q, ReturnHLLData: returnHLL}
+	qc := &AQLQueryContext{Query: q, ReturnHLLData: returnHLL, Stats: QueryStats{Device: -1}}
+	start := utils.Now()
+	defer func() {
+		qc.Stats.CompileTime = millisecondsSince(start)
+	}()
+
+	// the compare query is copied from the query before it's modified.
+	var compareQuery *AQLQuery
+	if q.CompareTo != "" {
+		compareQuery = q.clone()
+	}
 
 	// processTimezone might append additional joins
 	qc.processTimezone()
@@ -132,6 +187,22 @@ func (q *AQLQuery) Compile(store memstore.MemStore, returnHLL bool) *AQLQueryCon
 		return qc
 	}
 
+	// Resolve having filters and sorts of aggregation query.
+	qc.processHavingFilters()
+	if qc.Error != nil {
+		return qc
+	}
+	qc.processSorts()
+	if qc.Error != nil {
+		return qc
+	}
+
+	// Resolve offset and continuation token of non-aggregation query.
+	qc.processPagination()
+	if qc.Error != nil {
+		return qc
+	}
+
 	qc.sortUsedColumns()
 
 	qc.sortDimensionColumns()
@@ -139,6 +210,11 @@ func (q *AQLQuery) Compile(store memstore.MemStore, returnHLL bool) *AQLQueryCon
 		return qc
 	}
 
+	qc.processCompareTo(compareQuery)
+	if qc.Error != nil {
+		return qc
+	}
+
 	// TODO: VM instruction generation
 	return qc
 }
@@ -461,21 +537,295 @@ func (qc *AQLQueryContext) parseExprs() {
 
 	// Measures.
 	for i, measure := range qc.Query.Measures {
-		measure.expr, err = expr.ParseExpr(measure.Expr)
+		qc.Query.Measures[i] = qc.parseMeasure(measure)
+		if qc.Error != nil {
+			return
+		}
+	}
+	for i, measure := range qc.Query.SupportingMeasures {
+		qc.Query.SupportingMeasures[i] = qc.parseMeasure(measure)
+		if qc.Error != nil {
+			return
+		}
+	}
+
+	// Having filters.
+	qc.Query.havingFilters = make([]expr.Expr, len(qc.Query.HavingFilters))
+	for i, filter := range qc.Query.HavingFilters {
+		qc.Query.havingFilters[i], err = expr.ParseExpr(filter)
 		if err != nil {
-			qc.Error = utils.StackError(err, "Failed to parse measure: %s", measure.Expr)
+			qc.Error = utils.StackError(err, "Failed to parse having filter %s", filter)
 			return
 		}
-		measure.filters = make([]expr.Expr, len(measure.Filters))
-		for j, filter := range measure.Filters {
-			measure.filters[j], err = expr.ParseExpr(filter)
+	}
+
+	qc.expandMeasures()
+}
+
+// parseMeasure parses the expression and filters of the measure.
+func (qc *AQLQueryContext) parseMeasure(measure Measure) Measure {
+	var err error
+	measure.expr, err = expr.ParseExpr(measure.Expr)
+	if err != nil {
+		qc.Error = utils.StackError(err, "Failed to parse measure: %s", measure.Expr)
+		return measure
+	}
+	measure.filters, err = parseMeasureFilters(measure.Filters)
+	if err != nil {
+		qc.Error = err
+	}
+	return measure
+}
+
+func parseMeasureFilters(filters []string) ([]expr.Expr, error) {
+	var err error
+	parsedFilters := make([]expr.Expr, len(filters))
+	for i, filter := range filters {
+		parsedFilters[i], err = expr.ParseExpr(filter)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to parse measure filter %s", filter)
+		}
+	}
+	return parsedFilters, nil
+}
+
+// expandMeasures collects the measures to aggregate. A measure consisting of
+// a single aggregate function is aggregated directly. Other measures are
+// derived measures: references to other measures and supporting measures by
+// alias are expanded, aggregate functions in them are aggregated separately
+// and the measure is computed from the aggregated values after aggregation.
+func (qc *AQLQueryContext) expandMeasures() {
+	qc.Query.aggregateMeasures = nil
+	qc.Query.measureExprs = nil
+	qc.Query.measureWindows = nil
+	if len(qc.Query.Measures) == 0 {
+		return
+	}
+
+	// Non aggregation query.
+	if _, ok := qc.Query.Measures[0].expr.(*expr.NumberLiteral); ok {
+		for i := range qc.Query.Measures {
+			qc.Query.aggregateMeasures = append(qc.Query.aggregateMeasures, &qc.Query.Measures[i])
+		}
+		return
+	}
+
+	measureExprs := make([]expr.Expr, len(qc.Query.Measures))
+	// window measures are expanded from the expressions they apply to.
+	windowExprs := make([]expr.Expr, len(qc.Query.Measures))
+	var derived bool
+	for i := range qc.Query.Measures {
+		measure := &qc.Query.Measures[i]
+		if isWindowCall(measure.expr) {
+			window, windowExpr, err := parseMeasureWindow(measure.expr.(*expr.Call), measure)
 			if err != nil {
-				qc.Error = utils.StackError(err, "Failed to parse measure filter %s", filter)
+				qc.Error = err
 				return
 			}
+			if qc.Query.measureWindows == nil {
+				qc.Query.measureWindows = make([]*measureWindow, len(qc.Query.Measures))
+			}
+			qc.Query.measureWindows[i] = window
+			windowExprs[i] = windowExpr
+			continue
+		}
+		if isAggregateCall(measure.expr) {
+			qc.Query.aggregateMeasures = append(qc.Query.aggregateMeasures, measure)
+			measureExprs[i] = &expr.VarRef{
+				Val:      measure.name(),
+				ColumnID: len(qc.Query.aggregateMeasures) - 1,
+				ExprType: expr.Float,
+			}
+		}
+	}
+
+	for i := range qc.Query.Measures {
+		if measureExprs[i] != nil {
+			continue
+		}
+		derived = true
+		measure := &qc.Query.Measures[i]
+		e := measure.expr
+		if windowExprs[i] != nil {
+			e = windowExprs[i]
+		}
+		measureExprs[i] = qc.expandDerivedMeasure(e, measure, map[string]bool{measure.name(): true})
+		if qc.Error != nil {
+			return
+		}
+	}
+
+	// aggregate functions only referenced by having filters are aggregated as
+	// hidden measures, measures are then derived to not return them.
+	qc.Query.hiddenMeasures = nil
+	for _, filter := range qc.Query.havingFilters {
+		qc.addHiddenMeasures(filter)
+		if qc.Error != nil {
+			return
+		}
+	}
+	if len(qc.Query.hiddenMeasures) > 0 {
+		derived = true
+	}
+
+	if len(qc.Query.aggregateMeasures) == 0 {
+		qc.Error = utils.StackError(nil, "expect at least one aggregate function in measures")
+		return
+	}
+
+	if derived {
+		qc.Query.measureExprs = measureExprs
+	}
+}
+
+// expandDerivedMeasure returns the post aggregation expression of a derived
+// measure. Aggregate functions are replaced by VarRefs to aggregateMeasures,
+// and references to other measures are expanded recursively. visiting holds
+// the names of measures being expanded for detecting circular references.
+func (qc *AQLQueryContext) expandDerivedMeasure(e expr.Expr, owner *Measure, visiting map[string]bool) expr.Expr {
+	switch e := e.(type) {
+	case *expr.Call:
+		if !isAggregateCall(e) {
+			qc.Error = utils.StackError(nil, "unsupported aggregate function %s in measure %s", e.Name, owner.Expr)
+			return nil
+		}
+		name := strings.ToLower(e.Name)
+		if name == hllCallName || name == countDistinctHllCallName {
+			qc.Error = utils.StackError(nil, "hll aggregate function can not be used in derived measure %s", owner.Expr)
+			return nil
+		}
+		return qc.addAggregateMeasure(e, owner)
+	case *expr.VarRef:
+		measure := qc.findMeasureByAlias(e.Val)
+		if measure == nil {
+			qc.Error = utils.StackError(nil,
+				"unknown measure %s in measure %s, columns must be used within aggregate functions", e.Val, owner.Expr)
+			return nil
+		}
+		if visiting[measure.Alias] {
+			qc.Error = utils.StackError(nil, "circular reference of measure %s", measure.Alias)
+			return nil
+		}
+		visiting[measure.Alias] = true
+		defer delete(visiting, measure.Alias)
+		return &expr.ParenExpr{Expr: qc.expandDerivedMeasure(measure.expr, measure, visiting)}
+	case *expr.NumberLiteral:
+		return e
+	case *expr.ParenExpr:
+		return &expr.ParenExpr{Expr: qc.expandDerivedMeasure(e.Expr, owner, visiting)}
+	case *expr.UnaryExpr:
+		if e.Op != expr.UNARY_MINUS {
+			break
+		}
+		return &expr.UnaryExpr{Op: e.Op, Expr: qc.expandDerivedMeasure(e.Expr, owner, visiting)}
+	case *expr.BinaryExpr:
+		switch e.Op {
+		case expr.ADD, expr.SUB, expr.MUL, expr.DIV:
+			lhs := qc.expandDerivedMeasure(e.LHS, owner, visiting)
+			if qc.Error != nil {
+				return nil
+			}
+			return &expr.BinaryExpr{Op: e.Op, LHS: lhs, RHS: qc.expandDerivedMeasure(e.RHS, owner, visiting)}
+		}
+	}
+	qc.Error = utils.StackError(nil, "unsupported expression %s in derived measure %s", e.String(), owner.Expr)
+	return nil
+}
+
+// addAggregateMeasure appends the aggregate function with the filters of the
+// measure it belongs to into aggregateMeasures if it's not there yet, and
+// returns the VarRef to it.
+func (qc *AQLQueryContext) addAggregateMeasure(aggregate *expr.Call, owner *Measure) expr.Expr {
+	index := -1
+	for i, measure := range qc.Query.aggregateMeasures {
+		if measure.expr.String() == aggregate.String() && isSameFilters(measure.filters, owner.filters) {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		// Filters are parsed again to not share the ASTs, which will be rewritten
+		// for each aggregate measure during type resolution.
+		filters, err := parseMeasureFilters(owner.Filters)
+		if err != nil {
+			qc.Error = err
+			return nil
 		}
-		qc.Query.Measures[i] = measure
+		qc.Query.aggregateMeasures = append(qc.Query.aggregateMeasures, &Measure{
+			Expr:    aggregate.String(),
+			expr:    aggregate,
+			Filters: owner.Filters,
+			filters: filters,
+		})
+		index = len(qc.Query.aggregateMeasures) - 1
 	}
+
+	return &expr.VarRef{
+		Val:      aggregate.String(),
+		ColumnID: index,
+		ExprType: expr.Float,
+	}
+}
+
+// addHiddenMeasures adds aggregate functions in the having filter not matching
+// any measure into aggregateMeasures as hidden measures.
+func (qc *AQLQueryContext) addHiddenMeasures(filter expr.Expr) {
+	expr.WalkFunc(filter, func(e expr.Expr) {
+		if qc.Error != nil || !isAggregateCall(e) || qc.findMeasure(e) >= 0 || qc.findHiddenMeasure(e) >= 0 {
+			return
+		}
+
+		call := e.(*expr.Call)
+		name := strings.ToLower(call.Name)
+		if name == hllCallName || name == countDistinctHllCallName {
+			qc.Error = utils.StackError(nil, "hll aggregate function can not be used in having filter %s", filter.String())
+			return
+		}
+		if ref, ok := qc.addAggregateMeasure(call, &Measure{}).(*expr.VarRef); ok {
+			qc.Query.hiddenMeasures = append(qc.Query.hiddenMeasures, ref)
+		}
+	})
+}
+
+// findHiddenMeasure returns the index of the hidden measure of the aggregate
+// function, -1 if not found.
+func (qc *AQLQueryContext) findHiddenMeasure(aggregate expr.Expr) int {
+	for i, ref := range qc.Query.hiddenMeasures {
+		if ref.Val == aggregate.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// findMeasureByAlias finds the measure or supporting measure with the alias.
+func (qc *AQLQueryContext) findMeasureByAlias(alias string) *Measure {
+	for i, measure := range qc.Query.Measures {
+		if measure.Alias == alias {
+			return &qc.Query.Measures[i]
+		}
+	}
+	for i, measure := range qc.Query.SupportingMeasures {
+		if measure.Alias == alias {
+			return &qc.Query.SupportingMeasures[i]
+		}
+	}
+	return nil
+}
+
+// isAggregateCall returns whether the expression is an aggregate function call.
+func isAggregateCall(e expr.Expr) bool {
+	call, ok := e.(*expr.Call)
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(call.Name) {
+	case countCallName, sumCallName, avgCallName, minCallName, maxCallName, hllCallName, countDistinctHllCallName,
+		percentileCallName:
+		return true
+	}
+	return false
 }
 
 func (qc *AQLQueryContext) processTimezone() {
@@ -624,6 +974,7 @@ func cast(e expr.Expr, t expr.Type) expr.Expr {
 
 func blockNumericOpsForColumnOverFourBytes(token expr.Token, expressions ...expr.Expr) error {
 	if token == expr.UNARY_MINUS || token == expr.BITWISE_NOT ||
+		(token >= expr.ABS && token <= expr.LOG10) ||
 		(token >= expr.ADD && token <= expr.BITWISE_LEFT_SHIFT) {
 		for _, expression := range expressions {
 			if varRef, isVarRef := expression.(*expr.VarRef); isVarRef && memCom.DataTypeBytes(varRef.DataType) > 4 {
@@ -735,11 +1086,11 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 			return expression
 		}
 
-		if e.Op != expr.EQ && e.Op != expr.NEQ {
+		if e.Op != expr.EQ && e.Op != expr.NEQ && e.Op != expr.LIKE && e.Op != expr.NOT_LIKE {
 			_, isRHSStr := e.RHS.(*expr.StringLiteral)
 			_, isLHSStr := e.LHS.(*expr.StringLiteral)
 			if isRHSStr || isLHSStr {
-				qc.Error = utils.StackError(nil, "string type only support EQ and NEQ operators")
+				qc.Error = utils.StackError(nil, "string type only support EQ, NEQ and LIKE operators")
 				return expression
 			}
 		}
@@ -807,7 +1158,15 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 
 			// rhs is string enum
 			rhs, _ := e.RHS.(*expr.StringLiteral)
-			if lhs != nil && rhs != nil && lhs.EnumDict != nil {
+			lhsCase, _ := e.LHS.(*expr.Case)
+			if lhsCase != nil && rhs != nil && lhsCase.EnumDict != nil {
+				// Match case with string results against the string.
+				value, exists := lhsCase.EnumDict[rhs.Val]
+				if !exists {
+					value = -1
+				}
+				e.RHS = &expr.NumberLiteral{Int: value, ExprType: expr.Unsigned}
+			} else if lhs != nil && rhs != nil && lhs.EnumDict != nil {
 				// Enum dictionary translation
 				value, exists := lhs.EnumDict[rhs.Val]
 				if !exists {
@@ -823,7 +1182,7 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 				e.RHS = cast(e.RHS, highestType)
 			}
 
-			if rhs != nil && lhs.DataType == memCom.GeoPoint {
+			if lhs != nil && rhs != nil && lhs.DataType == memCom.GeoPoint {
 				if val, err := memCom.GeoPointFromString(rhs.Val); err != nil {
 					qc.Error = err
 				} else {
@@ -832,6 +1191,25 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 					}
 				}
 			}
+		case expr.LIKE, expr.NOT_LIKE:
+			pattern, isString := e.RHS.(*expr.StringLiteral)
+			if !isString {
+				qc.Error = utils.StackError(nil, "expect a string pattern for %s, but got %s", e.Op, e.String())
+				break
+			}
+			re, err := likePatternToRegexp(pattern.Val, '\\')
+			if err != nil {
+				qc.Error = utils.StackError(err, "invalid pattern %s", pattern.Val)
+				break
+			}
+			matched := qc.matchEnumCases(e.LHS, re.MatchString, e.String())
+			if qc.Error != nil {
+				break
+			}
+			if e.Op == expr.NOT_LIKE {
+				return &expr.UnaryExpr{Op: expr.NOT, Expr: matched, ExprType: expr.Boolean}
+			}
+			return matched
 		case expr.IN:
 			return qc.expandINop(e)
 		case expr.NOT_IN:
@@ -888,6 +1266,61 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 			}
 		case countCallName:
 			e.ExprType = expr.Unsigned
+		case coalesceCallName:
+			if len(e.Args) < 2 {
+				qc.Error = utils.StackError(
+					nil, "expect at least 2 arguments for %s, but got %s", e.Name, e.String())
+				break
+			}
+			return qc.rewriteCoalesce(e)
+		case ifNullCallName:
+			if len(e.Args) != 2 {
+				qc.Error = utils.StackError(
+					nil, "expect 2 arguments for %s, but got %s", e.Name, e.String())
+				break
+			}
+			return qc.rewriteCoalesce(e)
+		case absCallName, ceilCallName, sqrtCallName, lnCallName, log10CallName,
+			powCallName, roundCallName, greatestCallName, leastCallName:
+			return qc.rewriteMathCall(e)
+		case startsWithCallName, regexpLikeCallName:
+			if len(e.Args) != 2 {
+				qc.Error = utils.StackError(
+					nil, "expect 2 arguments for %s, but got %s", e.Name, e.String())
+				break
+			}
+			pattern, isString := e.Args[1].(*expr.StringLiteral)
+			if !isString {
+				qc.Error = utils.StackError(
+					nil, "expect the second argument of %s to be a string, but got %s", e.Name, e.Args[1].String())
+				break
+			}
+			match := func(value string) bool {
+				return strings.HasPrefix(value, pattern.Val)
+			}
+			if e.Name == regexpLikeCallName {
+				re, err := regexp.Compile(pattern.Val)
+				if err != nil {
+					qc.Error = utils.StackError(err, "invalid regular expression %s", pattern.Val)
+					break
+				}
+				match = re.MatchString
+			}
+			matched := qc.matchEnumCases(e.Args[0], match, e.String())
+			if qc.Error != nil {
+				break
+			}
+			return matched
+		case ifCallName:
+			if len(e.Args) != 3 {
+				qc.Error = utils.StackError(
+					nil, "expect 3 arguments for %s, but got %s", e.Name, e.String())
+				break
+			}
+			return qc.resolveCaseTypes(&expr.Case{
+				WhenThens: []expr.WhenThen{{When: e.Args[0], Then: e.Args[1]}},
+				Else:      e.Args[2],
+			})
 		case dayOfWeekCallName:
 			// dayofweek from ts: (ts / secondsInDay + 4) % 7 + 1
 			// ref: https://dev.mysql.com/doc/refman/5.5/en/date-and-time-functions.html#function_dayofweek
@@ -1087,26 +1520,344 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 				e.Args[0] = cast(e.Args[0], expr.Float)
 			}
 			e.ExprType = e.Args[0].Type()
+		case percentileCallName:
+			if len(e.Args) != 2 {
+				qc.Error = utils.StackError(
+					nil, "expect 2 arguments for %s, but got %s", e.Name, e.String())
+				break
+			}
+			percentile, isNumber := e.Args[1].(*expr.NumberLiteral)
+			if !isNumber || percentile.Val < 0 || percentile.Val > 1 {
+				qc.Error = utils.StackError(
+					nil, "expect the second argument of %s to be a number within [0, 1], but got %s",
+					e.Name, e.Args[1].String())
+				break
+			}
+			// Values are added into sketches as float.
+			e.Args[0] = cast(e.Args[0], expr.Float)
+			e.ExprType = expr.Float
 		default:
 			qc.Error = utils.StackError(nil, "unknown function %s", e.Name)
 		}
 	case *expr.Case:
-		highestType := e.Else.Type()
-		for _, whenThen := range e.WhenThens {
-			if whenThen.Then.Type() > highestType {
-				highestType = whenThen.Then.Type()
+		return qc.resolveCaseTypes(e)
+	}
+	return expression
+}
+
+// rewriteMathCall rewrites the math function call into unary or binary
+// expressions with following type promotions:
+//   abs(x) keeps the type of x.
+//   ceil(x) returns x itself if x is not float.
+//   sqrt(x), ln(x), log10(x), pow(x, y) and round(x[, n]) return float.
+//   greatest(x, y, ...) and least(x, y, ...) return the highest type among
+//   the arguments.
+func (qc *AQLQueryContext) rewriteMathCall(e *expr.Call) expr.Expr {
+	minArgs, maxArgs := 1, 1
+	switch e.Name {
+	case powCallName:
+		minArgs, maxArgs = 2, 2
+	case roundCallName:
+		maxArgs = 2
+	case greatestCallName, leastCallName:
+		minArgs, maxArgs = 2, len(e.Args)
+	}
+	if len(e.Args) < minArgs || len(e.Args) > maxArgs {
+		qc.Error = utils.StackError(nil, "wrong number of arguments for %s: %s", e.Name, e.String())
+		return e
+	}
+
+	op := mathCallOperators[e.Name]
+	if err := blockNumericOpsForColumnOverFourBytes(op, e.Args...); err != nil {
+		qc.Error = err
+		return e
+	}
+	highestType := expr.Boolean
+	for _, arg := range e.Args {
+		if arg.Type() == expr.UnknownType || arg.Type() > expr.Float {
+			qc.Error = utils.StackError(nil, "expect numeric arguments for %s, but got %s", e.Name, arg.String())
+			return e
+		}
+		if arg.Type() > highestType {
+			highestType = arg.Type()
+		}
+	}
+
+	switch e.Name {
+	case absCallName:
+		// abs of unsigned values is a noop.
+		if e.Args[0].Type() != expr.Signed && e.Args[0].Type() != expr.Float {
+			return e.Args[0]
+		}
+		return &expr.UnaryExpr{Op: op, Expr: e.Args[0], ExprType: e.Args[0].Type()}
+	case ceilCallName:
+		if e.Args[0].Type() != expr.Float {
+			return e.Args[0]
+		}
+		return &expr.UnaryExpr{Op: op, Expr: e.Args[0], ExprType: expr.Float}
+	case sqrtCallName, lnCallName, log10CallName:
+		return &expr.UnaryExpr{Op: op, Expr: cast(e.Args[0], expr.Float), ExprType: expr.Float}
+	case powCallName:
+		return &expr.BinaryExpr{
+			Op:       op,
+			LHS:      cast(e.Args[0], expr.Float),
+			RHS:      cast(e.Args[1], expr.Float),
+			ExprType: expr.Float,
+		}
+	case roundCallName:
+		var places expr.Expr = &expr.NumberLiteral{Expr: "0", ExprType: expr.Signed}
+		if len(e.Args) == 2 {
+			places = e.Args[1]
+		}
+		return &expr.BinaryExpr{
+			Op:       op,
+			LHS:      cast(e.Args[0], expr.Float),
+			RHS:      places,
+			ExprType: expr.Float,
+		}
+	default:
+		// greatest and least.
+		result := cast(e.Args[0], highestType)
+		for _, arg := range e.Args[1:] {
+			result = &expr.BinaryExpr{
+				Op:       op,
+				LHS:      result,
+				RHS:      cast(arg, highestType),
+				ExprType: highestType,
 			}
 		}
-		// Cast else and thens to highestType, cast whens to boolean.
+		return result
+	}
+}
+
+// likePatternToRegexp converts the LIKE pattern into a regular expression,
+// where % matches any sequence of characters and _ matches any single
+// character. The escape character escapes the following character, 0 means
+// there is no escape character.
+func likePatternToRegexp(pattern string, escape rune) (*regexp.Regexp, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("(?s)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			buffer.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case escape != 0 && c == escape:
+			escaped = true
+		case c == '%':
+			buffer.WriteString(".*")
+		case c == '_':
+			buffer.WriteString(".")
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if escaped {
+		return nil, utils.StackError(nil, "pattern %s ends with escape character", pattern)
+	}
+	buffer.WriteString("$")
+	return regexp.Compile(buffer.String())
+}
+
+// matchEnumCases evaluates the match function against the enum dictionary of
+// the enum column at compile time, and rewrites it into a lookup of the
+// matched enum cases:
+//   column = case1 OR column = case2 ...
+// If no enum case matches, the column is matched against an invalid value.
+func (qc *AQLQueryContext) matchEnumCases(column expr.Expr, match func(string) bool, exprStr string) expr.Expr {
+	varRef, isVarRef := column.(*expr.VarRef)
+	if !isVarRef || (varRef.DataType != memCom.SmallEnum && varRef.DataType != memCom.BigEnum) {
+		qc.Error = utils.StackError(nil, "expect an enum column for pattern matching, but got %s", exprStr)
+		return nil
+	}
+
+	var result expr.Expr
+	for enumCase, value := range varRef.EnumReverseDict {
+		if !match(value) {
+			continue
+		}
+		lookup := makeEnumCaseLookup(varRef, enumCase)
+		if result == nil {
+			result = lookup
+		} else {
+			result = &expr.BinaryExpr{Op: expr.OR, LHS: result, RHS: lookup, ExprType: expr.Boolean}
+		}
+	}
+
+	if result == nil {
+		// To play it safe we match against an invalid value as enum translation
+		// of EQ does.
+		result = makeEnumCaseLookup(varRef, -1)
+	}
+	return result
+}
+
+// makeEnumCaseLookup returns the expression of column = enumCase.
+func makeEnumCaseLookup(column *expr.VarRef, enumCase int) expr.Expr {
+	return &expr.BinaryExpr{
+		Op:  expr.EQ,
+		LHS: column,
+		RHS: &expr.NumberLiteral{
+			Int:      enumCase,
+			Expr:     strconv.Itoa(enumCase),
+			ExprType: expr.Unsigned,
+		},
+		ExprType: expr.Boolean,
+	}
+}
+
+// rewriteCoalesce rewrites coalesce(a, b, c) into COALESCE(a, COALESCE(b, c))
+// after casting all arguments to the highest type among them.
+func (qc *AQLQueryContext) rewriteCoalesce(e *expr.Call) expr.Expr {
+	var highestType expr.Type
+	for i, arg := range e.Args {
+		switch a := arg.(type) {
+		case *expr.StringLiteral:
+			qc.Error = utils.StackError(nil, "string argument %s is not supported in %s", a.String(), e.String())
+			return e
+		case *expr.BooleanLiteral:
+			// Boolean literals are evaluated as number literals by the VM.
+			value := 0
+			if a.Val {
+				value = 1
+			}
+			e.Args[i] = &expr.NumberLiteral{Val: float64(value), Int: value, Expr: a.String(), ExprType: expr.Boolean}
+		}
+		if arg.Type() > highestType {
+			highestType = arg.Type()
+		}
+	}
+	if highestType > expr.Float {
+		qc.Error = utils.StackError(nil, "unsupported argument type %s of %s", highestType, e.String())
+		return e
+	}
+
+	result := cast(e.Args[len(e.Args)-1], highestType)
+	for i := len(e.Args) - 2; i >= 0; i-- {
+		result = &expr.BinaryExpr{
+			Op:       expr.COALESCE,
+			LHS:      cast(e.Args[i], highestType),
+			RHS:      result,
+			ExprType: highestType,
+		}
+	}
+	return result
+}
+
+// resolveCaseTypes casts whens of the case expression to boolean, and casts
+// thens and else to the highest type among them. String results are
+// translated into enum cases.
+func (qc *AQLQueryContext) resolveCaseTypes(e *expr.Case) expr.Expr {
+	if qc.translateCaseStrings(e); qc.Error != nil {
+		return e
+	}
+
+	var highestType expr.Type
+	if e.Else != nil {
+		highestType = e.Else.Type()
+	}
+	for _, whenThen := range e.WhenThens {
+		if whenThen.Then.Type() > highestType {
+			highestType = whenThen.Then.Type()
+		}
+	}
+	if highestType > expr.Float {
+		qc.Error = utils.StackError(nil, "unsupported result type %s of %s", highestType, e.String())
+		return e
+	}
+
+	// Cast else and thens to highestType, cast whens to boolean.
+	if e.Else != nil {
 		e.Else = cast(e.Else, highestType)
-		for i, whenThen := range e.WhenThens {
-			whenThen.When = cast(whenThen.When, expr.Boolean)
-			whenThen.Then = cast(whenThen.Then, highestType)
-			e.WhenThens[i] = whenThen
+	}
+	for i, whenThen := range e.WhenThens {
+		whenThen.When = cast(whenThen.When, expr.Boolean)
+		whenThen.Then = cast(whenThen.Then, highestType)
+		e.WhenThens[i] = whenThen
+	}
+	e.ExprType = highestType
+	return e
+}
+
+// translateCaseStrings translates string literals in thens and else of the
+// case expression into enum cases in the order of their first appearances.
+// Either all or none of the results should be string literals.
+func (qc *AQLQueryContext) translateCaseStrings(e *expr.Case) {
+	results := make([]*expr.Expr, 0, len(e.WhenThens)+1)
+	for i := range e.WhenThens {
+		results = append(results, &e.WhenThens[i].Then)
+	}
+	if e.Else != nil {
+		results = append(results, &e.Else)
+	}
+
+	numStrings := 0
+	for _, result := range results {
+		if _, isString := (*result).(*expr.StringLiteral); isString {
+			numStrings++
+		}
+	}
+	if numStrings == 0 {
+		return
+	}
+	if numStrings != len(results) {
+		qc.Error = utils.StackError(nil, "expect all or none of results to be strings in %s", e.String())
+		return
+	}
+
+	e.EnumDict = make(map[string]int)
+	for _, result := range results {
+		str := (*result).(*expr.StringLiteral).Val
+		enumCase, exists := e.EnumDict[str]
+		if !exists {
+			enumCase = len(e.EnumReverseDict)
+			e.EnumDict[str] = enumCase
+			e.EnumReverseDict = append(e.EnumReverseDict, str)
+		}
+		*result = &expr.NumberLiteral{
+			Val:      float64(enumCase),
+			Int:      enumCase,
+			Expr:     strconv.Itoa(enumCase),
+			ExprType: expr.Unsigned,
 		}
-		e.ExprType = highestType
 	}
-	return expression
+}
+
+// rewriteCase rewrites the case expression into conditional binary
+// expressions that can be evaluated by the VM:
+//   CASE WHEN c1 THEN v1 WHEN c2 THEN v2 ELSE v3 END
+// is rewritten into
+//   COALESCE(IF_TRUE(c1, v1), IF_NOT_TRUE(c1, COALESCE(IF_TRUE(c2, v2), IF_NOT_TRUE(c2, v3))))
+// Notice that each when will be evaluated twice.
+func rewriteCase(e *expr.Case) expr.Expr {
+	result := e.Else
+	for i := len(e.WhenThens) - 1; i >= 0; i-- {
+		whenThen := e.WhenThens[i]
+		ifTrue := &expr.BinaryExpr{
+			Op:       expr.IF_TRUE,
+			LHS:      whenThen.When,
+			RHS:      whenThen.Then,
+			ExprType: e.ExprType,
+		}
+		// Without else, the result is null if none of the whens is true.
+		if result == nil {
+			result = ifTrue
+			continue
+		}
+		result = &expr.BinaryExpr{
+			Op:  expr.COALESCE,
+			LHS: ifTrue,
+			RHS: &expr.BinaryExpr{
+				Op:       expr.IF_NOT_TRUE,
+				LHS:      whenThen.When,
+				RHS:      result,
+				ExprType: e.ExprType,
+			},
+			ExprType: e.ExprType,
+		}
+	}
+	return result
 }
 
 // normalizeAndFilters extracts top AND operators and flatten them out to the
@@ -1131,7 +1882,7 @@ func (qc *AQLQueryContext) resolveTypes() {
 	// Join conditions.
 	for i, join := range qc.Query.Joins {
 		for j, cond := range join.conditions {
-			join.conditions[j] = expr.Rewrite(qc, cond)
+			join.conditions[j] = qc.resolveExprTypes(cond)
 			if qc.Error != nil {
 				return
 			}
@@ -1145,28 +1896,32 @@ func (qc *AQLQueryContext) resolveTypes() {
 		if qc.Error != nil {
 			return
 		}
+		// String results of case are translated back by the reverse dict.
+		if caseExpr, ok := dim.expr.(*expr.Case); ok {
+			dim.enumReverseDict = caseExpr.EnumReverseDict
+		}
+		dim.expr = expr.Rewrite(caseRewriter{}, dim.expr)
 		qc.Query.Dimensions[i] = dim
 	}
 
 	// Measures.
-	for i, measure := range qc.Query.Measures {
-		measure.expr = expr.Rewrite(qc, measure.expr)
+	for _, measure := range qc.Query.aggregateMeasures {
+		measure.expr = qc.resolveExprTypes(measure.expr)
 		if qc.Error != nil {
 			return
 		}
 		for j, filter := range measure.filters {
-			measure.filters[j] = expr.Rewrite(qc, filter)
+			measure.filters[j] = qc.resolveExprTypes(filter)
 			if qc.Error != nil {
 				return
 			}
 		}
 		measure.filters = normalizeAndFilters(measure.filters)
-		qc.Query.Measures[i] = measure
 	}
 
 	// Filters.
 	for i, filter := range qc.Query.filters {
-		qc.Query.filters[i] = expr.Rewrite(qc, filter)
+		qc.Query.filters[i] = qc.resolveExprTypes(filter)
 		if qc.Error != nil {
 			return
 		}
@@ -1174,6 +1929,28 @@ func (qc *AQLQueryContext) resolveTypes() {
 	qc.Query.filters = normalizeAndFilters(qc.Query.filters)
 }
 
+// resolveExprTypes resolves data types of the expression and rewrites case
+// expressions in it into the form evaluated by the VM, so that later passes,
+// e.g. memory estimation, see the expressions executed.
+func (qc *AQLQueryContext) resolveExprTypes(e expr.Expr) expr.Expr {
+	e = expr.Rewrite(qc, e)
+	if qc.Error != nil {
+		return e
+	}
+	return expr.Rewrite(caseRewriter{}, e)
+}
+
+// caseRewriter rewrites case expressions with resolved types, see rewriteCase.
+type caseRewriter struct{}
+
+// Rewrite implements expr.Rewriter.
+func (caseRewriter) Rewrite(e expr.Expr) expr.Expr {
+	if caseExpr, ok := e.(*expr.Case); ok {
+		return rewriteCase(caseExpr)
+	}
+	return e
+}
+
 // extractFitler processes the specified query level filter and matches it
 // against the following formats:
 //   column = value
@@ -1370,15 +2147,17 @@ func (c *foreignTableColumnDetector) Visit(expression expr.Expr) expr.Visitor {
 // processFilters processes all filters and categorize them into common filters,
 // prefilters, and time filters. It also collect column usages from the filters.
 func (qc *AQLQueryContext) processFilters() {
-	// OOPK engine only supports one measure per query.
-	if len(qc.Query.Measures) != 1 {
-		qc.Error = utils.StackError(nil, "expect one measure per query, but got %d",
-			len(qc.Query.Measures))
+	if len(qc.Query.Measures) == 0 {
+		qc.Error = utils.StackError(nil, "expect at least one measure per query")
 		return
 	}
 
+	// All measures are evaluated over the same set of rows, so only measure
+	// filters shared by all measures filter the rows. Other measure filters are
+	// applied to values of their own measures in processAggregateMeasure.
+	commonFilters := extractSharedFilters(qc.Query.aggregateMeasures)
+
 	// Categorize common filters and prefilters based on matched prefilters.
-	commonFilters := qc.Query.Measures[0].filters
 	prefilters := qc.Prefilters
 	for index, filter := range qc.Query.filters {
 		if len(prefilters) == 0 || prefilters[0] > index {
@@ -1464,6 +2243,57 @@ func (qc *AQLQueryContext) processFilters() {
 	}
 }
 
+// extractSharedFilters removes filters shared by all measures from filters of
+// each measure and returns them.
+func extractSharedFilters(measures []*Measure) (shared []expr.Expr) {
+	for _, filter := range measures[0].filters {
+		isShared := true
+		for _, measure := range measures[1:] {
+			if indexOfFilter(measure.filters, filter) < 0 {
+				isShared = false
+				break
+			}
+		}
+		if isShared {
+			shared = append(shared, filter)
+		}
+	}
+
+	for _, measure := range measures {
+		var filters []expr.Expr
+		for _, filter := range measure.filters {
+			if indexOfFilter(shared, filter) < 0 {
+				filters = append(filters, filter)
+			}
+		}
+		measure.filters = filters
+	}
+	return
+}
+
+// indexOfFilter returns the index of the filter in filters, or -1 if not found.
+func indexOfFilter(filters []expr.Expr, filter expr.Expr) int {
+	for i, f := range filters {
+		if f.String() == filter.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// isSameFilters tells whether two lists of compiled filters are identical.
+func isSameFilters(filters1, filters2 []expr.Expr) bool {
+	if len(filters1) != len(filters2) {
+		return false
+	}
+	for i := range filters1 {
+		if filters1[i].String() != filters2[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
 func getStrFromNumericalOrStrLiteral(e expr.Expr) (string, error) {
 	var str string
 	if strExpr, ok := e.(*expr.StringLiteral); ok {
@@ -1696,14 +2526,17 @@ func (g *geoTableUsageCollector) Visit(expression expr.Expr) expr.Visitor {
 }
 
 func (qc *AQLQueryContext) processMeasure() {
-	// OOPK engine only supports one measure per query.
-	if len(qc.Query.Measures) != 1 {
-		qc.Error = utils.StackError(nil, "expect one measure per query, but got %d",
-			len(qc.Query.Measures))
+	if len(qc.Query.Measures) == 0 {
+		qc.Error = utils.StackError(nil, "expect at least one measure per query")
 		return
 	}
 
 	if _, ok := qc.Query.Measures[0].expr.(*expr.NumberLiteral); ok {
+		if len(qc.Query.Measures) != 1 {
+			qc.Error = utils.StackError(nil, "expect one measure for non aggregation query, but got %d",
+				len(qc.Query.Measures))
+			return
+		}
 		qc.isNonAggregationQuery = true
 		if qc.Query.Limit <= 0 {
 			qc.Query.Limit = nonAggregationQueryLimit
@@ -1711,90 +2544,181 @@ func (qc *AQLQueryContext) processMeasure() {
 		return
 	}
 
-	// Match and strip the aggregate function.
-	aggregate, ok := qc.Query.Measures[0].expr.(*expr.Call)
+	measureNames := make(map[string]bool)
+	for _, measure := range qc.Query.Measures {
+		name := measure.name()
+		if measureNames[name] {
+			qc.Error = utils.StackError(nil, "duplicate measure name: %s", name)
+			return
+		}
+		measureNames[name] = true
+	}
+
+	qc.OOPK.Measures = make([]OOPKMeasure, len(qc.Query.aggregateMeasures))
+	for i, measure := range qc.Query.aggregateMeasures {
+		qc.OOPK.Measures[i] = qc.processAggregateMeasure(*measure)
+		if qc.Error != nil {
+			return
+		}
+	}
+
+	// Only one hll or percentile measure can be serialized as hll data.
+	if qc.ReturnHLLData && (len(qc.OOPK.Measures) > 1 || qc.Query.measureExprs != nil) {
+		qc.Error = utils.StackError(nil, "expect exactly one hll or percentile measure as client specify 'Accept' as "+
+			"'application/hll', but got %d", len(qc.Query.Measures))
+		return
+	}
+
+	// HLL results are serialized per dimension without any other measures.
+	if len(qc.OOPK.Measures) > 1 {
+		for i, measure := range qc.OOPK.Measures {
+			if measure.AggregateType == C.AGGR_HLL {
+				qc.Error = utils.StackError(nil,
+					"hll aggregate function can not be used together with other measures: %s",
+					qc.Query.aggregateMeasures[i].Expr)
+				return
+			}
+		}
+	}
+}
+
+// processAggregateMeasure matches and strips the aggregate function of a
+// measure and decides the aggregate type and value width.
+func (qc *AQLQueryContext) processAggregateMeasure(measure Measure) (oopkMeasure OOPKMeasure) {
+	aggregate, ok := measure.expr.(*expr.Call)
 	if !ok {
 		qc.Error = utils.StackError(nil, "expect aggregate function, but got %s",
-			qc.Query.Measures[0].Expr)
+			measure.Expr)
 		return
 	}
 
-	if qc.ReturnHLLData && aggregate.Name != hllCallName {
-		qc.Error = utils.StackError(nil, "expect hll aggregate function as client specify 'Accept' as "+
+	if qc.ReturnHLLData && aggregate.Name != hllCallName && aggregate.Name != percentileCallName {
+		qc.Error = utils.StackError(nil, "expect hll or percentile aggregate function as client specify 'Accept' as "+
 			"'application/hll', but got %s",
-			qc.Query.Measures[0].Expr)
+			measure.Expr)
 		return
 	}
 
-	if len(aggregate.Args) != 1 {
+	if strings.ToLower(aggregate.Name) == percentileCallName {
+		if len(aggregate.Args) != 2 {
+			qc.Error = utils.StackError(nil,
+				"expect two parameters for aggregate function %s, but got %d",
+				aggregate.Name, len(aggregate.Args))
+			return
+		}
+	} else if len(aggregate.Args) != 1 {
 		qc.Error = utils.StackError(nil,
 			"expect one parameter for aggregate function %s, but got %d",
 			aggregate.Name, len(aggregate.Args))
 		return
 	}
-	qc.OOPK.Measure = aggregate.Args[0]
+	oopkMeasure.Measure = aggregate.Args[0]
 	// default is 4 bytes
-	qc.OOPK.MeasureBytes = 4
+	oopkMeasure.MeasureBytes = 4
 	switch strings.ToLower(aggregate.Name) {
 	case countCallName:
-		qc.OOPK.Measure = &expr.NumberLiteral{
+		oopkMeasure.Measure = &expr.NumberLiteral{
 			Int:      1,
 			Expr:     "1",
 			ExprType: expr.Unsigned,
 		}
-		qc.OOPK.AggregateType = C.AGGR_SUM_UNSIGNED
+		oopkMeasure.AggregateType = C.AGGR_SUM_UNSIGNED
 	case sumCallName:
-		qc.OOPK.MeasureBytes = 8
-		switch qc.OOPK.Measure.Type() {
+		oopkMeasure.MeasureBytes = 8
+		switch oopkMeasure.Measure.Type() {
 		case expr.Float:
-			qc.OOPK.AggregateType = C.AGGR_SUM_FLOAT
+			oopkMeasure.AggregateType = C.AGGR_SUM_FLOAT
 		case expr.Signed:
-			qc.OOPK.AggregateType = C.AGGR_SUM_SIGNED
+			oopkMeasure.AggregateType = C.AGGR_SUM_SIGNED
 		case expr.Unsigned:
-			qc.OOPK.AggregateType = C.AGGR_SUM_UNSIGNED
+			oopkMeasure.AggregateType = C.AGGR_SUM_UNSIGNED
 		default:
 			qc.Error = utils.StackError(nil,
-				unsupportedInputType, sumCallName, qc.OOPK.Measure.String())
+				unsupportedInputType, sumCallName, oopkMeasure.Measure.String())
 			return
 		}
 	case avgCallName:
 		// 4 bytes for storing average result and another 4 byte for count
-		qc.OOPK.MeasureBytes = 8
+		oopkMeasure.MeasureBytes = 8
 		// for average, we should always use float type as the agg type.
-		qc.OOPK.AggregateType = C.AGGR_AVG_FLOAT
+		oopkMeasure.AggregateType = C.AGGR_AVG_FLOAT
 	case minCallName:
-		switch qc.OOPK.Measure.Type() {
+		switch oopkMeasure.Measure.Type() {
 		case expr.Float:
-			qc.OOPK.AggregateType = C.AGGR_MIN_FLOAT
+			oopkMeasure.AggregateType = C.AGGR_MIN_FLOAT
 		case expr.Signed:
-			qc.OOPK.AggregateType = C.AGGR_MIN_SIGNED
+			oopkMeasure.AggregateType = C.AGGR_MIN_SIGNED
 		case expr.Unsigned:
-			qc.OOPK.AggregateType = C.AGGR_MIN_UNSIGNED
+			oopkMeasure.AggregateType = C.AGGR_MIN_UNSIGNED
 		default:
 			qc.Error = utils.StackError(nil,
-				unsupportedInputType, minCallName, qc.OOPK.Measure.String())
+				unsupportedInputType, minCallName, oopkMeasure.Measure.String())
 			return
 		}
 	case maxCallName:
-		switch qc.OOPK.Measure.Type() {
+		switch oopkMeasure.Measure.Type() {
 		case expr.Float:
-			qc.OOPK.AggregateType = C.AGGR_MAX_FLOAT
+			oopkMeasure.AggregateType = C.AGGR_MAX_FLOAT
 		case expr.Signed:
-			qc.OOPK.AggregateType = C.AGGR_MAX_SIGNED
+			oopkMeasure.AggregateType = C.AGGR_MAX_SIGNED
 		case expr.Unsigned:
-			qc.OOPK.AggregateType = C.AGGR_MAX_UNSIGNED
+			oopkMeasure.AggregateType = C.AGGR_MAX_UNSIGNED
 		default:
 			qc.Error = utils.StackError(nil,
-				unsupportedInputType, maxCallName, qc.OOPK.Measure.String())
+				unsupportedInputType, maxCallName, oopkMeasure.Measure.String())
 			return
 		}
 	case hllCallName:
-		qc.OOPK.AggregateType = C.AGGR_HLL
+		oopkMeasure.AggregateType = C.AGGR_HLL
+	case percentileCallName:
+		percentile, ok := aggregate.Args[1].(*expr.NumberLiteral)
+		if !ok {
+			qc.Error = utils.StackError(nil,
+				"expect a number as percentile of %s, but got %s", aggregate.Name, aggregate.Args[1].String())
+			return
+		}
+		// Values of current batch are written the same as avg, which carries
+		// the value and the count, and then buffered for the sketches of their
+		// dimensions before reduce. Percentile measures are not reduced.
+		oopkMeasure.MeasureBytes = 8
+		oopkMeasure.AggregateType = C.AGGR_AVG_FLOAT
+		oopkMeasure.IsPercentile = true
+		oopkMeasure.Percentile = percentile.Val
 	default:
 		qc.Error = utils.StackError(nil,
 			"unsupported aggregate function: %s", aggregate.Name)
 		return
 	}
+
+	// Filters not shared by all measures turn values of rows not matching them
+	// into nulls, which are aggregated as identity values, e.g. 0 for count
+	// and sum. Groups with all rows filtered out would get identity values of
+	// min and max instead of nulls, so they are not supported either.
+	if len(measure.filters) > 0 {
+		switch strings.ToLower(aggregate.Name) {
+		case minCallName, maxCallName, percentileCallName:
+			qc.Error = utils.StackError(nil,
+				"expect same filters for %s and other measures, but got different filters for %s",
+				aggregate.Name, measure.Expr)
+			return
+		}
+		condition := cast(measure.filters[0], expr.Boolean)
+		for _, filter := range measure.filters[1:] {
+			condition = &expr.BinaryExpr{
+				Op:       expr.AND,
+				LHS:      condition,
+				RHS:      cast(filter, expr.Boolean),
+				ExprType: expr.Boolean,
+			}
+		}
+		oopkMeasure.Measure = &expr.BinaryExpr{
+			Op:       expr.IF_TRUE,
+			LHS:      condition,
+			RHS:      oopkMeasure.Measure,
+			ExprType: oopkMeasure.Measure.Type(),
+		}
+	}
+	return
 }
 
 func (qc *AQLQueryContext) getAllColumnsDimension() (columns []Dimension) {
@@ -1823,17 +2747,32 @@ func (qc *AQLQueryContext) processDimensions() {
 		}
 	}
 
+	qc.processFillGaps()
+	if qc.Error != nil {
+		return
+	}
+	qc.processMeasureWindows()
+	if qc.Error != nil {
+		return
+	}
+	qc.processGroupingSets()
+	if qc.Error != nil {
+		return
+	}
+
 	if qc.OOPK.geoIntersection != nil {
 		gc := &geoTableUsageCollector{
 			geoIntersection: *qc.OOPK.geoIntersection,
 		}
 		// Check whether measure and dimensions are referencing any geo table columns.
-		expr.Walk(gc, qc.OOPK.Measure)
+		for _, measure := range qc.OOPK.Measures {
+			expr.Walk(gc, measure.Measure)
 
-		if gc.useGeoTable {
-			qc.Error = utils.StackError(nil,
-				"Geo table column is not allowed to be used in measure: %s", qc.OOPK.Measure.String())
-			return
+			if gc.useGeoTable {
+				qc.Error = utils.StackError(nil,
+					"Geo table column is not allowed to be used in measure: %s", measure.Measure.String())
+				return
+			}
 		}
 
 		foundGeoJoin := false
@@ -1857,11 +2796,13 @@ func (qc *AQLQueryContext) processDimensions() {
 		}
 	}
 
-	// Collect column usage from measure and dimensions
-	expr.Walk(columnUsageCollector{
-		tableScanners: qc.TableScanners,
-		usages:        columnUsedByAllBatches,
-	}, qc.OOPK.Measure)
+	// Collect column usage from measures and dimensions
+	for _, measure := range qc.OOPK.Measures {
+		expr.Walk(columnUsageCollector{
+			tableScanners: qc.TableScanners,
+			usages:        columnUsedByAllBatches,
+		}, measure.Measure)
+	}
 
 	for _, dim := range qc.OOPK.Dimensions {
 		expr.Walk(columnUsageCollector{
@@ -1871,6 +2812,157 @@ func (qc *AQLQueryContext) processDimensions() {
 	}
 }
 
+// processSorts resolves sort fields of aggregation queries against dimensions
+// and measures. Sorting and limit of aggregation queries are applied during
+// postprocessing.
+func (qc *AQLQueryContext) processSorts() {
+	if qc.isNonAggregationQuery {
+		return
+	}
+
+	if qc.OOPK.IsHLL() && (len(qc.Query.Sorts) > 0 || qc.Query.Limit > 0) {
+		qc.Error = utils.StackError(nil, "sorts and limit are not supported for hll query")
+		return
+	}
+
+	for _, sortField := range qc.Query.Sorts {
+		var desc bool
+		switch strings.ToLower(sortField.Order) {
+		case "", "asc":
+		case "desc":
+			desc = true
+		default:
+			qc.Error = utils.StackError(nil, "unknown sort order %s for %s", sortField.Order, sortField.Name)
+			return
+		}
+
+		index, isMeasure, found := qc.resolveResultField(sortField.Name)
+		if !found {
+			qc.Error = utils.StackError(nil, "unknown dimension or measure to sort by: %s", sortField.Name)
+			return
+		}
+
+		qc.aggregationSorts = append(qc.aggregationSorts, aggregationSort{
+			index:     index,
+			isMeasure: isMeasure,
+			desc:      desc,
+		})
+	}
+}
+
+// processHavingFilters resolves measure references in having filters. Having
+// filters are evaluated against aggregated groups during postprocessing.
+func (qc *AQLQueryContext) processHavingFilters() {
+	if len(qc.Query.havingFilters) == 0 {
+		return
+	}
+
+	if qc.isNonAggregationQuery || qc.OOPK.IsHLL() {
+		qc.Error = utils.StackError(nil, "having filters are only supported for non hll aggregation query")
+		return
+	}
+
+	for i, filter := range qc.Query.havingFilters {
+		qc.Query.havingFilters[i] = qc.resolvePostAggregationExpr(filter)
+		if qc.Error != nil {
+			return
+		}
+	}
+}
+
+// resolvePostAggregationExpr resolves an expression evaluated on aggregated
+// results. Measures can be referenced by alias or expression, and are
+// rewritten to VarRefs with ColumnID set to the index of the measure.
+// Only arithmetic, comparison and logical operators are supported.
+func (qc *AQLQueryContext) resolvePostAggregationExpr(expression expr.Expr) expr.Expr {
+	if measureIndex := qc.findMeasure(expression); measureIndex >= 0 {
+		return &expr.VarRef{
+			Val:      expression.String(),
+			ColumnID: measureIndex,
+			ExprType: expr.Float,
+		}
+	}
+
+	switch e := expression.(type) {
+	case *expr.ParenExpr:
+		e.Expr = qc.resolvePostAggregationExpr(e.Expr)
+	case *expr.NumberLiteral, *expr.BooleanLiteral:
+	case *expr.UnaryExpr:
+		switch e.Op {
+		case expr.NOT, expr.UNARY_MINUS, expr.IS_NULL, expr.IS_NOT_NULL:
+		default:
+			qc.Error = utils.StackError(nil, "unsupported operator %s in post aggregation expression: %s",
+				e.Op.String(), e.String())
+			return expression
+		}
+		e.Expr = qc.resolvePostAggregationExpr(e.Expr)
+	case *expr.BinaryExpr:
+		switch e.Op {
+		case expr.ADD, expr.SUB, expr.MUL, expr.DIV,
+			expr.EQ, expr.NEQ, expr.LT, expr.LTE, expr.GT, expr.GTE,
+			expr.AND, expr.OR:
+		default:
+			qc.Error = utils.StackError(nil, "unsupported operator %s in post aggregation expression: %s",
+				e.Op.String(), e.String())
+			return expression
+		}
+		e.LHS = qc.resolvePostAggregationExpr(e.LHS)
+		if qc.Error != nil {
+			return expression
+		}
+		e.RHS = qc.resolvePostAggregationExpr(e.RHS)
+	case *expr.VarRef, *expr.Call:
+		// hidden measures follow measures in values of having filters.
+		if hiddenIndex := qc.findHiddenMeasure(e); hiddenIndex >= 0 {
+			return &expr.VarRef{
+				Val:      e.String(),
+				ColumnID: len(qc.Query.Measures) + hiddenIndex,
+				ExprType: expr.Float,
+			}
+		}
+		qc.Error = utils.StackError(nil, "unknown measure in post aggregation expression: %s", e.String())
+	default:
+		qc.Error = utils.StackError(nil, "unsupported post aggregation expression: %s", e.String())
+	}
+	return expression
+}
+
+// findMeasure returns the index of the measure matching the expression by
+// alias or by expression, -1 if not found.
+func (qc *AQLQueryContext) findMeasure(expression expr.Expr) int {
+	str := expression.String()
+	for i, measure := range qc.Query.Measures {
+		if measure.Alias != "" && measure.Alias == str {
+			return i
+		}
+	}
+
+	for i, measure := range qc.Query.Measures {
+		// Parse the raw expression again as the compiled one has been rewritten.
+		if measureExpr, err := expr.ParseExpr(measure.Expr); err == nil && measureExpr.String() == str {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveResultField finds the dimension or measure referenced by name, which
+// can be either the alias or the expression of the dimension or measure.
+func (qc *AQLQueryContext) resolveResultField(name string) (index int, isMeasure bool, found bool) {
+	for i, dim := range qc.Query.Dimensions {
+		if name == dim.Alias || name == dim.Expr {
+			return i, false, true
+		}
+	}
+
+	for i, measure := range qc.Query.Measures {
+		if name == measure.Alias || name == measure.Expr {
+			return i, true, true
+		}
+	}
+	return
+}
+
 func getDimensionDataType(expression expr.Expr) memCom.DataType {
 	if e, ok := expression.(*expr.VarRef); ok {
 		return e.DataType
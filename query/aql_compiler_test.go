@@ -412,28 +412,36 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 				ExprType: expr.Unsigned,
 			},
 		}))
-		Ω(qc.Query.Dimensions[6].expr).Should(Equal(&expr.Case{
+		// case is rewritten into conditional binary expressions.
+		when := &expr.NumberLiteral{
+			Val:      1.3,
+			Int:      1,
+			Expr:     "1.3",
+			ExprType: expr.Boolean,
+		}
+		Ω(qc.Query.Dimensions[6].expr).Should(Equal(&expr.BinaryExpr{
+			Op:       expr.COALESCE,
 			ExprType: expr.Float,
-			Else: &expr.NumberLiteral{
-				Val:      3.2,
-				Int:      3,
-				Expr:     "3.2",
+			LHS: &expr.BinaryExpr{
+				Op:       expr.IF_TRUE,
 				ExprType: expr.Float,
+				LHS:      when,
+				RHS: &expr.NumberLiteral{
+					Val:      2,
+					Int:      2,
+					Expr:     "2",
+					ExprType: expr.Float,
+				},
 			},
-			WhenThens: []expr.WhenThen{
-				{
-					When: &expr.NumberLiteral{
-						Val:      1.3,
-						Int:      1,
-						Expr:     "1.3",
-						ExprType: expr.Boolean,
-					},
-					Then: &expr.NumberLiteral{
-						Val:      2,
-						Int:      2,
-						Expr:     "2",
-						ExprType: expr.Float,
-					},
+			RHS: &expr.BinaryExpr{
+				Op:       expr.IF_NOT_TRUE,
+				ExprType: expr.Float,
+				LHS:      when,
+				RHS: &expr.NumberLiteral{
+					Val:      3.2,
+					Int:      3,
+					Expr:     "3.2",
+					ExprType: expr.Float,
 				},
 			},
 		}))
@@ -1390,7 +1398,7 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		qc.processDimensions()
 		Ω(qc.Error).Should(BeNil())
 
-		Ω(qc.OOPK.Measure).Should(Equal(&expr.VarRef{
+		Ω(qc.OOPK.Measures[0].Measure).Should(Equal(&expr.VarRef{
 			Val:      "fare",
 			ColumnID: 3,
 			ExprType: expr.Float,
@@ -1468,6 +1476,551 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		Ω(qc.OOPK.Dimensions).Should(HaveLen(7))
 	})
 
+	ginkgo.It("processes multiple measures", func() {
+		table := metaCom.Table{
+			Columns: []metaCom.Column{
+				{Name: "status", Type: metaCom.Uint8},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "is_first", Type: metaCom.Bool},
+				{Name: "fare", Type: metaCom.Float32},
+				{Name: "request_at", Type: metaCom.Uint32},
+			},
+		}
+		schema := memstore.NewTableSchema(&table)
+
+		qc := &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Expr: "count(*)"},
+				{Expr: "sum(fare)"},
+				{Alias: "avg_status", Expr: "avg(status)"},
+			},
+			Dimensions: []Dimension{
+				{Expr: "city_id"},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processFilters()
+		Ω(qc.Error).Should(BeNil())
+		qc.processMeasure()
+		Ω(qc.Error).Should(BeNil())
+		qc.processDimensions()
+		Ω(qc.Error).Should(BeNil())
+
+		Ω(qc.OOPK.Measures).Should(HaveLen(3))
+		Ω(qc.OOPK.Measures[0].AggregateType).Should(BeEquivalentTo(1))
+		Ω(qc.OOPK.Measures[0].MeasureBytes).Should(Equal(4))
+		Ω(qc.OOPK.Measures[1].AggregateType).Should(BeEquivalentTo(3))
+		Ω(qc.OOPK.Measures[1].MeasureBytes).Should(Equal(8))
+		Ω(qc.OOPK.Measures[2].AggregateType).Should(BeEquivalentTo(11))
+		Ω(qc.OOPK.Measures[2].MeasureBytes).Should(Equal(8))
+		Ω(qc.OOPK.MeasureRowBytes()).Should(Equal(20))
+		Ω(getMeasureStartOffsets(qc.OOPK.Measures, 10)).Should(Equal([]int{0, 40, 120}))
+		Ω(qc.OOPK.IsHLL()).Should(BeFalse())
+		Ω(qc.TableScanners[0].ColumnUsages).Should(Equal(map[int]columnUsage{
+			0: columnUsedByAllBatches,
+			1: columnUsedByAllBatches,
+			3: columnUsedByAllBatches,
+		}))
+
+		// measures with different filters.
+		qc = &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Expr: "count(*)", Filters: []string{"is_first"}},
+				{Expr: "sum(fare)"},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processFilters()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.MainTableCommonFilters).Should(BeEmpty())
+		qc.processMeasure()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.Measures[0].Measure.String()).Should(Equal("is_first IF_TRUE 1"))
+		Ω(qc.OOPK.Measures[1].Measure.String()).Should(Equal("fare"))
+
+		// groups with all rows filtered out can not get nulls for min and max.
+		for _, measureExpr := range []string{"min(fare)", "max(fare)"} {
+			qc.Error = nil
+			qc.Query.Measures = []Measure{
+				{Expr: "count(*)"},
+				{Expr: measureExpr, Filters: []string{"is_first"}},
+			}
+			qc.parseExprs()
+			Ω(qc.Error).Should(BeNil())
+			qc.resolveTypes()
+			Ω(qc.Error).Should(BeNil())
+			qc.processFilters()
+			Ω(qc.Error).Should(BeNil())
+			qc.processMeasure()
+			Ω(qc.Error).ShouldNot(BeNil())
+		}
+
+		// duplicate measure names.
+		qc.Error = nil
+		qc.Query.Measures = []Measure{
+			{Expr: "count(*)"},
+			{Alias: "count(*)", Expr: "sum(fare)"},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processMeasure()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		// hll can not be used together with other measures.
+		qc.Error = nil
+		qc.Query.Measures = []Measure{
+			{Expr: "count(*)"},
+			{Expr: "hll(city_id)"},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processMeasure()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("processes derived measures", func() {
+		table := metaCom.Table{
+			Columns: []metaCom.Column{
+				{Name: "status", Type: metaCom.Uint8},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "is_first", Type: metaCom.Bool},
+				{Name: "fare", Type: metaCom.Float32},
+			},
+		}
+		schema := memstore.NewTableSchema(&table)
+
+		qc := &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Alias: "fare_per_trip", Expr: "total_fare / trips"},
+				{Alias: "trips", Expr: "count(*)"},
+				{Expr: "max(fare) - min(fare)"},
+			},
+			SupportingMeasures: []Measure{
+				{Alias: "total_fare", Expr: "sum(fare)"},
+			},
+			Dimensions: []Dimension{
+				{Expr: "city_id"},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.aggregateMeasures).Should(HaveLen(4))
+		Ω(qc.Query.aggregateMeasures[0]).Should(BeIdenticalTo(&qc.Query.Measures[1]))
+		Ω(qc.Query.aggregateMeasures[1].Expr).Should(Equal("sum(fare)"))
+		Ω(qc.Query.aggregateMeasures[2].Expr).Should(Equal("max(fare)"))
+		Ω(qc.Query.aggregateMeasures[3].Expr).Should(Equal("min(fare)"))
+		Ω(qc.Query.measureExprs).Should(Equal([]expr.Expr{
+			&expr.BinaryExpr{
+				Op:  expr.DIV,
+				LHS: &expr.ParenExpr{Expr: &expr.VarRef{Val: "sum(fare)", ColumnID: 1, ExprType: expr.Float}},
+				RHS: &expr.ParenExpr{Expr: &expr.VarRef{Val: "count(*)", ColumnID: 0, ExprType: expr.Float}},
+			},
+			&expr.VarRef{Val: "trips", ColumnID: 0, ExprType: expr.Float},
+			&expr.BinaryExpr{
+				Op:  expr.SUB,
+				LHS: &expr.VarRef{Val: "max(fare)", ColumnID: 2, ExprType: expr.Float},
+				RHS: &expr.VarRef{Val: "min(fare)", ColumnID: 3, ExprType: expr.Float},
+			},
+		}))
+
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processFilters()
+		Ω(qc.Error).Should(BeNil())
+		qc.processMeasure()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.Measures).Should(HaveLen(4))
+		Ω(qc.OOPK.Measures[1].AggregateType).Should(BeEquivalentTo(3))
+		Ω(qc.OOPK.Measures[2].AggregateType).Should(BeEquivalentTo(9))
+
+		// ratio of measures with different filters.
+		qc.OOPK = OOPKContext{}
+		qc.Query.Measures = []Measure{
+			{Alias: "completion_rate", Expr: "completed / first_trips"},
+		}
+		qc.Query.SupportingMeasures = []Measure{
+			{Alias: "completed", Expr: "count(*)", Filters: []string{"city_id = 1", "status = 1", "is_first"}},
+			{Alias: "first_trips", Expr: "count(*)", Filters: []string{"is_first", "city_id = 1"}},
+		}
+		qc.Query.Filters = []string{"fare > 0"}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.aggregateMeasures).Should(HaveLen(2))
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processFilters()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(3))
+		Ω(qc.OOPK.MainTableCommonFilters[0].String()).Should(Equal("city_id = 1"))
+		Ω(qc.OOPK.MainTableCommonFilters[1].String()).Should(Equal("is_first"))
+		Ω(qc.OOPK.MainTableCommonFilters[2].String()).Should(Equal("fare > 0"))
+		qc.processMeasure()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.Measures).Should(HaveLen(2))
+		Ω(qc.OOPK.Measures[0].Measure.String()).Should(Equal("status = 1 IF_TRUE 1"))
+		Ω(qc.OOPK.Measures[1].Measure.String()).Should(Equal("1"))
+		qc.Query.Filters = nil
+		qc.Query.SupportingMeasures = nil
+
+		// columns must be aggregated.
+		qc.Query.Measures = []Measure{{Expr: "sum(fare) + fare"}}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		// circular reference.
+		qc.Error = nil
+		qc.Query.Measures = []Measure{{Alias: "a", Expr: "b + 1"}, {Alias: "b", Expr: "a * 2"}}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		// hll can not be derived.
+		qc.Error = nil
+		qc.Query.Measures = []Measure{{Expr: "hll(fare) * 2"}}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		// no aggregate function.
+		qc.Error = nil
+		qc.Query.Measures = []Measure{{Expr: "1 + 2"}}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("processes window measures", func() {
+		table := metaCom.Table{
+			Columns: []metaCom.Column{
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: metaCom.Float32},
+			},
+		}
+		schema := memstore.NewTableSchema(&table)
+
+		qc := &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Alias: "trips", Expr: "count(*)"},
+				{Expr: "cumsum(trips)"},
+				{Expr: "moving_avg(sum(fare) / trips, 7)"},
+				{Expr: "rate(count(*))"},
+			},
+			Dimensions: []Dimension{
+				{Expr: "city_id"},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.aggregateMeasures).Should(HaveLen(2))
+		Ω(qc.Query.measureWindows).Should(Equal([]*measureWindow{
+			nil,
+			{function: cumsumCallName},
+			{function: movingAvgCallName, size: 7},
+			{function: rateCallName},
+		}))
+		Ω(qc.Query.measureExprs).Should(Equal([]expr.Expr{
+			&expr.VarRef{Val: "trips", ColumnID: 0, ExprType: expr.Float},
+			&expr.ParenExpr{Expr: &expr.VarRef{Val: "count(*)", ColumnID: 0, ExprType: expr.Float}},
+			&expr.BinaryExpr{
+				Op:  expr.DIV,
+				LHS: &expr.VarRef{Val: "sum(fare)", ColumnID: 1, ExprType: expr.Float},
+				RHS: &expr.ParenExpr{Expr: &expr.VarRef{Val: "count(*)", ColumnID: 0, ExprType: expr.Float}},
+			},
+			&expr.VarRef{Val: "count(*)", ColumnID: 0, ExprType: expr.Float},
+		}))
+
+		// exactly one time dimension is required.
+		qc.processMeasureWindows()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.Dimensions = []Dimension{{Expr: "request_at", TimeBucketizer: "day"}, {Expr: "city_id"}}
+		qc.processMeasureWindows()
+		Ω(qc.Error).Should(BeNil())
+
+		qc.Query.Dimensions = []Dimension{{Expr: "request_at", TimeBucketizer: "day of week"}}
+		qc.processMeasureWindows()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		// the number of buckets must be a positive integer.
+		for _, measure := range []string{"moving_avg(count(*))", "moving_avg(count(*), 0)", "moving_avg(count(*), 1.5)",
+			"cumsum(count(*), 2)", "cumsum(cumsum(count(*)))"} {
+			qc.Error = nil
+			qc.Query.Measures = []Measure{{Expr: measure}}
+			qc.parseExprs()
+			Ω(qc.Error).ShouldNot(BeNil())
+		}
+	})
+
+	ginkgo.It("processes grouping sets", func() {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Alias: "city", Expr: "city_id"},
+					{Expr: "product_id"},
+				},
+				Measures: []Measure{
+					{Expr: "count(*)"},
+				},
+				GroupingSets: [][]string{{"city", "product_id"}, {"city"}, {}},
+			},
+		}
+		qc.processGroupingSets()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.groupingSets).Should(Equal([][]bool{{true, true}, {true, false}, {false, false}}))
+
+		qc.Query.GroupingSets = [][]string{{"city_id"}}
+		qc.processGroupingSets()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.GroupingSets = [][]string{{"city"}}
+		qc.Query.CompareTo = "-1 week"
+		qc.processGroupingSets()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.CompareTo = ""
+		qc.isNonAggregationQuery = true
+		qc.processGroupingSets()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("processes percentile measures", func() {
+		table := metaCom.Table{
+			Columns: []metaCom.Column{
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: metaCom.Float32},
+			},
+		}
+		schema := memstore.NewTableSchema(&table)
+
+		compile := func(returnHLL bool, measures ...string) *AQLQueryContext {
+			qc := &AQLQueryContext{
+				TableIDByAlias: map[string]int{
+					"trips": 0,
+				},
+				TableScanners: []*TableScanner{
+					{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+				},
+				ReturnHLLData: returnHLL,
+			}
+			qc.Query = &AQLQuery{
+				Table: "trips",
+				Dimensions: []Dimension{
+					{Expr: "city_id"},
+				},
+			}
+			for _, measure := range measures {
+				qc.Query.Measures = append(qc.Query.Measures, Measure{Expr: measure})
+			}
+			for _, process := range []func(){qc.parseExprs, qc.resolveTypes, qc.processFilters, qc.processMeasure} {
+				if qc.Error != nil {
+					break
+				}
+				process()
+			}
+			return qc
+		}
+
+		qc := compile(false, "percentile(fare, 0.95)", "count(*)", "percentile(fare, 0.5) - avg(fare)")
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.Measures).Should(HaveLen(4))
+		Ω(qc.OOPK.Measures[0].IsPercentile).Should(BeTrue())
+		Ω(qc.OOPK.Measures[0].Percentile).Should(Equal(0.95))
+		Ω(qc.OOPK.Measures[0].MeasureBytes).Should(Equal(8))
+		Ω(qc.OOPK.Measures[0].AggregateType).Should(BeEquivalentTo(11))
+		Ω(qc.OOPK.Measures[0].Measure).Should(Equal(&expr.VarRef{
+			Val:      "fare",
+			ColumnID: 1,
+			DataType: memCom.Float32,
+			ExprType: expr.Float,
+		}))
+		Ω(qc.OOPK.Measures[1].IsPercentile).Should(BeFalse())
+		Ω(qc.OOPK.Measures[2].IsPercentile).Should(BeTrue())
+		Ω(qc.OOPK.Measures[2].Percentile).Should(Equal(0.5))
+		Ω(qc.OOPK.IsPercentile()).Should(BeFalse())
+
+		qc = compile(true, "percentile(fare, 0.95)")
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.IsPercentile()).Should(BeTrue())
+
+		// only one percentile measure can be returned as hll data.
+		Ω(compile(true, "percentile(fare, 0.95)", "count(*)").Error).ShouldNot(BeNil())
+		Ω(compile(true, "percentile(fare, 0.95) * 2").Error).ShouldNot(BeNil())
+
+		// invalid percentiles.
+		Ω(compile(false, "percentile(fare)").Error).ShouldNot(BeNil())
+		Ω(compile(false, "percentile(fare, 1.5)").Error).ShouldNot(BeNil())
+		Ω(compile(false, "percentile(fare, city_id)").Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("processes sorts of aggregation query", func() {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "trips",
+				Measures: []Measure{
+					{Alias: "trips", Expr: "count(*)"},
+					{Expr: "sum(fare)"},
+				},
+				Dimensions: []Dimension{
+					{Alias: "city", Expr: "city_id"},
+				},
+				Sorts: []SortField{
+					{Name: "trips", Order: "DESC"},
+					{Name: "sum(fare)"},
+					{Name: "city_id", Order: "asc"},
+				},
+				Limit: 20,
+			},
+		}
+		qc.processSorts()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.aggregationSorts).Should(Equal([]aggregationSort{
+			{index: 0, isMeasure: true, desc: true},
+			{index: 1, isMeasure: true},
+			{index: 0},
+		}))
+
+		qc.aggregationSorts = nil
+		qc.Query.Sorts = []SortField{{Name: "trips", Order: "down"}}
+		qc.processSorts()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.Sorts = []SortField{{Name: "unknown"}}
+		qc.processSorts()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		// sorts are not resolved for non aggregation query.
+		qc.Error = nil
+		qc.isNonAggregationQuery = true
+		qc.processSorts()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.aggregationSorts).Should(BeEmpty())
+	})
+
+	ginkgo.It("processes having filters", func() {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "trips",
+				Measures: []Measure{
+					{Alias: "trips", Expr: "count(*)"},
+					{Expr: "sum(fare)"},
+				},
+				HavingFilters: []string{
+					"trips > 100 AND sum(fare) / trips >= 2.5",
+				},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.processHavingFilters()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.havingFilters[0]).Should(Equal(&expr.BinaryExpr{
+			Op: expr.AND,
+			LHS: &expr.BinaryExpr{
+				Op:  expr.GT,
+				LHS: &expr.VarRef{Val: "trips", ColumnID: 0, ExprType: expr.Float},
+				RHS: &expr.NumberLiteral{Val: 100, Int: 100, Expr: "100", ExprType: expr.Unsigned},
+			},
+			RHS: &expr.BinaryExpr{
+				Op: expr.GTE,
+				LHS: &expr.BinaryExpr{
+					Op:  expr.DIV,
+					LHS: &expr.VarRef{Val: "sum(fare)", ColumnID: 1, ExprType: expr.Float},
+					RHS: &expr.VarRef{Val: "trips", ColumnID: 0, ExprType: expr.Float},
+				},
+				RHS: &expr.NumberLiteral{Val: 2.5, Int: 2, Expr: "2.5", ExprType: expr.Float},
+			},
+		}))
+
+		qc.Query.havingFilters = []expr.Expr{&expr.VarRef{Val: "unknown"}}
+		qc.processHavingFilters()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		// aggregate functions not in measures are added as hidden measures.
+		qc.Error = nil
+		qc.Query.HavingFilters = []string{"count(*) > 100 AND sum(fare) > 10"}
+		qc.Query.Measures = []Measure{{Expr: "sum(fare)"}}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.aggregateMeasures).Should(HaveLen(2))
+		Ω(qc.Query.hiddenMeasures).Should(Equal([]*expr.VarRef{{Val: "count(*)", ColumnID: 1, ExprType: expr.Float}}))
+		Ω(qc.Query.measureExprs).Should(Equal([]expr.Expr{&expr.VarRef{Val: "sum(fare)", ColumnID: 0, ExprType: expr.Float}}))
+		qc.processHavingFilters()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.havingFilters[0].(*expr.BinaryExpr).LHS).Should(Equal(&expr.BinaryExpr{
+			Op:  expr.GT,
+			LHS: &expr.VarRef{Val: "count(*)", ColumnID: 1, ExprType: expr.Float},
+			RHS: &expr.NumberLiteral{Val: 100, Int: 100, Expr: "100", ExprType: expr.Unsigned},
+		}))
+		Ω(qc.Query.havingFilters[0].(*expr.BinaryExpr).RHS.(*expr.BinaryExpr).LHS).Should(Equal(
+			&expr.VarRef{Val: "sum(fare)", ColumnID: 0, ExprType: expr.Float}))
+
+		qc.Query.HavingFilters = []string{"hll(driver_id) > 100"}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.havingFilters = []expr.Expr{&expr.BinaryExpr{
+			Op:  expr.BITWISE_AND,
+			LHS: &expr.VarRef{Val: "trips"},
+			RHS: &expr.NumberLiteral{Val: 1, Int: 1, Expr: "1"},
+		}}
+		qc.processHavingFilters()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.isNonAggregationQuery = true
+		qc.Query.havingFilters = []expr.Expr{&expr.VarRef{Val: "trips"}}
+		qc.processHavingFilters()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
 	ginkgo.It("sorts used columns", func() {
 		schema := &memstore.TableSchema{
 			Schema: metaCom.Table{
@@ -3597,4 +4150,464 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		qc.processFilters()
 		Ω(qc.Error).Should(BeNil())
 	})
+
+	ginkgo.It("case when and if should work", func() {
+		query := &AQLQuery{
+			Table: "table1",
+			Dimensions: []Dimension{
+				{Expr: "case when distance < 5 then 'short' when distance < 20 then 'medium' else 'long' end"},
+				{Expr: "if(distance > 10, distance, 0)"},
+			},
+			Measures: []Measure{
+				{Expr: "sum(case when distance < 5 then 1 end)"},
+			},
+			Filters: []string{"case when distance < 5 then 'short' else 'long' end = 'long'"},
+		}
+		tableSchema := &memstore.TableSchema{
+			ColumnIDs: map[string]int{
+				"distance": 0,
+			},
+			Schema: metaCom.Table{
+				Name:        "table1",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "distance", Type: metaCom.Float32},
+				},
+			},
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Float32,
+			},
+		}
+		qc := AQLQueryContext{
+			Query: query,
+			TableSchemaByName: map[string]*memstore.TableSchema{
+				"table1": tableSchema,
+			},
+			TableIDByAlias: map[string]int{
+				"table1": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: tableSchema, ColumnUsages: make(map[int]columnUsage)},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+
+		// string results are translated into enum cases, and case is rewritten
+		// into conditional binary expressions for the VM.
+		dim0 := qc.Query.Dimensions[0].expr
+		Ω(dim0.String()).Should(Equal("distance < 5 IF_TRUE 0 COALESCE distance < 5 IF_NOT_TRUE " +
+			"distance < 20 IF_TRUE 1 COALESCE distance < 20 IF_NOT_TRUE 2"))
+		Ω(dim0.Type()).Should(Equal(expr.Unsigned))
+		Ω(qc.getEnumReverseDict(0, dim0)).Should(Equal([]string{"short", "medium", "long"}))
+		Ω(getDimensionDataType(dim0)).Should(Equal(memCom.Uint32))
+
+		// if is rewritten to case.
+		dim1 := qc.Query.Dimensions[1].expr
+		Ω(dim1.String()).Should(Equal("distance > 10 IF_TRUE distance COALESCE distance > 10 IF_NOT_TRUE 0"))
+		Ω(dim1.Type()).Should(Equal(expr.Float))
+		Ω(qc.getEnumReverseDict(1, dim1)).Should(BeNil())
+
+		measure := qc.Query.aggregateMeasures[0].expr.(*expr.Call)
+		Ω(measure.Args[0].String()).Should(Equal("distance < 5 IF_TRUE 1"))
+		Ω(measure.Args[0].Type()).Should(Equal(expr.Unsigned))
+
+		Ω(qc.Query.filters).Should(HaveLen(1))
+		Ω(qc.Query.filters[0].(*expr.BinaryExpr).RHS).Should(Equal(&expr.NumberLiteral{
+			Int:      1,
+			ExprType: expr.Unsigned,
+		}))
+
+		// memory of case expressions is estimated as they are executed.
+		_, maxMemUsage := estimateScratchSpaceMemUsage(dim0, 100, true)
+		Ω(maxMemUsage).Should(BeNumerically(">", 0))
+
+		qc.Query = &AQLQuery{
+			Table:      "table1",
+			Dimensions: []Dimension{{Expr: "case when distance < 5 then 'short' else 1 end"}},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query = &AQLQuery{
+			Table:      "table1",
+			Dimensions: []Dimension{{Expr: "if(distance > 10, distance)"}},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("coalesce and ifnull should work", func() {
+		tableSchema := &memstore.TableSchema{
+			ColumnIDs: map[string]int{
+				"fare":     0,
+				"city_id":  1,
+				"is_first": 2,
+			},
+			Schema: metaCom.Table{
+				Name:        "table1",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "fare", Type: metaCom.Float32},
+					{Name: "city_id", Type: metaCom.Uint16},
+					{Name: "is_first", Type: metaCom.Bool},
+				},
+			},
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Float32,
+				memCom.Uint16,
+				memCom.Bool,
+			},
+		}
+		qc := AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "table1",
+				Dimensions: []Dimension{
+					{Expr: "coalesce(city_id, 0)"},
+					{Expr: "coalesce(city_id, fare, 1)"},
+					{Expr: "ifnull(is_first, true)"},
+				},
+				Measures: []Measure{
+					{Expr: "sum(ifnull(fare, 0))"},
+				},
+			},
+			TableSchemaByName: map[string]*memstore.TableSchema{
+				"table1": tableSchema,
+			},
+			TableIDByAlias: map[string]int{
+				"table1": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: tableSchema, ColumnUsages: make(map[int]columnUsage)},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+
+		Ω(qc.Query.Dimensions[0].expr.String()).Should(Equal("city_id COALESCE 0"))
+		Ω(qc.Query.Dimensions[0].expr.Type()).Should(Equal(expr.Unsigned))
+		Ω(getDimensionDataType(qc.Query.Dimensions[0].expr)).Should(Equal(memCom.Uint32))
+
+		// arguments are casted to the highest type.
+		Ω(qc.Query.Dimensions[1].expr.String()).Should(Equal("(city_id) COALESCE fare COALESCE 1"))
+		Ω(qc.Query.Dimensions[1].expr.Type()).Should(Equal(expr.Float))
+		Ω(qc.Query.Dimensions[1].expr.(*expr.BinaryExpr).RHS.Type()).Should(Equal(expr.Float))
+
+		Ω(qc.Query.Dimensions[2].expr).Should(Equal(&expr.BinaryExpr{
+			Op: expr.COALESCE,
+			LHS: &expr.VarRef{
+				Val:      "is_first",
+				ExprType: expr.Boolean,
+				DataType: memCom.Bool,
+				ColumnID: 2,
+			},
+			RHS: &expr.NumberLiteral{
+				Val:      1,
+				Int:      1,
+				Expr:     "true",
+				ExprType: expr.Boolean,
+			},
+			ExprType: expr.Boolean,
+		}))
+
+		measure := qc.Query.aggregateMeasures[0].expr.(*expr.Call)
+		Ω(measure.ExprType).Should(Equal(expr.Float))
+		Ω(measure.Args[0].String()).Should(Equal("fare COALESCE 0"))
+
+		for _, dimension := range []string{"ifnull(fare)", "ifnull(fare, 1, 2)", "coalesce(fare)", "coalesce(fare, 'a')"} {
+			qc.Error = nil
+			qc.Query = &AQLQuery{
+				Table:      "table1",
+				Dimensions: []Dimension{{Expr: dimension}},
+			}
+			qc.parseExprs()
+			Ω(qc.Error).Should(BeNil())
+			qc.resolveTypes()
+			Ω(qc.Error).ShouldNot(BeNil())
+		}
+	})
+
+	ginkgo.It("pattern matching on enum columns should work", func() {
+		tableSchema := &memstore.TableSchema{
+			ColumnIDs: map[string]int{
+				"city": 0,
+				"fare": 1,
+			},
+			Schema: metaCom.Table{
+				Name:        "table1",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "city", Type: metaCom.SmallEnum},
+					{Name: "fare", Type: metaCom.Float32},
+				},
+			},
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.SmallEnum,
+				memCom.Float32,
+			},
+			EnumDicts: map[string]memstore.EnumDict{
+				"city": {
+					Dict: map[string]int{
+						"San Francisco": 0,
+						"San Jose":      1,
+						"Los Angeles":   2,
+						"Santa_Cruz":    3,
+					},
+					ReverseDict: []string{"San Francisco", "San Jose", "Los Angeles", "Santa_Cruz"},
+				},
+			},
+		}
+		qc := AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "table1",
+				Filters: []string{
+					"city LIKE 'San %'",
+					"city NOT LIKE 'San%'",
+					"starts_with(city, 'Los')",
+					"regexp_like(city, 'o$')",
+					"city LIKE 'Santa\\\\_%'",
+					"city LIKE 'Boston'",
+				},
+			},
+			TableSchemaByName: map[string]*memstore.TableSchema{
+				"table1": tableSchema,
+			},
+			TableIDByAlias: map[string]int{
+				"table1": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: tableSchema, ColumnUsages: make(map[int]columnUsage)},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+
+		Ω(qc.Query.filters).Should(HaveLen(6))
+		Ω(qc.Query.filters[0].String()).Should(Equal("city = 0 OR city = 1"))
+		Ω(qc.Query.filters[1].String()).Should(Equal("NOT(city = 0 OR city = 1 OR city = 3)"))
+		Ω(qc.Query.filters[2].String()).Should(Equal("city = 2"))
+		Ω(qc.Query.filters[3].String()).Should(Equal("city = 0"))
+		Ω(qc.Query.filters[4].String()).Should(Equal("city = 3"))
+		Ω(qc.Query.filters[5].String()).Should(Equal("city = -1"))
+		Ω(qc.Query.filters[0].Type()).Should(Equal(expr.Boolean))
+
+		for _, filter := range []string{
+			"fare LIKE 'San %'",
+			"city LIKE 1",
+			"city LIKE 'San\\\\'",
+			"regexp_like(city, '(')",
+			"starts_with(city, 1)",
+			"starts_with(city)",
+		} {
+			qc.Error = nil
+			qc.Query = &AQLQuery{
+				Table:   "table1",
+				Filters: []string{filter},
+			}
+			qc.parseExprs()
+			Ω(qc.Error).Should(BeNil())
+			qc.resolveTypes()
+			Ω(qc.Error).ShouldNot(BeNil())
+		}
+	})
+
+	ginkgo.It("math functions should work", func() {
+		tableSchema := &memstore.TableSchema{
+			ColumnIDs: map[string]int{
+				"fare":    0,
+				"city_id": 1,
+				"delta":   2,
+				"uuid":    3,
+			},
+			Schema: metaCom.Table{
+				Name:        "table1",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "fare", Type: metaCom.Float32},
+					{Name: "city_id", Type: metaCom.Uint16},
+					{Name: "delta", Type: metaCom.Int32},
+					{Name: "uuid", Type: metaCom.UUID},
+				},
+			},
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Float32,
+				memCom.Uint16,
+				memCom.Int32,
+				memCom.UUID,
+			},
+		}
+		qc := AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "table1",
+				Dimensions: []Dimension{
+					{Expr: "round(fare, 0)"},
+					{Expr: "round(fare)"},
+					{Expr: "abs(delta)"},
+					{Expr: "abs(city_id)"},
+					{Expr: "ceil(fare)"},
+					{Expr: "ceil(city_id)"},
+					{Expr: "greatest(city_id, delta, 0)"},
+					{Expr: "least(fare, 1)"},
+				},
+				Measures: []Measure{
+					{Expr: "sum(sqrt(fare*fare + delta*delta))"},
+					{Expr: "avg(ln(fare) + log10(city_id))"},
+					{Expr: "max(pow(city_id, 2))"},
+				},
+				Filters: []string{"abs(delta) > 10"},
+			},
+			TableSchemaByName: map[string]*memstore.TableSchema{
+				"table1": tableSchema,
+			},
+			TableIDByAlias: map[string]int{
+				"table1": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: tableSchema, ColumnUsages: make(map[int]columnUsage)},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+
+		dimensions := qc.Query.Dimensions
+		Ω(dimensions[0].expr).Should(Equal(&expr.BinaryExpr{
+			Op: expr.ROUND,
+			LHS: &expr.VarRef{
+				Val:      "fare",
+				ExprType: expr.Float,
+				DataType: memCom.Float32,
+			},
+			RHS: &expr.NumberLiteral{
+				Expr:     "0",
+				ExprType: expr.Unsigned,
+			},
+			ExprType: expr.Float,
+		}))
+		Ω(dimensions[1].expr.String()).Should(Equal("fare ROUND 0"))
+		Ω(dimensions[2].expr.String()).Should(Equal("ABS(delta)"))
+		Ω(dimensions[2].expr.Type()).Should(Equal(expr.Signed))
+		Ω(dimensions[3].expr.String()).Should(Equal("city_id"))
+		Ω(dimensions[4].expr.String()).Should(Equal("CEIL(fare)"))
+		Ω(dimensions[4].expr.Type()).Should(Equal(expr.Float))
+		Ω(dimensions[5].expr.String()).Should(Equal("city_id"))
+		Ω(dimensions[6].expr.String()).Should(Equal("city_id GREATEST delta GREATEST 0"))
+		Ω(dimensions[6].expr.Type()).Should(Equal(expr.Signed))
+		Ω(dimensions[7].expr.String()).Should(Equal("fare LEAST 1"))
+		Ω(dimensions[7].expr.(*expr.BinaryExpr).RHS.Type()).Should(Equal(expr.Float))
+
+		measures := qc.Query.aggregateMeasures
+		Ω(measures[0].expr.String()).Should(Equal("sum(SQRT(fare * fare + (delta * delta)))"))
+		Ω(measures[0].expr.Type()).Should(Equal(expr.Float))
+		Ω(measures[1].expr.String()).Should(Equal("avg(LN(fare) + LOG10((city_id)))"))
+		Ω(measures[2].expr.String()).Should(Equal("max((city_id) POW 2)"))
+		Ω(measures[2].expr.Type()).Should(Equal(expr.Float))
+
+		Ω(qc.Query.filters[0].String()).Should(Equal("ABS(delta) > 10"))
+
+		for _, dimension := range []string{"abs(uuid)", "abs(fare, 1)", "pow(fare)", "round(fare, 1, 2)", "greatest(fare)",
+			"sqrt('a')"} {
+			qc.Error = nil
+			qc.Query = &AQLQuery{
+				Table:      "table1",
+				Dimensions: []Dimension{{Expr: dimension}},
+			}
+			qc.parseExprs()
+			Ω(qc.Error).Should(BeNil())
+			qc.resolveTypes()
+			Ω(qc.Error).ShouldNot(BeNil())
+		}
+	})
+
+	ginkgo.It("compareTo should work", func() {
+		for expression, days := range map[string]int{
+			"-1 week":  -7,
+			"-2 weeks": -14,
+			"-1 day":   -1,
+			"-3 days":  -3,
+			"-1w":      -7,
+			"-1d":      -1,
+		} {
+			Ω(parseCompareTo(expression)).Should(Equal(days))
+		}
+		for _, expression := range []string{"", "0 day", "-1 month", "-1 hour", "last week", "-1 week ago"} {
+			_, err := parseCompareTo(expression)
+			Ω(err).ShouldNot(BeNil())
+		}
+
+		loc, _ := time.LoadLocation("America/Los_Angeles")
+		Ω(shiftDays(time.Date(2018, 11, 5, 10, 30, 0, 0, loc), -7)).
+			Should(BeTemporally("==", time.Date(2018, 10, 29, 10, 30, 0, 0, loc)))
+
+		q := &AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Expr: "count()"},
+			},
+			Dimensions: []Dimension{
+				{Expr: "request_at", TimeBucketizer: "hour", FillGaps: "zero"},
+			},
+			TimeFilter: TimeFilter{
+				Column: "trips.request_at",
+				From:   "2018-11-05",
+				To:     "2018-11-05",
+			},
+			HavingFilters: []string{"count() > 1"},
+			Sorts:         []SortField{{Name: "count()"}},
+			Limit:         10,
+			Timezone:      "America/Los_Angeles",
+			CompareTo:     "-1 week",
+		}
+		compareQuery := q.clone()
+		qc := &AQLQueryContext{Query: q}
+		qc.processTimezone()
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+
+		qc.processCompareTo(compareQuery)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.CompareQuery).Should(Equal(&AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Expr: "count()"},
+			},
+			Dimensions: []Dimension{
+				{Expr: "request_at", TimeBucketizer: "hour"},
+			},
+			// across daylight saving time switch.
+			TimeFilter: TimeFilter{
+				Column: "trips.request_at",
+				From:   "1540796400",
+				To:     "1540882800",
+			},
+			Timezone: "America/Los_Angeles",
+		}))
+		Ω(qc.compareTimeShift).Should(BeEquivalentTo(7 * 86400))
+
+		// month has various number of days.
+		qc.Query.Dimensions[0].TimeBucketizer = "month"
+		qc.processCompareTo(compareQuery)
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.Dimensions[0].TimeBucketizer = "week"
+		qc.processCompareTo(compareQuery)
+		Ω(qc.Error).Should(BeNil())
+
+		qc.fromTime = nil
+		qc.processCompareTo(compareQuery)
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
 })
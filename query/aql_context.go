@@ -19,6 +19,7 @@ import "C"
 
 import (
 	"bytes"
+	"context"
 	"github.com/uber/aresdb/memstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	queryCom "github.com/uber/aresdb/query/common"
@@ -208,7 +209,9 @@ type oopkBatchContext struct {
 	dimIndexVectorD [2]devicePointer
 
 	// Each element stores a 4 byte measure value.
-	// Except SUM that uses 8 bytes
+	// Except SUM and AVG that use 8 bytes.
+	// For queries with multiple measures, values of each measure are stored
+	// one after another, each taking resultCapacity elements.
 	measureVectorD [2]devicePointer
 
 	// Size of the results from prior batches.
@@ -218,6 +221,16 @@ type oopkBatchContext struct {
 	// resultSize+size.
 	resultCapacity int
 
+	// Dimension and percentile measure values of batches before reduce, which
+	// are added into percentile sketches after the final reduce or when the
+	// buffers are full. Values of each percentile measure are stored one after
+	// another, each taking percentileCapacity elements in the same format of
+	// the measure vector.
+	percentileDimensionVectorD devicePointer
+	percentileMeasureVectorD   devicePointer
+	percentileSize             int
+	percentileCapacity         int
+
 	// Query execution stats for current batch.
 	stats oopkBatchStats
 }
@@ -254,10 +267,11 @@ type OOPKContext struct {
 	// plus validity bytes, for memory allocation convenience
 	DimRowBytes int `json:"dimRowBytes"`
 
-	// For one-operator-per-kernel we only support one measure per query.
-	Measure       expr.Expr                `json:"measure"`
-	MeasureBytes  int                      `json:"measureBytes"`
-	AggregateType C.enum_AggregateFunction `json:"aggregate"`
+	// Compiled and annotated measures following the order of AQLQuery.Measures.
+	// All measures share the same dimensions and are reduced over the same
+	// sorted keys. Measure filters not shared by all measures are compiled
+	// into measure values.
+	Measures []OOPKMeasure `json:"measures"`
 
 	// Storage for current batch.
 	currentBatch oopkBatchContext
@@ -280,7 +294,13 @@ type OOPKContext struct {
 	hllVectorSize int64
 	// hllDimRegIDCountD stores regID count for each dim in device memory.
 	hllDimRegIDCountD devicePointer
-	ResultSize        int `json:"resultSize"`
+	// percentileSketches stores the t-digest sketches of each percentile measure
+	// keyed by dimension values, see getDimensionKey. nil for other measures.
+	percentileSketches []map[string]*queryCom.TDigest
+	// percentileBufferRows is the number of rows of the percentile buffers
+	// reserved in DeviceMemoryRequirement, see estimatePercentileBufferMemUsage.
+	percentileBufferRows int
+	ResultSize           int `json:"resultSize"`
 
 	// For reporting purpose only.
 	DeviceMemoryRequirement int           `json:"deviceMem"`
@@ -295,6 +315,27 @@ type OOPKContext struct {
 	done bool
 }
 
+// OOPKMeasure stores the compiled measure expression (with the aggregate
+// function stripped) and how its values are aggregated.
+type OOPKMeasure struct {
+	Measure       expr.Expr                `json:"measure"`
+	MeasureBytes  int                      `json:"measureBytes"`
+	AggregateType C.enum_AggregateFunction `json:"aggregate"`
+	// IsPercentile indicates the measure values are added into per dimension
+	// t-digest sketches to estimate the Percentile.
+	IsPercentile bool    `json:"isPercentile,omitempty"`
+	Percentile   float64 `json:"percentile,omitempty"`
+}
+
+// aggregationSort is a SortField resolved against dimensions and measures of
+// an aggregation query. Aggregation results are sorted in postprocessing.
+type aggregationSort struct {
+	// Index of the dimension or measure to sort by.
+	index     int
+	isMeasure bool
+	desc      bool
+}
+
 // timezoneTableContext stores context for timezone column queries
 type timezoneTableContext struct {
 	tableAlias  string
@@ -356,10 +397,23 @@ type AQLQueryContext struct {
 
 	Device int `json:"device"`
 
+	// Execution stats of the query.
+	Stats QueryStats `json:"stats"`
+
 	Debug bool `json:"debug,omitempty"`
 
 	Profiling string `json:"profiling,omitempty"`
 
+	// Context of the query execution. The query is stopped between batches
+	// once the context is done, i.e. the request is cancelled or the deadline
+	// is exceeded.
+	Context context.Context `json:"-"`
+	// Error of Context if the query is stopped by it.
+	contextErr error
+
+	// Whether OOPK.DeviceMemoryRequirement is estimated.
+	deviceMemoryEstimated bool
+
 	// We alternate with two Cuda streams between batches for pipelining.
 	// [0] stores the current stream, and [1] stores the other stream.
 	cudaStreams [2]unsafe.Pointer
@@ -382,9 +436,65 @@ type AQLQueryContext struct {
 
 	// Flag to indicate if this query is not aggregation query
 	isNonAggregationQuery bool
+
+	// Pagination state of non-aggregation query.
+	paging pagingContext
+
+	// Sorts of aggregation query resolved against dimensions and measures.
+	aggregationSorts []aggregationSort
+
+	// The same query over the time filter shifted by CompareTo, it should be
+	// executed and set to CompareContext before postprocessing this query.
+	CompareQuery   *AQLQuery        `json:"compareQuery,omitempty"`
+	CompareContext *AQLQueryContext `json:"compareContext,omitempty"`
+	// Seconds to add to time dimension values of CompareContext results to
+	// align with results of this query.
+	compareTimeShift int64
+
+	// Key and version of ResultCache when the query is looked up, the key is
+	// empty if results of the query can not be cached.
+	resultCacheKey     string
+	resultCacheVersion uint64
 }
 
 // IsHLL return if the aggregation function is HLL
 func (ctx *OOPKContext) IsHLL() bool {
-	return ctx.AggregateType == C.AGGR_HLL
+	return len(ctx.Measures) == 1 && ctx.Measures[0].AggregateType == C.AGGR_HLL
+}
+
+// IsPercentile returns if the query has a single percentile measure, whose
+// sketches can be returned as hll data for merging.
+func (ctx *OOPKContext) IsPercentile() bool {
+	return len(ctx.Measures) == 1 && ctx.Measures[0].IsPercentile
+}
+
+// hasPercentile returns if any measure is percentile.
+func (ctx *OOPKContext) hasPercentile() bool {
+	for _, measure := range ctx.Measures {
+		if measure.IsPercentile {
+			return true
+		}
+	}
+	return false
+}
+
+// MeasureRowBytes returns the sum number of bytes of all measure values.
+func (ctx *OOPKContext) MeasureRowBytes() (rowBytes int) {
+	for _, measure := range ctx.Measures {
+		rowBytes += measure.MeasureBytes
+	}
+	return
+}
+
+// getMeasureStartOffsets returns the start offset of each measure in a measure
+// vector with the given capacity. Values are stored measure by measure,
+// each measure taking capacity elements.
+func getMeasureStartOffsets(measures []OOPKMeasure, capacity int) []int {
+	offsets := make([]int, len(measures))
+	offset := 0
+	for i, measure := range measures {
+		offsets[i] = offset
+		offset += measure.MeasureBytes * capacity
+	}
+	return offsets
 }
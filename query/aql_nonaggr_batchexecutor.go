@@ -28,8 +28,12 @@ type NonAggrBatchExecutorImpl struct {
 // project for non-aggregation query will only calculate the selected columns
 // measure calculation, reduce will be skipped, once the generated result reaches limit, it will return and cancel all other ongoing processing.
 func (e *NonAggrBatchExecutorImpl) project() {
+	// skip rows returned by previous pages or skipped by offset.
+	rowsSkipped := e.skipRows()
+
 	// Prepare for dimension and measure evaluation.
 	e.prepareForDimEval(e.qc.OOPK.DimRowBytes, e.qc.OOPK.NumDimsPerDimWidth, e.stream)
+	prevResultSize := e.qc.OOPK.currentBatch.resultSize
 
 	e.qc.reportTimingForCurrentBatch(e.stream, &e.start, prepareForDimAndMeasureTiming)
 	// for non-aggregation query, we always write from start for dimension output
@@ -40,8 +44,19 @@ func (e *NonAggrBatchExecutorImpl) project() {
 	memutils.WaitForCudaStream(e.stream, e.qc.Device)
 	e.qc.OOPK.currentBatch.cleanupBeforeAggregation()
 
-	if e.qc.OOPK.currentBatch.resultSize >= e.qc.Query.Limit {
+	paging := &e.qc.paging
+	if e.qc.OOPK.currentBatch.resultSize > prevResultSize {
+		paging.end = continuationToken{
+			Shard: e.shardID,
+			Batch: e.batchID,
+			Rows:  rowsSkipped + e.qc.OOPK.currentBatch.resultSize - prevResultSize,
+		}
+	}
+
+	if e.qc.OOPK.currentBatch.resultSize >= e.qc.Query.Limit+paging.rowsToDrop {
 		e.qc.OOPK.done = true
+		// there may be more rows after the last result row.
+		paging.nextToken = paging.end.encode()
 	}
 }
 
@@ -74,8 +89,12 @@ func (e *NonAggrBatchExecutorImpl) prepareForDimEval(
 	dimRowBytes int, numDimsPerDimWidth queryCom.DimCountsPerDimWidth, stream unsafe.Pointer) {
 
 	bc := &e.qc.OOPK.currentBatch
-	if bc.resultCapacity == 0 {
-		bc.resultCapacity = e.qc.Query.Limit
+	// result rows to drop are only set before any result row is kept, so
+	// result buffers can be reallocated without copying.
+	if capacity := e.qc.Query.Limit + e.qc.paging.rowsToDrop; bc.resultCapacity < capacity {
+		deviceFreeAndSetNil(&bc.dimensionVectorD[0])
+		deviceFreeAndSetNil(&bc.dimensionVectorD[1])
+		bc.resultCapacity = capacity
 		bc.dimensionVectorD = [2]devicePointer{
 			deviceAllocate(bc.resultCapacity*dimRowBytes, bc.device),
 			deviceAllocate(bc.resultCapacity*dimRowBytes, bc.device),
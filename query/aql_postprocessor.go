@@ -23,6 +23,9 @@ import (
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
+	"sort"
+	"strconv"
+	"strings"
 	"unsafe"
 )
 
@@ -30,6 +33,11 @@ import (
 // format to AQLQueryResult nested result format. It also translates enum
 // values back to their string representations.
 func (qc *AQLQueryContext) Postprocess() queryCom.AQLQueryResult {
+	start := utils.Now()
+	defer func() {
+		qc.Stats.PostprocessTime += millisecondsSince(start)
+	}()
+
 	oopkContext := qc.OOPK
 	if oopkContext.IsHLL() {
 		result, err := queryCom.NewTimeSeriesHLLResult(qc.HLLQueryResult, queryCom.HLLDataHeader)
@@ -42,6 +50,87 @@ func (qc *AQLQueryContext) Postprocess() queryCom.AQLQueryResult {
 	}
 
 	result := make(queryCom.AQLQueryResult)
+	timeDimensionMetas := qc.getTimeDimensionMetas()
+	numMeasures := len(oopkContext.Measures)
+	if qc.Query != nil && qc.Query.measureExprs != nil {
+		numMeasures = len(qc.Query.measureExprs)
+	}
+	var measureNames []string
+	if numMeasures > 1 || len(qc.aggregationSorts) > 0 {
+		measureNames = make([]string, numMeasures)
+		for measureIndex := range measureNames {
+			measureNames[measureIndex] = qc.Query.Measures[measureIndex].name()
+		}
+	}
+
+	// aggregated rows are collected for sorting, limit, filling gaps, window
+	// measures and comparison before writing to result.
+	fillGapsDimIndex := qc.getFillGapsDimIndex()
+	collectRows := !qc.isNonAggregationQuery &&
+		(len(qc.aggregationSorts) > 0 || qc.Query.Limit > 0 ||
+			fillGapsDimIndex >= 0 || qc.hasMeasureWindows() || qc.CompareContext != nil)
+	rows := qc.readResults(result, timeDimensionMetas, measureNames, collectRows)
+
+	if collectRows {
+		if fillGapsDimIndex >= 0 {
+			rows = qc.fillGaps(rows, fillGapsDimIndex, numMeasures, timeDimensionMetas[fillGapsDimIndex])
+		}
+		if qc.hasMeasureWindows() {
+			// having filters are applied to results of window measures.
+			qc.applyMeasureWindows(rows)
+			rows = qc.filterRowsByHavingFilters(rows)
+		}
+		if qc.CompareContext != nil {
+			qc.compareRows(rows, timeDimensionMetas)
+		}
+		rows = qc.sortAndLimitRows(rows)
+		if len(qc.aggregationSorts) > 0 {
+			// sorted results are returned as rows to keep the order.
+			headers := make([]string, 0, len(qc.Query.Dimensions)+len(measureNames))
+			for _, dim := range qc.Query.Dimensions {
+				headers = append(headers, dim.name())
+			}
+			if qc.CompareContext != nil {
+				headers = append(headers, getComparedMeasureNames(measureNames)...)
+			} else {
+				headers = append(headers, measureNames...)
+			}
+			result.SetHeaders(headers)
+			for _, row := range rows {
+				result.AppendWithMeasures(row.dimValues, row.getMeasureValues())
+			}
+		} else {
+			for _, row := range rows {
+				if row.previousValues != nil {
+					result.SetComparedMeasures(row.dimValues, measureNames, row.getMeasureValues())
+				} else {
+					setAggregatedRow(result, row.dimValues, measureNames, row.measureValues)
+				}
+			}
+		}
+	}
+
+	if qc.isNonAggregationQuery {
+		headers := make([]string, len(qc.Query.Dimensions))
+		for i, dim := range qc.Query.Dimensions {
+			headers[i] = dim.Expr
+		}
+		result.SetHeaders(headers)
+		if qc.paging.nextToken != "" {
+			result.SetContinuationToken(qc.paging.nextToken)
+		}
+	}
+	return result
+}
+
+// readResults reads dimension and measure values of result rows from host
+// buffers, where time dimensions are formatted with timeDimensionMetas. Rows of
+// non aggregation query are appended to result directly, aggregated rows (or
+// groups of grouping sets) are returned if collectRows is true, otherwise they
+// are written to result.
+func (qc *AQLQueryContext) readResults(result queryCom.AQLQueryResult, timeDimensionMetas []*queryCom.TimeDimensionMeta,
+	measureNames []string, collectRows bool) []aggregatedRow {
+	oopkContext := qc.OOPK
 	dimValues := make([]*string, len(oopkContext.Dimensions))
 	dataTypes := make([]memCom.DataType, len(oopkContext.Dimensions))
 	reverseDicts := make(map[int][]string)
@@ -54,68 +143,474 @@ func (qc *AQLQueryContext) Postprocess() queryCom.AQLQueryResult {
 		dataTypes[dimIndex], reverseDicts[dimIndex] = getDimensionDataType(dimExpr), qc.getEnumReverseDict(dimIndex, dimExpr)
 	}
 
-	var fromOffset, toOffset int
-	if qc.fromTime != nil && qc.toTime != nil {
-		_, fromOffset = qc.fromTime.Time.Zone()
-		_, toOffset = qc.toTime.Time.Zone()
+	// measure values are compacted to ResultSize values per measure in host memory.
+	measureOffsets := getMeasureStartOffsets(oopkContext.Measures, oopkContext.ResultSize)
+	aggregateValues := make([]*float64, len(oopkContext.Measures))
+	var derivedValues []*float64
+	if qc.Query != nil && qc.Query.measureExprs != nil {
+		derivedValues = make([]*float64, len(qc.Query.measureExprs))
 	}
+
+	var rows []aggregatedRow
+	addRow := func(dimValues []*string, aggregateValues []*float64) {
+		measureValues := aggregateValues
+		// derived measures are computed from the aggregated values.
+		if derivedValues != nil {
+			for measureIndex, measureExpr := range qc.Query.measureExprs {
+				derivedValues[measureIndex] = evalPostAggregationExpr(measureExpr, aggregateValues)
+			}
+			measureValues = derivedValues
+		}
+
+		hiddenValues := qc.getHiddenValues(aggregateValues)
+		if !qc.hasMeasureWindows() && !qc.matchHavingFilters(measureValues, hiddenValues) {
+			return
+		}
+
+		if collectRows {
+			row := newAggregatedRow(dimValues, measureValues)
+			row.hiddenValues = hiddenValues
+			rows = append(rows, row)
+		} else {
+			setAggregatedRow(result, dimValues, measureNames, measureValues)
+		}
+	}
+
+	// aggregated groups are rolled up into groups of grouping sets.
+	var aggregator *groupingSetsAggregator
+	if qc.hasGroupingSets() {
+		aggregator = newGroupingSetsAggregator(qc)
+	}
+
 	// caches time formatted time dimension values
 	dimensionValueCache := make([]map[queryCom.TimeDimensionMeta]map[int64]string, len(oopkContext.Dimensions))
-	for i := 0; i < oopkContext.ResultSize; i++ {
+	// leading rows of non aggregation query results may be skipped by pagination.
+	for i := qc.paging.rowsToDrop; i < oopkContext.ResultSize; i++ {
 		for dimIndex := range oopkContext.Dimensions {
 			offsets := dimOffsets[dimIndex]
 			valueOffset, nullOffset := offsets[0], offsets[1]
 			valuePtr, nullPtr := utils.MemAccess(oopkContext.dimensionVectorH, valueOffset), utils.MemAccess(oopkContext.dimensionVectorH, nullOffset)
 
-			if qc.Query.Dimensions[dimIndex].isTimeDimension() && dimensionValueCache[dimIndex] == nil {
+			if timeDimensionMetas[dimIndex] != nil && dimensionValueCache[dimIndex] == nil {
 				dimensionValueCache[dimIndex] = make(map[queryCom.TimeDimensionMeta]map[int64]string)
 			}
 
-			var timeDimensionMeta *queryCom.TimeDimensionMeta
-
-			if qc.Query.Dimensions[dimIndex].isTimeDimension() {
-				timeDimensionMeta = &queryCom.TimeDimensionMeta{
-					TimeBucketizer:  qc.Query.Dimensions[dimIndex].TimeBucketizer,
-					TimeUnit:        qc.Query.Dimensions[dimIndex].TimeUnit,
-					IsTimezoneTable: qc.timezoneTable.tableColumn != "",
-					TimeZone:        qc.fixedTimezone,
-					DSTSwitchTs:     qc.dstswitch,
-					FromOffset:      fromOffset,
-					ToOffset:        toOffset,
-				}
-			}
-
 			dimValues[dimIndex] = queryCom.ReadDimension(
 				valuePtr, nullPtr, i, dataTypes[dimIndex], reverseDicts[dimIndex],
-				timeDimensionMeta, dimensionValueCache[dimIndex])
+				timeDimensionMetas[dimIndex], dimensionValueCache[dimIndex])
 		}
 
 		if qc.isNonAggregationQuery {
 			result.Append(dimValues)
 		} else {
-			measureBytes := oopkContext.MeasureBytes
+			var percentileKey string
+			if oopkContext.hasPercentile() {
+				percentileKey = getDimensionKey(oopkContext.dimensionVectorH, i, oopkContext.ResultSize, oopkContext.NumDimsPerDimWidth)
+			}
+			for measureIndex, measure := range oopkContext.Measures {
+				// Percentiles are estimated from the sketches of the dimensions.
+				if measure.IsPercentile {
+					aggregateValues[measureIndex] = qc.getPercentile(measureIndex, percentileKey)
+					continue
+				}
 
-			// For avg aggregation function, we only need to read first 4 bytes which is the average.
-			if qc.OOPK.AggregateType == C.AGGR_AVG_FLOAT {
-				measureBytes = 4
+				measureBytes := measure.MeasureBytes
+
+				// For avg aggregation function, we only need to read first 4 bytes which is the average.
+				if measure.AggregateType == C.AGGR_AVG_FLOAT {
+					measureBytes = 4
+				}
+
+				aggregateValues[measureIndex] = readMeasure(
+					utils.MemAccess(oopkContext.measureVectorH, measureOffsets[measureIndex]+i*measure.MeasureBytes), measure.Measure,
+					measureBytes)
 			}
 
-			measureValue := readMeasure(
-				utils.MemAccess(oopkContext.measureVectorH, i*oopkContext.MeasureBytes), oopkContext.Measure,
-				measureBytes)
+			if aggregator != nil {
+				aggregator.add(i, dimValues, aggregateValues, percentileKey)
+			} else {
+				addRow(dimValues, aggregateValues)
+			}
+		}
+	}
 
-			result.Set(dimValues, measureValue)
+	if aggregator != nil {
+		aggregator.forEach(addRow)
+	}
+	return rows
+}
+
+// matchHavingFilters returns whether the aggregated group with the measure
+// values and values of hidden measures passes all having filters.
+func (qc *AQLQueryContext) matchHavingFilters(measureValues, hiddenValues []*float64) bool {
+	values := measureValues
+	if len(qc.Query.hiddenMeasures) > 0 {
+		// values of hidden measures missing (e.g. in filled gaps) are null.
+		values = make([]*float64, len(measureValues)+len(qc.Query.hiddenMeasures))
+		copy(values, measureValues)
+		copy(values[len(measureValues):], hiddenValues)
+	}
+
+	for _, filter := range qc.Query.havingFilters {
+		value := evalPostAggregationExpr(filter, values)
+		if value == nil || *value == 0 {
+			return false
 		}
 	}
+	return true
+}
 
-	if qc.isNonAggregationQuery {
-		headers := make([]string, len(qc.Query.Dimensions))
-		for i, dim := range qc.Query.Dimensions {
-			headers[i] = dim.Expr
+// getHiddenValues returns the values of hidden measures from the aggregated
+// values, nil if there is no hidden measure.
+func (qc *AQLQueryContext) getHiddenValues(aggregateValues []*float64) []*float64 {
+	if qc.Query == nil || len(qc.Query.hiddenMeasures) == 0 {
+		return nil
+	}
+	hiddenValues := make([]*float64, len(qc.Query.hiddenMeasures))
+	for i, ref := range qc.Query.hiddenMeasures {
+		hiddenValues[i] = aggregateValues[ref.ColumnID]
+	}
+	return hiddenValues
+}
+
+// filterRowsByHavingFilters returns the rows passing all having filters.
+func (qc *AQLQueryContext) filterRowsByHavingFilters(rows []aggregatedRow) []aggregatedRow {
+	filtered := rows[:0]
+	for _, row := range rows {
+		if qc.matchHavingFilters(row.measureValues, row.hiddenValues) {
+			filtered = append(filtered, row)
 		}
-		result.SetHeaders(headers)
 	}
-	return result
+	return filtered
+}
+
+// evalPostAggregationExpr evaluates a resolved post aggregation expression
+// against the measure values of one aggregated group. VarRefs refer to measures
+// by ColumnID. Boolean results are represented as 1 and 0, and nil is returned
+// for null results.
+func evalPostAggregationExpr(e expr.Expr, measureValues []*float64) *float64 {
+	boolValue := func(b bool) *float64 {
+		var v float64
+		if b {
+			v = 1
+		}
+		return &v
+	}
+
+	switch e := e.(type) {
+	case *expr.VarRef:
+		return measureValues[e.ColumnID]
+	case *expr.NumberLiteral:
+		v := e.Val
+		return &v
+	case *expr.BooleanLiteral:
+		return boolValue(e.Val)
+	case *expr.ParenExpr:
+		return evalPostAggregationExpr(e.Expr, measureValues)
+	case *expr.UnaryExpr:
+		value := evalPostAggregationExpr(e.Expr, measureValues)
+		switch e.Op {
+		case expr.IS_NULL:
+			return boolValue(value == nil)
+		case expr.IS_NOT_NULL:
+			return boolValue(value != nil)
+		}
+		if value == nil {
+			return nil
+		}
+		switch e.Op {
+		case expr.NOT:
+			return boolValue(*value == 0)
+		case expr.UNARY_MINUS:
+			v := -*value
+			return &v
+		}
+	case *expr.BinaryExpr:
+		lhs, rhs := evalPostAggregationExpr(e.LHS, measureValues), evalPostAggregationExpr(e.RHS, measureValues)
+		switch e.Op {
+		case expr.AND:
+			if (lhs != nil && *lhs == 0) || (rhs != nil && *rhs == 0) {
+				return boolValue(false)
+			}
+			if lhs == nil || rhs == nil {
+				return nil
+			}
+			return boolValue(true)
+		case expr.OR:
+			if (lhs != nil && *lhs != 0) || (rhs != nil && *rhs != 0) {
+				return boolValue(true)
+			}
+			if lhs == nil || rhs == nil {
+				return nil
+			}
+			return boolValue(false)
+		}
+		if lhs == nil || rhs == nil {
+			return nil
+		}
+		l, r := *lhs, *rhs
+		switch e.Op {
+		case expr.ADD:
+			v := l + r
+			return &v
+		case expr.SUB:
+			v := l - r
+			return &v
+		case expr.MUL:
+			v := l * r
+			return &v
+		case expr.DIV:
+			if r == 0 {
+				return nil
+			}
+			v := l / r
+			return &v
+		case expr.EQ:
+			return boolValue(l == r)
+		case expr.NEQ:
+			return boolValue(l != r)
+		case expr.LT:
+			return boolValue(l < r)
+		case expr.LTE:
+			return boolValue(l <= r)
+		case expr.GT:
+			return boolValue(l > r)
+		case expr.GTE:
+			return boolValue(l >= r)
+		}
+	}
+	return nil
+}
+
+// getTimeDimensionMetas returns the meta for formatting values of each time
+// dimension, nil for other dimensions.
+func (qc *AQLQueryContext) getTimeDimensionMetas() []*queryCom.TimeDimensionMeta {
+	var fromOffset, toOffset int
+	if qc.fromTime != nil && qc.toTime != nil {
+		_, fromOffset = qc.fromTime.Time.Zone()
+		_, toOffset = qc.toTime.Time.Zone()
+	}
+
+	metas := make([]*queryCom.TimeDimensionMeta, len(qc.OOPK.Dimensions))
+	for dimIndex := range metas {
+		dim := qc.Query.Dimensions[dimIndex]
+		if !dim.isTimeDimension() {
+			continue
+		}
+		metas[dimIndex] = &queryCom.TimeDimensionMeta{
+			TimeBucketizer:  dim.TimeBucketizer,
+			TimeUnit:        dim.TimeUnit,
+			IsTimezoneTable: qc.timezoneTable.tableColumn != "",
+			TimeZone:        qc.fixedTimezone,
+			DSTSwitchTs:     qc.dstswitch,
+			FromOffset:      fromOffset,
+			ToOffset:        toOffset,
+		}
+	}
+	return metas
+}
+
+// getFillGapsDimIndex returns the index of the dimension to fill gaps for, -1
+// if gaps should not be filled.
+func (qc *AQLQueryContext) getFillGapsDimIndex() int {
+	if qc.Query == nil || qc.isNonAggregationQuery {
+		return -1
+	}
+	for dimIndex, dim := range qc.Query.Dimensions {
+		if dim.FillGaps != "" {
+			return dimIndex
+		}
+	}
+	return -1
+}
+
+// fillGaps appends rows for time buckets without data to each series of rows
+// with the same values of other dimensions. Measure values of appended rows are
+// either null or 0 depending on FillGaps of the dimension.
+func (qc *AQLQueryContext) fillGaps(rows []aggregatedRow, dimIndex, numMeasures int, meta *queryCom.TimeDimensionMeta) []aggregatedRow {
+	buckets, err := qc.getTimeBuckets(meta.TimeBucketizer)
+	if err != nil {
+		// should never be here as time buckets are validated during compilation.
+		qc.Error = utils.StackError(err, "failed to fill gaps")
+		return rows
+	}
+
+	fillValues := make([]*float64, numMeasures)
+	if qc.Query.Dimensions[dimIndex].FillGaps == fillGapsWithZero {
+		for i := range fillValues {
+			var zero float64
+			fillValues[i] = &zero
+		}
+	}
+
+	// series are identified by values of other dimensions.
+	var seriesKeys []string
+	seriesDimValues := make(map[string][]*string)
+	seriesBuckets := make(map[string]map[string]bool)
+	for _, row := range rows {
+		key := getDimValuesKey(row.dimValues, dimIndex)
+		if _, ok := seriesDimValues[key]; !ok {
+			seriesKeys = append(seriesKeys, key)
+			seriesDimValues[key] = row.dimValues
+			seriesBuckets[key] = make(map[string]bool)
+		}
+		if bucket := row.dimValues[dimIndex]; bucket != nil {
+			seriesBuckets[key][*bucket] = true
+		}
+	}
+
+	// all buckets are filled if the time dimension is the only dimension.
+	if len(rows) == 0 && len(qc.Query.Dimensions) == 1 {
+		seriesKeys = append(seriesKeys, "")
+		seriesDimValues[""] = make([]*string, 1)
+		seriesBuckets[""] = make(map[string]bool)
+	}
+
+	cache := make(map[queryCom.TimeDimensionMeta]map[int64]string)
+	for _, bucket := range buckets {
+		bucketValue := queryCom.FormatTimeDimension(bucket, *meta, cache)
+		for _, key := range seriesKeys {
+			if seriesBuckets[key][bucketValue] {
+				continue
+			}
+			row := newAggregatedRow(seriesDimValues[key], fillValues)
+			row.dimValues[dimIndex] = &bucketValue
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// getDimValuesKey returns the key of dimension values excluding the dimension
+// at excludedDimIndex, -1 to include all dimensions.
+func getDimValuesKey(dimValues []*string, excludedDimIndex int) string {
+	var key []byte
+	for i, value := range dimValues {
+		if i == excludedDimIndex {
+			continue
+		}
+		if value == nil {
+			key = append(key, 0)
+		} else {
+			key = append(key, 1)
+			key = append(key, *value...)
+			key = append(key, 0)
+		}
+	}
+	return string(key)
+}
+
+// aggregatedRow stores dimension and measure values of one group of an
+// aggregation query for processing after aggregation. previousValues stores
+// measure values of the same group from the compare query if any.
+type aggregatedRow struct {
+	dimValues      []*string
+	measureValues  []*float64
+	previousValues []*float64
+	// values of hidden measures for having filters.
+	hiddenValues []*float64
+}
+
+// getMeasureValues returns the measure values to write to result. For compared
+// rows, each measure has the current value, previous value and delta.
+func (r aggregatedRow) getMeasureValues() []*float64 {
+	if r.previousValues == nil {
+		return r.measureValues
+	}
+	return getComparedValues(r.measureValues, r.previousValues)
+}
+
+// newAggregatedRow copies the reused dimension and measure value buffers into a new row.
+func newAggregatedRow(dimValues []*string, measureValues []*float64) aggregatedRow {
+	row := aggregatedRow{
+		dimValues:     make([]*string, len(dimValues)),
+		measureValues: make([]*float64, len(measureValues)),
+	}
+	copy(row.dimValues, dimValues)
+	copy(row.measureValues, measureValues)
+	return row
+}
+
+// setAggregatedRow writes one aggregated row into the nested result.
+func setAggregatedRow(result queryCom.AQLQueryResult, dimValues []*string, measureNames []string, measureValues []*float64) {
+	if len(measureValues) == 1 {
+		result.Set(dimValues, measureValues[0])
+	} else {
+		result.SetMeasures(dimValues, measureNames, measureValues)
+	}
+}
+
+// sortAndLimitRows sorts aggregated rows according to the resolved sorts and
+// keeps at most Limit rows. Without sorts, the rows kept are arbitrary.
+func (qc *AQLQueryContext) sortAndLimitRows(rows []aggregatedRow) []aggregatedRow {
+	if len(qc.aggregationSorts) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, s := range qc.aggregationSorts {
+				var res int
+				if s.isMeasure {
+					res = compareMeasureValues(rows[i].measureValues[s.index], rows[j].measureValues[s.index])
+				} else {
+					res = compareDimensionValues(rows[i].dimValues[s.index], rows[j].dimValues[s.index])
+				}
+
+				if res != 0 {
+					if s.desc {
+						return res > 0
+					}
+					return res < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if qc.Query.Limit > 0 && len(rows) > qc.Query.Limit {
+		rows = rows[:qc.Query.Limit]
+	}
+	return rows
+}
+
+// compareDimensionValues compares two dimension values. Values are compared
+// numerically if both of them are numbers, otherwise they are compared as
+// strings. NULL is smaller than any other value.
+func compareDimensionValues(v1, v2 *string) int {
+	if v1 == nil || v2 == nil {
+		return compareNulls(v1 == nil, v2 == nil)
+	}
+
+	f1, err1 := strconv.ParseFloat(*v1, 64)
+	f2, err2 := strconv.ParseFloat(*v2, 64)
+	if err1 == nil && err2 == nil {
+		return compareFloats(f1, f2)
+	}
+	return strings.Compare(*v1, *v2)
+}
+
+// compareMeasureValues compares two measure values. NULL is smaller than any
+// other value.
+func compareMeasureValues(v1, v2 *float64) int {
+	if v1 == nil || v2 == nil {
+		return compareNulls(v1 == nil, v2 == nil)
+	}
+	return compareFloats(*v1, *v2)
+}
+
+func compareNulls(isNull1, isNull2 bool) int {
+	if isNull1 && isNull2 {
+		return 0
+	} else if isNull1 {
+		return -1
+	}
+	return 1
+}
+
+func compareFloats(f1, f2 float64) int {
+	if f1 < f2 {
+		return -1
+	} else if f1 > f2 {
+		return 1
+	}
+	return 0
 }
 
 // PostprocessAsHLLData serializes the query result into HLLData format. It will also release the device memory after
@@ -126,28 +621,49 @@ func (qc *AQLQueryContext) PostprocessAsHLLData() ([]byte, error) {
 		return []byte{}, nil
 	}
 
-	dataTypes := make([]memCom.DataType, len(oopkContext.Dimensions))
-	reverseDicts := make(map[int][]string)
+	dataTypes, reverseDicts, timeDimensions := qc.getSerializedDimensions()
+	return qc.SerializeHLL(dataTypes, reverseDicts, timeDimensions)
+}
 
-	var timeDimensions []int
-	for dimIndex, ast := range oopkContext.Dimensions {
+// PostprocessAsPercentileData serializes the percentile sketches of the query
+// result as hll data for brokers to merge.
+func (qc *AQLQueryContext) PostprocessAsPercentileData() ([]byte, error) {
+	oopkContext := qc.OOPK
+	if oopkContext.ResultSize == 0 {
+		return []byte{}, nil
+	}
+
+	dataTypes, reverseDicts, timeDimensions := qc.getSerializedDimensions()
+	return qc.SerializePercentile(dataTypes, reverseDicts, timeDimensions)
+}
+
+// getSerializedDimensions returns the data types, enum reverse dicts and time
+// dimension indexes of dimensions for serializing results as hll data.
+func (qc *AQLQueryContext) getSerializedDimensions() (dataTypes []memCom.DataType, reverseDicts map[int][]string, timeDimensions []int) {
+	dataTypes = make([]memCom.DataType, len(qc.OOPK.Dimensions))
+	reverseDicts = make(map[int][]string)
+	for dimIndex, ast := range qc.OOPK.Dimensions {
 		dataTypes[dimIndex], reverseDicts[dimIndex] = getDimensionDataType(ast), qc.getEnumReverseDict(dimIndex, ast)
 		if qc.Query.Dimensions[dimIndex].isTimeDimension() {
 			timeDimensions = append(timeDimensions, dimIndex)
 		}
 	}
-
-	return qc.SerializeHLL(dataTypes, reverseDicts, timeDimensions)
+	return
 }
 
-// getEnumReverseDict returns the enum reverse dict of a ast node if it's a VarRef node, otherwise it will return
-// a nil slice.
+// getEnumReverseDict returns the enum reverse dict of a ast node if it's a VarRef node or the dimension is a case
+// expression with string results, otherwise it will return a nil slice.
 func (qc *AQLQueryContext) getEnumReverseDict(dimIndex int, expression expr.Expr) []string {
 	varRef, ok := expression.(*expr.VarRef)
 	if ok && (varRef.DataType == memCom.SmallEnum || varRef.DataType == memCom.BigEnum) {
 		return varRef.EnumReverseDict
 	}
 
+	// return reverse dict of string results for case expression
+	if qc.Query != nil && dimIndex < len(qc.Query.Dimensions) && qc.Query.Dimensions[dimIndex].enumReverseDict != nil {
+		return qc.Query.Dimensions[dimIndex].enumReverseDict
+	}
+
 	// return validShapeUUIDs as the reverse enum dict if dimIndex match geo dimension
 	if qc.OOPK.geoIntersection != nil && qc.OOPK.geoIntersection.dimIndex == dimIndex {
 		return qc.OOPK.geoIntersection.validShapeUUIDs
@@ -161,6 +677,7 @@ func (qc *AQLQueryContext) ReleaseHostResultsBuffers() {
 	ctx := &qc.OOPK
 	memutils.HostFree(ctx.dimensionVectorH)
 	ctx.dimensionVectorH = nil
+	ctx.percentileSketches = nil
 	if ctx.measureVectorH != nil {
 		memutils.HostFree(ctx.measureVectorH)
 		ctx.measureVectorH = nil
@@ -172,6 +689,10 @@ func (qc *AQLQueryContext) ReleaseHostResultsBuffers() {
 
 	// set geoIntersection to nil
 	qc.OOPK.geoIntersection = nil
+
+	if qc.CompareContext != nil {
+		qc.CompareContext.ReleaseHostResultsBuffers()
+	}
 }
 
 func readMeasure(measureRow unsafe.Pointer, ast expr.Expr, measureBytes int) *float64 {
@@ -21,6 +21,7 @@ import (
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
+	"math"
 	"time"
 	"unsafe"
 )
@@ -46,10 +47,14 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 					DataType: memCom.Uint32,
 				},
 			},
-			Measure: &expr.NumberLiteral{
-				ExprType: expr.Float,
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Float,
+					},
+					MeasureBytes: 4,
+				},
 			},
-			MeasureBytes:         4,
 			DimRowBytes:          5,
 			DimensionVectorIndex: []int{0},
 			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
@@ -65,6 +70,656 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 		}))
 	})
 
+	ginkgo.It("works on multiple measures", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: ""},
+				},
+				Measures: []Measure{
+					{Expr: "count(*)"},
+					{Alias: "total_fare", Expr: "sum(fare)"},
+				},
+			},
+		}
+
+		// values are stored measure by measure.
+		measureVectorH := struct {
+			counts [2]uint32
+			sums   [2]float64
+		}{
+			counts: [2]uint32{3, 5},
+			sums:   [2]float64{1.5, 2.5},
+		}
+
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Unsigned,
+					},
+					MeasureBytes: 4,
+				},
+				{
+					Measure: &expr.VarRef{
+						ExprType: expr.Float,
+					},
+					MeasureBytes: 8,
+				},
+			},
+			DimRowBytes:          5,
+			DimensionVectorIndex: []int{0},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
+			ResultSize:           2,
+			dimensionVectorH:     unsafe.Pointer(&[]uint8{1, 0, 0, 0, 2, 0, 0, 0, 1, 1}[0]),
+			measureVectorH:       unsafe.Pointer(&measureVectorH),
+		}
+
+		ctx.OOPK = oopkContext
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1": map[string]interface{}{
+				"count(*)":   float64(3),
+				"total_fare": float64(1.5),
+			},
+			"2": map[string]interface{}{
+				"count(*)":   float64(5),
+				"total_fare": float64(2.5),
+			},
+		}))
+	})
+
+	ginkgo.It("sorts and limits aggregation results", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Alias: "city", Expr: "city_id"},
+				},
+				Measures: []Measure{
+					{Expr: "count(*)"},
+				},
+				Limit: 2,
+			},
+			aggregationSorts: []aggregationSort{
+				{index: 0, isMeasure: true, desc: true},
+			},
+		}
+
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Unsigned,
+					},
+					MeasureBytes: 4,
+				},
+			},
+			DimRowBytes:          5,
+			DimensionVectorIndex: []int{0},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
+			ResultSize:           3,
+			dimensionVectorH:     unsafe.Pointer(&[]uint8{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 1, 1, 1}[0]),
+			measureVectorH:       unsafe.Pointer(&[]uint32{5, 20, 10}[0]),
+		}
+		ctx.OOPK = oopkContext
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"headers": []string{"city", "count(*)"},
+			"matrixData": [][]interface{}{
+				{"2", float64(20)},
+				{"3", float64(10)},
+			},
+		}))
+
+		// limit without sorts.
+		ctx.aggregationSorts = nil
+		ctx.Query.Limit = 1
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1": float64(5),
+		}))
+	})
+
+	ginkgo.It("fills gaps of time dimension", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: "request_at", TimeBucketizer: "h", FillGaps: "zero"},
+					{Expr: "city_id"},
+				},
+				Measures: []Measure{
+					{Expr: "count(*)"},
+				},
+			},
+			fixedTimezone: time.UTC,
+			fromTime:      &alignedTime{time.Unix(0, 0).UTC(), "h"},
+			toTime:        &alignedTime{time.Unix(3*3600, 0).UTC(), "h"},
+		}
+
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Unsigned,
+					},
+					MeasureBytes: 4,
+				},
+			},
+			DimRowBytes:          10,
+			DimensionVectorIndex: []int{0, 1},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 2, 0, 0},
+			ResultSize:           2,
+			dimensionVectorH:     unsafe.Pointer(&[]uint8{0, 0, 0, 0, 32, 28, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 1, 1, 1, 1}[0]),
+			measureVectorH:       unsafe.Pointer(&[]uint32{5, 10}[0]),
+		}
+		ctx.OOPK = oopkContext
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1970-01-01 00:00": map[string]interface{}{
+				"1": float64(5),
+				"2": float64(0),
+			},
+			"1970-01-01 01:00": map[string]interface{}{
+				"1": float64(0),
+				"2": float64(0),
+			},
+			"1970-01-01 02:00": map[string]interface{}{
+				"1": float64(0),
+				"2": float64(10),
+			},
+		}))
+
+		ctx.Query.Dimensions[0].FillGaps = "null"
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1970-01-01 00:00": map[string]interface{}{
+				"1": float64(5),
+				"2": nil,
+			},
+			"1970-01-01 01:00": map[string]interface{}{
+				"1": nil,
+				"2": nil,
+			},
+			"1970-01-01 02:00": map[string]interface{}{
+				"1": nil,
+				"2": float64(10),
+			},
+		}))
+		Ω(ctx.Error).Should(BeNil())
+	})
+
+	ginkgo.It("compares with results of previous period", func() {
+		newOOPKContext := func(dimensionVector []uint8, measureVector []uint32) OOPKContext {
+			return OOPKContext{
+				Dimensions: []expr.Expr{
+					&expr.VarRef{
+						ExprType: expr.Unsigned,
+						DataType: memCom.Uint32,
+					},
+				},
+				Measures: []OOPKMeasure{
+					{
+						Measure: &expr.NumberLiteral{
+							ExprType: expr.Unsigned,
+						},
+						MeasureBytes: 4,
+					},
+				},
+				DimRowBytes:          5,
+				DimensionVectorIndex: []int{0},
+				NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
+				ResultSize:           2,
+				dimensionVectorH:     unsafe.Pointer(&dimensionVector[0]),
+				measureVectorH:       unsafe.Pointer(&measureVector[0]),
+			}
+		}
+
+		query := &AQLQuery{
+			Dimensions: []Dimension{
+				{Alias: "day", Expr: "request_at", TimeBucketizer: "day"},
+			},
+			Measures: []Measure{
+				{Expr: "count(*)"},
+			},
+			CompareTo: "-1 week",
+		}
+		ctx := &AQLQueryContext{
+			Query: query,
+			// 1970-01-08 and 1970-01-09
+			OOPK:             newOOPKContext([]uint8{128, 58, 9, 0, 0, 140, 10, 0, 1, 1}, []uint32{5, 10}),
+			compareTimeShift: 7 * 86400,
+			CompareContext: &AQLQueryContext{
+				Query: &AQLQuery{
+					Dimensions: query.Dimensions,
+					Measures:   query.Measures,
+				},
+				// 1970-01-01 and 1970-01-03
+				OOPK: newOOPKContext([]uint8{0, 0, 0, 0, 0, 163, 2, 0, 1, 1}, []uint32{4, 3}),
+			},
+		}
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1970-01-08": map[string]interface{}{
+				"current":  float64(5),
+				"previous": float64(4),
+				"delta":    float64(1),
+			},
+			"1970-01-09": map[string]interface{}{
+				"current":  float64(10),
+				"previous": nil,
+				"delta":    nil,
+			},
+		}))
+
+		ctx.aggregationSorts = []aggregationSort{
+			{index: 0, isMeasure: true, desc: true},
+		}
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"headers": []string{"day", "count(*)", "count(*).previous", "count(*).delta"},
+			"matrixData": [][]interface{}{
+				{"1970-01-09", float64(10), nil, nil},
+				{"1970-01-08", float64(5), float64(4), float64(1)},
+			},
+		}))
+	})
+
+	ginkgo.It("computes window measures over time dimension", func() {
+		countRef := &expr.VarRef{Val: "count(*)", ColumnID: 0, ExprType: expr.Float}
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: "request_at", TimeBucketizer: "h"},
+				},
+				Measures: []Measure{
+					{Alias: "total", Expr: "cumsum(count(*))"},
+					{Alias: "avg", Expr: "moving_avg(count(*), 2)"},
+					{Alias: "rate", Expr: "rate(count(*))"},
+				},
+				measureExprs: []expr.Expr{countRef, countRef, countRef},
+				measureWindows: []*measureWindow{
+					{function: cumsumCallName},
+					{function: movingAvgCallName, size: 2},
+					{function: rateCallName},
+				},
+			},
+		}
+
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Unsigned,
+					},
+					MeasureBytes: 4,
+				},
+			},
+			DimRowBytes:          5,
+			DimensionVectorIndex: []int{0},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
+			ResultSize:           4,
+			// hour 2, 0, 3 and 1.
+			dimensionVectorH: unsafe.Pointer(&[]uint8{32, 28, 0, 0, 0, 0, 0, 0, 48, 42, 0, 0, 16, 14, 0, 0, 1, 1, 1, 1}[0]),
+			measureVectorH:   unsafe.Pointer(&[]uint32{30, 10, 30, 20}[0]),
+		}
+		ctx.OOPK = oopkContext
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1970-01-01 00:00": map[string]interface{}{
+				"total": float64(10),
+				"avg":   float64(10),
+				"rate":  nil,
+			},
+			"1970-01-01 01:00": map[string]interface{}{
+				"total": float64(30),
+				"avg":   float64(15),
+				"rate":  float64(1),
+			},
+			"1970-01-01 02:00": map[string]interface{}{
+				"total": float64(60),
+				"avg":   float64(25),
+				"rate":  float64(0.5),
+			},
+			"1970-01-01 03:00": map[string]interface{}{
+				"total": float64(90),
+				"avg":   float64(30),
+				"rate":  float64(0),
+			},
+		}))
+
+		// having filters are applied to results of window measures.
+		ctx.Query.havingFilters = []expr.Expr{
+			&expr.BinaryExpr{
+				Op:  expr.GT,
+				LHS: &expr.VarRef{Val: "total", ColumnID: 0, ExprType: expr.Float},
+				RHS: &expr.NumberLiteral{Val: 20, Int: 20, Expr: "20"},
+			},
+		}
+		result := ctx.Postprocess()
+		Ω(result).Should(HaveLen(3))
+		Ω(result).ShouldNot(HaveKey("1970-01-01 00:00"))
+		Ω(ctx.Error).Should(BeNil())
+	})
+
+	ginkgo.It("rolls up subtotals of grouping sets", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Alias: "city", Expr: "city_id"},
+					{Alias: "product", Expr: "product_id"},
+				},
+				Measures: []Measure{
+					{Alias: "trips", Expr: "count(*)"},
+					{Alias: "avg_fare", Expr: "avg(fare)"},
+					{Alias: "max_fare", Expr: "max(fare)"},
+				},
+				groupingSets: [][]bool{{true, true}, {true, false}, {false, false}},
+			},
+		}
+
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure:       &expr.NumberLiteral{ExprType: expr.Unsigned},
+					MeasureBytes:  4,
+					AggregateType: 1,
+				},
+				{
+					Measure:       &expr.VarRef{ExprType: expr.Float},
+					MeasureBytes:  8,
+					AggregateType: 11,
+				},
+				{
+					Measure:       &expr.VarRef{ExprType: expr.Unsigned},
+					MeasureBytes:  4,
+					AggregateType: 7,
+				},
+			},
+			DimRowBytes:          10,
+			DimensionVectorIndex: []int{0, 1},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 2, 0, 0},
+			ResultSize:           3,
+			// (city, product): (1, 1), (1, 2) and (2, 1).
+			dimensionVectorH: unsafe.Pointer(&[]uint8{1, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0,
+				1, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 1, 1, 1}[0]),
+			// avg values are followed by their counts.
+			measureVectorH: unsafe.Pointer(&[]uint32{5, 10, 20,
+				math.Float32bits(1), 5, math.Float32bits(4), 10, math.Float32bits(1.25), 20,
+				3, 7, 4}[0]),
+		}
+		ctx.OOPK = oopkContext
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1": map[string]interface{}{
+				"1":      map[string]interface{}{"trips": float64(5), "avg_fare": float64(1), "max_fare": float64(3)},
+				"2":      map[string]interface{}{"trips": float64(10), "avg_fare": float64(4), "max_fare": float64(7)},
+				"_total": map[string]interface{}{"trips": float64(15), "avg_fare": float64(3), "max_fare": float64(7)},
+			},
+			"2": map[string]interface{}{
+				"1":      map[string]interface{}{"trips": float64(20), "avg_fare": float64(1.25), "max_fare": float64(4)},
+				"_total": map[string]interface{}{"trips": float64(20), "avg_fare": float64(1.25), "max_fare": float64(4)},
+			},
+			"_total": map[string]interface{}{
+				"_total": map[string]interface{}{"trips": float64(35), "avg_fare": float64(2), "max_fare": float64(7)},
+			},
+		}))
+		Ω(ctx.Error).Should(BeNil())
+	})
+
+	ginkgo.It("filters aggregation results by having filters", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Alias: "city", Expr: "city_id"},
+				},
+				Measures: []Measure{
+					{Expr: "count(*)"},
+				},
+				havingFilters: []expr.Expr{
+					&expr.BinaryExpr{
+						Op:  expr.GT,
+						LHS: &expr.VarRef{Val: "count(*)", ColumnID: 0, ExprType: expr.Float},
+						RHS: &expr.NumberLiteral{Val: 8, Int: 8, Expr: "8"},
+					},
+				},
+			},
+		}
+
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Unsigned,
+					},
+					MeasureBytes: 4,
+				},
+			},
+			DimRowBytes:          5,
+			DimensionVectorIndex: []int{0},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
+			ResultSize:           3,
+			dimensionVectorH:     unsafe.Pointer(&[]uint8{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 1, 1, 1}[0]),
+			measureVectorH:       unsafe.Pointer(&[]uint32{5, 20, 10}[0]),
+		}
+		ctx.OOPK = oopkContext
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"2": float64(20),
+			"3": float64(10),
+		}))
+
+		// count(*) is a hidden measure only referenced by the having filter.
+		ctx.Query.Measures = []Measure{{Expr: "max(fare)"}}
+		ctx.Query.measureExprs = []expr.Expr{&expr.VarRef{Val: "max(fare)", ColumnID: 0, ExprType: expr.Float}}
+		ctx.Query.hiddenMeasures = []*expr.VarRef{{Val: "count(*)", ColumnID: 1, ExprType: expr.Float}}
+		ctx.Query.havingFilters[0].(*expr.BinaryExpr).LHS = &expr.VarRef{Val: "count(*)", ColumnID: 1, ExprType: expr.Float}
+		ctx.OOPK.Measures = append([]OOPKMeasure{oopkContext.Measures[0]}, oopkContext.Measures[0])
+		ctx.OOPK.measureVectorH = unsafe.Pointer(&[]uint32{1, 2, 3, 5, 20, 10}[0])
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"2": float64(2),
+			"3": float64(3),
+		}))
+	})
+
+	ginkgo.It("computes derived measures", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Alias: "city", Expr: "city_id"},
+				},
+				Measures: []Measure{
+					{Alias: "fare_per_trip", Expr: "total_fare / trips"},
+					{Alias: "trips", Expr: "count(*)"},
+				},
+				measureExprs: []expr.Expr{
+					&expr.BinaryExpr{
+						Op:  expr.DIV,
+						LHS: &expr.VarRef{Val: "sum(fare)", ColumnID: 1, ExprType: expr.Float},
+						RHS: &expr.VarRef{Val: "count(*)", ColumnID: 0, ExprType: expr.Float},
+					},
+					&expr.VarRef{Val: "trips", ColumnID: 0, ExprType: expr.Float},
+				},
+			},
+		}
+
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Unsigned,
+					},
+					MeasureBytes: 4,
+				},
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Unsigned,
+					},
+					MeasureBytes: 4,
+				},
+			},
+			DimRowBytes:          5,
+			DimensionVectorIndex: []int{0},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
+			ResultSize:           2,
+			dimensionVectorH:     unsafe.Pointer(&[]uint8{1, 0, 0, 0, 2, 0, 0, 0, 1, 1}[0]),
+			measureVectorH:       unsafe.Pointer(&[]uint32{4, 0, 10, 20}[0]),
+		}
+		ctx.OOPK = oopkContext
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1": map[string]interface{}{
+				"fare_per_trip": float64(2.5),
+				"trips":         float64(4),
+			},
+			"2": map[string]interface{}{
+				"fare_per_trip": nil,
+				"trips":         float64(0),
+			},
+		}))
+	})
+
+	ginkgo.It("computes percentiles from sketches", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Alias: "city", Expr: "city_id"},
+				},
+				Measures: []Measure{
+					{Alias: "p50", Expr: "percentile(fare, 0.5)"},
+				},
+			},
+		}
+
+		sketch := queryCom.NewTDigest(queryCom.DefaultTDigestCompression)
+		sketch.Add(1, 1)
+		sketch.Add(2, 1)
+		sketch.Add(3, 1)
+		dimensionVectorH := unsafe.Pointer(&[]uint8{1, 0, 0, 0, 2, 0, 0, 0, 1, 1}[0])
+		oopkContext := OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint32,
+				},
+			},
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.VarRef{
+						ExprType: expr.Float,
+					},
+					MeasureBytes:  8,
+					AggregateType: 11,
+					IsPercentile:  true,
+					Percentile:    0.5,
+				},
+			},
+			DimRowBytes:          5,
+			DimensionVectorIndex: []int{0},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
+			ResultSize:           2,
+			dimensionVectorH:     dimensionVectorH,
+			measureVectorH:       unsafe.Pointer(&[]uint32{0, 0, 0, 0}[0]),
+			percentileSketches: []map[string]*queryCom.TDigest{
+				{
+					getDimensionKey(dimensionVectorH, 0, 2, queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0}): sketch,
+				},
+			},
+		}
+		ctx.OOPK = oopkContext
+
+		Ω(getDimensionKey(dimensionVectorH, 0, 2, oopkContext.NumDimsPerDimWidth)).Should(Equal(string([]byte{1, 1, 0, 0, 0})))
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"1": float64(2),
+			"2": nil,
+		}))
+	})
+
+	ginkgo.It("evaluates post aggregation expressions", func() {
+		one, two := 1.0, 2.0
+		measureValues := []*float64{&one, &two, nil}
+		m0 := &expr.VarRef{ColumnID: 0}
+		m1 := &expr.VarRef{ColumnID: 1}
+		m2 := &expr.VarRef{ColumnID: 2}
+
+		Ω(*evalPostAggregationExpr(&expr.BinaryExpr{Op: expr.DIV, LHS: m1, RHS: m0}, measureValues)).Should(Equal(2.0))
+		Ω(*evalPostAggregationExpr(&expr.BinaryExpr{Op: expr.LT, LHS: m1, RHS: m0}, measureValues)).Should(Equal(0.0))
+		Ω(*evalPostAggregationExpr(&expr.UnaryExpr{Op: expr.UNARY_MINUS, Expr: m0}, measureValues)).Should(Equal(-1.0))
+		Ω(*evalPostAggregationExpr(&expr.UnaryExpr{Op: expr.IS_NULL, Expr: m2}, measureValues)).Should(Equal(1.0))
+		Ω(evalPostAggregationExpr(&expr.BinaryExpr{Op: expr.ADD, LHS: m0, RHS: m2}, measureValues)).Should(BeNil())
+		Ω(evalPostAggregationExpr(&expr.BinaryExpr{Op: expr.DIV, LHS: m0, RHS: &expr.NumberLiteral{}}, measureValues)).Should(BeNil())
+		// false AND null is false, true OR null is true.
+		Ω(*evalPostAggregationExpr(&expr.BinaryExpr{
+			Op:  expr.AND,
+			LHS: &expr.BooleanLiteral{Val: false},
+			RHS: m2,
+		}, measureValues)).Should(Equal(0.0))
+		Ω(*evalPostAggregationExpr(&expr.BinaryExpr{
+			Op:  expr.OR,
+			LHS: &expr.ParenExpr{Expr: m0},
+			RHS: m2,
+		}, measureValues)).Should(Equal(1.0))
+	})
+
+	ginkgo.It("compares dimension and measure values", func() {
+		one, two, ten, a := "1", "2", "10", "a"
+		Ω(compareDimensionValues(&two, &ten)).Should(Equal(-1))
+		Ω(compareDimensionValues(&ten, &a)).Should(Equal(-1))
+		Ω(compareDimensionValues(nil, &one)).Should(Equal(-1))
+		Ω(compareDimensionValues(&one, &one)).Should(Equal(0))
+
+		f1, f2 := 1.0, 2.0
+		Ω(compareMeasureValues(&f2, &f1)).Should(Equal(1))
+		Ω(compareMeasureValues(&f1, nil)).Should(Equal(1))
+		Ω(compareMeasureValues(nil, nil)).Should(Equal(0))
+	})
+
 	ginkgo.It("works on two dimensions and two rows", func() {
 		ctx := &AQLQueryContext{
 			Query: &AQLQuery{
@@ -85,10 +740,14 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 					ExprType: expr.Signed,
 				},
 			},
-			Measure: &expr.NumberLiteral{
-				ExprType: expr.Float,
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Float,
+					},
+					MeasureBytes: 4,
+				},
 			},
-			MeasureBytes:       4,
 			DimRowBytes:        8,
 			NumDimsPerDimWidth: queryCom.DimCountsPerDimWidth{0, 0, 1, 1, 0},
 			DimensionVectorIndex: []int{
@@ -124,8 +783,12 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 					DataType: memCom.Float32,
 				},
 			},
-			Measure: &expr.NumberLiteral{
-				ExprType: expr.UnknownType,
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.UnknownType,
+					},
+				},
 			},
 			DimRowBytes:        5,
 			NumDimsPerDimWidth: queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0},
@@ -205,10 +868,14 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 					ExprType: expr.Signed,
 				},
 			},
-			Measure: &expr.NumberLiteral{
-				ExprType: expr.Float,
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Float,
+					},
+					MeasureBytes: 4,
+				},
 			},
-			MeasureBytes:       4,
 			DimRowBytes:        10,
 			NumDimsPerDimWidth: queryCom.DimCountsPerDimWidth{0, 0, 2, 0, 0},
 			DimensionVectorIndex: []int{
@@ -335,10 +1002,14 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 					ExprType: expr.Signed,
 				},
 			},
-			Measure: &expr.NumberLiteral{
-				ExprType: expr.Float,
+			Measures: []OOPKMeasure{
+				{
+					Measure: &expr.NumberLiteral{
+						ExprType: expr.Float,
+					},
+					MeasureBytes: 4,
+				},
 			},
-			MeasureBytes:       4,
 			DimRowBytes:        10,
 			NumDimsPerDimWidth: queryCom.DimCountsPerDimWidth{0, 0, 2, 0, 0},
 			DimensionVectorIndex: []int{
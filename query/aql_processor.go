@@ -15,7 +15,9 @@
 package query
 
 import (
+	"context"
 	"math"
+	"sort"
 	"unsafe"
 
 	"encoding/binary"
@@ -64,6 +66,7 @@ func (qc *AQLQueryContext) ProcessQuery(memStore memstore.MemStore) {
 	qc.cudaStreams[0] = memutils.CreateCudaStream(qc.Device)
 	qc.cudaStreams[1] = memutils.CreateCudaStream(qc.Device)
 	qc.OOPK.currentBatch.device = qc.Device
+	qc.Stats.Device = qc.Device
 	qc.OOPK.LiveBatchStats = oopkQueryStats{
 		Name2Stage: make(map[stageName]*oopkStageSummaryStats),
 	}
@@ -106,7 +109,19 @@ func (qc *AQLQueryContext) ProcessQuery(memStore memstore.MemStore) {
 	}
 
 	for _, shardID := range qc.TableScanners[0].Shards {
-		previousBatchExecutor = qc.processShard(memStore, shardID, previousBatchExecutor)
+		var err error
+		previousBatchExecutor, err = qc.processShard(memStore, shardID, previousBatchExecutor)
+		if err != nil {
+			// the query is stopped between batches, device memory allocated
+			// for the previous batch not executed yet is released.
+			if qc.IsCanceled() {
+				qc.Error = utils.StackError(err, "query is cancelled by the client before finishing")
+			} else {
+				qc.Error = utils.StackError(err, "query is stopped as it exceeds the timeout")
+			}
+			qc.Release()
+			return
+		}
 		if qc.Error != nil {
 			return
 		}
@@ -128,25 +143,38 @@ func (qc *AQLQueryContext) ProcessQuery(memStore memstore.MemStore) {
 	}
 
 	start = utils.Now()
+	resultTransferStart := start
 	if qc.Error == nil {
 		// Copy the result to host memory.
 		qc.OOPK.ResultSize = qc.OOPK.currentBatch.resultSize
 		if qc.OOPK.IsHLL() {
 			qc.HLLQueryResult, qc.Error = qc.PostprocessAsHLLData()
 		} else {
+			if qc.OOPK.hasPercentile() {
+				qc.buildPercentileSketches(qc.cudaStreams[0])
+			}
 			// copy dimensions
 			qc.OOPK.dimensionVectorH = memutils.HostAlloc(qc.OOPK.ResultSize * qc.OOPK.DimRowBytes)
 			asyncCopyDimensionVector(qc.OOPK.dimensionVectorH, qc.OOPK.currentBatch.dimensionVectorD[0].getPointer(), qc.OOPK.ResultSize, 0,
 				qc.OOPK.NumDimsPerDimWidth, qc.OOPK.ResultSize, qc.OOPK.currentBatch.resultCapacity,
 				memutils.AsyncCopyDeviceToHost, qc.cudaStreams[0], qc.Device)
 			if !qc.isNonAggregationQuery {
-				// copy measures
-				qc.OOPK.measureVectorH = memutils.HostAlloc(qc.OOPK.ResultSize * qc.OOPK.MeasureBytes)
-				memutils.AsyncCopyDeviceToHost(
-					qc.OOPK.measureVectorH, qc.OOPK.currentBatch.measureVectorD[0].getPointer(),
-					qc.OOPK.ResultSize*qc.OOPK.MeasureBytes, qc.cudaStreams[0], qc.Device)
+				// copy measures, host measure vector is compacted to ResultSize values per measure.
+				qc.OOPK.measureVectorH = memutils.HostAlloc(qc.OOPK.ResultSize * qc.OOPK.MeasureRowBytes())
+				hostOffsets := getMeasureStartOffsets(qc.OOPK.Measures, qc.OOPK.ResultSize)
+				deviceOffsets := getMeasureStartOffsets(qc.OOPK.Measures, qc.OOPK.currentBatch.resultCapacity)
+				for i, measure := range qc.OOPK.Measures {
+					memutils.AsyncCopyDeviceToHost(
+						utils.MemAccess(qc.OOPK.measureVectorH, hostOffsets[i]),
+						utils.MemAccess(qc.OOPK.currentBatch.measureVectorD[0].getPointer(), deviceOffsets[i]),
+						qc.OOPK.ResultSize*measure.MeasureBytes, qc.cudaStreams[0], qc.Device)
+				}
 			}
 			memutils.WaitForCudaStream(qc.cudaStreams[0], qc.Device)
+			qc.Stats.TransferTime += millisecondsSince(resultTransferStart)
+			if qc.ReturnHLLData && qc.OOPK.IsPercentile() {
+				qc.HLLQueryResult, qc.Error = qc.PostprocessAsPercentileData()
+			}
 		}
 	}
 	qc.reportTiming(qc.cudaStreams[0], &start, resultTransferTiming)
@@ -154,15 +182,20 @@ func (qc *AQLQueryContext) ProcessQuery(memStore memstore.MemStore) {
 	qc.reportTiming(nil, &start, finalCleanupTiming)
 }
 
-func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int, previousBatchExecutor BatchExecutor) BatchExecutor {
+// processShard processes live batches and archive batches of the shard. The
+// error of the context is returned if the query is stopped before processing
+// all batches, see checkContext.
+func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
+	previousBatchExecutor BatchExecutor) (BatchExecutor, error) {
 	var liveRecordsProcessed, archiveRecordsProcessed, liveBatchProcessed, archiveBatchProcessed, liveBytesTransferred, archiveBytesTransferred int
 	shard, err := memStore.GetTableShard(qc.Query.Table, shardID)
 	if err != nil {
 		qc.Error = utils.StackError(err, "failed to get shard %d for table %s",
 			shardID, qc.Query.Table)
-		return previousBatchExecutor
+		return previousBatchExecutor, nil
 	}
 	defer shard.Users.Done()
+	qc.paging.shardID = shardID
 
 	var archiveStore *memstore.ArchiveStoreVersion
 	var cutoff uint32
@@ -175,10 +208,18 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 	// Process live batches.
 	if qc.toTime == nil || cutoff < uint32(qc.toTime.Time.Unix()) {
 		batchIDs, numRecordsInLastBatch := shard.LiveStore.GetBatchIDs()
+		// batches are processed in the order of IDs for a stable order of rows
+		// in non aggregation query results, the last batch remains last.
+		sort.Slice(batchIDs, func(i, j int) bool {
+			return batchIDs[i] < batchIDs[j]
+		})
 		for i, batchID := range batchIDs {
 			if qc.OOPK.done {
 				break
 			}
+			if qc.isBeforeStart(shardID, batchID) {
+				continue
+			}
 			batch := shard.LiveStore.GetBatchForRead(batchID)
 			if batch == nil {
 				continue
@@ -190,6 +231,7 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 			if shard.Schema.Schema.IsFactTable && qc.shouldSkipLiveBatch(batch) {
 				batch.RUnlock()
 				qc.OOPK.LiveBatchStats.NumBatchSkipped++
+				qc.Stats.LiveBatchesSkipped++
 				continue
 			}
 
@@ -199,11 +241,14 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 				size = numRecordsInLastBatch
 			}
 			liveRecordsProcessed += size
-			previousBatchExecutor = qc.processBatch(&batch.Batch,
+			previousBatchExecutor, err = qc.processBatch(&batch.Batch,
 				batchID,
 				size,
 				qc.transferLiveBatch(batch, size),
 				qc.liveBatchCustomFilterExecutor(cutoff), previousBatchExecutor, true)
+			if err != nil {
+				return previousBatchExecutor, err
+			}
 			qc.cudaStreams[0], qc.cudaStreams[1] = qc.cudaStreams[1], qc.cudaStreams[0]
 			liveBytesTransferred += qc.OOPK.currentBatch.stats.bytesTransferred
 		}
@@ -216,25 +261,36 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 			if qc.OOPK.done {
 				break
 			}
+			if qc.isBeforeStart(shardID, int32(batchID)) {
+				continue
+			}
 			archiveBatch := archiveStore.RequestBatch(int32(batchID))
 			if archiveBatch.Size == 0 {
 				qc.OOPK.ArchiveBatchStats.NumBatchSkipped++
+				qc.Stats.ArchiveBatchesSkipped++
 				continue
 			}
 			isFirstOrLast := batchID == scanner.ArchiveBatchIDStart || batchID == scanner.ArchiveBatchIDEnd-1
-			previousBatchExecutor = qc.processBatch(
+			previousBatchExecutor, err = qc.processBatch(
 				&archiveBatch.Batch,
 				int32(batchID),
 				archiveBatch.Size,
 				qc.transferArchiveBatch(archiveBatch, isFirstOrLast),
 				qc.archiveBatchCustomFilterExecutor(isFirstOrLast),
 				previousBatchExecutor, false)
+			if err != nil {
+				return previousBatchExecutor, err
+			}
 			archiveRecordsProcessed += archiveBatch.Size
 			archiveBatchProcessed++
 			qc.cudaStreams[0], qc.cudaStreams[1] = qc.cudaStreams[1], qc.cudaStreams[0]
 			archiveBytesTransferred += qc.OOPK.currentBatch.stats.bytesTransferred
 		}
 	}
+	qc.Stats.LiveBatches += liveBatchProcessed
+	qc.Stats.ArchiveBatches += archiveBatchProcessed
+	qc.Stats.RowsScanned += liveRecordsProcessed + archiveRecordsProcessed
+	qc.Stats.BytesTransferred += liveBytesTransferred + archiveBytesTransferred
 	utils.GetReporter(qc.Query.Table, shardID).GetCounter(utils.QueryLiveRecordsProcessed).Inc(int64(liveRecordsProcessed))
 	utils.GetReporter(qc.Query.Table, shardID).GetCounter(utils.QueryArchiveRecordsProcessed).Inc(int64(archiveRecordsProcessed))
 	utils.GetReporter(qc.Query.Table, shardID).GetCounter(utils.QueryLiveBatchProcessed).Inc(int64(liveBatchProcessed))
@@ -242,7 +298,30 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 	utils.GetReporter(qc.Query.Table, shardID).GetCounter(utils.QueryLiveBytesTransferred).Inc(int64(liveBytesTransferred))
 	utils.GetReporter(qc.Query.Table, shardID).GetCounter(utils.QueryArchiveBytesTransferred).Inc(int64(archiveBytesTransferred))
 
-	return previousBatchExecutor
+	return previousBatchExecutor, nil
+}
+
+// checkContext returns the error of the context of the query if it's done,
+// either context.Canceled or context.DeadlineExceeded, so that the query is
+// stopped before processing the next batch.
+func (qc *AQLQueryContext) checkContext() error {
+	if qc.Context == nil {
+		return nil
+	}
+	qc.contextErr = qc.Context.Err()
+	return qc.contextErr
+}
+
+// IsTimeout returns whether the query is stopped as the deadline of its
+// context is exceeded.
+func (qc *AQLQueryContext) IsTimeout() bool {
+	return qc.contextErr == context.DeadlineExceeded
+}
+
+// IsCanceled returns whether the query is stopped as its context is cancelled,
+// e.g. the client closes the request.
+func (qc *AQLQueryContext) IsCanceled() bool {
+	return qc.contextErr == context.Canceled
 }
 
 // Release releases all device memory it allocated. It **should only called** when any errors happens while the query is
@@ -594,6 +673,10 @@ func (qc *AQLQueryContext) transferArchiveBatch(batch *memstore.ArchiveBatch,
 			}
 		}
 
+		if startRow >= endRow {
+			qc.Stats.ArchiveBatchesPrefiltered++
+		}
+
 		for i, dstVPSlice := range deviceSlices {
 			columnID := qc.TableScanners[0].Columns[i]
 			usage := qc.TableScanners[0].ColumnUsages[columnID]
@@ -687,8 +770,13 @@ func (bc *oopkBatchContext) cleanupDeviceResultBuffers() {
 	deviceFreeAndSetNil(&bc.measureVectorD[0])
 	deviceFreeAndSetNil(&bc.measureVectorD[1])
 
+	deviceFreeAndSetNil(&bc.percentileDimensionVectorD)
+	deviceFreeAndSetNil(&bc.percentileMeasureVectorD)
+
 	bc.resultSize = 0
 	bc.resultCapacity = 0
+	bc.percentileSize = 0
+	bc.percentileCapacity = 0
 }
 
 // clean up memory not used in final aggregation (sort, reduce, hll)
@@ -742,7 +830,7 @@ func (bc *oopkBatchContext) prepareForFiltering(
 // prepareForDimAndMeasureEval ensures that dim/measure vectors have enough
 // capacity for bc.resultSize+bc.size.
 func (bc *oopkBatchContext) prepareForDimAndMeasureEval(
-	dimRowBytes int, measureBytes int, numDimsPerDimWidth queryCom.DimCountsPerDimWidth, isHLL bool, stream unsafe.Pointer) {
+	dimRowBytes int, measures []OOPKMeasure, numDimsPerDimWidth queryCom.DimCountsPerDimWidth, isHLL bool, stream unsafe.Pointer) {
 	if bc.resultSize+bc.size > bc.resultCapacity {
 		oldCapacity := bc.resultCapacity
 
@@ -768,8 +856,16 @@ func (bc *oopkBatchContext) prepareForDimAndMeasureEval(
 			bc.hashVectorD = bc.reallocateResultBuffers(bc.hashVectorD, 8, stream, nil)
 		}
 
-		bc.measureVectorD = bc.reallocateResultBuffers(bc.measureVectorD, measureBytes, stream, func(to, from unsafe.Pointer) {
-			memutils.AsyncCopyDeviceToDevice(to, from, bc.resultSize*measureBytes, stream, bc.device)
+		measureRowBytes := 0
+		for _, measure := range measures {
+			measureRowBytes += measure.MeasureBytes
+		}
+		bc.measureVectorD = bc.reallocateResultBuffers(bc.measureVectorD, measureRowBytes, stream, func(to, from unsafe.Pointer) {
+			oldOffsets := getMeasureStartOffsets(measures, oldCapacity)
+			for i, newOffset := range getMeasureStartOffsets(measures, bc.resultCapacity) {
+				memutils.AsyncCopyDeviceToDevice(utils.MemAccess(to, newOffset), utils.MemAccess(from, oldOffsets[i]),
+					bc.resultSize*measures[i].MeasureBytes, stream, bc.device)
+			}
 		})
 	}
 }
@@ -816,16 +912,22 @@ func (qc *AQLQueryContext) doProfile(action func(), profileName string, stream u
 // asynchronously to process the previous batch. When both async operations
 // finish, it prepares for the current batch execution and returns it as
 // a function closure to be invoked later. customFilterExecutor is the executor
-// to apply custom filters for live batch and archive batch.
+// to apply custom filters for live batch and archive batch. The error of the
+// context is returned without processing the batch if the query is stopped,
+// previousBatchExecutor is returned as is then.
 func (qc *AQLQueryContext) processBatch(
 	batch *memstore.Batch, batchID int32, batchSize int, transferFunc batchTransferExecutor,
-	customFilterFunc customFilterExecutor, previousBatchExecutor BatchExecutor, needToUnlockBatch bool) BatchExecutor {
+	customFilterFunc customFilterExecutor, previousBatchExecutor BatchExecutor, needToUnlockBatch bool) (BatchExecutor, error) {
 	defer func() {
 		if needToUnlockBatch {
 			batch.RUnlock()
 		}
 	}()
 
+	if err := qc.checkContext(); err != nil {
+		return previousBatchExecutor, err
+	}
+
 	if qc.Debug {
 		// Finish executing previous batch first to avoid timeline overlapping
 		qc.runBatchExecutor(previousBatchExecutor, false)
@@ -838,6 +940,7 @@ func (qc *AQLQueryContext) processBatch(
 		timings: make(map[stageName]float64),
 	}
 	start := utils.Now()
+	transferStart := start
 
 	// Async transfer.
 	stream := qc.cudaStreams[0]
@@ -871,6 +974,7 @@ func (qc *AQLQueryContext) processBatch(
 
 	// Wait for data transfer of the current batch.
 	memutils.WaitForCudaStream(stream, qc.Device)
+	qc.Stats.TransferTime += millisecondsSince(transferStart)
 
 	for _, vp := range hostVPs {
 		if vp != nil {
@@ -900,7 +1004,7 @@ func (qc *AQLQueryContext) processBatch(
 		for _, column := range deviceSlices {
 			deviceFreeAndSetNil(&column.basePtr)
 		}
-		return NewDummyBatchExecutor()
+		return NewDummyBatchExecutor(), nil
 	}
 
 	// no prefilter slicing in livebatch, startRow is always 0
@@ -909,7 +1013,7 @@ func (qc *AQLQueryContext) processBatch(
 
 	qc.reportTimingForCurrentBatch(stream, &start, prepareForFilteringTiming)
 
-	return NewBatchExecutor(qc, batchID, customFilterFunc, stream)
+	return NewBatchExecutor(qc, batchID, customFilterFunc, stream), nil
 }
 
 // prefilterSlice does the following:
@@ -1105,6 +1209,7 @@ func (qc *AQLQueryContext) estimateArchiveBatchMemoryUsage(batch *memstore.Archi
 // * Measurement
 // * Sort (hash/index)
 // * Reduce
+// * Percentile buffers
 func (qc *AQLQueryContext) estimateMemUsageForBatch(firstColumnSize int, columnMemUsage int) (memUsage int) {
 	// 1. columnMemUsage
 	memUsageBeforeAgg := columnMemUsage
@@ -1133,7 +1238,10 @@ func (qc *AQLQueryContext) estimateMemUsageForBatch(firstColumnSize int, columnM
 	memUsage += firstColumnSize * qc.OOPK.DimRowBytes * 2
 
 	// 9. Measure vector memory usage (input + output)
-	memUsage += firstColumnSize * qc.OOPK.MeasureBytes * 2
+	memUsage += firstColumnSize * qc.OOPK.MeasureRowBytes() * 2
+
+	// 10. Percentile buffers kept across batches.
+	memUsage += qc.estimatePercentileBufferMemUsage(firstColumnSize)
 
 	return
 }
@@ -1161,9 +1269,11 @@ func (qc *AQLQueryContext) estimateExpressionEvaluationMemUsage(inputSize int) (
 	}
 
 	// measure expression evaluation
-	_, maxExpMemUsage := estimateScratchSpaceMemUsage(qc.OOPK.Measure, inputSize, true)
-	utils.GetQueryLogger().Debugf("Measure %+v: maxExpMemUsage=%d", qc.OOPK.Measure, maxExpMemUsage)
-	memUsage = int(math.Max(float64(memUsage), float64(maxExpMemUsage)))
+	for _, measure := range qc.OOPK.Measures {
+		_, maxExpMemUsage := estimateScratchSpaceMemUsage(measure.Measure, inputSize, true)
+		utils.GetQueryLogger().Debugf("Measure %+v: maxExpMemUsage=%d", measure.Measure, maxExpMemUsage)
+		memUsage = int(math.Max(float64(memUsage), float64(maxExpMemUsage)))
+	}
 
 	return memUsage
 }
@@ -1261,16 +1371,25 @@ func (qc *AQLQueryContext) calculateForeignTableMemUsage(memStore memstore.MemSt
 	return memUsage
 }
 
+// EstimateDeviceMemory estimates the device memory required by the query,
+// which is reused by FindDeviceForQuery.
+func (qc *AQLQueryContext) EstimateDeviceMemory(memStore memstore.MemStore) int {
+	qc.OOPK.DeviceMemoryRequirement = qc.calculateMemoryRequirement(memStore)
+	qc.deviceMemoryEstimated = true
+	return qc.OOPK.DeviceMemoryRequirement
+}
+
 // FindDeviceForQuery calls device manager to find a device for the query
 func (qc *AQLQueryContext) FindDeviceForQuery(memStore memstore.MemStore, preferredDevice int,
 	deviceManager *DeviceManager, timeout int) {
-	memoryRequired := qc.calculateMemoryRequirement(memStore)
+	memoryRequired := qc.OOPK.DeviceMemoryRequirement
+	if !qc.deviceMemoryEstimated {
+		memoryRequired = qc.EstimateDeviceMemory(memStore)
+	}
 	if qc.Error != nil {
 		return
 	}
 
-	qc.OOPK.DeviceMemoryRequirement = memoryRequired
-
 	waitStart := utils.Now()
 	device := deviceManager.FindDevice(qc.Query, memoryRequired, preferredDevice, timeout)
 	if device == -1 {
@@ -1293,6 +1412,7 @@ func (qc *AQLQueryContext) runBatchExecutor(e BatchExecutor, isLastBatch bool) {
 	e.reduce()
 
 	e.postExec(start)
+	qc.Stats.KernelTime += millisecondsSince(start)
 }
 
 // copyHostToDevice copy vector party slice to device vector party slice
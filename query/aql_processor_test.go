@@ -15,6 +15,7 @@
 package query
 
 import (
+	"context"
 	"unsafe"
 
 	"encoding/binary"
@@ -589,7 +590,7 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		// test boolean dimension value
 		ctx.prepareForFiltering(columns, 0, 0, stream)
 		initIndexVector(ctx.indexVectorD.getPointer(), 0, ctx.size, stream, 0)
-		ctx.prepareForDimAndMeasureEval(oopkContext.DimRowBytes, 4, oopkContext.NumDimsPerDimWidth, false, stream)
+		ctx.prepareForDimAndMeasureEval(oopkContext.DimRowBytes, []OOPKMeasure{{MeasureBytes: 4}}, oopkContext.NumDimsPerDimWidth, false, stream)
 		valueOffset, nullOffset := queryCom.GetDimensionStartOffsets(oopkContext.NumDimsPerDimWidth, 0, ctx.resultCapacity)
 		dimensionExprRootAction := ctx.makeWriteToDimensionVectorAction(valueOffset, nullOffset, 0)
 		// vp2
@@ -640,7 +641,7 @@ var _ = ginkgo.Describe("aql_processor", func() {
 
 		ctx.prepareForFiltering(columns, 0, 0, stream)
 		initIndexVector(ctx.indexVectorD.getPointer(), 0, ctx.size, stream, 0)
-		ctx.prepareForDimAndMeasureEval(oopkContext.DimRowBytes, 4, oopkContext.NumDimsPerDimWidth, false, stream)
+		ctx.prepareForDimAndMeasureEval(oopkContext.DimRowBytes, []OOPKMeasure{{MeasureBytes: 4}}, oopkContext.NumDimsPerDimWidth, false, stream)
 		valueOffset, nullOffset := queryCom.GetDimensionStartOffsets(oopkContext.NumDimsPerDimWidth, 0, ctx.resultCapacity)
 		dimensionExprRootAction := ctx.makeWriteToDimensionVectorAction(valueOffset, nullOffset, 0)
 		// vp2 == 2
@@ -721,7 +722,7 @@ var _ = ginkgo.Describe("aql_processor", func() {
 
 		ctx.prepareForFiltering(columns, 0, 0, stream)
 		initIndexVector(ctx.indexVectorD.getPointer(), 0, ctx.size, stream, 0)
-		ctx.prepareForDimAndMeasureEval(oopkContext.DimRowBytes, 4, oopkContext.NumDimsPerDimWidth, false, stream)
+		ctx.prepareForDimAndMeasureEval(oopkContext.DimRowBytes, []OOPKMeasure{{MeasureBytes: 4}}, oopkContext.NumDimsPerDimWidth, false, stream)
 		valueOffset, nullOffset := queryCom.GetDimensionStartOffsets(oopkContext.NumDimsPerDimWidth, 0, ctx.resultCapacity)
 		dimensionExprRootAction := ctx.makeWriteToDimensionVectorAction(valueOffset, nullOffset, 0)
 		ctx.processExpression(exp, nil, tableScanners, foreignTables, stream, 0, dimensionExprRootAction)
@@ -786,8 +787,8 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		ctx.prepareForFiltering(columns, 0, 0, stream)
 		initIndexVector(ctx.indexVectorD.getPointer(), 0, ctx.size, stream, 0)
 
-		ctx.prepareForDimAndMeasureEval(dimRowBytes, 4, queryCom.DimCountsPerDimWidth{}, false, stream)
-		measureExprRootAction := ctx.makeWriteToMeasureVectorAction(uint32(1), 4)
+		ctx.prepareForDimAndMeasureEval(dimRowBytes, []OOPKMeasure{{MeasureBytes: 4}}, queryCom.DimCountsPerDimWidth{}, false, stream)
+		measureExprRootAction := ctx.makeWriteToMeasureVectorAction(uint32(1), 0, 4)
 		ctx.processExpression(exp, nil, tableScanners, foreignTables, stream, 0, measureExprRootAction)
 
 		Ω(*(*uint32)(ctx.measureVectorD[0].getPointer())).Should(Equal(uint32(0)))
@@ -866,11 +867,115 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		}
 
 		// 1 is AGGR_SUM_UNSIGNED
-		batchCtx.reduceByKey(numDims, 4, uint32(1), stream, 0)
+		batchCtx.reduceByKey(numDims, []OOPKMeasure{{MeasureBytes: 4, AggregateType: 1}}, stream, 0)
 		Ω(dimensionOutputVector).Should(Equal([5]uint32{1, 2, 0, 0, 0x0101}))
 		Ω(measureOutputVector).Should(Equal([4]uint32{6, 4, 0, 0}))
 	})
 
+	ginkgo.It("reduce keys of percentile measures should work", func() {
+		// one 4 byte dim
+		numDims := queryCom.DimCountsPerDimWidth{0, 0, 1, 0, 0}
+		var stream unsafe.Pointer
+
+		dimensionInputVector := [5]uint32{1, 1, 1, 2, 0x01010101}
+		hashInputVector := [4]uint64{1, 1, 1, 2}
+		dimIndexInputVector := [4]uint32{0, 1, 2, 3}
+		measureInputVector := [4]uint64{1, 2, 3, 4}
+
+		var dimensionOutputVector [5]uint32
+		var hashOutputVector [4]uint64
+		var dimIndexOutputVector [4]uint32
+		var measureOutputVector [4]uint64
+
+		batchCtx := oopkBatchContext{
+			dimensionVectorD: [2]devicePointer{{pointer: unsafe.Pointer(&dimensionInputVector)}, {pointer: unsafe.Pointer(&dimensionOutputVector)}},
+			hashVectorD:      [2]devicePointer{{pointer: unsafe.Pointer(&hashInputVector)}, {pointer: unsafe.Pointer(&hashOutputVector)}},
+			dimIndexVectorD:  [2]devicePointer{{pointer: unsafe.Pointer(&dimIndexInputVector)}, {pointer: unsafe.Pointer(&dimIndexOutputVector)}},
+			measureVectorD:   [2]devicePointer{{pointer: unsafe.Pointer(&measureInputVector)}, {pointer: unsafe.Pointer(&measureOutputVector)}},
+			size:             4,
+			resultSize:       0,
+			resultCapacity:   4,
+		}
+
+		// 11 is AGGR_AVG_FLOAT, percentile measures are not reduced.
+		batchCtx.reduceByKey(numDims, []OOPKMeasure{{MeasureBytes: 8, AggregateType: 11, IsPercentile: true}}, stream, 0)
+		Ω(batchCtx.resultSize).Should(Equal(2))
+		Ω(dimensionOutputVector).Should(Equal([5]uint32{1, 2, 0, 0, 0x0101}))
+		Ω(measureOutputVector).Should(Equal([4]uint64{0, 0, 0, 0}))
+	})
+
+	ginkgo.It("buffer percentile values and build sketches should work", func() {
+		// one 1 byte dim, rows [1, 4) are from current batch.
+		dimensionVector := [8]uint8{9, 1, 2, 1, 1, 1, 1, 1}
+		// count measure followed by percentile measure written as avg.
+		measureVector := struct {
+			counts      [4]uint32
+			percentiles [4][2]uint32
+		}{
+			percentiles: [4][2]uint32{
+				{math.Float32bits(9), 1},
+				{math.Float32bits(1), 1},
+				{math.Float32bits(2), 1},
+				{math.Float32bits(3), 2},
+			},
+		}
+
+		qc := &AQLQueryContext{}
+		qc.OOPK.NumDimsPerDimWidth = queryCom.DimCountsPerDimWidth{0, 0, 0, 0, 1}
+		qc.OOPK.DimRowBytes = 2
+		qc.OOPK.Measures = []OOPKMeasure{
+			// 1 is AGGR_SUM_UNSIGNED and 11 is AGGR_AVG_FLOAT.
+			{MeasureBytes: 4, AggregateType: 1},
+			{MeasureBytes: 8, AggregateType: 11, IsPercentile: true, Percentile: 0.5},
+		}
+		qc.OOPK.currentBatch = oopkBatchContext{
+			dimensionVectorD: [2]devicePointer{{pointer: unsafe.Pointer(&dimensionVector)}, nullDevicePointer},
+			measureVectorD:   [2]devicePointer{{pointer: unsafe.Pointer(&measureVector)}, nullDevicePointer},
+			size:             3,
+			resultSize:       1,
+			resultCapacity:   4,
+		}
+
+		var stream unsafe.Pointer
+		qc.bufferPercentileValues(stream)
+		Ω(qc.OOPK.currentBatch.percentileSize).Should(Equal(3))
+		Ω(qc.OOPK.percentileSketches).Should(BeNil())
+
+		qc.buildPercentileSketches(stream)
+		Ω(qc.OOPK.currentBatch.percentileSize).Should(Equal(0))
+		Ω(qc.OOPK.percentileSketches[0]).Should(BeNil())
+		sketches := qc.OOPK.percentileSketches[1]
+		Ω(sketches).Should(HaveLen(2))
+		Ω(sketches["\x01\x01"].Count()).Should(Equal(3.0))
+		Ω(sketches["\x01\x02"].Count()).Should(Equal(1.0))
+		Ω(sketches["\x01\x02"].Quantile(0.5)).Should(Equal(2.0))
+
+		qc.OOPK.currentBatch.cleanupDeviceResultBuffers()
+		Ω(qc.OOPK.currentBatch.percentileCapacity).Should(Equal(0))
+	})
+
+	ginkgo.It("estimatePercentileBufferMemUsage should work", func() {
+		qc := &AQLQueryContext{}
+		qc.OOPK.DimRowBytes = 5
+		qc.OOPK.Measures = []OOPKMeasure{
+			{MeasureBytes: 4},
+			{MeasureBytes: 8, IsPercentile: true},
+		}
+
+		// values of 4 batches are buffered.
+		Ω(qc.estimatePercentileBufferMemUsage(100)).Should(Equal(400 * 13))
+		Ω(qc.estimatePercentileBufferMemUsage(10)).Should(Equal(40 * 13))
+		Ω(qc.OOPK.percentileBufferRows).Should(Equal(400))
+
+		// buffers are capped unless a single batch is larger.
+		Ω(qc.estimatePercentileBufferMemUsage(maxPercentileBufferRows / 2)).Should(Equal(maxPercentileBufferRows * 13))
+		Ω(qc.estimatePercentileBufferMemUsage(maxPercentileBufferRows + 1)).Should(Equal((maxPercentileBufferRows + 1) * 13))
+		Ω(qc.OOPK.percentileBufferRows).Should(Equal(maxPercentileBufferRows + 1))
+
+		qc.OOPK.Measures = qc.OOPK.Measures[:1]
+		Ω(qc.estimatePercentileBufferMemUsage(100)).Should(Equal(0))
+	})
+
 	ginkgo.It("estimateScratchSpaceMemUsage should work", func() {
 		expression, _ := expr.ParseExpr(`a`)
 		currentMemUsage, maxMemUsage := estimateScratchSpaceMemUsage(expression, 100, true)
@@ -1036,6 +1141,65 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		Ω(qc.OOPK.hllDimRegIDCountD).Should(BeZero())
 	})
 
+	ginkgo.It("ProcessQuery should stop when context is done", func() {
+		q := &AQLQuery{
+			Table: table,
+			Dimensions: []Dimension{
+				{Expr: "c0", TimeBucketizer: "m", TimeUnit: "millisecond"},
+			},
+			Measures: []Measure{
+				{Expr: "count(c1)"},
+			},
+			TimeFilter: TimeFilter{
+				Column: "c0",
+				From:   "1970-01-01",
+				To:     "1970-01-02",
+			},
+		}
+
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		qc.Context = ctx
+		qc.ProcessQuery(memStore)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring(context.Canceled.Error()))
+		Ω(qc.IsCanceled()).Should(BeTrue())
+		Ω(qc.IsTimeout()).Should(BeFalse())
+
+		bc := qc.OOPK.currentBatch
+		Ω(qc.cudaStreams[0]).Should(BeZero())
+		Ω(qc.cudaStreams[1]).Should(BeZero())
+		Ω(bc.dimensionVectorD[0]).Should(BeZero())
+		Ω(bc.measureVectorD[0]).Should(BeZero())
+		Ω(len(bc.columns)).Should(BeZero())
+
+		Ω(qc.Stats.LiveBatches + qc.Stats.ArchiveBatches).Should(BeZero())
+
+		qc = q.Compile(memStore, false)
+		ctx, cancel = context.WithDeadline(context.Background(), time.Unix(0, 0))
+		defer cancel()
+		qc.Context = ctx
+		qc.ProcessQuery(memStore)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring(context.DeadlineExceeded.Error()))
+		Ω(qc.IsTimeout()).Should(BeTrue())
+		Ω(qc.IsCanceled()).Should(BeFalse())
+
+		qc = q.Compile(memStore, false)
+		Ω(qc.Stats.Device).Should(Equal(-1))
+		qc.Context = context.Background()
+		qc.ProcessQuery(memStore)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.IsTimeout()).Should(BeFalse())
+		Ω(qc.IsCanceled()).Should(BeFalse())
+		Ω(qc.Stats.Device).Should(Equal(qc.Device))
+		Ω(qc.Stats.LiveBatches + qc.Stats.ArchiveBatches).Should(BeNumerically(">", 0))
+		Ω(qc.Stats.RowsScanned).Should(BeNumerically(">", 0))
+		qc.ReleaseHostResultsBuffers()
+	})
+
 	ginkgo.It("ProcessQuery should work for timezone column queries", func() {
 		timezoneTable := "table2"
 		memStore := new(memMocks.MemStore)
@@ -1496,13 +1660,17 @@ var _ = ginkgo.Describe("aql_processor", func() {
 						DataType: memCom.Uint32,
 					},
 				},
-				AggregateType: 1,
-				MeasureBytes:  4,
-				Measure: &expr.NumberLiteral{
-					Val:      1,
-					Int:      1,
-					Expr:     "1",
-					ExprType: expr.Unsigned,
+				Measures: []OOPKMeasure{
+					{
+						AggregateType: 1,
+						MeasureBytes:  4,
+						Measure: &expr.NumberLiteral{
+							Val:      1,
+							Int:      1,
+							Expr:     "1",
+							ExprType: expr.Unsigned,
+						},
+					},
 				},
 				geoIntersection: &geoIntersection{
 					shapeTableID:  1,
@@ -1772,13 +1940,17 @@ var _ = ginkgo.Describe("aql_processor", func() {
 						DataType: memCom.Uint8,
 					},
 				},
-				AggregateType: 1,
-				MeasureBytes:  4,
-				Measure: &expr.NumberLiteral{
-					Val:      1,
-					Int:      1,
-					Expr:     "1",
-					ExprType: expr.Unsigned,
+				Measures: []OOPKMeasure{
+					{
+						AggregateType: 1,
+						MeasureBytes:  4,
+						Measure: &expr.NumberLiteral{
+							Val:      1,
+							Int:      1,
+							Expr:     "1",
+							ExprType: expr.Unsigned,
+						},
+					},
 				},
 				geoIntersection: &geoIntersection{
 					shapeTableID:  1,
@@ -2033,13 +2205,17 @@ var _ = ginkgo.Describe("aql_processor", func() {
 						DataType: memCom.Uint32,
 					},
 				},
-				AggregateType: 1,
-				MeasureBytes:  4,
-				Measure: &expr.NumberLiteral{
-					Val:      1,
-					Int:      1,
-					Expr:     "1",
-					ExprType: expr.Unsigned,
+				Measures: []OOPKMeasure{
+					{
+						AggregateType: 1,
+						MeasureBytes:  4,
+						Measure: &expr.NumberLiteral{
+							Val:      1,
+							Int:      1,
+							Expr:     "1",
+							ExprType: expr.Unsigned,
+						},
+					},
 				},
 			},
 			fromTime: &alignedTime{time.Unix(0, 0), "s"},
@@ -0,0 +1,529 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// Arrow IPC constants, see https://arrow.apache.org/docs/format/Columnar.html
+// and Schema.fbs, Message.fbs of the Arrow format.
+const (
+	arrowMetadataVersionV5 = 4
+	// message header types.
+	arrowHeaderSchema          = 1
+	arrowHeaderDictionaryBatch = 2
+	arrowHeaderRecordBatch     = 3
+	// field types.
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	// floating point precisions.
+	arrowPrecisionSingle = 1
+	arrowPrecisionDouble = 2
+	// continuation marker preceding each message of the stream.
+	arrowContinuation = 0xFFFFFFFF
+	// buffers in message body are padded to 8 bytes.
+	arrowAlignment = 8
+	// key of the error message in custom metadata of the schema of failed
+	// queries.
+	arrowErrorMetadataKey = "error"
+)
+
+// arrowType is the type of an Arrow column of query results.
+type arrowType int
+
+const (
+	arrowUtf8 arrowType = iota
+	// strings encoded by a dictionary with int32 indices.
+	arrowDictionary
+	arrowBool
+	arrowInt8
+	arrowInt16
+	arrowInt32
+	arrowInt64
+	arrowUint8
+	arrowUint16
+	arrowUint32
+	arrowFloat32
+	arrowFloat64
+)
+
+// arrowIntTypes stores the bit width and signedness of integer arrow types.
+var arrowIntTypes = map[arrowType]struct {
+	bitWidth int
+	signed   bool
+}{
+	arrowInt8:   {8, true},
+	arrowInt16:  {16, true},
+	arrowInt32:  {32, true},
+	arrowInt64:  {64, true},
+	arrowUint8:  {8, false},
+	arrowUint16: {16, false},
+	arrowUint32: {32, false},
+}
+
+// arrowTypesByDataType maps dimension data types to arrow types.
+var arrowTypesByDataType = map[memCom.DataType]arrowType{
+	memCom.Bool:      arrowBool,
+	memCom.Int8:      arrowInt8,
+	memCom.Int16:     arrowInt16,
+	memCom.Int32:     arrowInt32,
+	memCom.Int64:     arrowInt64,
+	memCom.Uint8:     arrowUint8,
+	memCom.Uint16:    arrowUint16,
+	memCom.Uint32:    arrowUint32,
+	memCom.SmallEnum: arrowUint8,
+	memCom.BigEnum:   arrowUint16,
+	memCom.Float32:   arrowFloat32,
+}
+
+// arrowColumn is a column of query results to be written as Arrow arrays.
+type arrowColumn struct {
+	name   string
+	typ    arrowType
+	values []interface{}
+}
+
+// arrowBody is the body of an Arrow message, which consists of buffers of the
+// arrays of all fields.
+type arrowBody struct {
+	data bytes.Buffer
+	// offset and length of each buffer.
+	buffers [][]int64
+	// length and null count of each field.
+	nodes [][]int64
+}
+
+// WriteArrowResults writes the postprocessed results of the query to w as an
+// Arrow IPC stream. The stream consists of the schema, dictionaries of enum
+// dimensions and one record batch of all rows of the flattened results.
+// Dimensions are typed by their data types, enum dimensions are dictionary
+// encoded strings, time dimensions with time unit are int64 and measures are
+// float64. Dimensions having values not of their types, e.g. "_total" of
+// grouping sets, are strings.
+func (qc *AQLQueryContext) WriteArrowResults(w io.Writer) error {
+	flat := qc.FlattenResults()
+	columns := qc.getArrowColumns(flat)
+
+	buildSchema := func(b *fbBuilder) int {
+		return buildArrowSchema(b, columns, nil)
+	}
+	if err := writeArrowMessage(w, arrowHeaderSchema, buildSchema, nil); err != nil {
+		return err
+	}
+
+	for id, column := range columns {
+		if column.typ != arrowDictionary {
+			continue
+		}
+		var dictionary []string
+		column.values, dictionary = encodeArrowDictionary(column.values)
+		body := &arrowBody{}
+		body.addStrings(stringsToValues(dictionary))
+		buildDictionaryBatch := func(b *fbBuilder) int {
+			recordBatch := body.buildRecordBatch(b, len(dictionary))
+			b.startObject(3)
+			b.addInt64(0, int64(id))
+			b.addOffset(1, recordBatch)
+			return b.endObject()
+		}
+		if err := writeArrowMessage(w, arrowHeaderDictionaryBatch, buildDictionaryBatch, body); err != nil {
+			return err
+		}
+	}
+
+	body := &arrowBody{}
+	for _, column := range columns {
+		body.addColumn(column)
+	}
+	buildRecordBatch := func(b *fbBuilder) int {
+		return body.buildRecordBatch(b, len(flat.Rows))
+	}
+	if err := writeArrowMessage(w, arrowHeaderRecordBatch, buildRecordBatch, body); err != nil {
+		return err
+	}
+
+	// end of stream.
+	return writeArrowUint32s(w, arrowContinuation, 0)
+}
+
+// WriteArrowError writes the error of the query to w as an Arrow IPC stream
+// of a schema without fields and no record batch. The error message is stored
+// in the custom metadata of the schema with key "error".
+func WriteArrowError(w io.Writer, err error) error {
+	buildSchema := func(b *fbBuilder) int {
+		return buildArrowSchema(b, nil, map[string]string{arrowErrorMetadataKey: err.Error()})
+	}
+	if err := writeArrowMessage(w, arrowHeaderSchema, buildSchema, nil); err != nil {
+		return err
+	}
+	return writeArrowUint32s(w, arrowContinuation, 0)
+}
+
+// getArrowColumns returns the columns of the flattened results with their
+// arrow types.
+func (qc *AQLQueryContext) getArrowColumns(flat *FlatResult) []*arrowColumn {
+	columns := make([]*arrowColumn, len(flat.Headers))
+	for i, header := range flat.Headers {
+		columns[i] = &arrowColumn{
+			name:   header,
+			typ:    arrowFloat64,
+			values: make([]interface{}, len(flat.Rows)),
+		}
+		for j, row := range flat.Rows {
+			columns[i].values[j] = row[i]
+		}
+	}
+
+	for dimIndex, dim := range qc.Query.Dimensions {
+		if dimIndex >= len(columns) {
+			break
+		}
+		column := columns[dimIndex]
+		column.typ = arrowUtf8
+		if dim.isTimeDimension() {
+			if dim.TimeUnit != "" {
+				column.typ = arrowInt64
+			}
+		} else if dimIndex < len(qc.OOPK.Dimensions) {
+			dimExpr := qc.OOPK.Dimensions[dimIndex]
+			if qc.getEnumReverseDict(dimIndex, dimExpr) != nil {
+				column.typ = arrowDictionary
+			} else if typ, ok := arrowTypesByDataType[getDimensionDataType(dimExpr)]; ok {
+				column.typ = typ
+			}
+		}
+	}
+
+	for _, column := range columns {
+		if column.hasValuesOfType() {
+			continue
+		}
+		column.typ = arrowUtf8
+		for i, value := range column.values {
+			if value != nil {
+				column.values[i] = FormatFlatValue(value)
+			}
+		}
+	}
+	return columns
+}
+
+// hasValuesOfType returns whether all non null values of the column are of
+// the go type of its arrow type in flat results.
+func (c *arrowColumn) hasValuesOfType() bool {
+	for _, value := range c.values {
+		if value == nil {
+			continue
+		}
+		var ok bool
+		switch c.typ {
+		case arrowUtf8, arrowDictionary:
+			_, ok = value.(string)
+		case arrowBool:
+			_, ok = value.(bool)
+		case arrowFloat32, arrowFloat64:
+			_, ok = value.(float64)
+		default:
+			_, ok = value.(int64)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeArrowDictionary returns the int32 indices of the string values to the
+// dictionary of distinct values in the order of their first appearance.
+func encodeArrowDictionary(values []interface{}) ([]interface{}, []string) {
+	var dictionary []string
+	indexes := make(map[string]int64)
+	encoded := make([]interface{}, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		str := value.(string)
+		index, ok := indexes[str]
+		if !ok {
+			index = int64(len(dictionary))
+			indexes[str] = index
+			dictionary = append(dictionary, str)
+		}
+		encoded[i] = index
+	}
+	return encoded, dictionary
+}
+
+func stringsToValues(strs []string) []interface{} {
+	values := make([]interface{}, len(strs))
+	for i, str := range strs {
+		values[i] = str
+	}
+	return values
+}
+
+// writeArrowMessage writes the message with the header built by buildHeader
+// and the body in the encapsulated message format: the continuation marker,
+// the padded size of the metadata, the flatbuffer of the message and the body.
+func writeArrowMessage(w io.Writer, headerType uint8, buildHeader func(*fbBuilder) int, body *arrowBody) error {
+	b := newFBBuilder()
+	header := buildHeader(b)
+	var bodyLength int
+	if body != nil {
+		bodyLength = body.data.Len()
+	}
+	b.startObject(5)
+	b.addInt16(0, arrowMetadataVersionV5)
+	b.addUint8(1, headerType)
+	b.addOffset(2, header)
+	b.addInt64(3, int64(bodyLength))
+	metadata := b.finish(b.endObject())
+
+	// the body following the metadata should be aligned.
+	padding := (-len(metadata)) & (arrowAlignment - 1)
+	if err := writeArrowUint32s(w, arrowContinuation, uint32(len(metadata)+padding)); err != nil {
+		return err
+	}
+	if _, err := w.Write(metadata); err != nil {
+		return utils.StackError(err, "Failed to write arrow message")
+	}
+	if _, err := w.Write(make([]byte, padding)); err != nil {
+		return utils.StackError(err, "Failed to write arrow message")
+	}
+	if body != nil {
+		if _, err := body.data.WriteTo(w); err != nil {
+			return utils.StackError(err, "Failed to write arrow message body")
+		}
+	}
+	return nil
+}
+
+func writeArrowUint32s(w io.Writer, values ...uint32) error {
+	if err := binary.Write(w, binary.LittleEndian, values); err != nil {
+		return utils.StackError(err, "Failed to write arrow message")
+	}
+	return nil
+}
+
+// buildArrowSchema builds the Schema table of the columns with the custom
+// metadata.
+func buildArrowSchema(b *fbBuilder, columns []*arrowColumn, metadata map[string]string) int {
+	fields := make([]int, len(columns))
+	for id, column := range columns {
+		name := b.createString(column.name)
+		typeType, typ := buildArrowType(b, column.typ)
+		var dictionary int
+		if column.typ == arrowDictionary {
+			b.startObject(2)
+			b.addInt32(0, 32)
+			b.addBool(1, true)
+			indexType := b.endObject()
+			b.startObject(4)
+			b.addInt64(0, int64(id))
+			b.addOffset(1, indexType)
+			dictionary = b.endObject()
+		}
+		children := b.createOffsetVector(nil)
+
+		b.startObject(7)
+		b.addOffset(0, name)
+		b.addBool(1, true)
+		b.addUint8(2, typeType)
+		b.addOffset(3, typ)
+		if dictionary != 0 {
+			b.addOffset(4, dictionary)
+		}
+		b.addOffset(5, children)
+		fields[id] = b.endObject()
+	}
+	fieldsVector := b.createOffsetVector(fields)
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	keyValues := make([]int, len(keys))
+	for i, key := range keys {
+		keyOffset := b.createString(key)
+		valueOffset := b.createString(metadata[key])
+		b.startObject(2)
+		b.addOffset(0, keyOffset)
+		b.addOffset(1, valueOffset)
+		keyValues[i] = b.endObject()
+	}
+	var metadataVector int
+	if len(keyValues) > 0 {
+		metadataVector = b.createOffsetVector(keyValues)
+	}
+
+	b.startObject(4)
+	// little endian.
+	b.addInt16(0, 0)
+	b.addOffset(1, fieldsVector)
+	if metadataVector != 0 {
+		b.addOffset(2, metadataVector)
+	}
+	return b.endObject()
+}
+
+// buildArrowType builds the type table of the arrow type and returns the type
+// of the type table and its offset. Dictionary encoded fields are of the type
+// of the dictionary values.
+func buildArrowType(b *fbBuilder, typ arrowType) (uint8, int) {
+	switch typ {
+	case arrowBool:
+		b.startObject(0)
+		return arrowTypeBool, b.endObject()
+	case arrowFloat32, arrowFloat64:
+		precision := int16(arrowPrecisionDouble)
+		if typ == arrowFloat32 {
+			precision = arrowPrecisionSingle
+		}
+		b.startObject(1)
+		b.addInt16(0, precision)
+		return arrowTypeFloatingPoint, b.endObject()
+	case arrowUtf8, arrowDictionary:
+		b.startObject(0)
+		return arrowTypeUtf8, b.endObject()
+	}
+	intType := arrowIntTypes[typ]
+	b.startObject(2)
+	b.addInt32(0, int32(intType.bitWidth))
+	b.addBool(1, intType.signed)
+	return arrowTypeInt, b.endObject()
+}
+
+// buildRecordBatch builds the RecordBatch table of length rows describing
+// fields and buffers in the body.
+func (body *arrowBody) buildRecordBatch(b *fbBuilder, length int) int {
+	nodes := b.createStructVector(body.nodes)
+	buffers := b.createStructVector(body.buffers)
+	b.startObject(4)
+	b.addInt64(0, int64(length))
+	b.addOffset(1, nodes)
+	b.addOffset(2, buffers)
+	return b.endObject()
+}
+
+// addBuffer appends the buffer to body with padding.
+func (body *arrowBody) addBuffer(buffer []byte) {
+	body.buffers = append(body.buffers, []int64{int64(body.data.Len()), int64(len(buffer))})
+	body.data.Write(buffer)
+	body.data.Write(make([]byte, (-len(buffer))&(arrowAlignment-1)))
+}
+
+// addValidity adds the field node and the validity bitmap of values. The
+// bitmap is empty if there is no null.
+func (body *arrowBody) addValidity(values []interface{}) {
+	var nullCount int
+	bitmap := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value == nil {
+			nullCount++
+		} else {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	body.nodes = append(body.nodes, []int64{int64(len(values)), int64(nullCount)})
+	if nullCount == 0 {
+		bitmap = nil
+	}
+	body.addBuffer(bitmap)
+}
+
+// addStrings adds the validity bitmap, offsets and data buffers of strings.
+func (body *arrowBody) addStrings(values []interface{}) {
+	body.addValidity(values)
+	offsets := make([]byte, 4*(len(values)+1))
+	var data []byte
+	for i, value := range values {
+		if value != nil {
+			data = append(data, value.(string)...)
+		}
+		binary.LittleEndian.PutUint32(offsets[4*(i+1):], uint32(len(data)))
+	}
+	body.addBuffer(offsets)
+	body.addBuffer(data)
+}
+
+// addColumn adds buffers of the column, dictionary encoded columns should
+// have been replaced by indices.
+func (body *arrowBody) addColumn(column *arrowColumn) {
+	switch column.typ {
+	case arrowUtf8:
+		body.addStrings(column.values)
+		return
+	case arrowBool:
+		body.addValidity(column.values)
+		bitmap := make([]byte, (len(column.values)+7)/8)
+		for i, value := range column.values {
+			if value != nil && value.(bool) {
+				bitmap[i/8] |= 1 << uint(i%8)
+			}
+		}
+		body.addBuffer(bitmap)
+		return
+	}
+
+	body.addValidity(column.values)
+	var valueBytes int
+	switch column.typ {
+	case arrowDictionary, arrowFloat32:
+		valueBytes = 4
+	case arrowFloat64:
+		valueBytes = 8
+	default:
+		valueBytes = arrowIntTypes[column.typ].bitWidth / 8
+	}
+	buffer := make([]byte, valueBytes*len(column.values))
+	for i, value := range column.values {
+		if value == nil {
+			continue
+		}
+		var bits uint64
+		switch column.typ {
+		case arrowFloat32:
+			bits = uint64(math.Float32bits(float32(value.(float64))))
+		case arrowFloat64:
+			bits = math.Float64bits(value.(float64))
+		default:
+			bits = uint64(value.(int64))
+		}
+		element := buffer[i*valueBytes : (i+1)*valueBytes]
+		switch valueBytes {
+		case 1:
+			element[0] = uint8(bits)
+		case 2:
+			binary.LittleEndian.PutUint16(element, uint16(bits))
+		case 4:
+			binary.LittleEndian.PutUint32(element, uint32(bits))
+		case 8:
+			binary.LittleEndian.PutUint64(element, bits)
+		}
+	}
+	body.addBuffer(buffer)
+}
@@ -0,0 +1,258 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	memCom "github.com/uber/aresdb/memstore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+)
+
+var _ = ginkgo.Describe("arrow result", func() {
+	// createQueryContext creates a query context grouped by a time dimension in
+	// days, an enum dimension and an uint16 dimension.
+	createQueryContext := func(results queryCom.AQLQueryResult) *AQLQueryContext {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: "reqAt", TimeUnit: "day"},
+					{Expr: "status"},
+					{Expr: "city_id"},
+				},
+				Measures: []Measure{{Expr: "count(*)"}},
+			},
+			Results: results,
+		}
+		qc.OOPK.Dimensions = []expr.Expr{
+			&expr.VarRef{Val: "reqAt", DataType: memCom.Uint32},
+			&expr.VarRef{Val: "status", DataType: memCom.SmallEnum, EnumReverseDict: []string{"completed", "canceled"}},
+			&expr.VarRef{Val: "city_id", DataType: memCom.Uint16},
+		}
+		return qc
+	}
+
+	results := queryCom.AQLQueryResult{
+		"17000": map[string]interface{}{
+			"completed": map[string]interface{}{"12": 2.0, "NULL": nil},
+		},
+		"16999": map[string]interface{}{
+			"canceled": map[string]interface{}{"1": 3.0},
+		},
+	}
+
+	// readMessages returns the header type and the body of each message in the
+	// arrow stream.
+	readMessages := func(stream []byte) (headerTypes []uint8, bodies [][]byte) {
+		for {
+			Ω(binary.LittleEndian.Uint32(stream)).Should(Equal(uint32(arrowContinuation)))
+			size := int(binary.LittleEndian.Uint32(stream[4:]))
+			stream = stream[8:]
+			if size == 0 {
+				Ω(stream).Should(BeEmpty())
+				return
+			}
+			Ω(size % arrowAlignment).Should(Equal(0))
+
+			// fields of the Message table at the root of the flatbuffer.
+			metadata := stream[:size]
+			table := int(binary.LittleEndian.Uint32(metadata))
+			vtable := table - int(int32(binary.LittleEndian.Uint32(metadata[table:])))
+			field := func(slot int) []byte {
+				return metadata[table+int(binary.LittleEndian.Uint16(metadata[vtable+4+2*slot:])):]
+			}
+			Ω(binary.LittleEndian.Uint16(field(0))).Should(Equal(uint16(arrowMetadataVersionV5)))
+			headerTypes = append(headerTypes, field(1)[0])
+			bodyLength := int(binary.LittleEndian.Uint64(field(3)))
+			bodies = append(bodies, stream[size:size+bodyLength])
+			stream = stream[size+bodyLength:]
+		}
+	}
+
+	ginkgo.It("gets arrow columns of results", func() {
+		qc := createQueryContext(results)
+		columns := qc.getArrowColumns(qc.FlattenResults())
+		Ω(columns).Should(Equal([]*arrowColumn{
+			{name: "reqAt", typ: arrowInt64, values: []interface{}{int64(16999), int64(17000), int64(17000)}},
+			{name: "status", typ: arrowDictionary, values: []interface{}{"canceled", "completed", "completed"}},
+			{name: "city_id", typ: arrowUint16, values: []interface{}{int64(1), nil, int64(12)}},
+			{name: "count(*)", typ: arrowFloat64, values: []interface{}{3.0, nil, 2.0}},
+		}))
+
+		// Dimensions with values rolled up by grouping sets are strings.
+		qc = createQueryContext(queryCom.AQLQueryResult{
+			"17000": map[string]interface{}{
+				"_total": map[string]interface{}{"_total": 1.0, "12": 2.0},
+			},
+		})
+		columns = qc.getArrowColumns(qc.FlattenResults())
+		Ω(columns[2]).Should(Equal(&arrowColumn{
+			name: "city_id", typ: arrowUtf8, values: []interface{}{"12", "_total"},
+		}))
+		Ω(columns[1].typ).Should(Equal(arrowDictionary))
+	})
+
+	ginkgo.It("encodes dictionary of strings", func() {
+		indices, dictionary := encodeArrowDictionary([]interface{}{"b", nil, "a", "b"})
+		Ω(indices).Should(Equal([]interface{}{int64(0), nil, int64(1), int64(0)}))
+		Ω(dictionary).Should(Equal([]string{"b", "a"}))
+	})
+
+	ginkgo.It("writes buffers of columns", func() {
+		body := &arrowBody{}
+		body.addColumn(&arrowColumn{typ: arrowUint16, values: []interface{}{int64(1), nil, int64(300)}})
+		body.addColumn(&arrowColumn{typ: arrowBool, values: []interface{}{true, false, true}})
+		body.addColumn(&arrowColumn{typ: arrowUtf8, values: []interface{}{"ab", nil, "c"}})
+		Ω(body.nodes).Should(Equal([][]int64{{3, 1}, {3, 0}, {3, 1}}))
+		Ω(body.buffers).Should(Equal([][]int64{
+			{0, 1}, {8, 6},
+			{16, 0}, {16, 1},
+			{24, 1}, {32, 16}, {48, 3},
+		}))
+		Ω(body.data.Bytes()).Should(Equal([]byte{
+			5, 0, 0, 0, 0, 0, 0, 0,
+			1, 0, 0, 0, 44, 1, 0, 0,
+			5, 0, 0, 0, 0, 0, 0, 0,
+			5, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0,
+			'a', 'b', 'c', 0, 0, 0, 0, 0,
+		}))
+	})
+
+	ginkgo.It("writes results as arrow stream", func() {
+		qc := createQueryContext(results)
+		var stream bytes.Buffer
+		Ω(qc.WriteArrowResults(&stream)).Should(BeNil())
+
+		headerTypes, bodies := readMessages(stream.Bytes())
+		Ω(headerTypes).Should(Equal([]uint8{arrowHeaderSchema, arrowHeaderDictionaryBatch, arrowHeaderRecordBatch}))
+		Ω(bodies[0]).Should(BeEmpty())
+		Ω(bodies[1]).Should(Equal([]byte{
+			0, 0, 0, 0, 8, 0, 0, 0, 17, 0, 0, 0, 0, 0, 0, 0,
+			'c', 'a', 'n', 'c', 'e', 'l', 'e', 'd', 'c', 'o', 'm', 'p', 'l', 'e', 't', 'e',
+			'd', 0, 0, 0, 0, 0, 0, 0,
+		}))
+		// int64 day, int32 indices of status, validity and uint16 values of city
+		// and validity and float64 values of count.
+		Ω(bodies[2]).Should(HaveLen(24 + 16 + 8 + 8 + 8 + 24))
+	})
+
+	ginkgo.It("writes errors as arrow stream", func() {
+		var stream bytes.Buffer
+		Ω(WriteArrowError(&stream, errors.New("test err"))).Should(BeNil())
+		headerTypes, bodies := readMessages(stream.Bytes())
+		Ω(headerTypes).Should(Equal([]uint8{arrowHeaderSchema}))
+		Ω(bodies[0]).Should(BeEmpty())
+		Ω(bytes.Contains(stream.Bytes(), []byte("error\x00"))).Should(BeTrue())
+		Ω(bytes.Contains(stream.Bytes(), []byte("test err\x00"))).Should(BeTrue())
+	})
+
+	ginkgo.It("writes streams read by arrow readers", func() {
+		// dictionaries are not read by the arrow go reader, so there is no enum
+		// dimension.
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: "reqAt", TimeUnit: "day"},
+					{Expr: "city_id"},
+				},
+				Measures: []Measure{{Expr: "count(*)"}},
+			},
+			Results: queryCom.AQLQueryResult{
+				"17000": map[string]interface{}{"12": 2.0, "NULL": nil},
+				"16999": map[string]interface{}{"1": 3.0},
+			},
+		}
+		qc.OOPK.Dimensions = []expr.Expr{
+			&expr.VarRef{Val: "reqAt", DataType: memCom.Uint32},
+			&expr.VarRef{Val: "city_id", DataType: memCom.Uint16},
+		}
+		var stream bytes.Buffer
+		Ω(qc.WriteArrowResults(&stream)).Should(BeNil())
+
+		reader, err := ipc.NewReader(&stream)
+		Ω(err).Should(BeNil())
+		defer reader.Release()
+		schema := reader.Schema()
+		Ω(schema.Fields()).Should(HaveLen(3))
+		Ω(schema.Field(0).Name).Should(Equal("reqAt"))
+		Ω(schema.Field(0).Type.ID()).Should(Equal(arrow.INT64))
+		Ω(schema.Field(1).Type.ID()).Should(Equal(arrow.UINT16))
+		Ω(schema.Field(2).Type.ID()).Should(Equal(arrow.FLOAT64))
+
+		Ω(reader.Next()).Should(BeTrue())
+		record := reader.Record()
+		Ω(record.NumRows()).Should(BeEquivalentTo(3))
+		Ω(record.Column(0).(*array.Int64).Int64Values()).Should(Equal([]int64{16999, 17000, 17000}))
+		cities := record.Column(1).(*array.Uint16)
+		Ω(cities.NullN()).Should(Equal(1))
+		Ω(cities.IsNull(1)).Should(BeTrue())
+		Ω(cities.Value(0)).Should(Equal(uint16(1)))
+		Ω(cities.Value(2)).Should(Equal(uint16(12)))
+		counts := record.Column(2).(*array.Float64)
+		Ω(counts.IsNull(1)).Should(BeTrue())
+		Ω(counts.Value(0)).Should(Equal(3.0))
+		Ω(counts.Value(2)).Should(Equal(2.0))
+		Ω(reader.Next()).Should(BeFalse())
+		Ω(reader.Err()).Should(BeNil())
+
+		stream.Reset()
+		Ω(WriteArrowError(&stream, errors.New("test err"))).Should(BeNil())
+		errReader, err := ipc.NewReader(&stream)
+		Ω(err).Should(BeNil())
+		defer errReader.Release()
+		Ω(errReader.Schema().Fields()).Should(BeEmpty())
+		Ω(errReader.Schema().Metadata().Keys()).Should(Equal([]string{arrowErrorMetadataKey}))
+		Ω(errReader.Schema().Metadata().Values()).Should(Equal([]string{"test err"}))
+		Ω(errReader.Next()).Should(BeFalse())
+		Ω(errReader.Err()).Should(BeNil())
+	})
+
+	ginkgo.It("builds flatbuffer tables", func() {
+		b := newFBBuilder()
+		name := b.createString("abc")
+		vector := b.createStructVector([][]int64{{1, 2}})
+		b.startObject(3)
+		b.addOffset(0, name)
+		b.addInt16(2, -2)
+		b.addOffset(1, vector)
+		buf := b.finish(b.endObject())
+
+		table := int(binary.LittleEndian.Uint32(buf))
+		vtable := table - int(int32(binary.LittleEndian.Uint32(buf[table:])))
+		Ω(binary.LittleEndian.Uint16(buf[vtable:])).Should(Equal(uint16(10)))
+		field := func(slot int) int {
+			return table + int(binary.LittleEndian.Uint16(buf[vtable+4+2*slot:]))
+		}
+		Ω(int16(binary.LittleEndian.Uint16(buf[field(2):]))).Should(Equal(int16(-2)))
+
+		str := field(0) + int(binary.LittleEndian.Uint32(buf[field(0):]))
+		Ω(string(buf[str+4 : str+4+int(binary.LittleEndian.Uint32(buf[str:]))])).Should(Equal("abc"))
+
+		vec := field(1) + int(binary.LittleEndian.Uint32(buf[field(1):]))
+		Ω(binary.LittleEndian.Uint32(buf[vec:])).Should(Equal(uint32(1)))
+		Ω((vec + 4) % 8).Should(Equal(0))
+		Ω(binary.LittleEndian.Uint64(buf[vec+4:])).Should(Equal(uint64(1)))
+		Ω(binary.LittleEndian.Uint64(buf[vec+12:])).Should(Equal(uint64(2)))
+	})
+})
@@ -15,10 +15,25 @@
 package common
 
 const (
-	MatrixDataKey = "matrixData"
-	HeadersKey    = "headers"
+	MatrixDataKey        = "matrixData"
+	HeadersKey           = "headers"
+	ContinuationTokenKey = "continuationToken"
 )
 
+// keys of compared measure values.
+const (
+	CurrentKey  = "current"
+	PreviousKey = "previous"
+	DeltaKey    = "delta"
+)
+
+// NULLString is the dimension value representing NULL in results.
+const NULLString = "NULL"
+
+// TotalKey is the reserved dimension value of dimensions rolled up in
+// subtotal and grand total groups of grouping sets.
+const TotalKey = "_total"
+
 // AQLQueryResult represents final result of one AQL query
 //
 // It has 2 possible formats:
@@ -29,12 +44,21 @@ const (
 //  - after the time dimension, there could be zero or more layers of additional
 //    dimensions (all values are represented as strings). a special "NULL" string
 ///   is used to represent NULL values.
-//  - there is always a single measure, and the measure type is either float64
-//    or nil (not *float64);
+//  - for a single measure, the measure type is either float64 or nil (not *float64);
+//  - for multiple measures, the leaf is a map from measure name (alias or
+//    expression) to measure value, each value is either float64 or nil;
+//  - for queries with compareTo, each measure value is replaced by a map of its
+//    current value, previous value and delta;
+//  - for queries with grouping sets, values of dimensions rolled up in subtotal
+//    and grand total groups are "_total";
 //
 // Non aggregate query result format:
 //  - there will be a "headers" key, value will be a list of column names
 //  - there will be a "matrixData" key, value will be a 2d arary of values (row formated)
+//  - there will be a "continuationToken" key if there may be more rows, value
+//    will be the token to fetch the next page
+//  - sorted aggregation results also use this format, with measure values
+//    (float64 or nil) following dimension values in each row
 //
 // user should use it as only 1 of the 2 formats consistently
 type AQLQueryResult map[string]interface{}
@@ -43,33 +67,70 @@ type AQLQueryResult map[string]interface{}
 
 // Set measure value for dimensions
 func (r AQLQueryResult) Set(dimValues []*string, measureValue *float64) {
-	null := "NULL"
-	var current map[string]interface{} = r
-	for i, dimValue := range dimValues {
-		if dimValue == nil {
-			dimValue = &null
+	if measureValue == nil {
+		r.setLeaf(dimValues, nil)
+	} else {
+		r.setLeaf(dimValues, *measureValue)
+	}
+}
+
+// SetMeasures sets values of multiple measures for dimensions. The leaf of the
+// nested map is a map from measure name to measure value.
+func (r AQLQueryResult) SetMeasures(dimValues []*string, measureNames []string, measureValues []*float64) {
+	measures := make(map[string]interface{}, len(measureNames))
+	for i, name := range measureNames {
+		if measureValues[i] == nil {
+			measures[name] = nil
+		} else {
+			measures[name] = *measureValues[i]
 		}
+	}
+	r.setLeaf(dimValues, measures)
+}
 
-		if i == len(dimValues)-1 {
-			if measureValue == nil {
-				current[*dimValue] = nil
+// SetComparedMeasures sets values of measures compared with a previous period
+// for dimensions. values consists of the current value, previous value and
+// delta of each measure, which is set as a map from CurrentKey, PreviousKey and
+// DeltaKey to the value. For a single measure (nil measureNames), the map is
+// the leaf, otherwise the leaf is a map from measure name to the map.
+func (r AQLQueryResult) SetComparedMeasures(dimValues []*string, measureNames []string, values []*float64) {
+	compared := func(measureIndex int) map[string]interface{} {
+		m := make(map[string]interface{}, 3)
+		for i, key := range []string{CurrentKey, PreviousKey, DeltaKey} {
+			if value := values[3*measureIndex+i]; value == nil {
+				m[key] = nil
 			} else {
-				current[*dimValue] = *measureValue
+				m[key] = *value
 			}
-		} else {
-			child := current[*dimValue]
-			if child == nil {
-				child = make(map[string]interface{})
-				current[*dimValue] = child
-			}
-			current = child.(map[string]interface{})
 		}
+		return m
 	}
+
+	if measureNames == nil {
+		r.setLeaf(dimValues, compared(0))
+		return
+	}
+
+	measures := make(map[string]interface{}, len(measureNames))
+	for i, name := range measureNames {
+		measures[name] = compared(i)
+	}
+	r.setLeaf(dimValues, measures)
 }
 
 // SetHLL sets hll struct to be the leaves of the nested map.
 func (r AQLQueryResult) SetHLL(dimValues []*string, hll HLL) {
-	null := "NULL"
+	r.setLeaf(dimValues, hll)
+}
+
+// SetTDigest sets t-digest sketch to be the leaves of the nested map.
+func (r AQLQueryResult) SetTDigest(dimValues []*string, digest *TDigest) {
+	r.setLeaf(dimValues, digest)
+}
+
+// setLeaf sets the leaf value of the nested map for dimensions.
+func (r AQLQueryResult) setLeaf(dimValues []*string, leaf interface{}) {
+	null := NULLString
 	var current map[string]interface{} = r
 	for i, dimValue := range dimValues {
 		if dimValue == nil {
@@ -77,7 +138,7 @@ func (r AQLQueryResult) SetHLL(dimValues []*string, hll HLL) {
 		}
 
 		if i == len(dimValues)-1 {
-			current[*dimValue] = hll
+			current[*dimValue] = leaf
 		} else {
 			child := current[*dimValue]
 			if child == nil {
@@ -106,7 +167,7 @@ func (r AQLQueryResult) Append(dimValues []*string) {
 	values := make([]interface{}, len(dimValues))
 	for index, v := range dimValues {
 		if v == nil {
-			values[index] = "NULL"
+			values[index] = NULLString
 		} else {
 			values[index] = *v
 		}
@@ -114,9 +175,36 @@ func (r AQLQueryResult) Append(dimValues []*string) {
 	r.append(values)
 }
 
+// AppendWithMeasures appends one row of dimension values followed by measure values.
+// It's used for returning sorted aggregation results.
+func (r AQLQueryResult) AppendWithMeasures(dimValues []*string, measureValues []*float64) {
+	values := make([]interface{}, 0, len(dimValues)+len(measureValues))
+	for _, v := range dimValues {
+		if v == nil {
+			values = append(values, NULLString)
+		} else {
+			values = append(values, *v)
+		}
+	}
+
+	for _, v := range measureValues {
+		if v == nil {
+			values = append(values, nil)
+		} else {
+			values = append(values, *v)
+		}
+	}
+	r.append(values)
+}
+
 // SetHeaders sets headers field for the results
 func (r AQLQueryResult) SetHeaders(headers []string) {
 	r[HeadersKey] = headers
 }
 
+// SetContinuationToken sets the continuation token of the next page for the results
+func (r AQLQueryResult) SetContinuationToken(token string) {
+	r[ContinuationTokenKey] = token
+}
+
 // =====  Non aggregate query result methods end =====
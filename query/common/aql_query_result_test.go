@@ -49,6 +49,21 @@ var _ = ginkgo.Describe("time series result", func() {
 		}))
 	})
 
+	ginkgo.It("SetMeasures should work", func() {
+		res := AQLQueryResult{}
+		dim0 := "dim0"
+		v := 0.01
+		res.SetMeasures([]*string{&dim0, nil}, []string{"m0", "m1"}, []*float64{&v, nil})
+		Ω(res).Should(Equal(AQLQueryResult{
+			"dim0": map[string]interface{}{
+				"NULL": map[string]interface{}{
+					"m0": 0.01,
+					"m1": nil,
+				},
+			},
+		}))
+	})
+
 	ginkgo.It("Append should work", func() {
 		res := AQLQueryResult{}
 		str := "1"
@@ -60,6 +75,18 @@ var _ = ginkgo.Describe("time series result", func() {
 		}))
 	})
 
+	ginkgo.It("AppendWithMeasures should work", func() {
+		res := AQLQueryResult{}
+		dim0 := "dim0"
+		v := 0.01
+		res.AppendWithMeasures([]*string{&dim0, nil}, []*float64{&v, nil})
+		Ω(res).Should(Equal(AQLQueryResult{
+			"matrixData": [][]interface{}{
+				{"dim0", "NULL", 0.01, nil},
+			},
+		}))
+	})
+
 	ginkgo.It("SetHeaders should work", func() {
 		res := AQLQueryResult{}
 		res.SetHeaders([]string{"field1", "field2"})
@@ -138,7 +138,14 @@ func GetDimensionStartOffsets(numDimsPerDimWidth DimCountsPerDimWidth, dimIndex
 	return valueOffset, nullOffset
 }
 
+// FormatTimeDimension formats the time dimension value in the same way as
+// ReadDimension does.
+func FormatTimeDimension(val int64, meta TimeDimensionMeta, cache map[TimeDimensionMeta]map[int64]string) string {
+	return formatTimeDimension(val, meta, cache)
+}
+
 func formatTimeDimension(val int64, meta TimeDimensionMeta, cache map[TimeDimensionMeta]map[int64]string) (result string) {
+	val += meta.TimeShift
 	// We will not process timeUnit for application/hll because if application/hll holds the raw uint32
 	// value. If we convert it to milliseconds, it will overflow.
 	if meta.TimeUnit != "" {
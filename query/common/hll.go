@@ -38,6 +38,10 @@ const (
 	DenseDataLength = 1 << 14 // 16kb
 	// DenseThreshold is the thresold to convert sparse value to dense value.
 	DenseThreshold = DenseDataLength / 4
+	// PercentileResultFlag is written in place of the error flag of a query
+	// result to mark the result as percentile sketches, see
+	// parseTimeseriesPercentileResult.
+	PercentileResultFlag uint8 = 2
 )
 
 // HLLData stores fields for serialize and deserialize an hyperloglog query result when client sets Content-Accept
@@ -605,7 +609,14 @@ func ParseHLLQueryResults(data []byte) (queryResults []AQLQueryResult, queryErro
 			break
 		}
 
-		if isErr != 0 {
+		if isErr == PercentileResultFlag {
+			var res AQLQueryResult
+			if res, err = parseTimeseriesPercentileResult(bs); err != nil {
+				return
+			}
+			queryResults = append(queryResults, res)
+			queryErrors = append(queryErrors, nil)
+		} else if isErr != 0 {
 			queryErrors = append(queryErrors, errors.New(string(bs)))
 			queryResults = append(queryResults, nil)
 		} else {
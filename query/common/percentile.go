@@ -0,0 +1,217 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"strings"
+	"unsafe"
+
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// readHLLDataHeader reads the header of a hll data and returns the header
+// size.
+func readHLLDataHeader(buffer []byte) (data HLLData, headerSize uint32, err error) {
+	reader := utils.NewStreamDataReader(bytes.NewBuffer(buffer))
+	numEnumColumns, err := reader.ReadUint8()
+	if err != nil {
+		return
+	}
+
+	if err = reader.Read([]byte(data.NumDimsPerDimWidth[:])); err != nil {
+		return
+	}
+
+	totalDims := 0
+	for _, dimCount := range data.NumDimsPerDimWidth {
+		totalDims += int(dimCount)
+	}
+
+	if err = reader.ReadPadding(int(reader.GetBytesRead()), 8); err != nil {
+		return
+	}
+
+	if data.ResultSize, err = reader.ReadUint32(); err != nil {
+		return
+	}
+
+	if data.PaddedRawDimValuesVectorLength, err = reader.ReadUint32(); err != nil {
+		return
+	}
+
+	data.DimIndexes = make([]int, totalDims)
+	for i := range data.DimIndexes {
+		var dimIndex uint8
+		if dimIndex, err = reader.ReadUint8(); err != nil {
+			return
+		}
+		data.DimIndexes[i] = int(dimIndex)
+	}
+
+	if err = reader.ReadPadding(int(totalDims), 8); err != nil {
+		return
+	}
+
+	data.DataTypes = make([]memCom.DataType, totalDims)
+	for i := range data.DataTypes {
+		var rawDataType uint32
+		if rawDataType, err = reader.ReadUint32(); err != nil {
+			return
+		}
+
+		if data.DataTypes[i], err = memCom.NewDataType(rawDataType); err != nil {
+			return
+		}
+	}
+
+	if err = reader.ReadPadding(int(totalDims)*4, 8); err != nil {
+		return
+	}
+
+	data.EnumDicts = make(map[int][]string)
+	var i uint8
+	for ; i < numEnumColumns; i++ {
+		var enumCasesBytes uint32
+		if enumCasesBytes, err = reader.ReadUint32(); err != nil {
+			return
+		}
+
+		var columnID uint16
+		if columnID, err = reader.ReadUint16(); err != nil {
+			return
+		}
+		reader.SkipBytes(2)
+		rawEnumCases := make([]byte, enumCasesBytes)
+		if err = reader.Read(rawEnumCases); err != nil {
+			return
+		}
+
+		enumCases := strings.Split(string(rawEnumCases), EnumDelimiter)
+
+		// remove last empty element.
+		enumCases = enumCases[:len(enumCases)-1]
+		data.EnumDicts[int(columnID)] = enumCases
+	}
+
+	headerSize = reader.GetBytesRead()
+	return
+}
+
+// getDimensionOffsets returns the value and null offsets of each dimension in
+// the raw dim values vector of a hll data.
+func getDimensionOffsets(data HLLData) [][2]int {
+	dimOffsets := make([][2]int, len(data.DimIndexes))
+	for i, dimIndex := range data.DimIndexes {
+		valueOffset, nullOffset := GetDimensionStartOffsets(data.NumDimsPerDimWidth, dimIndex, int(data.ResultSize))
+		dimOffsets[i] = [2]int{valueOffset, nullOffset}
+	}
+	return dimOffsets
+}
+
+// readDimensionValues reads dimension values of a row from the raw dim values
+// vector of a hll data into dimValues.
+func readDimensionValues(data HLLData, dimOffsets [][2]int, dimValuesVector unsafe.Pointer, row int, dimValues []*string) {
+	for dimIndex, offsets := range dimOffsets {
+		valuePtr, nullPtr := memAccess(dimValuesVector, offsets[0]), memAccess(dimValuesVector, offsets[1])
+		dimValues[dimIndex] = ReadDimension(valuePtr, nullPtr, row, data.DataTypes[dimIndex], data.EnumDicts[dimIndex], nil, nil)
+	}
+}
+
+// parseTimeseriesPercentileResult parses the percentile sketches serialized in
+// hll data format, where counts and hll vector are replaced by the bytes and
+// encoded t-digest sketches of each row.
+func parseTimeseriesPercentileResult(buffer []byte) (AQLQueryResult, error) {
+	// empty result buffer
+	if len(buffer) == 0 {
+		return AQLQueryResult{}, nil
+	}
+
+	data, headerSize, err := readHLLDataHeader(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(AQLQueryResult)
+
+	paddedSketchBytesLength := (4*data.ResultSize + 7) / 8 * 8
+	sketchBytesOffset := int(headerSize + data.PaddedRawDimValuesVectorLength)
+	sketchOffset := sketchBytesOffset + int(paddedSketchBytesLength)
+	if len(buffer) < sketchOffset {
+		return nil, utils.StackError(nil, "expect at least %d bytes for percentile result, but got %d",
+			sketchOffset, len(buffer))
+	}
+
+	dimValuesVector := unsafe.Pointer(&buffer[headerSize])
+	reader := utils.NewBufferReader(buffer)
+	dimOffsets := getDimensionOffsets(data)
+	dimValues := make([]*string, len(data.DimIndexes))
+
+	for i := 0; i < int(data.ResultSize); i++ {
+		sketchBytes, err := reader.ReadUint32(sketchBytesOffset + 4*i)
+		if err != nil {
+			return nil, err
+		}
+
+		// rows without any values have no sketch.
+		if sketchBytes == 0 {
+			continue
+		}
+
+		if len(buffer) < sketchOffset+int(sketchBytes) {
+			return nil, utils.StackError(nil, "expect %d bytes for t-digest at offset %d, but got %d",
+				sketchBytes, sketchOffset, len(buffer)-sketchOffset)
+		}
+
+		digest := &TDigest{}
+		if _, err = digest.Decode(buffer[sketchOffset : sketchOffset+int(sketchBytes)]); err != nil {
+			return nil, err
+		}
+		sketchOffset += int(sketchBytes)
+
+		readDimensionValues(data, dimOffsets, dimValuesVector, i, dimValues)
+		result.SetTDigest(dimValues, digest)
+	}
+
+	return result, nil
+}
+
+// ComputePercentileResult replaces t-digest sketches in the result with the
+// estimated percentile.
+func ComputePercentileResult(result AQLQueryResult, percentile float64) AQLQueryResult {
+	return computePercentileResultRecursive(result, percentile).(AQLQueryResult)
+}
+
+// computePercentileResultRecursive computes percentile value
+func computePercentileResultRecursive(result interface{}, percentile float64) interface{} {
+	switch r := result.(type) {
+	case AQLQueryResult:
+		for k, v := range r {
+			r[k] = computePercentileResultRecursive(v, percentile)
+		}
+		return r
+	case map[string]interface{}:
+		for k, v := range r {
+			r[k] = computePercentileResultRecursive(v, percentile)
+		}
+		return r
+	case *TDigest:
+		return r.Quantile(percentile)
+	default:
+		// return original for all other types
+		return r
+	}
+}
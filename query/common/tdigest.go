@@ -0,0 +1,249 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"github.com/uber/aresdb/utils"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultTDigestCompression is the default compression of t-digest. Larger
+	// compression means more centroids and better accuracy.
+	DefaultTDigestCompression = 100
+	// tDigestHeaderBytes is the number of bytes of the serialized t-digest header.
+	tDigestHeaderBytes = 32
+	// tDigestCentroidBytes is the number of bytes of a serialized centroid.
+	tDigestCentroidBytes = 16
+)
+
+// Centroid is a cluster of values in t-digest, represented by their mean and
+// total weight.
+type Centroid struct {
+	Mean   float64
+	Weight float64
+}
+
+// TDigest is a mergeable sketch for estimating quantiles of a distribution,
+// see https://github.com/tdunning/t-digest. Values are buffered and merged into
+// centroids sorted by mean, where centroids near the tails are kept small to
+// give accurate estimations for extreme quantiles like p99.
+type TDigest struct {
+	Compression float64
+	Centroids   []Centroid
+	TotalWeight float64
+	Min         float64
+	Max         float64
+
+	unmerged       []Centroid
+	unmergedWeight float64
+}
+
+// NewTDigest creates a new empty TDigest with the compression.
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{
+		Compression: compression,
+		Min:         math.Inf(1),
+		Max:         math.Inf(-1),
+	}
+}
+
+// Add adds a value with the weight into the digest.
+func (t *TDigest) Add(value, weight float64) {
+	if weight <= 0 || math.IsNaN(value) {
+		return
+	}
+
+	t.unmerged = append(t.unmerged, Centroid{Mean: value, Weight: weight})
+	t.unmergedWeight += weight
+	t.Min = math.Min(t.Min, value)
+	t.Max = math.Max(t.Max, value)
+	if len(t.unmerged) >= int(5*t.Compression) {
+		t.compress()
+	}
+}
+
+// Merge merges the other digest into this one.
+func (t *TDigest) Merge(other *TDigest) {
+	for _, centroids := range [][]Centroid{other.Centroids, other.unmerged} {
+		for _, centroid := range centroids {
+			t.Add(centroid.Mean, centroid.Weight)
+		}
+	}
+	t.Min = math.Min(t.Min, other.Min)
+	t.Max = math.Max(t.Max, other.Max)
+}
+
+// Count returns the total weight of values added.
+func (t *TDigest) Count() float64 {
+	return t.TotalWeight + t.unmergedWeight
+}
+
+// Quantile returns the estimated value at quantile q, which should be within
+// [0, 1]. NaN is returned for empty digest.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.compress()
+	if len(t.Centroids) == 0 {
+		return math.NaN()
+	}
+
+	if q <= 0 {
+		return t.Min
+	}
+
+	if q >= 1 {
+		return t.Max
+	}
+
+	// Values are interpolated between centers of centroids, while the first
+	// half of the first centroid and the last half of the last centroid are
+	// interpolated with min and max.
+	index := q * t.TotalWeight
+	first := t.Centroids[0]
+	if index < first.Weight/2 {
+		return t.Min + (index/(first.Weight/2))*(first.Mean-t.Min)
+	}
+
+	weightSoFar := first.Weight / 2
+	for i := 0; i < len(t.Centroids)-1; i++ {
+		left, right := t.Centroids[i], t.Centroids[i+1]
+		deltaWeight := (left.Weight + right.Weight) / 2
+		if weightSoFar+deltaWeight > index {
+			return left.Mean + (index-weightSoFar)/deltaWeight*(right.Mean-left.Mean)
+		}
+		weightSoFar += deltaWeight
+	}
+
+	last := t.Centroids[len(t.Centroids)-1]
+	return last.Mean + (index-weightSoFar)/(last.Weight/2)*(t.Max-last.Mean)
+}
+
+// compress merges the buffered values into centroids. Adjacent centroids are
+// merged as long as the merged one spans no more than one unit of the scale
+// function k(q) = compression / (2 * pi) * asin(2q - 1).
+func (t *TDigest) compress() {
+	if len(t.unmerged) == 0 {
+		return
+	}
+
+	centroids := append(t.unmerged, t.Centroids...)
+	sort.Slice(centroids, func(i, j int) bool {
+		return centroids[i].Mean < centroids[j].Mean
+	})
+
+	totalWeight := t.TotalWeight + t.unmergedWeight
+	merged := make([]Centroid, 0, len(t.Centroids)+1)
+	current := centroids[0]
+	var weightSoFar float64
+	qLimit := t.kInverse(t.k(0) + 1)
+	for _, centroid := range centroids[1:] {
+		if (weightSoFar+current.Weight+centroid.Weight)/totalWeight <= qLimit {
+			current.Weight += centroid.Weight
+			current.Mean += (centroid.Mean - current.Mean) * centroid.Weight / current.Weight
+		} else {
+			weightSoFar += current.Weight
+			merged = append(merged, current)
+			current = centroid
+			qLimit = t.kInverse(t.k(weightSoFar/totalWeight) + 1)
+		}
+	}
+	merged = append(merged, current)
+
+	t.Centroids = merged
+	t.TotalWeight = totalWeight
+	t.unmerged = nil
+	t.unmergedWeight = 0
+}
+
+func (t *TDigest) k(q float64) float64 {
+	return t.Compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (t *TDigest) kInverse(k float64) float64 {
+	if k >= t.Compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/t.Compression) + 1) / 2
+}
+
+// EncodedBytes returns the number of bytes of the encoded digest.
+func (t *TDigest) EncodedBytes() int {
+	t.compress()
+	return tDigestHeaderBytes + tDigestCentroidBytes*len(t.Centroids)
+}
+
+// Encode encodes the digest into bytes in following format:
+//	 [float64] compression [float64] min [float64] max
+//	 [uint32] number of centroids [4 bytes padding]
+//	 [float64] mean_0 [float64] weight_0 ... [float64] mean_n [float64] weight_n
+func (t *TDigest) Encode() []byte {
+	buffer := make([]byte, t.EncodedBytes())
+	writer := utils.NewBufferWriter(buffer)
+	// errors are not possible as the buffer is preallocated.
+	writer.AppendUint64(math.Float64bits(t.Compression))
+	writer.AppendUint64(math.Float64bits(t.Min))
+	writer.AppendUint64(math.Float64bits(t.Max))
+	writer.AppendUint32(uint32(len(t.Centroids)))
+	writer.SkipBytes(4)
+	for _, centroid := range t.Centroids {
+		writer.AppendUint64(math.Float64bits(centroid.Mean))
+		writer.AppendUint64(math.Float64bits(centroid.Weight))
+	}
+	return buffer
+}
+
+// Decode decodes the digest from bytes encoded by Encode and returns the
+// number of bytes read.
+func (t *TDigest) Decode(data []byte) (int, error) {
+	reader := utils.NewBufferReader(data)
+	var values [3]uint64
+	for i := range values {
+		value, err := reader.ReadUint64(i * 8)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = value
+	}
+
+	numCentroids, err := reader.ReadUint32(24)
+	if err != nil {
+		return 0, err
+	}
+
+	size := tDigestHeaderBytes + tDigestCentroidBytes*int(numCentroids)
+	if len(data) < size {
+		return 0, utils.StackError(nil, "expect %d bytes for t-digest, but got %d", size, len(data))
+	}
+
+	t.Compression = math.Float64frombits(values[0])
+	t.Min = math.Float64frombits(values[1])
+	t.Max = math.Float64frombits(values[2])
+	t.Centroids = make([]Centroid, numCentroids)
+	t.TotalWeight = 0
+	t.unmerged = nil
+	t.unmergedWeight = 0
+	for i := range t.Centroids {
+		offset := tDigestHeaderBytes + i*tDigestCentroidBytes
+		mean, _ := reader.ReadUint64(offset)
+		weight, _ := reader.ReadUint64(offset + 8)
+		t.Centroids[i] = Centroid{
+			Mean:   math.Float64frombits(mean),
+			Weight: math.Float64frombits(weight),
+		}
+		t.TotalWeight += t.Centroids[i].Weight
+	}
+	return size, nil
+}
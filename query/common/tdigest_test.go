@@ -0,0 +1,98 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"math"
+)
+
+var _ = ginkgo.Describe("tdigest", func() {
+	ginkgo.It("Quantile should work", func() {
+		digest := NewTDigest(DefaultTDigestCompression)
+		Ω(math.IsNaN(digest.Quantile(0.5))).Should(BeTrue())
+
+		for i := 1; i <= 10000; i++ {
+			digest.Add(float64(i), 1)
+		}
+		// invalid weights are ignored.
+		digest.Add(1e9, 0)
+
+		Ω(digest.Count()).Should(BeEquivalentTo(10000))
+		Ω(digest.Quantile(0)).Should(BeEquivalentTo(1))
+		Ω(digest.Quantile(1)).Should(BeEquivalentTo(10000))
+		Ω(digest.Quantile(0.5)).Should(BeNumerically("~", 5000, 50))
+		Ω(digest.Quantile(0.95)).Should(BeNumerically("~", 9500, 20))
+		Ω(digest.Quantile(0.99)).Should(BeNumerically("~", 9900, 5))
+		Ω(len(digest.Centroids)).Should(BeNumerically("<", 200))
+	})
+
+	ginkgo.It("Add with weights should work", func() {
+		digest := NewTDigest(DefaultTDigestCompression)
+		digest.Add(1, 3)
+		digest.Add(2, 1)
+		Ω(digest.Count()).Should(BeEquivalentTo(4))
+		Ω(digest.Quantile(0.25)).Should(BeNumerically("<", 1.5))
+	})
+
+	ginkgo.It("Merge should work", func() {
+		digest1 := NewTDigest(DefaultTDigestCompression)
+		digest2 := NewTDigest(DefaultTDigestCompression)
+		for i := 1; i <= 10000; i++ {
+			if i%2 == 0 {
+				digest1.Add(float64(i), 1)
+			} else {
+				digest2.Add(float64(i), 1)
+			}
+		}
+
+		digest1.Merge(digest2)
+		Ω(digest1.Count()).Should(BeEquivalentTo(10000))
+		Ω(digest1.Min).Should(BeEquivalentTo(1))
+		Ω(digest1.Max).Should(BeEquivalentTo(10000))
+		Ω(digest1.Quantile(0.5)).Should(BeNumerically("~", 5000, 50))
+
+		digest1.Merge(NewTDigest(DefaultTDigestCompression))
+		Ω(digest1.Count()).Should(BeEquivalentTo(10000))
+		Ω(digest1.Min).Should(BeEquivalentTo(1))
+	})
+
+	ginkgo.It("Encode and Decode should work", func() {
+		digest := NewTDigest(DefaultTDigestCompression)
+		for i := 0; i < 1000; i++ {
+			digest.Add(float64(i), 2)
+		}
+
+		data := digest.Encode()
+		Ω(data).Should(HaveLen(digest.EncodedBytes()))
+
+		decoded := &TDigest{}
+		size, err := decoded.Decode(data)
+		Ω(err).Should(BeNil())
+		Ω(size).Should(Equal(len(data)))
+		Ω(decoded.Compression).Should(BeEquivalentTo(DefaultTDigestCompression))
+		Ω(decoded.Min).Should(BeEquivalentTo(0))
+		Ω(decoded.Max).Should(BeEquivalentTo(999))
+		Ω(decoded.Count()).Should(BeEquivalentTo(2000))
+		Ω(decoded.Centroids).Should(Equal(digest.Centroids))
+		Ω(decoded.Quantile(0.9)).Should(Equal(digest.Quantile(0.9)))
+
+		_, err = decoded.Decode(data[:len(data)-1])
+		Ω(err).ShouldNot(BeNil())
+		_, err = decoded.Decode(data[:10])
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -56,6 +56,9 @@ type TimeDimensionMeta struct {
 	DSTSwitchTs     int64
 	FromOffset      int
 	ToOffset        int
+	// TimeShift is added to values before formatting to align them with
+	// values of another query over a shifted time range.
+	TimeShift int64
 }
 
 // TimeSeriesBucketizer is the helper struct to express parsed time bucketizer, see comment below
@@ -0,0 +1,205 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clone returns a copy of the query before compilation, slices are copied as
+// they are modified in place during compilation.
+func (q *AQLQuery) clone() *AQLQuery {
+	clone := *q
+	clone.Joins = append([]Join(nil), q.Joins...)
+	clone.Dimensions = append([]Dimension(nil), q.Dimensions...)
+	clone.Measures = append([]Measure(nil), q.Measures...)
+	clone.Filters = append([]string(nil), q.Filters...)
+	clone.HavingFilters = append([]string(nil), q.HavingFilters...)
+	clone.SupportingDimensions = append([]Dimension(nil), q.SupportingDimensions...)
+	clone.SupportingMeasures = append([]Measure(nil), q.SupportingMeasures...)
+	clone.Sorts = append([]SortField(nil), q.Sorts...)
+	return &clone
+}
+
+// parseCompareTo parses the compareTo expression in the format of "-1 week",
+// "-2 days" or "-1w" into number of days to shift.
+func parseCompareTo(expression string) (int, error) {
+	segments := strings.Fields(expression)
+	var amountStr, unit string
+	switch len(segments) {
+	case 1:
+		if len(expression) < 2 {
+			return 0, utils.StackError(nil, "Unknown compareTo expression: %s", expression)
+		}
+		amountStr, unit = expression[:len(expression)-1], expression[len(expression)-1:]
+	case 2:
+		amountStr, unit = segments[0], timeUnitMap[strings.TrimSuffix(segments[1], "s")]
+	default:
+		return 0, utils.StackError(nil, "Unknown compareTo expression: %s", expression)
+	}
+
+	amount, err := strconv.Atoi(amountStr)
+	if err != nil {
+		return 0, utils.StackError(err, "failed to parse %s as a number", amountStr)
+	}
+	if amount == 0 {
+		return 0, utils.StackError(nil, "compareTo should not be zero: %s", expression)
+	}
+
+	switch unit {
+	case "d":
+		return amount, nil
+	case "w":
+		return 7 * amount, nil
+	}
+	return 0, utils.StackError(nil, "compareTo only supports day and week, got: %s", expression)
+}
+
+// shiftDays shifts t by the number of calendar days in its location, keeping
+// the time elapsed since the start of the day.
+func shiftDays(t time.Time, days int) time.Time {
+	// day is always a known unit of applyTimeOffset.
+	dayStart, _, _ := applyTimeOffset(t, 0, "d")
+	shiftedDayStart, _, _ := applyTimeOffset(t, days, "d")
+	return shiftedDayStart.Add(t.Sub(dayStart))
+}
+
+// processCompareTo validates CompareTo of the query and generates the compare
+// query over the shifted time filter from the copy of the query before
+// compilation. Time is shifted by calendar days in the query timezone, so time
+// dimension values of the compare query can be aligned by adding the same
+// number of seconds.
+func (qc *AQLQueryContext) processCompareTo(compareQuery *AQLQuery) {
+	if compareQuery == nil {
+		return
+	}
+
+	if qc.ReturnHLLData {
+		qc.Error = utils.StackError(nil, "compareTo is not supported for hll query")
+		return
+	}
+
+	if qc.isNonAggregationQuery {
+		qc.Error = utils.StackError(nil, "compareTo is not supported for non aggregation query")
+		return
+	}
+
+	if qc.fromTime == nil || qc.toTime == nil {
+		qc.Error = utils.StackError(nil, "compareTo requires time filter")
+		return
+	}
+
+	if qc.timezoneTable.tableColumn != "" {
+		qc.Error = utils.StackError(nil, "compareTo is not supported for timezone column")
+		return
+	}
+
+	days, err := parseCompareTo(qc.Query.CompareTo)
+	if err != nil {
+		qc.Error = err
+		return
+	}
+
+	for _, dim := range qc.Query.Dimensions {
+		// month, quarter and year have various number of days.
+		if _, ok := irregularBucketizer2Functor[dim.TimeBucketizer]; ok && !(dim.TimeBucketizer == "week" && days%7 == 0) {
+			qc.Error = utils.StackError(nil, "compareTo %s is not supported for time bucketizer %s",
+				qc.Query.CompareTo, dim.TimeBucketizer)
+			return
+		}
+	}
+
+	compareQuery.TimeFilter.From = strconv.FormatInt(shiftDays(qc.fromTime.Time, days).Unix(), 10)
+	compareQuery.TimeFilter.To = strconv.FormatInt(shiftDays(qc.toTime.Time, days).Unix(), 10)
+	// all groups of the compare query are returned to match with results of
+	// this query.
+	compareQuery.CompareTo = ""
+	compareQuery.HavingFilters = nil
+	compareQuery.Sorts = nil
+	compareQuery.Limit = 0
+	for i := range compareQuery.Dimensions {
+		compareQuery.Dimensions[i].FillGaps = ""
+	}
+
+	qc.CompareQuery = compareQuery
+	qc.compareTimeShift = -int64(days) * queryCom.SecondsPerDay
+}
+
+// compareRows sets previous values of rows to measure values of the groups
+// with same dimension values from results of the compare query. Previous values
+// are nulls if the group does not exist in results of the compare query.
+func (qc *AQLQueryContext) compareRows(rows []aggregatedRow, timeDimensionMetas []*queryCom.TimeDimensionMeta) {
+	// values of time dimensions of the compare query are formatted with the
+	// same meta as this query after shifted.
+	compareMetas := make([]*queryCom.TimeDimensionMeta, len(timeDimensionMetas))
+	for dimIndex, meta := range timeDimensionMetas {
+		if meta == nil {
+			continue
+		}
+		compareMeta := *meta
+		if !isRecurringTimeBucketizer(meta.TimeBucketizer) {
+			compareMeta.TimeShift = qc.compareTimeShift
+		}
+		compareMetas[dimIndex] = &compareMeta
+	}
+
+	previousRows := qc.CompareContext.readResults(nil, compareMetas, nil, true)
+	if qc.CompareContext.hasMeasureWindows() {
+		qc.CompareContext.applyMeasureWindows(previousRows)
+	}
+
+	previousValues := make(map[string][]*float64)
+	for _, row := range previousRows {
+		previousValues[getDimValuesKey(row.dimValues, -1)] = row.measureValues
+	}
+
+	for i, row := range rows {
+		values := previousValues[getDimValuesKey(row.dimValues, -1)]
+		if values == nil {
+			values = make([]*float64, len(row.measureValues))
+		}
+		rows[i].previousValues = values
+	}
+}
+
+// getComparedValues returns the current value, previous value and delta of
+// each measure.
+func getComparedValues(currentValues, previousValues []*float64) []*float64 {
+	values := make([]*float64, 0, 3*len(currentValues))
+	for i, current := range currentValues {
+		previous := previousValues[i]
+		var delta *float64
+		if current != nil && previous != nil {
+			value := *current - *previous
+			delta = &value
+		}
+		values = append(values, current, previous, delta)
+	}
+	return values
+}
+
+// getComparedMeasureNames returns the names of current value, previous value
+// and delta of each measure in the same order as getComparedValues.
+func getComparedMeasureNames(measureNames []string) []string {
+	names := make([]string, 0, 3*len(measureNames))
+	for _, name := range measureNames {
+		names = append(names, name, name+"."+queryCom.PreviousKey, name+"."+queryCom.DeltaKey)
+	}
+	return names
+}
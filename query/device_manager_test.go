@@ -230,7 +230,6 @@ var _ = ginkgo.Describe("device_manager", func() {
 			OOPK: OOPKContext{
 				NumDimsPerDimWidth: common.DimCountsPerDimWidth{0, 0, 1, 0, 0},
 				DimRowBytes:        5,
-				MeasureBytes:       4,
 				foreignTables:      []*foreignTable{{}},
 				MainTableCommonFilters: []expr.Expr{
 					&expr.BinaryExpr{
@@ -280,17 +279,22 @@ var _ = ginkgo.Describe("device_manager", func() {
 						ExprType: expr.Signed,
 					},
 				},
-				Measure: &expr.BinaryExpr{
-					Op: expr.DIV,
-					LHS: &expr.VarRef{
-						Val:      "vp2",
-						ColumnID: 2,
-					},
-					RHS: &expr.NumberLiteral{
-						Val:      2,
-						Int:      2,
-						Expr:     "2",
-						ExprType: expr.Signed,
+				Measures: []OOPKMeasure{
+					{
+						Measure: &expr.BinaryExpr{
+							Op: expr.DIV,
+							LHS: &expr.VarRef{
+								Val:      "vp2",
+								ColumnID: 2,
+							},
+							RHS: &expr.NumberLiteral{
+								Val:      2,
+								Int:      2,
+								Expr:     "2",
+								ExprType: expr.Signed,
+							},
+						},
+						MeasureBytes: 4,
 					},
 				},
 			},
@@ -0,0 +1,144 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/uber/aresdb/memstore"
+	"github.com/uber/aresdb/query/expr"
+	"time"
+)
+
+const (
+	// joinStrategyEqual joins foreign table by primary key lookup.
+	joinStrategyEqual = "equal"
+	// joinStrategyGeo joins shape table by point in shape intersection.
+	joinStrategyGeo = "geo"
+)
+
+// QueryPlan describes how a compiled query will be executed without executing
+// it.
+type QueryPlan struct {
+	// The query input.
+	Query *AQLQuery `json:"query"`
+
+	// Compiled and annotated ASTs.
+	Filters    []string `json:"filters,omitempty"`
+	Dimensions []string `json:"dimensions,omitempty"`
+	Measures   []string `json:"measures,omitempty"`
+
+	// Row filters matched as prefilters on archiving sort columns.
+	Prefilters []string `json:"prefilters,omitempty"`
+
+	TimeFilter *QueryPlanTimeFilter `json:"timeFilter,omitempty"`
+	Joins      []QueryPlanJoin      `json:"joins,omitempty"`
+
+	// Columns used by the query of each table by table alias.
+	Columns map[string][]string `json:"columns,omitempty"`
+
+	// Estimated device memory required to process the query.
+	DeviceMemoryRequirement int `json:"deviceMemoryRequirement"`
+
+	Error error `json:"error,omitempty"`
+}
+
+// QueryPlanTimeFilter is the resolved time range of the time filter and the
+// archive batches to scan.
+type QueryPlanTimeFilter struct {
+	Column              string     `json:"column"`
+	From                *time.Time `json:"from,omitempty"`
+	To                  *time.Time `json:"to,omitempty"`
+	ArchiveBatchIDStart int        `json:"archiveBatchIDStart"`
+	ArchiveBatchIDEnd   int        `json:"archiveBatchIDEnd"`
+}
+
+// QueryPlanJoin is a join of the query and how it's executed.
+type QueryPlanJoin struct {
+	Table    string `json:"table"`
+	Alias    string `json:"alias"`
+	Strategy string `json:"strategy"`
+}
+
+// Explain returns the plan of the compiled query, the device memory
+// requirement is estimated from the data in memStore.
+func (qc *AQLQueryContext) Explain(memStore memstore.MemStore) *QueryPlan {
+	plan := &QueryPlan{Query: qc.Query}
+	if qc.Error != nil {
+		plan.Error = qc.Error
+		return plan
+	}
+
+	plan.Filters = exprsToStrings(qc.OOPK.MainTableCommonFilters, qc.OOPK.ForeignTableCommonFilters)
+	plan.Dimensions = exprsToStrings(qc.OOPK.Dimensions)
+	for _, measure := range qc.OOPK.Measures {
+		if measure.Measure != nil {
+			plan.Measures = append(plan.Measures, measure.Measure.String())
+		}
+	}
+	for _, index := range qc.Prefilters {
+		plan.Prefilters = append(plan.Prefilters, qc.Query.Filters[index])
+	}
+
+	scanner := qc.TableScanners[0]
+	if qc.Query.TimeFilter.Column != "" {
+		plan.TimeFilter = &QueryPlanTimeFilter{
+			Column:              qc.Query.TimeFilter.Column,
+			ArchiveBatchIDStart: scanner.ArchiveBatchIDStart,
+			ArchiveBatchIDEnd:   scanner.ArchiveBatchIDEnd,
+		}
+		if qc.fromTime != nil {
+			plan.TimeFilter.From = &qc.fromTime.Time
+		}
+		if qc.toTime != nil {
+			plan.TimeFilter.To = &qc.toTime.Time
+		}
+	}
+
+	for joinIndex, join := range qc.Query.Joins {
+		strategy := joinStrategyEqual
+		if qc.OOPK.geoIntersection != nil && qc.OOPK.geoIntersection.shapeTableID == joinIndex+1 {
+			strategy = joinStrategyGeo
+		}
+		plan.Joins = append(plan.Joins, QueryPlanJoin{
+			Table:    join.Table,
+			Alias:    join.Alias,
+			Strategy: strategy,
+		})
+	}
+
+	plan.Columns = make(map[string][]string, len(qc.TableIDByAlias))
+	for alias, tableID := range qc.TableIDByAlias {
+		scanner := qc.TableScanners[tableID]
+		columns := make([]string, len(scanner.Columns))
+		for i, columnID := range scanner.Columns {
+			columns[i] = scanner.Schema.Schema.Columns[columnID].Name
+		}
+		plan.Columns[alias] = columns
+	}
+
+	plan.DeviceMemoryRequirement = qc.calculateMemoryRequirement(memStore)
+	plan.Error = qc.Error
+	return plan
+}
+
+// exprsToStrings returns the string representations of the expressions.
+func exprsToStrings(exprLists ...[]expr.Expr) []string {
+	var strs []string
+	for _, exprs := range exprLists {
+		for _, e := range exprs {
+			strs = append(strs, e.String())
+		}
+	}
+	return strs
+}
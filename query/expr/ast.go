@@ -229,6 +229,11 @@ type Case struct {
 	WhenThens []WhenThen
 	Else      Expr
 	ExprType  Type
+
+	// Enum dictionary for string results of thens and else, which are
+	// translated into enum cases at query compilation time.
+	EnumDict        map[string]int `json:"-"`
+	EnumReverseDict []string       `json:"-"`
 }
 
 // Type returns the type.
@@ -323,8 +323,10 @@ func (p *Parser) ParseExpr(binOpPrcdncLb int) (Expr, error) {
 			op, pos, lit = p.scanIgnoreWhitespace()
 			if op == IN {
 				op = NOT_IN
+			} else if op == LIKE {
+				op = NOT_LIKE
 			} else {
-				return nil, newParseError(tokstr(op, lit), []string{"IN"}, pos)
+				return nil, newParseError(tokstr(op, lit), []string{"IN", "LIKE"}, pos)
 			}
 		}
 		if !op.isBinaryOperator() || op.Precedence() < binOpPrcdncLb {
@@ -416,6 +418,12 @@ func (p *Parser) parseUnaryExpr(inclusion bool) (Expr, error) {
 	switch tok {
 	case CASE:
 		return p.parseCase()
+	case IF:
+		// if(cond, a, b) is parsed as a function call.
+		if tok0, pos0, lit0 := p.scanIgnoreWhitespace(); tok0 != LPAREN {
+			return nil, newParseError(tokstr(tok0, lit0), []string{"("}, pos0)
+		}
+		return p.parseCall("if")
 	case IDENT:
 		// If the next immediate token is a left parentheses, parse as function call.
 		// Otherwise parse as a variable reference.
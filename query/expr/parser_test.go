@@ -167,6 +167,26 @@ func TestParser_ParseExpr(t *testing.T) {
 				}},
 			},
 		},
+		{
+			s: "city LIKE 'San %'",
+			expr: &expr.BinaryExpr{
+				Op:  expr.LIKE,
+				LHS: &expr.VarRef{Val: "city"},
+				RHS: &expr.StringLiteral{Val: "San %"},
+			},
+		},
+		{
+			s: "city NOT LIKE 'San %'",
+			expr: &expr.BinaryExpr{
+				Op:  expr.NOT_LIKE,
+				LHS: &expr.VarRef{Val: "city"},
+				RHS: &expr.StringLiteral{Val: "San %"},
+			},
+		},
+		{
+			s:   "city NOT = 'San %'",
+			err: "found =, expected IN, LIKE at line 1, char 10",
+		},
 		// Unary expression.
 		{
 			s: "not now",
@@ -340,6 +360,27 @@ func TestParser_ParseExpr(t *testing.T) {
 			err: "found EOF, expected END at line 1, char 20",
 		},
 
+		// If
+		{
+			s: "if(a > 1, b, c)",
+			expr: &expr.Call{
+				Name: "if",
+				Args: []expr.Expr{
+					&expr.BinaryExpr{
+						Op:  expr.GT,
+						LHS: &expr.VarRef{Val: "a"},
+						RHS: &expr.NumberLiteral{Val: 1, Int: 1, Expr: "1", ExprType: expr.Unsigned},
+					},
+					&expr.VarRef{Val: "b"},
+					&expr.VarRef{Val: "c"},
+				},
+			},
+		},
+		{
+			s:   "if a then b",
+			err: "found a, expected ( at line 1, char 4",
+		},
+
 		// Function call (empty)
 		{
 			s: `my_func()`,
@@ -66,6 +66,12 @@ const (
 	GET_QUARTER_OF_YEAR
 	// hll operator
 	GET_HLL_VALUE
+	// math operators
+	ABS
+	CEIL
+	SQRT
+	LN
+	LOG10
 	unary_operator_end
 
 	derived_unary_operator_beg
@@ -83,6 +89,10 @@ const (
 	MOD        // %
 	FLOOR      // floor
 	CONVERT_TZ // convert_tz
+	POW        // pow
+	ROUND      // round
+	GREATEST   // greatest
+	LEAST      // least
 
 	BITWISE_AND         // &
 	BITWISE_OR          // |
@@ -104,8 +114,17 @@ const (
 	GT  // >
 	GTE // >=
 
+	// Pattern matching on enum columns
+	LIKE     // LIKE
+	NOT_LIKE // NOT LIKE
+
 	// Geo intersects
 	GEOGRAPHY_INTERSECTS
+
+	// Conditional operators generated from CASE WHEN and if().
+	IF_TRUE     // if_true
+	IF_NOT_TRUE // if_not_true
+	COALESCE    // coalesce
 	binary_operator_end
 	operator_end
 
@@ -186,6 +205,11 @@ var tokens = map[Token]string{
 	GET_MONTH_OF_YEAR:   "GET_MONTH_OF_YEAR",
 	GET_QUARTER_OF_YEAR: "GET_QUARTER_OF_YEAR",
 	GET_HLL_VALUE:       "GET_HLL_VALUE",
+	ABS:                 "ABS",
+	CEIL:                "CEIL",
+	SQRT:                "SQRT",
+	LN:                  "LN",
+	LOG10:               "LOG10",
 
 	ADD:        "+",
 	SUB:        "-",
@@ -194,6 +218,10 @@ var tokens = map[Token]string{
 	MOD:        "%",
 	FLOOR:      "FLOOR",
 	CONVERT_TZ: "CONVERT_TZ",
+	POW:        "POW",
+	ROUND:      "ROUND",
+	GREATEST:   "GREATEST",
+	LEAST:      "LEAST",
 
 	BITWISE_AND:         "&",
 	BITWISE_OR:          "|",
@@ -215,8 +243,15 @@ var tokens = map[Token]string{
 	GT:     ">",
 	GTE:    ">=",
 
+	LIKE:     "LIKE",
+	NOT_LIKE: "NOT LIKE",
+
 	GEOGRAPHY_INTERSECTS: "GEOGRAPHY_INTERSECTS",
 
+	IF_TRUE:     "IF_TRUE",
+	IF_NOT_TRUE: "IF_NOT_TRUE",
+	COALESCE:    "COALESCE",
+
 	LPAREN: "(",
 	RPAREN: ")",
 	COMMA:  ",",
@@ -266,7 +301,7 @@ func init() {
 	for tok := keyword_beg + 1; tok < keyword_end; tok++ {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
-	for _, tok := range []Token{AND, OR, IN, IS, NOT} {
+	for _, tok := range []Token{AND, OR, IN, IS, NOT, LIKE} {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
 	keywords["null"] = NULL
@@ -299,7 +334,7 @@ func (tok Token) Precedence() int {
 		return 2
 	case NOT:
 		return 3
-	case IN, NOT_IN, IS, EQ, NEQ, LT, LTE, GT, GTE:
+	case IN, NOT_IN, LIKE, NOT_LIKE, IS, EQ, NEQ, LT, LTE, GT, GTE:
 		return 4
 	case BITWISE_OR:
 		return 5
@@ -0,0 +1,263 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"encoding/json"
+	memCom "github.com/uber/aresdb/memstore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"sort"
+	"strconv"
+)
+
+// FlatResult is the result of a query as a table. Each row consists of the
+// values of dimensions followed by the values of measures, in the order of
+// headers. NULL values are nil. Dimension values are typed by the dimension:
+// numbers for numeric dimensions and time dimensions with time unit, booleans
+// for boolean dimensions, and strings for others, e.g. enums and time buckets.
+// Dimension values rolled up by grouping sets are "_total". ContinuationToken
+// is set for non-aggregation queries if there may be more rows.
+type FlatResult struct {
+	Headers           []string        `json:"headers"`
+	Rows              [][]interface{} `json:"rows"`
+	ContinuationToken string          `json:"continuationToken,omitempty"`
+}
+
+// FlatAQLResponse is the response of AQL queries with flat results.
+type FlatAQLResponse struct {
+	Results      []*FlatResult      `json:"results"`
+	Errors       []error            `json:"errors,omitempty"`
+	QueryContext []*AQLQueryContext `json:"context,omitempty"`
+	// Execution stats of each query, only returned when requested.
+	Stats []*QueryStats `json:"stats,omitempty"`
+}
+
+// flatValueType is the type of dimension values in flat results.
+type flatValueType int
+
+const (
+	flatString flatValueType = iota
+	flatInt
+	flatFloat
+	flatBool
+)
+
+// FlattenResults converts the postprocessed results of the query to a
+// FlatResult. Rows of the nested result format are ordered by dimension values,
+// rows of the headers and matrixData format keep their order.
+func (qc *AQLQueryContext) FlattenResults() *FlatResult {
+	flat := &FlatResult{Rows: [][]interface{}{}}
+	valueTypes := qc.getFlatValueTypes()
+
+	if headers, ok := qc.Results[queryCom.HeadersKey].([]string); ok {
+		flat.Headers = headers
+		flat.ContinuationToken, _ = qc.Results[queryCom.ContinuationTokenKey].(string)
+		matrixData, _ := qc.Results[queryCom.MatrixDataKey].([][]interface{})
+		for _, values := range matrixData {
+			row := make([]interface{}, len(values))
+			for i, value := range values {
+				str, isString := value.(string)
+				if isString && i < len(valueTypes) {
+					row[i] = valueTypes[i].parse(str)
+				} else {
+					row[i] = value
+				}
+			}
+			flat.Rows = append(flat.Rows, row)
+		}
+		return flat
+	}
+
+	measureNames := make([]string, len(qc.Query.Measures))
+	for i, measure := range qc.Query.Measures {
+		measureNames[i] = measure.name()
+	}
+	compared := qc.Query.CompareTo != ""
+	for _, dim := range qc.Query.Dimensions {
+		flat.Headers = append(flat.Headers, dim.name())
+	}
+	if compared {
+		flat.Headers = append(flat.Headers, getComparedMeasureNames(measureNames)...)
+	} else {
+		flat.Headers = append(flat.Headers, measureNames...)
+	}
+
+	numDims := len(qc.Query.Dimensions)
+	if numDims == 0 {
+		return flat
+	}
+
+	// dimension values of rows are kept for ordering rows.
+	var rowDimValues [][]*string
+	dimValues := make([]*string, numDims)
+	var walk func(node map[string]interface{}, depth int)
+	walk = func(node map[string]interface{}, depth int) {
+		for key, child := range node {
+			value := key
+			if key == queryCom.NULLString {
+				dimValues[depth] = nil
+			} else {
+				dimValues[depth] = &value
+			}
+
+			if depth < numDims-1 {
+				if childNode, ok := child.(map[string]interface{}); ok {
+					walk(childNode, depth+1)
+				}
+				continue
+			}
+
+			row := make([]interface{}, numDims, len(flat.Headers))
+			for dimIndex, dimValue := range dimValues {
+				if dimValue != nil {
+					row[dimIndex] = valueTypes[dimIndex].parse(*dimValue)
+				}
+			}
+			flat.Rows = append(flat.Rows, appendFlatMeasureValues(row, child, measureNames, compared))
+			rowDimValues = append(rowDimValues, append([]*string(nil), dimValues...))
+		}
+	}
+	walk(qc.Results, 0)
+
+	sort.Sort(flatRows{rows: flat.Rows, dimValues: rowDimValues})
+	return flat
+}
+
+// appendFlatMeasureValues appends the measure values of the leaf of the nested
+// result to the row.
+func appendFlatMeasureValues(row []interface{}, leaf interface{}, measureNames []string, compared bool) []interface{} {
+	appendComparedValues := func(value interface{}) {
+		m, _ := value.(map[string]interface{})
+		row = append(row, m[queryCom.CurrentKey], m[queryCom.PreviousKey], m[queryCom.DeltaKey])
+	}
+
+	if len(measureNames) == 1 {
+		if compared {
+			appendComparedValues(leaf)
+		} else {
+			row = append(row, leaf)
+		}
+		return row
+	}
+
+	measures, _ := leaf.(map[string]interface{})
+	for _, name := range measureNames {
+		if compared {
+			appendComparedValues(measures[name])
+		} else {
+			row = append(row, measures[name])
+		}
+	}
+	return row
+}
+
+// getFlatValueTypes returns the types of values of each dimension in flat
+// results.
+func (qc *AQLQueryContext) getFlatValueTypes() []flatValueType {
+	valueTypes := make([]flatValueType, len(qc.Query.Dimensions))
+	for dimIndex, dim := range qc.Query.Dimensions {
+		if dim.isTimeDimension() {
+			if dim.TimeUnit != "" {
+				valueTypes[dimIndex] = flatInt
+			}
+			continue
+		}
+
+		if dimIndex >= len(qc.OOPK.Dimensions) {
+			continue
+		}
+		dimExpr := qc.OOPK.Dimensions[dimIndex]
+		if qc.getEnumReverseDict(dimIndex, dimExpr) != nil {
+			continue
+		}
+		switch getDimensionDataType(dimExpr) {
+		case memCom.Bool:
+			valueTypes[dimIndex] = flatBool
+		case memCom.Int8, memCom.Int16, memCom.Int32, memCom.Int64,
+			memCom.Uint8, memCom.Uint16, memCom.Uint32, memCom.SmallEnum, memCom.BigEnum:
+			valueTypes[dimIndex] = flatInt
+		case memCom.Float32:
+			valueTypes[dimIndex] = flatFloat
+		}
+	}
+	return valueTypes
+}
+
+// parse returns the typed dimension value of the string in results. NULL is
+// nil, "_total" and values failed to parse are returned as is.
+func (t flatValueType) parse(value string) interface{} {
+	if value == queryCom.NULLString {
+		return nil
+	}
+
+	switch t {
+	case flatInt:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v
+		}
+	case flatFloat:
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v
+		}
+	case flatBool:
+		if v, err := strconv.ParseBool(value); err == nil {
+			return v
+		}
+	}
+	return value
+}
+
+// FormatFlatValue formats the value of flat results as a string, NULL is
+// formatted as an empty string.
+func FormatFlatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	bytes, _ := json.Marshal(value)
+	return string(bytes)
+}
+
+// flatRows sorts rows of flat results by their dimension values.
+type flatRows struct {
+	rows      [][]interface{}
+	dimValues [][]*string
+}
+
+func (r flatRows) Len() int {
+	return len(r.rows)
+}
+
+func (r flatRows) Less(i, j int) bool {
+	for dimIndex := range r.dimValues[i] {
+		if res := compareDimensionValues(r.dimValues[i][dimIndex], r.dimValues[j][dimIndex]); res != 0 {
+			return res < 0
+		}
+	}
+	return false
+}
+
+func (r flatRows) Swap(i, j int) {
+	r.rows[i], r.rows[j] = r.rows[j], r.rows[i]
+	r.dimValues[i], r.dimValues[j] = r.dimValues[j], r.dimValues[i]
+}
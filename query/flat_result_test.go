@@ -0,0 +1,128 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	memCom "github.com/uber/aresdb/memstore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+)
+
+var _ = ginkgo.Describe("flat result", func() {
+	// createQueryContext creates a query context grouped by a time dimension in
+	// days, an enum dimension and an integer dimension.
+	createQueryContext := func(measures []Measure, results queryCom.AQLQueryResult) *AQLQueryContext {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: "reqAt", TimeUnit: "day"},
+					{Expr: "status"},
+					{Expr: "city_id", Alias: "city"},
+				},
+				Measures: measures,
+			},
+			Results: results,
+		}
+		qc.OOPK.Dimensions = []expr.Expr{
+			&expr.VarRef{Val: "reqAt", DataType: memCom.Uint32},
+			&expr.VarRef{Val: "status", DataType: memCom.SmallEnum, EnumReverseDict: []string{"completed", "canceled"}},
+			&expr.VarRef{Val: "city_id", DataType: memCom.Uint16},
+		}
+		return qc
+	}
+
+	ginkgo.It("flattens results of a single measure", func() {
+		qc := createQueryContext([]Measure{{Expr: "count(*)"}}, queryCom.AQLQueryResult{
+			"17000": map[string]interface{}{
+				"completed": map[string]interface{}{"12": 2.0, "NULL": nil},
+			},
+			"16999": map[string]interface{}{
+				"canceled": map[string]interface{}{"1": 3.0},
+				"NULL":     map[string]interface{}{"1": 1.0},
+			},
+		})
+		Ω(*qc.FlattenResults()).Should(Equal(FlatResult{
+			Headers: []string{"reqAt", "status", "city", "count(*)"},
+			Rows: [][]interface{}{
+				{int64(16999), nil, int64(1), 1.0},
+				{int64(16999), "canceled", int64(1), 3.0},
+				{int64(17000), "completed", nil, nil},
+				{int64(17000), "completed", int64(12), 2.0},
+			},
+		}))
+	})
+
+	ginkgo.It("flattens results of multiple and compared measures", func() {
+		measures := []Measure{{Expr: "count(*)", Alias: "trips"}, {Expr: "sum(fare)"}}
+		qc := createQueryContext(measures, queryCom.AQLQueryResult{
+			"17000": map[string]interface{}{
+				"_total": map[string]interface{}{
+					"_total": map[string]interface{}{"trips": 2.0, "sum(fare)": nil},
+				},
+			},
+		})
+		Ω(qc.FlattenResults().Rows).Should(Equal([][]interface{}{
+			{int64(17000), "_total", "_total", 2.0, nil},
+		}))
+
+		qc = createQueryContext(measures[:1], queryCom.AQLQueryResult{
+			"17000": map[string]interface{}{
+				"completed": map[string]interface{}{
+					"1": map[string]interface{}{"current": 2.0, "previous": 1.0, "delta": 1.0},
+				},
+			},
+		})
+		qc.Query.CompareTo = "-1 week"
+		Ω(*qc.FlattenResults()).Should(Equal(FlatResult{
+			Headers: []string{"reqAt", "status", "city", "trips", "trips.previous", "trips.delta"},
+			Rows: [][]interface{}{
+				{int64(17000), "completed", int64(1), 2.0, 1.0, 1.0},
+			},
+		}))
+	})
+
+	ginkgo.It("flattens results with headers", func() {
+		qc := createQueryContext(nil, queryCom.AQLQueryResult{
+			queryCom.HeadersKey: []string{"reqAt", "status", "city_id"},
+			queryCom.MatrixDataKey: [][]interface{}{
+				{"17000", "canceled", "NULL"},
+				{"16999", "NULL", "3"},
+			},
+		})
+		Ω(*qc.FlattenResults()).Should(Equal(FlatResult{
+			Headers: []string{"reqAt", "status", "city_id"},
+			Rows: [][]interface{}{
+				{int64(17000), "canceled", nil},
+				{int64(16999), nil, int64(3)},
+			},
+		}))
+
+		qc = createQueryContext(nil, queryCom.AQLQueryResult{
+			queryCom.HeadersKey: []string{"reqAt"},
+		})
+		Ω(qc.FlattenResults().Rows).Should(BeEmpty())
+	})
+
+	ginkgo.It("FormatFlatValue should work", func() {
+		Ω(FormatFlatValue(nil)).Should(Equal(""))
+		Ω(FormatFlatValue("a")).Should(Equal("a"))
+		Ω(FormatFlatValue(1.5e9)).Should(Equal("1500000000"))
+		Ω(FormatFlatValue(0.25)).Should(Equal("0.25"))
+		Ω(FormatFlatValue(int64(-3))).Should(Equal("-3"))
+		Ω(FormatFlatValue(true)).Should(Equal("true"))
+	})
+})
@@ -0,0 +1,241 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"encoding/binary"
+)
+
+// fbBuilder builds a flatbuffer back to front. It implements the subset of
+// the flatbuffers builder needed by Arrow IPC metadata: tables of scalars,
+// strings, vectors of offsets and vectors of structs. Offsets are counted from
+// the end of the buffer, and objects must be built before the objects
+// referring to them. See https://google.github.io/flatbuffers/ for the format.
+type fbBuilder struct {
+	// bytes are written to buf[head:].
+	buf      []byte
+	head     int
+	minAlign int
+	// offsets of fields of the object being built, 0 for absent fields.
+	vtable    []int
+	objectEnd int
+}
+
+// newFBBuilder creates a fbBuilder.
+func newFBBuilder() *fbBuilder {
+	return &fbBuilder{
+		buf:      make([]byte, 256),
+		head:     256,
+		minAlign: 1,
+	}
+}
+
+// offset returns the offset of the last written byte from the end of buffer.
+func (b *fbBuilder) offset() int {
+	return len(b.buf) - b.head
+}
+
+// grow makes room for n more bytes in front of buf.
+func (b *fbBuilder) grow(n int) {
+	for b.head < n {
+		newBuf := make([]byte, 2*len(b.buf))
+		copy(newBuf[len(newBuf)-len(b.buf):], b.buf)
+		b.head += len(newBuf) - len(b.buf)
+		b.buf = newBuf
+	}
+}
+
+// pad writes n zero bytes.
+func (b *fbBuilder) pad(n int) {
+	b.grow(n)
+	for i := 0; i < n; i++ {
+		b.head--
+		b.buf[b.head] = 0
+	}
+}
+
+// prep pads buffer so that size bytes are aligned to size after additional
+// bytes are written.
+func (b *fbBuilder) prep(size, additional int) {
+	if size > b.minAlign {
+		b.minAlign = size
+	}
+	b.pad((-(b.offset() + additional)) & (size - 1))
+}
+
+func (b *fbBuilder) prependBytes(bytes []byte) {
+	b.grow(len(bytes))
+	b.head -= len(bytes)
+	copy(b.buf[b.head:], bytes)
+}
+
+func (b *fbBuilder) prependUint8(v uint8) {
+	b.prep(1, 0)
+	b.prependBytes([]byte{v})
+}
+
+func (b *fbBuilder) prependUint16(v uint16) {
+	b.prep(2, 0)
+	b.grow(2)
+	b.head -= 2
+	binary.LittleEndian.PutUint16(b.buf[b.head:], v)
+}
+
+func (b *fbBuilder) prependUint32(v uint32) {
+	b.prep(4, 0)
+	b.grow(4)
+	b.head -= 4
+	binary.LittleEndian.PutUint32(b.buf[b.head:], v)
+}
+
+func (b *fbBuilder) prependUint64(v uint64) {
+	b.prep(8, 0)
+	b.grow(8)
+	b.head -= 8
+	binary.LittleEndian.PutUint64(b.buf[b.head:], v)
+}
+
+// prependOffset writes the offset to the object at off relative to where it's
+// written.
+func (b *fbBuilder) prependOffset(off int) {
+	b.prep(4, 0)
+	b.prependUint32(uint32(b.offset() - off + 4))
+}
+
+// createString writes a null terminated string and returns its offset.
+func (b *fbBuilder) createString(s string) int {
+	b.prep(4, len(s)+1)
+	b.pad(1)
+	b.prependBytes([]byte(s))
+	b.prependUint32(uint32(len(s)))
+	return b.offset()
+}
+
+// startVector starts a vector of n elements of elemSize bytes, elements should
+// be written in reverse order before endVector.
+func (b *fbBuilder) startVector(elemSize, n, alignment int) {
+	b.prep(4, elemSize*n)
+	b.prep(alignment, elemSize*n)
+}
+
+// endVector ends the vector of n elements and returns its offset.
+func (b *fbBuilder) endVector(n int) int {
+	b.prependUint32(uint32(n))
+	return b.offset()
+}
+
+// createOffsetVector writes a vector of offsets to objects and returns its
+// offset.
+func (b *fbBuilder) createOffsetVector(offsets []int) int {
+	b.startVector(4, len(offsets), 4)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.prependOffset(offsets[i])
+	}
+	return b.endVector(len(offsets))
+}
+
+// createStructVector writes a vector of structs of int64 fields and returns
+// its offset.
+func (b *fbBuilder) createStructVector(structs [][]int64) int {
+	var structSize int
+	if len(structs) > 0 {
+		structSize = 8 * len(structs[0])
+	}
+	b.startVector(structSize, len(structs), 8)
+	for i := len(structs) - 1; i >= 0; i-- {
+		b.prep(8, structSize)
+		for j := len(structs[i]) - 1; j >= 0; j-- {
+			b.prependUint64(uint64(structs[i][j]))
+		}
+	}
+	return b.endVector(len(structs))
+}
+
+// startObject starts a table of numFields fields.
+func (b *fbBuilder) startObject(numFields int) {
+	b.vtable = make([]int, numFields)
+	b.objectEnd = b.offset()
+}
+
+// slot records the last written value as the field at slot.
+func (b *fbBuilder) slot(slot int) {
+	b.vtable[slot] = b.offset()
+}
+
+func (b *fbBuilder) addBool(slot int, v bool) {
+	var u uint8
+	if v {
+		u = 1
+	}
+	b.prependUint8(u)
+	b.slot(slot)
+}
+
+func (b *fbBuilder) addUint8(slot int, v uint8) {
+	b.prependUint8(v)
+	b.slot(slot)
+}
+
+func (b *fbBuilder) addInt16(slot int, v int16) {
+	b.prependUint16(uint16(v))
+	b.slot(slot)
+}
+
+func (b *fbBuilder) addInt32(slot int, v int32) {
+	b.prependUint32(uint32(v))
+	b.slot(slot)
+}
+
+func (b *fbBuilder) addInt64(slot int, v int64) {
+	b.prependUint64(uint64(v))
+	b.slot(slot)
+}
+
+func (b *fbBuilder) addOffset(slot int, off int) {
+	b.prependOffset(off)
+	b.slot(slot)
+}
+
+// endObject writes the vtable of the table and returns the offset of the table.
+func (b *fbBuilder) endObject() int {
+	// placeholder of the offset to vtable.
+	b.prependUint32(0)
+	objectOffset := b.offset()
+
+	numFields := len(b.vtable)
+	for numFields > 0 && b.vtable[numFields-1] == 0 {
+		numFields--
+	}
+	for i := numFields - 1; i >= 0; i-- {
+		var fieldOffset uint16
+		if b.vtable[i] != 0 {
+			fieldOffset = uint16(objectOffset - b.vtable[i])
+		}
+		b.prependUint16(fieldOffset)
+	}
+	b.prependUint16(uint16(objectOffset - b.objectEnd))
+	b.prependUint16(uint16((numFields + 2) * 2))
+
+	binary.LittleEndian.PutUint32(b.buf[len(b.buf)-objectOffset:], uint32(b.offset()-objectOffset))
+	b.vtable = nil
+	return objectOffset
+}
+
+// finish writes the offset to the root table and returns the finished buffer.
+func (b *fbBuilder) finish(root int) []byte {
+	b.prep(b.minAlign, 4)
+	b.prependOffset(root)
+	return b.buf[b.head:]
+}
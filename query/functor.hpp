@@ -332,6 +332,173 @@ struct FloorFunctor {
   }
 };
 
+// conditional operators, CASE WHEN is compiled into combinations of them.
+
+// IfTrueFunctor returns the 2nd argument if the 1st argument is true,
+// otherwise null.
+template<typename T>
+struct IfTrueFunctor {
+  __host__ __device__
+  thrust::tuple<T, bool> operator()(const thrust::tuple<T, bool> t1,
+                                    const thrust::tuple<T, bool> t2) const {
+    if (thrust::get<1>(t1) && thrust::get<0>(t1)) {
+      return t2;
+    }
+    return thrust::make_tuple(0, false);
+  }
+};
+
+// IfNotTrueFunctor returns the 2nd argument if the 1st argument is false or
+// null, otherwise null.
+template<typename T>
+struct IfNotTrueFunctor {
+  __host__ __device__
+  thrust::tuple<T, bool> operator()(const thrust::tuple<T, bool> t1,
+                                    const thrust::tuple<T, bool> t2) const {
+    if (thrust::get<1>(t1) && thrust::get<0>(t1)) {
+      return thrust::make_tuple(0, false);
+    }
+    return t2;
+  }
+};
+
+// CoalesceFunctor returns the 1st argument if it's not null, otherwise the
+// 2nd argument.
+template<typename T>
+struct CoalesceFunctor {
+  __host__ __device__
+  thrust::tuple<T, bool> operator()(const thrust::tuple<T, bool> t1,
+                                    const thrust::tuple<T, bool> t2) const {
+    if (thrust::get<1>(t1)) {
+      return t1;
+    }
+    return t2;
+  }
+};
+
+// math operators, values are computed as float except for abs, greatest
+// and least. Invalid results like sqrt of negative values are null.
+
+template<typename T>
+struct AbsFunctor {
+  __host__ __device__
+  thrust::tuple<T, bool> operator()(const thrust::tuple<T, bool> t) const {
+    T value = thrust::get<0>(t);
+    return thrust::make_tuple(value < 0 ? -value : value, thrust::get<1>(t));
+  }
+};
+
+template<typename T>
+struct CeilFunctor {
+  __host__ __device__
+  thrust::tuple<float_t, bool> operator()(
+      const thrust::tuple<T, bool> t) const {
+    return thrust::make_tuple(ceilf(thrust::get<0>(t)), thrust::get<1>(t));
+  }
+};
+
+template<typename T>
+struct SqrtFunctor {
+  __host__ __device__
+  thrust::tuple<float_t, bool> operator()(
+      const thrust::tuple<T, bool> t) const {
+    float_t value = thrust::get<0>(t);
+    if (!thrust::get<1>(t) || value < 0) {
+      return thrust::make_tuple(0, false);
+    }
+    return thrust::make_tuple(sqrtf(value), true);
+  }
+};
+
+template<typename T>
+struct LnFunctor {
+  __host__ __device__
+  thrust::tuple<float_t, bool> operator()(
+      const thrust::tuple<T, bool> t) const {
+    float_t value = thrust::get<0>(t);
+    if (!thrust::get<1>(t) || value <= 0) {
+      return thrust::make_tuple(0, false);
+    }
+    return thrust::make_tuple(logf(value), true);
+  }
+};
+
+template<typename T>
+struct Log10Functor {
+  __host__ __device__
+  thrust::tuple<float_t, bool> operator()(
+      const thrust::tuple<T, bool> t) const {
+    float_t value = thrust::get<0>(t);
+    if (!thrust::get<1>(t) || value <= 0) {
+      return thrust::make_tuple(0, false);
+    }
+    return thrust::make_tuple(log10f(value), true);
+  }
+};
+
+template<typename T>
+struct PowFunctor {
+  __host__ __device__
+  thrust::tuple<float_t, bool> operator()(
+      const thrust::tuple<T, bool> t1,
+      const thrust::tuple<T, bool> t2) const {
+    // if one of them is null, the result is null.
+    if (!thrust::get<1>(t1) || !thrust::get<1>(t2)) {
+      return thrust::make_tuple(0, false);
+    }
+    float_t value = powf(thrust::get<0>(t1), thrust::get<0>(t2));
+    // negative base with non integer exponent.
+    if (isnan(value)) {
+      return thrust::make_tuple(0, false);
+    }
+    return thrust::make_tuple(value, true);
+  }
+};
+
+// RoundFunctor rounds the 1st argument to the number of decimal places
+// specified by the 2nd argument, which can be negative.
+template<typename T>
+struct RoundFunctor {
+  __host__ __device__
+  thrust::tuple<float_t, bool> operator()(
+      const thrust::tuple<T, bool> t1,
+      const thrust::tuple<T, bool> t2) const {
+    // if one of them is null, the result is null.
+    if (!thrust::get<1>(t1) || !thrust::get<1>(t2)) {
+      return thrust::make_tuple(0, false);
+    }
+    float_t scale = powf(10, static_cast<int>(thrust::get<0>(t2)));
+    return thrust::make_tuple(roundf(thrust::get<0>(t1) * scale) / scale,
+                              true);
+  }
+};
+
+template<typename T>
+struct GreatestFunctor {
+  __host__ __device__
+  thrust::tuple<T, bool> operator()(const thrust::tuple<T, bool> t1,
+                                    const thrust::tuple<T, bool> t2) const {
+    // if one of them is null, the result is null.
+    if (!thrust::get<1>(t1) || !thrust::get<1>(t2)) {
+      return thrust::make_tuple(0, false);
+    }
+    return thrust::get<0>(t1) >= thrust::get<0>(t2) ? t1 : t2;
+  }
+};
+
+template<typename T>
+struct LeastFunctor {
+  __host__ __device__
+  thrust::tuple<T, bool> operator()(const thrust::tuple<T, bool> t1,
+                                    const thrust::tuple<T, bool> t2) const {
+    // if one of them is null, the result is null.
+    if (!thrust::get<1>(t1) || !thrust::get<1>(t2)) {
+      return thrust::make_tuple(0, false);
+    }
+    return thrust::get<0>(t1) <= thrust::get<0>(t2) ? t1 : t2;
+  }
+};
+
 
 // misc operators
 
@@ -479,6 +646,11 @@ struct UnaryFunctor {
       case GetMonthOfYear: return GetMonthOfYearFunctor()(t);
       case GetQuarterOfYear: return GetQuarterOfYearFunctor()(t);
       case GetHLLValue: return GetHLLValueFunctor<I>()(t);
+      case Abs: return AbsFunctor<I>()(t);
+      case Ceil: return CeilFunctor<I>()(t);
+      case Sqrt: return SqrtFunctor<I>()(t);
+      case Ln: return LnFunctor<I>()(t);
+      case Log10: return Log10Functor<I>()(t);
       default:
         // We will not handle uncaught enum here since the AQL compiler
         // should ensure that.
@@ -529,6 +701,16 @@ struct UnaryFunctor<
         return NegateFunctor<float_t>()(t);
       case Noop:
         return NoopFunctor<float_t>()(t);
+      case Abs:
+        return AbsFunctor<float_t>()(t);
+      case Ceil:
+        return CeilFunctor<float_t>()(t);
+      case Sqrt:
+        return SqrtFunctor<float_t>()(t);
+      case Ln:
+        return LnFunctor<float_t>()(t);
+      case Log10:
+        return Log10Functor<float_t>()(t);
       default:
         // We will not handle uncaught enum here since the AQL compiler
         // should ensure that.
@@ -711,6 +893,13 @@ struct BinaryFunctor {
       case BitwiseOr:return BitwiseOrFunctor<I>()(t1, t2);
       case BitwiseXor:return BitwiseXorFunctor<I>()(t1, t2);
       case Floor:return FloorFunctor<I>()(t1, t2);
+      case IfTrue:return IfTrueFunctor<I>()(t1, t2);
+      case IfNotTrue:return IfNotTrueFunctor<I>()(t1, t2);
+      case Coalesce:return CoalesceFunctor<I>()(t1, t2);
+      case Pow:return PowFunctor<I>()(t1, t2);
+      case Round:return RoundFunctor<I>()(t1, t2);
+      case Greatest:return GreatestFunctor<I>()(t1, t2);
+      case Least:return LeastFunctor<I>()(t1, t2);
       default:
         // We will not handle uncaught enum here since the AQL compiler
         // should ensure that.
@@ -767,6 +956,13 @@ struct BinaryFunctor<
       case Minus:return MinusFunctor<float_t>()(t1, t2);
       case Multiply:return MultiplyFunctor<float_t>()(t1, t2);
       case Divide:return DivideFunctor<float_t>()(t1, t2);
+      case IfTrue:return IfTrueFunctor<float_t>()(t1, t2);
+      case IfNotTrue:return IfNotTrueFunctor<float_t>()(t1, t2);
+      case Coalesce:return CoalesceFunctor<float_t>()(t1, t2);
+      case Pow:return PowFunctor<float_t>()(t1, t2);
+      case Round:return RoundFunctor<float_t>()(t1, t2);
+      case Greatest:return GreatestFunctor<float_t>()(t1, t2);
+      case Least:return LeastFunctor<float_t>()(t1, t2);
       default:
         // We will not handle uncaught enum here since the AQL compiler
         // should ensure that.
@@ -643,6 +643,106 @@ TEST(MiscFunctorTest, TestInt) {
                     std::begin(expectedNulls3)));
 }
 
+// cppcheck-suppress *
+TEST(ConditionalFunctorTest, TestInt) {
+  IfTrueFunctor<int> ifTrue;
+  thrust::tuple<int, bool> res = ifTrue(thrust::make_tuple(1, true),
+                                        thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<0>(res), 10);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = ifTrue(thrust::make_tuple(0, true), thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<1>(res), false);
+
+  res = ifTrue(thrust::make_tuple(1, false), thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<1>(res), false);
+
+  IfNotTrueFunctor<int> ifNotTrue;
+  res = ifNotTrue(thrust::make_tuple(1, true), thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<1>(res), false);
+
+  res = ifNotTrue(thrust::make_tuple(0, true), thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<0>(res), 10);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = ifNotTrue(thrust::make_tuple(1, false), thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<0>(res), 10);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  CoalesceFunctor<int> coalesce;
+  res = coalesce(thrust::make_tuple(1, true), thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<0>(res), 1);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = coalesce(thrust::make_tuple(1, false), thrust::make_tuple(10, true));
+  EXPECT_EQ(thrust::get<0>(res), 10);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = coalesce(thrust::make_tuple(1, false), thrust::make_tuple(10, false));
+  EXPECT_EQ(thrust::get<1>(res), false);
+}
+
+// cppcheck-suppress *
+TEST(MathFunctorTest, TestFloat) {
+  thrust::tuple<int, bool> intRes = AbsFunctor<int>()(
+      thrust::make_tuple(-3, true));
+  EXPECT_EQ(thrust::get<0>(intRes), 3);
+  EXPECT_EQ(thrust::get<1>(intRes), true);
+
+  thrust::tuple<float_t, bool> res = CeilFunctor<float_t>()(
+      thrust::make_tuple(1.2, true));
+  EXPECT_EQ(thrust::get<0>(res), 2.0);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = SqrtFunctor<int>()(thrust::make_tuple(16, true));
+  EXPECT_EQ(thrust::get<0>(res), 4.0);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = SqrtFunctor<int>()(thrust::make_tuple(-1, true));
+  EXPECT_EQ(thrust::get<1>(res), false);
+
+  res = LnFunctor<float_t>()(thrust::make_tuple(1.0, true));
+  EXPECT_EQ(thrust::get<0>(res), 0.0);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = Log10Functor<float_t>()(thrust::make_tuple(0.0, true));
+  EXPECT_EQ(thrust::get<1>(res), false);
+
+  res = Log10Functor<float_t>()(thrust::make_tuple(100.0, true));
+  EXPECT_EQ(thrust::get<0>(res), 2.0);
+
+  res = PowFunctor<float_t>()(thrust::make_tuple(2.0, true),
+                              thrust::make_tuple(3.0, true));
+  EXPECT_EQ(thrust::get<0>(res), 8.0);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = PowFunctor<float_t>()(thrust::make_tuple(-2.0, true),
+                              thrust::make_tuple(0.5, true));
+  EXPECT_EQ(thrust::get<1>(res), false);
+
+  res = RoundFunctor<float_t>()(thrust::make_tuple(1.26, true),
+                                thrust::make_tuple(1.0, true));
+  EXPECT_NEAR(thrust::get<0>(res), 1.3, 0.0001);
+  EXPECT_EQ(thrust::get<1>(res), true);
+
+  res = RoundFunctor<float_t>()(thrust::make_tuple(1250.0, true),
+                                thrust::make_tuple(-2.0, true));
+  EXPECT_EQ(thrust::get<0>(res), 1300.0);
+
+  intRes = GreatestFunctor<int>()(thrust::make_tuple(1, true),
+                                  thrust::make_tuple(2, true));
+  EXPECT_EQ(thrust::get<0>(intRes), 2);
+  EXPECT_EQ(thrust::get<1>(intRes), true);
+
+  intRes = LeastFunctor<int>()(thrust::make_tuple(1, true),
+                               thrust::make_tuple(2, true));
+  EXPECT_EQ(thrust::get<0>(intRes), 1);
+
+  intRes = LeastFunctor<int>()(thrust::make_tuple(1, true),
+                               thrust::make_tuple(2, false));
+  EXPECT_EQ(thrust::get<1>(intRes), false);
+}
+
 // cppcheck-suppress *
 TEST(RemoveFilterTest, CheckRemoveFilter) {
   uint8_t predicates[5] = {1, 1, 1, 1, 0};
@@ -0,0 +1,203 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+// #include "time_series_aggregate.h"
+import "C"
+
+import (
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"math"
+	"strconv"
+)
+
+// processGroupingSets resolves grouping sets of the query against dimensions.
+// Subtotals are rolled up from the aggregated groups of all dimensions during
+// postprocessing, so only measures that can be rolled up are supported.
+func (qc *AQLQueryContext) processGroupingSets() {
+	qc.Query.groupingSets = nil
+	if len(qc.Query.GroupingSets) == 0 {
+		return
+	}
+
+	if qc.isNonAggregationQuery {
+		qc.Error = utils.StackError(nil, "grouping sets are not supported for non aggregation query")
+		return
+	}
+
+	if qc.ReturnHLLData || qc.OOPK.IsHLL() {
+		qc.Error = utils.StackError(nil, "grouping sets are not supported for hll query")
+		return
+	}
+
+	if qc.Query.CompareTo != "" || qc.hasMeasureWindows() || qc.getFillGapsDimIndex() >= 0 {
+		qc.Error = utils.StackError(nil, "grouping sets can not be used together with compareTo, fillGaps or window measures")
+		return
+	}
+
+	dimIndexes := make(map[string]int, len(qc.Query.Dimensions))
+	for dimIndex, dim := range qc.Query.Dimensions {
+		dimIndexes[dim.name()] = dimIndex
+	}
+
+	qc.Query.groupingSets = make([][]bool, len(qc.Query.GroupingSets))
+	for i, groupingSet := range qc.Query.GroupingSets {
+		qc.Query.groupingSets[i] = make([]bool, len(qc.Query.Dimensions))
+		for _, name := range groupingSet {
+			dimIndex, ok := dimIndexes[name]
+			if !ok {
+				qc.Error = utils.StackError(nil, "unknown dimension %s in grouping set %v", name, groupingSet)
+				return
+			}
+			qc.Query.groupingSets[i][dimIndex] = true
+		}
+	}
+}
+
+// hasGroupingSets returns whether the query aggregates by grouping sets.
+func (qc *AQLQueryContext) hasGroupingSets() bool {
+	return qc.Query != nil && qc.Query.groupingSets != nil
+}
+
+// groupingSetGroup stores the rolled up aggregate values of one group of a
+// grouping set. Values of avg measures are summed up with their counts as
+// weights, and sketches of percentile measures are merged.
+type groupingSetGroup struct {
+	dimValues []*string
+	values    []*float64
+	weights   []float64
+	sketches  []*queryCom.TDigest
+}
+
+// groupingSetsAggregator rolls up aggregated groups of all dimensions into
+// groups of each grouping set.
+type groupingSetsAggregator struct {
+	qc             *AQLQueryContext
+	measureOffsets []int
+	// keys of groups in the order they are created.
+	keys   []string
+	groups map[string]*groupingSetGroup
+}
+
+// newGroupingSetsAggregator creates a groupingSetsAggregator for results of
+// the query.
+func newGroupingSetsAggregator(qc *AQLQueryContext) *groupingSetsAggregator {
+	return &groupingSetsAggregator{
+		qc:             qc,
+		measureOffsets: getMeasureStartOffsets(qc.OOPK.Measures, qc.OOPK.ResultSize),
+		groups:         make(map[string]*groupingSetGroup),
+	}
+}
+
+// add rolls up the aggregated group at row of the result with the dimension
+// and aggregate values into its group of each grouping set. percentileKey is
+// the dimension key of the row for reading percentile sketches.
+func (a *groupingSetsAggregator) add(row int, dimValues []*string, aggregateValues []*float64, percentileKey string) {
+	oopkContext := a.qc.OOPK
+	total := queryCom.TotalKey
+	for setIndex, groupingSet := range a.qc.Query.groupingSets {
+		groupDimValues := make([]*string, len(dimValues))
+		for dimIndex, value := range dimValues {
+			if groupingSet[dimIndex] {
+				groupDimValues[dimIndex] = value
+			} else {
+				groupDimValues[dimIndex] = &total
+			}
+		}
+
+		key := strconv.Itoa(setIndex) + ":" + getDimValuesKey(groupDimValues, -1)
+		group := a.groups[key]
+		if group == nil {
+			group = &groupingSetGroup{
+				dimValues: groupDimValues,
+				values:    make([]*float64, len(oopkContext.Measures)),
+				weights:   make([]float64, len(oopkContext.Measures)),
+				sketches:  make([]*queryCom.TDigest, len(oopkContext.Measures)),
+			}
+			a.keys = append(a.keys, key)
+			a.groups[key] = group
+		}
+
+		for measureIndex, measure := range oopkContext.Measures {
+			if measure.IsPercentile {
+				if oopkContext.percentileSketches == nil {
+					continue
+				}
+				if sketch := oopkContext.percentileSketches[measureIndex][percentileKey]; sketch != nil {
+					if group.sketches[measureIndex] == nil {
+						group.sketches[measureIndex] = queryCom.NewTDigest(queryCom.DefaultTDigestCompression)
+					}
+					group.sketches[measureIndex].Merge(sketch)
+				}
+				continue
+			}
+
+			value := aggregateValues[measureIndex]
+			if value == nil {
+				continue
+			}
+			v := *value
+			if measure.AggregateType == C.AGGR_AVG_FLOAT {
+				// avg is followed by the uint32 count of values.
+				weight := float64(*(*uint32)(utils.MemAccess(oopkContext.measureVectorH,
+					a.measureOffsets[measureIndex]+row*measure.MeasureBytes+4)))
+				group.weights[measureIndex] += weight
+				v *= weight
+			}
+
+			current := group.values[measureIndex]
+			if current == nil {
+				group.values[measureIndex] = &v
+				continue
+			}
+			switch measure.AggregateType {
+			case C.AGGR_MIN_UNSIGNED, C.AGGR_MIN_SIGNED, C.AGGR_MIN_FLOAT:
+				*current = math.Min(*current, v)
+			case C.AGGR_MAX_UNSIGNED, C.AGGR_MAX_SIGNED, C.AGGR_MAX_FLOAT:
+				*current = math.Max(*current, v)
+			default:
+				*current += v
+			}
+		}
+	}
+}
+
+// forEach calls f with the dimension values and the aggregate values of each
+// group of grouping sets in the order they are created.
+func (a *groupingSetsAggregator) forEach(f func(dimValues []*string, aggregateValues []*float64)) {
+	oopkContext := a.qc.OOPK
+	aggregateValues := make([]*float64, len(oopkContext.Measures))
+	for _, key := range a.keys {
+		group := a.groups[key]
+		for measureIndex, measure := range oopkContext.Measures {
+			aggregateValues[measureIndex] = group.values[measureIndex]
+			if measure.IsPercentile {
+				aggregateValues[measureIndex] = nil
+				if sketch := group.sketches[measureIndex]; sketch != nil && sketch.Count() > 0 {
+					value := sketch.Quantile(measure.Percentile)
+					aggregateValues[measureIndex] = &value
+				}
+			} else if measure.AggregateType == C.AGGR_AVG_FLOAT && group.values[measureIndex] != nil {
+				aggregateValues[measureIndex] = nil
+				if weight := group.weights[measureIndex]; weight > 0 {
+					value := *group.values[measureIndex] / weight
+					aggregateValues[measureIndex] = &value
+				}
+			}
+		}
+		f(group.dimValues, aggregateValues)
+	}
+}
@@ -55,6 +55,18 @@ func (r *HLLQueryResults) WriteResult(result []byte) {
 	r.buffer.Write(result)
 }
 
+// WritePercentileResult writes serialized percentile sketches to the buffer.
+func (r *HLLQueryResults) WritePercentileResult(result []byte) {
+	totalSize := uint32(len(result))
+	// Write total size.
+	r.buffer.Write((*(*[4]byte)(unsafe.Pointer(&totalSize)))[:])
+	r.buffer.WriteByte(queryCom.PercentileResultFlag)
+	// Padding.
+	var bs [3]byte
+	r.buffer.Write(bs[:])
+	r.buffer.Write(result)
+}
+
 // WriteError write error to the buffer.
 func (r *HLLQueryResults) WriteError(err error) {
 	totalSize := len(err.Error())
@@ -123,7 +135,76 @@ func (qc *AQLQueryContext) SerializeHLL(dataTypes []memCom.DataType,
 		oopkContext.hllVectorD.getPointer(), int(qc.OOPK.hllVectorSize), qc.cudaStreams[0], qc.Device)
 	memutils.WaitForCudaStream(qc.cudaStreams[0], qc.Device)
 
-	// Fix time dimension by substracting the timezone.
+	qc.adjustTimeDimensions(dimVectorH, timeDimensions)
+	return builder.buffer, nil
+}
+
+// SerializePercentile serializes the sketches of the single percentile measure in the
+// same format as hll data, except that counts and hll vector are replaced by:
+//	 [uint32] sketch_bytes_0 ... [uint32] sketch_bytes_n [padding for 8 bytes]
+//	 <encoded t-digest 0> ... <encoded t-digest n>
+// It should be called after results are copied to host memory.
+func (qc *AQLQueryContext) SerializePercentile(dataTypes []memCom.DataType,
+	enumDicts map[int][]string, timeDimensions []int) ([]byte, error) {
+	oopkContext := qc.OOPK
+	sketches := make([][]byte, oopkContext.ResultSize)
+	var sketchesLength int64
+	for i := range sketches {
+		key := getDimensionKey(oopkContext.dimensionVectorH, i, oopkContext.ResultSize, oopkContext.NumDimsPerDimWidth)
+		if sketch := oopkContext.percentileSketches[0][key]; sketch != nil {
+			sketches[i] = sketch.Encode()
+			sketchesLength += int64(len(sketches[i]))
+		}
+	}
+
+	rawDimValuesVectorLength := dimValResVectorSize(oopkContext.ResultSize, oopkContext.NumDimsPerDimWidth)
+	paddedRawDimValuesVectorLength := (uint32(rawDimValuesVectorLength) + 7) / 8 * 8
+	paddedSketchBytesLength := uint32(4*oopkContext.ResultSize+7) / 8 * 8
+	builder := HLLDataWriter{
+		HLLData: queryCom.HLLData{
+			ResultSize:                     uint32(oopkContext.ResultSize),
+			NumDimsPerDimWidth:             oopkContext.NumDimsPerDimWidth,
+			DimIndexes:                     oopkContext.DimensionVectorIndex,
+			DataTypes:                      dataTypes,
+			EnumDicts:                      enumDicts,
+			PaddedRawDimValuesVectorLength: paddedRawDimValuesVectorLength,
+			PaddedHLLVectorLength:          sketchesLength,
+		},
+	}
+
+	headerSize, _ := builder.CalculateSizes()
+	totalSize := int64(headerSize) + int64(paddedRawDimValuesVectorLength) + int64(paddedSketchBytesLength) + sketchesLength
+	builder.buffer = make([]byte, totalSize)
+	if err := builder.SerializeHeader(); err != nil {
+		return nil, err
+	}
+
+	// Copy dim values vector from host result.
+	copy(builder.buffer[headerSize:],
+		memutils.MakeSliceFromCPtr(uintptr(oopkContext.dimensionVectorH), rawDimValuesVectorLength))
+	qc.adjustTimeDimensions(unsafe.Pointer(&builder.buffer[headerSize]), timeDimensions)
+
+	writer := utils.NewBufferWriter(builder.buffer)
+	writer.SkipBytes(int(headerSize + paddedRawDimValuesVectorLength))
+	for _, sketch := range sketches {
+		if err := writer.AppendUint32(uint32(len(sketch))); err != nil {
+			return nil, err
+		}
+	}
+	writer.AlignBytes(8)
+
+	for _, sketch := range sketches {
+		if err := writer.Append(sketch); err != nil {
+			return nil, err
+		}
+	}
+	return builder.buffer, nil
+}
+
+// adjustTimeDimensions fixes time dimensions in the host dimension vector of
+// ResultSize rows by substracting the timezone.
+func (qc *AQLQueryContext) adjustTimeDimensions(dimVectorH unsafe.Pointer, timeDimensions []int) {
+	oopkContext := qc.OOPK
 	if len(timeDimensions) > 0 && qc.fixedTimezone.String() != time.UTC.String() {
 		// length is equal to length of timeDimensions
 		dimPtrs := make([][2]unsafe.Pointer, len(timeDimensions))
@@ -164,8 +245,6 @@ func (qc *AQLQueryContext) SerializeHLL(dataTypes []memCom.DataType,
 			}
 		}
 	}
-
-	return builder.buffer, nil
 }
 
 // SerializeHeader serialize HLL header
@@ -102,6 +102,72 @@ var _ = ginkgo.Describe("hll", func() {
 		Ω(data[104 : 104+queryCom.DenseDataLength+28]).Should(Equal(hllData[:]))
 	})
 
+	ginkgo.It("SerializePercentile should work", func() {
+		dimensionVectorH := []byte{
+			0, 0, 0, 0,
+			1, 0, 0, 0,
+			0xFF, 0xFF, 0xFF, 0xFF, // dim0
+			0, 0,
+			2, 0,
+			2, 2, // dim1
+			0, 2, 3, // dim2
+			0, 1, 1, // dim0
+			0, 1, 1, // dim1
+			0, 1, 1, // dim2
+		}
+		numDims := queryCom.DimCountsPerDimWidth{0, 0, 1, 1, 1}
+		sketch1 := queryCom.NewTDigest(queryCom.DefaultTDigestCompression)
+		sketch1.Add(1, 1)
+		sketch1.Add(2, 1)
+		sketch1.Add(3, 1)
+		sketch2 := queryCom.NewTDigest(queryCom.DefaultTDigestCompression)
+		sketch2.Add(10, 2)
+
+		qc := AQLQueryContext{
+			OOPK: OOPKContext{
+				ResultSize:           3,
+				NumDimsPerDimWidth:   numDims,
+				DimensionVectorIndex: []int{0, 2, 1},
+				Measures:             []OOPKMeasure{{IsPercentile: true, Percentile: 0.5}},
+				dimensionVectorH:     unsafe.Pointer(&dimensionVectorH[0]),
+				percentileSketches: []map[string]*queryCom.TDigest{
+					{
+						getDimensionKey(unsafe.Pointer(&dimensionVectorH[0]), 1, 3, numDims): sketch1,
+						getDimensionKey(unsafe.Pointer(&dimensionVectorH[0]), 2, 3, numDims): sketch2,
+					},
+				},
+			},
+		}
+
+		dataTypes := []memCom.DataType{memCom.Uint32, memCom.Uint8, memCom.Int16}
+		enumReverseDict := map[int][]string{1: {"a", "b", "c", "d"}}
+		data, err := qc.SerializePercentile(dataTypes, enumReverseDict, nil)
+		Ω(err).Should(BeNil())
+		Ω(data[64:94]).Should(Equal(dimensionVectorH))
+		Ω(len(data)).Should(Equal(96 + 16 + len(sketch1.Encode()) + len(sketch2.Encode())))
+
+		results := NewHLLQueryResults()
+		results.WritePercentileResult(data)
+		results.WriteError(errors.New("err"))
+		res, errs, err := queryCom.ParseHLLQueryResults(results.GetBytes())
+		Ω(err).Should(BeNil())
+		Ω(res).Should(HaveLen(2))
+		Ω(errs[0]).Should(BeNil())
+		Ω(errs[1]).ShouldNot(BeNil())
+		Ω(queryCom.ComputePercentileResult(res[0], 0.5)).Should(Equal(queryCom.AQLQueryResult{
+			"1": map[string]interface{}{
+				"c": map[string]interface{}{
+					"2": float64(2),
+				},
+			},
+			"4294967295": map[string]interface{}{
+				"d": map[string]interface{}{
+					"514": float64(10),
+				},
+			},
+		}))
+	})
+
 	ginkgo.It("Should work with timezone", func() {
 		timeDimensionData := []byte{
 			0, 0, 0, 0,
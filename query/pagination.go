@@ -0,0 +1,227 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/uber/aresdb/memutils"
+	"github.com/uber/aresdb/utils"
+	"unsafe"
+)
+
+// maxEntriesToCopy is the max number of entries of the index vector copied to
+// host at a time for counting entries to skip.
+const maxEntriesToCopy = 1 << 16
+
+// continuationToken is the position in the rows of a non-aggregation query
+// where the next page starts. Rows are ordered by shard (in the order of shards
+// scanned), batch ID (live batches come before archive batches as their IDs
+// are negative) and record index within the batch. Rows is the number of rows
+// of the batch matching the query returned by previous pages.
+//
+// The order is stable as long as batches are not changed, archiving between
+// pages moves records from live batches to archive batches, so some records
+// can be returned twice or skipped.
+type continuationToken struct {
+	Shard int   `json:"shard"`
+	Batch int32 `json:"batch"`
+	Rows  int   `json:"rows"`
+}
+
+// pagingContext is the pagination state of a non-aggregation query.
+type pagingContext struct {
+	// position to start from, nil for the first page.
+	start *continuationToken
+	// number of rows left to skip by offset.
+	rowsToSkip int
+	// number of leading result rows to drop in postprocessing. Compressed rows
+	// of archive batches are skipped on device in whole runs, the rest of rows
+	// to skip are dropped from results.
+	rowsToDrop int
+	// shard being scanned.
+	shardID int
+	// position after the last result row.
+	end continuationToken
+	// continuation token of the next page, empty if all rows are returned.
+	nextToken string
+}
+
+// encode returns the opaque string of the continuation token.
+func (t continuationToken) encode() string {
+	bytes, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// decodeContinuationToken decodes the continuation token returned by encode.
+func decodeContinuationToken(token string) (*continuationToken, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, utils.StackError(err, "invalid continuation token %s", token)
+	}
+	var t continuationToken
+	if err = json.Unmarshal(bytes, &t); err != nil || t.Rows < 0 {
+		return nil, utils.StackError(err, "invalid continuation token %s", token)
+	}
+	return &t, nil
+}
+
+// processPagination validates the offset and continuation token of the query.
+// Shards before the one of the continuation token are not scanned.
+func (qc *AQLQueryContext) processPagination() {
+	if qc.Query.Offset == 0 && qc.Query.ContinuationToken == "" {
+		return
+	}
+
+	if !qc.isNonAggregationQuery {
+		qc.Error = utils.StackError(nil, "offset and continuation token are only supported for non aggregation query")
+		return
+	}
+
+	if qc.Query.Offset < 0 {
+		qc.Error = utils.StackError(nil, "invalid offset %d", qc.Query.Offset)
+		return
+	}
+
+	if qc.OOPK.geoIntersection != nil && qc.OOPK.geoIntersection.dimIndex >= 0 {
+		qc.Error = utils.StackError(nil, "offset and continuation token are not supported with geo dimension")
+		return
+	}
+	qc.paging.rowsToSkip = qc.Query.Offset
+
+	if qc.Query.ContinuationToken == "" {
+		return
+	}
+	qc.paging.start, qc.Error = decodeContinuationToken(qc.Query.ContinuationToken)
+	if qc.Error != nil {
+		return
+	}
+
+	scanner := qc.TableScanners[0]
+	for i, shardID := range scanner.Shards {
+		if shardID == qc.paging.start.Shard {
+			scanner.Shards = scanner.Shards[i:]
+			return
+		}
+	}
+	qc.Error = utils.StackError(nil, "unknown shard %d in continuation token", qc.paging.start.Shard)
+}
+
+// isBeforeStart returns whether the batch is before the start position of the
+// page, such batches are not processed.
+func (qc *AQLQueryContext) isBeforeStart(shardID int, batchID int32) bool {
+	start := qc.paging.start
+	return start != nil && shardID == start.Shard && batchID < start.Batch
+}
+
+// skipRows skips the leading rows of the batch returned by previous pages or
+// skipped by offset, and returns the number of rows skipped.
+func (e *NonAggrBatchExecutorImpl) skipRows() int {
+	paging := &e.qc.paging
+	var startRows int
+	if start := paging.start; start != nil && e.shardID == start.Shard && e.batchID == start.Batch {
+		startRows = start.Rows
+	}
+
+	bc := &e.qc.OOPK.currentBatch
+	rowsToSkip := startRows + paging.rowsToSkip
+	if rowsToSkip == 0 || bc.size == 0 {
+		return 0
+	}
+
+	var numEntries, numRows int
+	if bc.baseCountD.isNull() {
+		numEntries = rowsToSkip
+		if numEntries > bc.size {
+			numEntries = bc.size
+		}
+		numRows = numEntries
+	} else {
+		numEntries, numRows = bc.countEntriesToSkip(rowsToSkip, e.stream, e.qc.Device)
+	}
+	bc.skipEntries(numEntries, e.stream)
+
+	if numRows > startRows {
+		paging.rowsToSkip -= numRows - startRows
+	}
+	if bc.size > 0 && numRows < rowsToSkip {
+		// the rest of rows to skip are within the next compressed run.
+		paging.rowsToDrop = rowsToSkip - numRows
+		paging.rowsToSkip = 0
+	}
+	return numRows
+}
+
+// countEntriesToSkip returns the number of leading entries in the index vector
+// of a compressed batch whose expanded rows are all skipped, and the number of
+// these rows. As each entry has at least one row, at most rowsToSkip + 1
+// entries are checked, which are copied to host in chunks with the range of
+// base counts they refer to, as the index vector is in ascending order.
+func (bc *oopkBatchContext) countEntriesToSkip(rowsToSkip int, stream unsafe.Pointer, device int) (numEntries, numRows int) {
+	size := bc.size
+	if size > rowsToSkip+1 {
+		size = rowsToSkip + 1
+	}
+	chunkSize := size
+	if chunkSize > maxEntriesToCopy {
+		chunkSize = maxEntriesToCopy
+	}
+	indexVector := make([]uint32, chunkSize)
+
+	for numEntries < size {
+		numIndexes := size - numEntries
+		if numIndexes > chunkSize {
+			numIndexes = chunkSize
+		}
+		memutils.AsyncCopyDeviceToHost(unsafe.Pointer(&indexVector[0]), bc.indexVectorD.offset(numEntries*4).getPointer(),
+			numIndexes*4, stream, device)
+		memutils.WaitForCudaStream(stream, device)
+
+		// counts of entries are the differences of adjacent base counts.
+		first := int(indexVector[0])
+		baseCounts := make([]uint32, int(indexVector[numIndexes-1])-first+2)
+		memutils.AsyncCopyDeviceToHost(unsafe.Pointer(&baseCounts[0]), bc.baseCountD.offset(first*4).getPointer(),
+			len(baseCounts)*4, stream, device)
+		memutils.WaitForCudaStream(stream, device)
+
+		for _, index := range indexVector[:numIndexes] {
+			i := int(index) - first
+			count := int(baseCounts[i+1] - baseCounts[i])
+			if numRows+count > rowsToSkip {
+				return
+			}
+			numRows += count
+			numEntries++
+		}
+	}
+	return
+}
+
+// skipEntries removes the first n entries from the index vector.
+func (bc *oopkBatchContext) skipEntries(n int, stream unsafe.Pointer) {
+	if n == 0 {
+		return
+	}
+
+	bc.size -= n
+	if bc.size == 0 {
+		return
+	}
+	indexVectorD := deviceAllocate(bc.size*4, bc.device)
+	memutils.AsyncCopyDeviceToDevice(indexVectorD.getPointer(), bc.indexVectorD.offset(n*4).getPointer(), bc.size*4, stream, bc.device)
+	memutils.WaitForCudaStream(stream, bc.device)
+	deviceFreeAndSetNil(&bc.indexVectorD)
+	bc.indexVectorD = indexVectorD
+}
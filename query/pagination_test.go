@@ -0,0 +1,184 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"unsafe"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/memutils"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("pagination", func() {
+	ginkgo.It("continuationToken encode and decode should work", func() {
+		token := continuationToken{Shard: 1, Batch: -2147483647, Rows: 1000}
+		decoded, err := decodeContinuationToken(token.encode())
+		Ω(err).Should(BeNil())
+		Ω(*decoded).Should(Equal(token))
+
+		_, err = decodeContinuationToken("not a token")
+		Ω(err).ShouldNot(BeNil())
+		_, err = decodeContinuationToken(continuationToken{Rows: -1}.encode())
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("processPagination should work", func() {
+		createQueryContext := func(query AQLQuery, isNonAggregationQuery bool) *AQLQueryContext {
+			qc := &AQLQueryContext{
+				Query:                 &query,
+				TableScanners:         []*TableScanner{{Shards: []int{0, 1, 2}}},
+				isNonAggregationQuery: isNonAggregationQuery,
+			}
+			qc.processPagination()
+			return qc
+		}
+
+		qc := createQueryContext(AQLQuery{}, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.paging.start).Should(BeNil())
+
+		qc = createQueryContext(AQLQuery{Offset: 10}, false)
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc = createQueryContext(AQLQuery{Offset: -1}, true)
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc = createQueryContext(AQLQuery{ContinuationToken: "invalid"}, true)
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc = createQueryContext(AQLQuery{ContinuationToken: continuationToken{Shard: 3}.encode()}, true)
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		token := continuationToken{Shard: 1, Batch: 5, Rows: 20}
+		qc = createQueryContext(AQLQuery{Offset: 10, ContinuationToken: token.encode()}, true)
+		Ω(qc.Error).Should(BeNil())
+		Ω(*qc.paging.start).Should(Equal(token))
+		Ω(qc.paging.rowsToSkip).Should(Equal(10))
+		Ω(qc.TableScanners[0].Shards).Should(Equal([]int{1, 2}))
+
+		Ω(qc.isBeforeStart(1, 4)).Should(BeTrue())
+		Ω(qc.isBeforeStart(1, 5)).Should(BeFalse())
+		Ω(qc.isBeforeStart(2, 4)).Should(BeFalse())
+	})
+
+	ginkgo.It("skipRows should work", func() {
+		qc := &AQLQueryContext{isNonAggregationQuery: true}
+		qc.paging.start = &continuationToken{Shard: 0, Batch: 1, Rows: 2}
+		qc.paging.rowsToSkip = 3
+		e := NewBatchExecutor(qc, 1, nil, nil).(*NonAggrBatchExecutorImpl)
+
+		// rows of the start batch returned by previous pages are skipped first.
+		qc.OOPK.currentBatch.size = 4
+		Ω(e.skipRows()).Should(Equal(4))
+		Ω(qc.OOPK.currentBatch.size).Should(Equal(0))
+		Ω(qc.paging.rowsToSkip).Should(Equal(1))
+
+		// the rest of offset is applied to following batches.
+		e = NewBatchExecutor(qc, 2, nil, nil).(*NonAggrBatchExecutorImpl)
+		qc.OOPK.currentBatch.size = 0
+		Ω(e.skipRows()).Should(Equal(0))
+		Ω(qc.paging.rowsToSkip).Should(Equal(1))
+
+		qc.paging.rowsToSkip = 5
+		e = NewBatchExecutor(qc, 3, nil, nil).(*NonAggrBatchExecutorImpl)
+		qc.OOPK.currentBatch.size = 5
+		Ω(e.skipRows()).Should(Equal(5))
+		Ω(qc.paging.rowsToSkip).Should(Equal(0))
+		Ω(qc.paging.rowsToDrop).Should(Equal(0))
+	})
+
+	ginkgo.It("skipRows should work for compressed archive batches", func() {
+		// copyToDevice copies the uint32 values to device memory.
+		copyToDevice := func(values []uint32) devicePointer {
+			p := deviceAllocate(len(values)*4, 0)
+			memutils.AsyncCopyHostToDevice(p.getPointer(), unsafe.Pointer(&values[0]), len(values)*4, nil, 0)
+			return p
+		}
+
+		qc := &AQLQueryContext{isNonAggregationQuery: true}
+		qc.paging.rowsToSkip = 4
+		bc := &qc.OOPK.currentBatch
+		// counts of records are 2, 3, 1 and 4, the second one is filtered out.
+		bc.baseCountD = copyToDevice([]uint32{0, 2, 5, 6, 10})
+		bc.indexVectorD = copyToDevice([]uint32{0, 2, 3})
+		bc.size = 3
+		e := NewBatchExecutor(qc, -1, nil, nil).(*NonAggrBatchExecutorImpl)
+
+		// the first two entries of 3 rows are skipped on device, and the last
+		// row to skip is dropped from results.
+		Ω(e.skipRows()).Should(Equal(3))
+		Ω(bc.size).Should(Equal(1))
+		Ω(*(*uint32)(utils.MemAccess(bc.indexVectorD.getPointer(), 0))).Should(Equal(uint32(3)))
+		Ω(qc.paging.rowsToSkip).Should(Equal(0))
+		Ω(qc.paging.rowsToDrop).Should(Equal(1))
+		deviceFreeAndSetNil(&bc.baseCountD)
+		deviceFreeAndSetNil(&bc.indexVectorD)
+
+		// entries are copied to host in chunks.
+		size := maxEntriesToCopy + 10
+		baseCounts := make([]uint32, size+1)
+		indexVector := make([]uint32, size)
+		for i := range indexVector {
+			baseCounts[i+1] = uint32(i + 1)
+			indexVector[i] = uint32(i)
+		}
+		bc.baseCountD = copyToDevice(baseCounts)
+		bc.indexVectorD = copyToDevice(indexVector)
+		bc.size = size
+		numEntries, numRows := bc.countEntriesToSkip(maxEntriesToCopy+5, nil, 0)
+		Ω(numEntries).Should(Equal(maxEntriesToCopy + 5))
+		Ω(numRows).Should(Equal(maxEntriesToCopy + 5))
+		numEntries, numRows = bc.countEntriesToSkip(size*2, nil, 0)
+		Ω(numEntries).Should(Equal(size))
+		Ω(numRows).Should(Equal(size))
+		deviceFreeAndSetNil(&bc.baseCountD)
+		deviceFreeAndSetNil(&bc.indexVectorD)
+	})
+
+	ginkgo.It("Postprocess should return continuation token with results", func() {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{{Expr: "someField"}},
+			},
+			isNonAggregationQuery: true,
+		}
+		qc.OOPK = OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{ExprType: expr.Unsigned, DataType: memCom.Uint8},
+			},
+			DimRowBytes:          2,
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 0, 0, 1},
+			DimensionVectorIndex: []int{0},
+			ResultSize:           3,
+			dimensionVectorH:     unsafe.Pointer(&[]uint8{1, 2, 3, 1, 1, 1}[0]),
+		}
+		// the first row is skipped by offset.
+		qc.paging.rowsToDrop = 1
+		qc.paging.nextToken = continuationToken{Batch: 1, Rows: 3}.encode()
+
+		Ω(qc.Postprocess()).Should(Equal(queryCom.AQLQueryResult{
+			"headers":           []string{"someField"},
+			"matrixData":        [][]interface{}{{"2"}, {"3"}},
+			"continuationToken": qc.paging.nextToken,
+		}))
+		qc.Results = qc.Postprocess()
+		Ω(qc.FlattenResults().ContinuationToken).Should(Equal(qc.paging.nextToken))
+	})
+})
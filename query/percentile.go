@@ -0,0 +1,255 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/uber/aresdb/memutils"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"unsafe"
+)
+
+const (
+	// maxPercentileBufferRows is the max number of rows of percentile values
+	// buffered in device memory before they are added into sketches, unless a
+	// single batch has more rows.
+	maxPercentileBufferRows = 1 << 22
+	// percentileBufferBatches is the number of batches of percentile values
+	// buffered in device memory before they are added into sketches.
+	percentileBufferBatches = 4
+)
+
+// estimatePercentileBufferMemUsage returns the device memory used by the
+// percentile buffers for batches of batchSize rows, which are kept across
+// batches. The number of buffered rows is recorded so that the buffers never
+// grow beyond the estimation.
+func (qc *AQLQueryContext) estimatePercentileBufferMemUsage(batchSize int) int {
+	if !qc.OOPK.hasPercentile() {
+		return 0
+	}
+
+	rows := percentileBufferBatches * batchSize
+	if rows > maxPercentileBufferRows {
+		rows = maxPercentileBufferRows
+	}
+	if rows < batchSize {
+		rows = batchSize
+	}
+	if rows > qc.OOPK.percentileBufferRows {
+		qc.OOPK.percentileBufferRows = rows
+	}
+	return rows * (qc.OOPK.DimRowBytes + getPercentileRowBytes(qc.OOPK.Measures))
+}
+
+// getDimensionKey returns the key of the row in a dimension vector with the
+// capacity, which consists of the validity and the value (if valid) of each
+// dimension. Rows with same dimension values have the same key no matter
+// where they are stored.
+func getDimensionKey(dimVector unsafe.Pointer, row, capacity int, numDimsPerDimWidth queryCom.DimCountsPerDimWidth) string {
+	var key []byte
+	dimIndex := 0
+	dimBytes := 1 << uint(len(numDimsPerDimWidth)-1)
+	for _, numDims := range numDimsPerDimWidth {
+		for i := 0; i < int(numDims); i++ {
+			valueOffset, nullOffset := queryCom.GetDimensionStartOffsets(numDimsPerDimWidth, dimIndex, capacity)
+			valid := *(*uint8)(utils.MemAccess(dimVector, nullOffset+row))
+			key = append(key, valid)
+			if valid != 0 {
+				value := utils.MemAccess(dimVector, valueOffset+row*dimBytes)
+				key = append(key, memutils.MakeSliceFromCPtr(uintptr(value), dimBytes)...)
+			}
+			dimIndex++
+		}
+		dimBytes >>= 1
+	}
+	return string(key)
+}
+
+// bufferPercentileValues appends dimension and percentile measure values of
+// current batch to the percentile buffers in device memory, so that sketches
+// are built once after the final reduce. It should be called before sort and
+// reduce when values of current batch are still stored at
+// [resultSize, resultSize+size) of the dimension and measure vectors. Buffered
+// values are added into sketches when the buffers are full.
+func (qc *AQLQueryContext) bufferPercentileValues(stream unsafe.Pointer) {
+	bc := &qc.OOPK.currentBatch
+	if bc.size <= 0 {
+		return
+	}
+
+	if bc.percentileSize+bc.size > bc.percentileCapacity {
+		qc.buildPercentileSketches(stream)
+		if bc.size > bc.percentileCapacity {
+			qc.allocatePercentileBuffers()
+		}
+	}
+
+	copyDimensionRows(bc.percentileDimensionVectorD.getPointer(), bc.dimensionVectorD[0].getPointer(),
+		bc.percentileSize, bc.resultSize, bc.size, qc.OOPK.NumDimsPerDimWidth, bc.percentileCapacity, bc.resultCapacity,
+		memutils.AsyncCopyDeviceToDevice, stream, qc.Device)
+	percentileOffsets := getPercentileStartOffsets(qc.OOPK.Measures, bc.percentileCapacity)
+	for i, measureOffset := range getMeasureStartOffsets(qc.OOPK.Measures, bc.resultCapacity) {
+		measure := qc.OOPK.Measures[i]
+		if !measure.IsPercentile {
+			continue
+		}
+		memutils.AsyncCopyDeviceToDevice(
+			utils.MemAccess(bc.percentileMeasureVectorD.getPointer(), percentileOffsets[i]+bc.percentileSize*measure.MeasureBytes),
+			utils.MemAccess(bc.measureVectorD[0].getPointer(), measureOffset+bc.resultSize*measure.MeasureBytes),
+			bc.size*measure.MeasureBytes, stream, qc.Device)
+	}
+	bc.percentileSize += bc.size
+}
+
+// allocatePercentileBuffers allocates empty percentile buffers with the number
+// of rows reserved by the memory estimation, or the size of current batch if
+// it's larger.
+func (qc *AQLQueryContext) allocatePercentileBuffers() {
+	bc := &qc.OOPK.currentBatch
+	deviceFreeAndSetNil(&bc.percentileDimensionVectorD)
+	deviceFreeAndSetNil(&bc.percentileMeasureVectorD)
+
+	bc.percentileCapacity = qc.OOPK.percentileBufferRows
+	if bc.size > bc.percentileCapacity {
+		bc.percentileCapacity = bc.size
+	}
+	bc.percentileDimensionVectorD = deviceAllocate(bc.percentileCapacity*qc.OOPK.DimRowBytes, bc.device)
+	bc.percentileMeasureVectorD = deviceAllocate(bc.percentileCapacity*getPercentileRowBytes(qc.OOPK.Measures), bc.device)
+}
+
+// buildPercentileSketches copies the buffered percentile values to host memory
+// in one transfer and adds them into the sketches of their dimensions.
+func (qc *AQLQueryContext) buildPercentileSketches(stream unsafe.Pointer) {
+	bc := &qc.OOPK.currentBatch
+	if bc.percentileSize <= 0 {
+		return
+	}
+
+	if qc.OOPK.percentileSketches == nil {
+		qc.OOPK.percentileSketches = make([]map[string]*queryCom.TDigest, len(qc.OOPK.Measures))
+	}
+
+	dimVectorH := memutils.HostAlloc(bc.percentileSize * qc.OOPK.DimRowBytes)
+	defer memutils.HostFree(dimVectorH)
+	asyncCopyDimensionVector(dimVectorH, bc.percentileDimensionVectorD.getPointer(), bc.percentileSize, 0,
+		qc.OOPK.NumDimsPerDimWidth, bc.percentileSize, bc.percentileCapacity, memutils.AsyncCopyDeviceToHost, stream, qc.Device)
+
+	measureVectorH := memutils.HostAlloc(bc.percentileSize * getPercentileRowBytes(qc.OOPK.Measures))
+	defer memutils.HostFree(measureVectorH)
+	hostOffsets := getPercentileStartOffsets(qc.OOPK.Measures, bc.percentileSize)
+	for i, deviceOffset := range getPercentileStartOffsets(qc.OOPK.Measures, bc.percentileCapacity) {
+		measure := qc.OOPK.Measures[i]
+		if !measure.IsPercentile {
+			continue
+		}
+		memutils.AsyncCopyDeviceToHost(utils.MemAccess(measureVectorH, hostOffsets[i]),
+			utils.MemAccess(bc.percentileMeasureVectorD.getPointer(), deviceOffset),
+			bc.percentileSize*measure.MeasureBytes, stream, qc.Device)
+		if qc.OOPK.percentileSketches[i] == nil {
+			qc.OOPK.percentileSketches[i] = make(map[string]*queryCom.TDigest)
+		}
+	}
+	memutils.WaitForCudaStream(stream, qc.Device)
+
+	for row := 0; row < bc.percentileSize; row++ {
+		key := getDimensionKey(dimVectorH, row, bc.percentileSize, qc.OOPK.NumDimsPerDimWidth)
+		for i, measure := range qc.OOPK.Measures {
+			if !measure.IsPercentile {
+				continue
+			}
+			// Values are written as avg: float32 value followed by uint32 count,
+			// where count is 0 for null values.
+			valuePtr := utils.MemAccess(measureVectorH, hostOffsets[i]+row*measure.MeasureBytes)
+			count := *(*uint32)(utils.MemAccess(valuePtr, 4))
+			if count == 0 {
+				continue
+			}
+			sketch := qc.OOPK.percentileSketches[i][key]
+			if sketch == nil {
+				sketch = queryCom.NewTDigest(queryCom.DefaultTDigestCompression)
+				qc.OOPK.percentileSketches[i][key] = sketch
+			}
+			sketch.Add(float64(*(*float32)(valuePtr)), float64(count))
+		}
+	}
+	bc.percentileSize = 0
+}
+
+// getPercentileStartOffsets returns the start offset of each percentile
+// measure in the percentile measure vector with the capacity. Offsets of
+// other measures are not used.
+func getPercentileStartOffsets(measures []OOPKMeasure, capacity int) []int {
+	offsets := make([]int, len(measures))
+	offset := 0
+	for i, measure := range measures {
+		offsets[i] = offset
+		if measure.IsPercentile {
+			offset += capacity * measure.MeasureBytes
+		}
+	}
+	return offsets
+}
+
+// getPercentileRowBytes returns the sum number of bytes of all percentile
+// measure values.
+func getPercentileRowBytes(measures []OOPKMeasure) (rowBytes int) {
+	for _, measure := range measures {
+		if measure.IsPercentile {
+			rowBytes += measure.MeasureBytes
+		}
+	}
+	return
+}
+
+// copyDimensionRows copies length rows of dimension values starting at
+// fromOffset of fromDimVector to toOffset of toDimVector.
+func copyDimensionRows(toDimVector, fromDimVector unsafe.Pointer, toOffset, fromOffset, length int,
+	numDimsPerDimWidth queryCom.DimCountsPerDimWidth, toVectorCapacity, fromVectorCapacity int,
+	copyFunc memutils.AsyncMemCopyFunc, stream unsafe.Pointer, device int) {
+	ptrFrom, ptrTo := fromDimVector, toDimVector
+	numNullVectors := 0
+	dimBytes := 1 << uint(len(numDimsPerDimWidth)-1)
+	for _, numDims := range numDimsPerDimWidth {
+		for i := 0; i < int(numDims); i++ {
+			copyFunc(utils.MemAccess(ptrTo, dimBytes*toOffset), utils.MemAccess(ptrFrom, dimBytes*fromOffset),
+				length*dimBytes, stream, device)
+			ptrTo = utils.MemAccess(ptrTo, dimBytes*toVectorCapacity)
+			ptrFrom = utils.MemAccess(ptrFrom, dimBytes*fromVectorCapacity)
+		}
+		numNullVectors += int(numDims)
+		dimBytes >>= 1
+	}
+
+	// copy null bytes
+	for i := 0; i < numNullVectors; i++ {
+		copyFunc(utils.MemAccess(ptrTo, toOffset), utils.MemAccess(ptrFrom, fromOffset), length, stream, device)
+		ptrTo = utils.MemAccess(ptrTo, toVectorCapacity)
+		ptrFrom = utils.MemAccess(ptrFrom, fromVectorCapacity)
+	}
+}
+
+// getPercentile returns the estimated percentile of the measure for the
+// dimension key, nil if there is no value.
+func (qc *AQLQueryContext) getPercentile(measureIndex int, key string) *float64 {
+	if qc.OOPK.percentileSketches == nil {
+		return nil
+	}
+	sketch := qc.OOPK.percentileSketches[measureIndex][key]
+	if sketch == nil || sketch.Count() == 0 {
+		return nil
+	}
+	value := sketch.Quantile(qc.OOPK.Measures[measureIndex].Percentile)
+	return &value
+}
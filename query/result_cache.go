@@ -0,0 +1,195 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"container/list"
+	"encoding/json"
+	"github.com/uber/aresdb/memstore"
+	queryCom "github.com/uber/aresdb/query/common"
+	"sync"
+)
+
+// ResultCache caches results of queries over time ranges fully archived in
+// all shards. Such results only change when archive batches in the range are
+// changed by archiving, backfill or purge, which should be reported to
+// Invalidate.
+type ResultCache struct {
+	sync.Mutex
+	capacity int
+	// number of invalidations so far. Results of queries looked up before an
+	// invalidation are not cached as they may be computed from stale data.
+	version uint64
+	entries map[string]*list.Element
+	// entries from the most recently used to the least recently used.
+	lru *list.List
+}
+
+// resultCacheEntry stores results of a query and the archive batches it covers.
+type resultCacheEntry struct {
+	key          string
+	table        string
+	shards       []int
+	batchIDStart int
+	batchIDEnd   int
+	results      queryCom.AQLQueryResult
+}
+
+// resultCacheKey is the normalized compiled query for result cache.
+type resultCacheKey struct {
+	Query AQLQuery `json:"query"`
+	From  int64    `json:"from"`
+	To    int64    `json:"to"`
+}
+
+// NewResultCache creates a ResultCache holding results of up to capacity queries.
+func NewResultCache(capacity int) *ResultCache {
+	return &ResultCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get returns the cached results of the compiled query. The query is
+// remembered for Put if its results can be cached.
+func (c *ResultCache) Get(qc *AQLQueryContext, memStore memstore.MemStore) (queryCom.AQLQueryResult, bool) {
+	key, ok := qc.getResultCacheKey(memStore)
+	if !ok {
+		return nil, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	qc.resultCacheKey = key
+	qc.resultCacheVersion = c.version
+	if element, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(element)
+		return element.Value.(*resultCacheEntry).results, true
+	}
+	return nil, false
+}
+
+// Put caches the postprocessed results of the query looked up by Get, unless
+// archive batches are changed since then.
+func (c *ResultCache) Put(qc *AQLQueryContext) {
+	if qc.resultCacheKey == "" || qc.Error != nil || qc.Results == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if qc.resultCacheVersion != c.version {
+		return
+	}
+	if element, ok := c.entries[qc.resultCacheKey]; ok {
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	scanner := qc.TableScanners[0]
+	c.entries[qc.resultCacheKey] = c.lru.PushFront(&resultCacheEntry{
+		key:          qc.resultCacheKey,
+		table:        qc.Query.Table,
+		shards:       scanner.Shards,
+		batchIDStart: scanner.ArchiveBatchIDStart,
+		batchIDEnd:   scanner.ArchiveBatchIDEnd,
+		results:      qc.Results,
+	})
+	for c.lru.Len() > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// Invalidate removes results of queries covering archive batches
+// [batchIDStart, batchIDEnd) of the table shard. It should be registered as a
+// memstore.ArchiveChangeListener.
+func (c *ResultCache) Invalidate(table string, shardID, batchIDStart, batchIDEnd int) {
+	c.Lock()
+	defer c.Unlock()
+	c.version++
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*resultCacheEntry)
+		if entry.table == table && entry.batchIDStart < batchIDEnd && batchIDStart < entry.batchIDEnd {
+			for _, shard := range entry.shards {
+				if shard == shardID {
+					c.removeElement(element)
+					break
+				}
+			}
+		}
+		element = next
+	}
+}
+
+// Len returns the number of cached results.
+func (c *ResultCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+func (c *ResultCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*resultCacheEntry).key)
+}
+
+// getResultCacheKey returns the key of the compiled query for result cache,
+// and whether its results can be cached. Results can be cached only if the
+// time filter on the time column of the fact table ends before the archiving
+// cutoff of all shards, so no live batch is processed. Queries with joins,
+// including the timezone table, are never cached as dimension tables can be
+// changed at any time.
+func (qc *AQLQueryContext) getResultCacheKey(memStore memstore.MemStore) (string, bool) {
+	if qc.Error != nil || qc.ReturnHLLData || qc.CompareQuery != nil || len(qc.Query.Joins) > 0 {
+		return "", false
+	}
+
+	schema := qc.TableSchemaByName[qc.Query.Table]
+	if schema == nil || !schema.Schema.IsFactTable || qc.fromTime == nil || qc.toTime == nil ||
+		qc.OOPK.TimeFilters[1] == nil {
+		return "", false
+	}
+
+	to := qc.toTime.Time.Unix()
+	for _, shardID := range qc.TableScanners[0].Shards {
+		shard, err := memStore.GetTableShard(qc.Query.Table, shardID)
+		if err != nil {
+			return "", false
+		}
+		archiveStore := shard.ArchiveStore.GetCurrentVersion()
+		cutoff := archiveStore.ArchivingCutoff
+		archiveStore.Users.Done()
+		shard.Users.Done()
+		if int64(cutoff) < to {
+			return "", false
+		}
+	}
+
+	// Relative time filters are replaced by the absolute time range.
+	key := resultCacheKey{
+		Query: *qc.Query,
+		From:  qc.fromTime.Time.Unix(),
+		To:    to,
+	}
+	key.Query.TimeFilter.From, key.Query.TimeFilter.To = "", ""
+	key.Query.SQLQuery = ""
+	bytes, err := json.Marshal(key)
+	if err != nil {
+		return "", false
+	}
+	return string(bytes), true
+}
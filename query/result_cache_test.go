@@ -0,0 +1,158 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+)
+
+var _ = ginkgo.Describe("result cache", func() {
+	var memStore *memMocks.MemStore
+	var shard *memstore.TableShard
+	table := "table1"
+
+	// createQueryContext creates a compiled query over [from, to) days.
+	createQueryContext := func(from, to int) *AQLQueryContext {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:    table,
+				Measures: []Measure{{Expr: "count(*)"}},
+				TimeFilter: TimeFilter{
+					Column: "c0",
+					From:   "-1d",
+				},
+			},
+			TableSchemaByName: map[string]*memstore.TableSchema{
+				table: {Schema: metaCom.Table{Name: table, IsFactTable: true}},
+			},
+			TableScanners: []*TableScanner{
+				{Shards: []int{0}, ArchiveBatchIDStart: from, ArchiveBatchIDEnd: to},
+			},
+			fromTime: &alignedTime{Time: time.Unix(int64(from)*86400, 0).UTC(), Unit: "d"},
+			toTime:   &alignedTime{Time: time.Unix(int64(to)*86400, 0).UTC(), Unit: "d"},
+		}
+		qc.OOPK.TimeFilters[1] = &expr.BooleanLiteral{Val: true}
+		return qc
+	}
+
+	ginkgo.BeforeEach(func() {
+		shard = &memstore.TableShard{
+			ArchiveStore: &memstore.ArchiveStore{
+				CurrentVersion: memstore.NewArchiveStoreVersion(86400*2, nil),
+			},
+		}
+		memStore = new(memMocks.MemStore)
+		memStore.On("GetTableShard", table, 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+	})
+
+	ginkgo.It("caches results of queries over archived data", func() {
+		cache := NewResultCache(10)
+		qc := createQueryContext(0, 1)
+		results, found := cache.Get(qc, memStore)
+		Ω(found).Should(BeFalse())
+		Ω(results).Should(BeNil())
+
+		qc.Results = queryCom.AQLQueryResult{"0": 1.0}
+		cache.Put(qc)
+		Ω(cache.Len()).Should(Equal(1))
+
+		// Relative time filter is normalized to the absolute time range.
+		qc = createQueryContext(0, 1)
+		qc.Query.TimeFilter.From = "1970-01-01"
+		results, found = cache.Get(qc, memStore)
+		Ω(found).Should(BeTrue())
+		Ω(results).Should(Equal(queryCom.AQLQueryResult{"0": 1.0}))
+
+		// Time range not fully archived.
+		qc = createQueryContext(1, 3)
+		_, found = cache.Get(qc, memStore)
+		Ω(found).Should(BeFalse())
+		qc.Results = queryCom.AQLQueryResult{}
+		cache.Put(qc)
+		Ω(cache.Len()).Should(Equal(1))
+
+		// Queries with joins are not cached.
+		qc = createQueryContext(0, 1)
+		qc.Query.Joins = []Join{{Table: "table2", Alias: "t2"}}
+		qc.Results = queryCom.AQLQueryResult{}
+		cache.Get(qc, memStore)
+		cache.Put(qc)
+		Ω(cache.Len()).Should(Equal(1))
+	})
+
+	ginkgo.It("invalidates results when archive batches are changed", func() {
+		cache := NewResultCache(10)
+		qc1 := createQueryContext(0, 1)
+		qc2 := createQueryContext(1, 2)
+		for _, qc := range []*AQLQueryContext{qc1, qc2} {
+			cache.Get(qc, memStore)
+			qc.Results = queryCom.AQLQueryResult{}
+			cache.Put(qc)
+		}
+		Ω(cache.Len()).Should(Equal(2))
+
+		cache.Invalidate("table2", 0, 0, 2)
+		cache.Invalidate(table, 1, 0, 2)
+		cache.Invalidate(table, 0, 2, 3)
+		Ω(cache.Len()).Should(Equal(2))
+
+		cache.Invalidate(table, 0, 1, 2)
+		Ω(cache.Len()).Should(Equal(1))
+		_, found := cache.Get(createQueryContext(0, 1), memStore)
+		Ω(found).Should(BeTrue())
+
+		// Results looked up before an invalidation are not cached.
+		qc := createQueryContext(1, 2)
+		cache.Get(qc, memStore)
+		cache.Invalidate(table, 0, 5, 6)
+		qc.Results = queryCom.AQLQueryResult{}
+		cache.Put(qc)
+		Ω(cache.Len()).Should(Equal(1))
+	})
+
+	ginkgo.It("evicts least recently used results", func() {
+		cache := NewResultCache(2)
+		qcs := []*AQLQueryContext{createQueryContext(0, 1), createQueryContext(1, 2)}
+		for _, qc := range qcs {
+			cache.Get(qc, memStore)
+			qc.Results = queryCom.AQLQueryResult{}
+			cache.Put(qc)
+		}
+		_, found := cache.Get(createQueryContext(0, 1), memStore)
+		Ω(found).Should(BeTrue())
+
+		qc := createQueryContext(0, 2)
+		cache.Get(qc, memStore)
+		qc.Results = queryCom.AQLQueryResult{}
+		cache.Put(qc)
+		Ω(cache.Len()).Should(Equal(2))
+
+		_, found = cache.Get(createQueryContext(1, 2), memStore)
+		Ω(found).Should(BeFalse())
+		_, found = cache.Get(createQueryContext(0, 1), memStore)
+		Ω(found).Should(BeTrue())
+	})
+})
@@ -16,6 +16,7 @@
 #include <thrust/iterator/discard_iterator.h>
 #include <thrust/gather.h>
 #include <thrust/transform.h>
+#include <thrust/unique.h>
 #include <cstring>
 #include <algorithm>
 #include <exception>
@@ -240,21 +241,44 @@ int bindValueAndAggFunc(uint64_t *inputHashValues,
   }
 }
 
+// reduceKeys writes the index of the first element of each unique hash value
+// into outputIndexVector and returns number of unique hash values.
+int reduceKeys(uint64_t *inputHashValues, uint32_t *inputIndexVector,
+               uint32_t *outputIndexVector, int length,
+               cudaStream_t cudaStream) {
+  auto resEnd = thrust::unique_by_key_copy(GET_EXECUTION_POLICY(cudaStream),
+                                           inputHashValues,
+                                           inputHashValues + length,
+                                           inputIndexVector,
+                                           thrust::make_discard_iterator(),
+                                           outputIndexVector);
+  return resEnd.second - outputIndexVector;
+}
+
 int reduce(DimensionColumnVector inputKeys, uint8_t *inputValues,
            DimensionColumnVector outputKeys, uint8_t *outputValues,
            int valueBytes, int length, AggregateFunction aggFunc,
            cudaStream_t cudaStream) {
-  int outputLength = bindValueAndAggFunc(
-      inputKeys.HashValues,
-      inputKeys.IndexVector,
-      inputValues,
-      outputKeys.HashValues,
-      outputKeys.IndexVector,
-      outputValues,
-      valueBytes,
-      length,
-      aggFunc,
-      cudaStream);
+  int outputLength;
+  if (inputValues == nullptr) {
+    outputLength = reduceKeys(inputKeys.HashValues,
+                              inputKeys.IndexVector,
+                              outputKeys.IndexVector,
+                              length,
+                              cudaStream);
+  } else {
+    outputLength = bindValueAndAggFunc(
+        inputKeys.HashValues,
+        inputKeys.IndexVector,
+        inputValues,
+        outputKeys.HashValues,
+        outputKeys.IndexVector,
+        outputValues,
+        valueBytes,
+        length,
+        aggFunc,
+        cudaStream);
+  }
   DimensionColumnPermutateIterator iterIn(
       inputKeys.DimValues, outputKeys.IndexVector, inputKeys.VectorCapacity,
       outputLength, inputKeys.NumDimsPerDimWidth);
@@ -0,0 +1,39 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// AddColumn is ALTER TABLE ADD COLUMN statement
+type AddColumn struct {
+	// Statement is IStatement
+	IStatement
+	// Table is table name
+	Table string
+	// Column is definition of the new column
+	Column *ColumnDefinition
+}
+
+// NewAddColumn creates AddColumn
+func NewAddColumn(location *NodeLocation, table string, column *ColumnDefinition) *AddColumn {
+	return &AddColumn{
+		IStatement: NewStatement(location),
+		Table:      table,
+		Column:     column,
+	}
+}
+
+// Accept accepts visitor
+func (e *AddColumn) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitAddColumn(e, ctx)
+}
@@ -23,18 +23,39 @@ type AstVisitor interface {
 	// VisitAliasedRelation visits the node
 	VisitAliasedRelation(aliasedRelation *AliasedRelation, ctx interface{}) interface{}
 
+	// VisitAddColumn visits the node
+	VisitAddColumn(addColumn *AddColumn, ctx interface{}) interface{}
+
 	// VisitAllColumns visits the node
 	VisitAllColumns(allColumns *AllColumns, ctx interface{}) interface{}
 
+	// VisitColumnDefinition visits the node
+	VisitColumnDefinition(columnDefinition *ColumnDefinition, ctx interface{}) interface{}
+
+	// VisitCreateTable visits the node
+	VisitCreateTable(createTable *CreateTable, ctx interface{}) interface{}
+
+	// VisitDropColumn visits the node
+	VisitDropColumn(dropColumn *DropColumn, ctx interface{}) interface{}
+
+	// VisitDropTable visits the node
+	VisitDropTable(dropTable *DropTable, ctx interface{}) interface{}
+
 	// VisitExpression visits the node
 	VisitExpression(exp IExpression, ctx interface{}) interface{}
 
+	// VisitExplain visits the node
+	VisitExplain(explain *Explain, ctx interface{}) interface{}
+
 	// VisitGroupBy visits the node
 	VisitGroupBy(groupby *GroupBy, ctx interface{}) interface{}
 
 	// VisitExpression visits the node
 	VisitGroupingElement(groupElement IGroupingElement, ctx interface{}) interface{}
 
+	// VisitGroupingSets visits the node
+	VisitGroupingSets(groupingSets *GroupingSets, ctx interface{}) interface{}
+
 	// VisitIdentifier visits the node
 	VisitIdentifier(identifier *Identifier, ctx interface{}) interface{}
 
@@ -47,6 +68,9 @@ type AstVisitor interface {
 	// VisitOrderBy visits the node
 	VisitOrderBy(orderBy *OrderBy, ctx interface{}) interface{}
 
+	// VisitProperty visits the node
+	VisitProperty(property *Property, ctx interface{}) interface{}
+
 	// VisitQuery visits the node
 	VisitQuery(query *Query, ctx interface{}) interface{}
 
@@ -65,6 +89,15 @@ type AstVisitor interface {
 	// VisitSelectItem visits the node
 	VisitSelectItem(selectItem ISelectItem, ctx interface{}) interface{}
 
+	// VisitShowColumns visits the node
+	VisitShowColumns(showColumns *ShowColumns, ctx interface{}) interface{}
+
+	// VisitShowCreateTable visits the node
+	VisitShowCreateTable(showCreateTable *ShowCreateTable, ctx interface{}) interface{}
+
+	// VisitShowTables visits the node
+	VisitShowTables(showTables *ShowTables, ctx interface{}) interface{}
+
 	// VisitSimpleGroupBy visits the node
 	VisitSimpleGroupBy(simpleGroupBy *SimpleGroupBy, ctx interface{}) interface{}
 
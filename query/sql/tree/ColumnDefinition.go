@@ -0,0 +1,39 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// ColumnDefinition is column definition of CREATE TABLE and ADD COLUMN
+type ColumnDefinition struct {
+	// Node is INode
+	INode
+	// Name is column name
+	Name string
+	// Type is column type
+	Type string
+}
+
+// NewColumnDefinition creates ColumnDefinition
+func NewColumnDefinition(location *NodeLocation, name, typ string) *ColumnDefinition {
+	return &ColumnDefinition{
+		INode: NewNode(location),
+		Name:  name,
+		Type:  typ,
+	}
+}
+
+// Accept accepts visitor
+func (e *ColumnDefinition) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitColumnDefinition(e, ctx)
+}
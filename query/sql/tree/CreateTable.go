@@ -0,0 +1,45 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// CreateTable is CREATE TABLE statement
+type CreateTable struct {
+	// Statement is IStatement
+	IStatement
+	// Name is table name
+	Name string
+	// NotExists is flag of IF NOT EXISTS
+	NotExists bool
+	// Columns is list of column definitions
+	Columns []*ColumnDefinition
+	// Properties is list of table properties in WITH clause
+	Properties []*Property
+}
+
+// NewCreateTable creates CreateTable
+func NewCreateTable(location *NodeLocation, name string, notExists bool, columns []*ColumnDefinition, properties []*Property) *CreateTable {
+	return &CreateTable{
+		IStatement: NewStatement(location),
+		Name:       name,
+		NotExists:  notExists,
+		Columns:    columns,
+		Properties: properties,
+	}
+}
+
+// Accept accepts visitor
+func (e *CreateTable) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitCreateTable(e, ctx)
+}
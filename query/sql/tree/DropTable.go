@@ -0,0 +1,39 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// DropTable is DROP TABLE statement
+type DropTable struct {
+	// Statement is IStatement
+	IStatement
+	// Name is table name
+	Name string
+	// Exists is flag of IF EXISTS
+	Exists bool
+}
+
+// NewDropTable creates DropTable
+func NewDropTable(location *NodeLocation, name string, exists bool) *DropTable {
+	return &DropTable{
+		IStatement: NewStatement(location),
+		Name:       name,
+		Exists:     exists,
+	}
+}
+
+// Accept accepts visitor
+func (e *DropTable) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitDropTable(e, ctx)
+}
@@ -0,0 +1,35 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// GroupingSets is ROLLUP or GROUPING SETS
+type GroupingSets struct {
+	IGroupingElement
+	// Sets is dimension names of each grouping set
+	Sets [][]string
+}
+
+// NewGroupingSets creates GroupingSets
+func NewGroupingSets(location *NodeLocation, sets [][]string) *GroupingSets {
+	return &GroupingSets{
+		NewGroupingElement(location),
+		sets,
+	}
+}
+
+// Accept accepts visitor
+func (g *GroupingSets) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitGroupingSets(g, ctx)
+}
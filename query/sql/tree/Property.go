@@ -0,0 +1,39 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// Property is table property
+type Property struct {
+	// Node is INode
+	INode
+	// Name is property name
+	Name string
+	// Value is string, bool, int64 or float64 property value
+	Value interface{}
+}
+
+// NewProperty creates Property
+func NewProperty(location *NodeLocation, name string, value interface{}) *Property {
+	return &Property{
+		INode: NewNode(location),
+		Name:  name,
+		Value: value,
+	}
+}
+
+// Accept accepts visitor
+func (e *Property) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitProperty(e, ctx)
+}
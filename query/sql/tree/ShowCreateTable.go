@@ -0,0 +1,36 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// ShowCreateTable is SHOW CREATE TABLE statement
+type ShowCreateTable struct {
+	// Statement is IStatement
+	IStatement
+	// Table is table name
+	Table string
+}
+
+// NewShowCreateTable creates ShowCreateTable
+func NewShowCreateTable(location *NodeLocation, table string) *ShowCreateTable {
+	return &ShowCreateTable{
+		IStatement: NewStatement(location),
+		Table:      table,
+	}
+}
+
+// Accept accepts visitor
+func (e *ShowCreateTable) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitShowCreateTable(e, ctx)
+}
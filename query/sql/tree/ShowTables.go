@@ -0,0 +1,39 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// ShowTables is SHOW TABLES statement
+type ShowTables struct {
+	// Statement is IStatement
+	IStatement
+	// LikePattern is pattern of table names, empty if not specified
+	LikePattern string
+	// Escape is escape character of LikePattern, empty if not specified
+	Escape string
+}
+
+// NewShowTables creates ShowTables
+func NewShowTables(location *NodeLocation, likePattern, escape string) *ShowTables {
+	return &ShowTables{
+		IStatement:  NewStatement(location),
+		LikePattern: likePattern,
+		Escape:      escape,
+	}
+}
+
+// Accept accepts visitor
+func (e *ShowTables) Accept(visitor AstVisitor, ctx interface{}) interface{} {
+	return visitor.VisitShowTables(e, ctx)
+}
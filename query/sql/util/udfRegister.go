@@ -93,3 +93,11 @@ var AggregateFunctions = map[string]bool{
 	"min":   true,
 	"hll":   true,
 }
+
+// WindowFunctions is a set of call names that are window functions applied to
+// aggregate functions over the time dimension
+var WindowFunctions = map[string]bool{
+	"cumsum":     true,
+	"moving_avg": true,
+	"rate":       true,
+}
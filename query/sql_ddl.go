@@ -0,0 +1,264 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query/sql/tree"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// isFactTable property of CREATE TABLE.
+	propertyIsFactTable = "isFactTable"
+	// comma separated primary key column names.
+	propertyPrimaryKeyColumns = "primaryKeyColumns"
+	// comma separated archiving sort column names.
+	propertyArchivingSortColumns = "archivingSortColumns"
+)
+
+// sqlColumnTypes maps lower case SQL type names to column data types. Data
+// type names are accepted along with common SQL type names.
+var sqlColumnTypes = map[string]string{
+	"bool":      metaCom.Bool,
+	"boolean":   metaCom.Bool,
+	"int8":      metaCom.Int8,
+	"tinyint":   metaCom.Int8,
+	"uint8":     metaCom.Uint8,
+	"int16":     metaCom.Int16,
+	"smallint":  metaCom.Int16,
+	"uint16":    metaCom.Uint16,
+	"int32":     metaCom.Int32,
+	"int":       metaCom.Int32,
+	"integer":   metaCom.Int32,
+	"uint32":    metaCom.Uint32,
+	"int64":     metaCom.Int64,
+	"bigint":    metaCom.Int64,
+	"float32":   metaCom.Float32,
+	"float":     metaCom.Float32,
+	"real":      metaCom.Float32,
+	"smallenum": metaCom.SmallEnum,
+	"bigenum":   metaCom.BigEnum,
+	"uuid":      metaCom.UUID,
+	"geopoint":  metaCom.GeoPoint,
+	"geoshape":  metaCom.GeoShape,
+}
+
+// ExecuteDDL applies the schema change statement returned by ParseStatement to
+// the metastore. The schema is checked by the validator before any change is
+// made, a new validator should be used for each statement. applied is false if
+// the statement is skipped because of IF [NOT] EXISTS.
+func ExecuteDDL(statement tree.IStatement, mutator metastore.TableSchemaMutator, validator metastore.TableSchemaValidator) (applied bool, err error) {
+	switch s := statement.(type) {
+	case *tree.CreateTable:
+		return createTable(s, mutator, validator)
+	case *tree.DropTable:
+		return dropTable(s, mutator, validator)
+	case *tree.AddColumn:
+		return true, addColumn(s, mutator, validator)
+	case *tree.DropColumn:
+		return true, dropColumn(s, mutator, validator)
+	}
+	return false, utils.StackError(nil, "unsupported schema change statement %T", statement)
+}
+
+func createTable(s *tree.CreateTable, mutator metastore.TableSchemaMutator, validator metastore.TableSchemaValidator) (bool, error) {
+	exists, err := tableExists(s.Name, mutator)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		if s.NotExists {
+			return false, nil
+		}
+		return false, metastore.ErrTableAlreadyExist
+	}
+
+	table := metaCom.Table{
+		Name:    s.Name,
+		Columns: make([]metaCom.Column, len(s.Columns)),
+		Config:  metastore.DefaultTableConfig,
+	}
+	for i, c := range s.Columns {
+		if table.Columns[i], err = newColumn(c); err != nil {
+			return false, err
+		}
+	}
+
+	configs := make(map[string]interface{})
+	configNames := getTableConfigNames()
+	for _, property := range s.Properties {
+		switch name := strings.ToLower(property.Name); name {
+		case strings.ToLower(propertyIsFactTable):
+			isFactTable, ok := property.Value.(bool)
+			if !ok {
+				return false, utils.StackError(nil, "invalid value %v of property %s", property.Value, property.Name)
+			}
+			table.IsFactTable = isFactTable
+		case strings.ToLower(propertyPrimaryKeyColumns):
+			if table.PrimaryKeyColumns, err = getColumnIDs(table.Columns, property); err != nil {
+				return false, err
+			}
+		case strings.ToLower(propertyArchivingSortColumns):
+			if table.ArchivingSortColumns, err = getColumnIDs(table.Columns, property); err != nil {
+				return false, err
+			}
+		default:
+			configName, ok := configNames[name]
+			if !ok {
+				return false, utils.StackError(nil, "unknown table property %s", property.Name)
+			}
+			configs[configName] = property.Value
+		}
+	}
+
+	// table configs are set by their json names.
+	configsJSON, _ := json.Marshal(configs)
+	if err = json.Unmarshal(configsJSON, &table.Config); err != nil {
+		return false, utils.StackError(err, "invalid table config properties")
+	}
+
+	validator.SetNewTable(table)
+	if err = validator.Validate(); err != nil {
+		return false, err
+	}
+	return true, mutator.CreateTable(&table)
+}
+
+func dropTable(s *tree.DropTable, mutator metastore.TableSchemaMutator, validator metastore.TableSchemaValidator) (bool, error) {
+	exists, err := tableExists(s.Name, mutator)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		if s.Exists {
+			return false, nil
+		}
+		return false, metastore.ErrTableDoesNotExist
+	}
+
+	table, err := mutator.GetTable(s.Name)
+	if err != nil {
+		return false, err
+	}
+	validator.SetNewTable(*table)
+	if err = validator.Validate(); err != nil {
+		return false, err
+	}
+	return true, mutator.DeleteTable(s.Name)
+}
+
+func addColumn(s *tree.AddColumn, mutator metastore.TableSchemaMutator, validator metastore.TableSchemaValidator) error {
+	table, err := mutator.GetTable(s.Table)
+	if err != nil {
+		return err
+	}
+
+	column, err := newColumn(s.Column)
+	if err != nil {
+		return err
+	}
+
+	newTable := *table
+	newTable.Columns = append(append([]metaCom.Column{}, table.Columns...), column)
+	validator.SetOldTable(*table)
+	validator.SetNewTable(newTable)
+	if err = validator.Validate(); err != nil {
+		return err
+	}
+	return mutator.AddColumn(s.Table, column, false)
+}
+
+func dropColumn(s *tree.DropColumn, mutator metastore.TableSchemaMutator, validator metastore.TableSchemaValidator) error {
+	table, err := mutator.GetTable(s.Table)
+	if err != nil {
+		return err
+	}
+
+	newTable := *table
+	newTable.Columns = append([]metaCom.Column{}, table.Columns...)
+	columnID := getColumnID(newTable.Columns, s.Column)
+	if columnID < 0 {
+		return metastore.ErrColumnDoesNotExist
+	}
+	newTable.Columns[columnID].Deleted = true
+	validator.SetOldTable(*table)
+	validator.SetNewTable(newTable)
+	if err = validator.Validate(); err != nil {
+		return err
+	}
+	return mutator.DeleteColumn(s.Table, s.Column)
+}
+
+func tableExists(name string, mutator metastore.TableSchemaMutator) (bool, error) {
+	tables, err := mutator.ListTables()
+	if err != nil {
+		return false, err
+	}
+	return utils.IndexOfStr(tables, name) >= 0, nil
+}
+
+// newColumn creates the column of the column definition.
+func newColumn(c *tree.ColumnDefinition) (metaCom.Column, error) {
+	dataType, ok := sqlColumnTypes[strings.ToLower(c.Type)]
+	if !ok {
+		return metaCom.Column{}, utils.StackError(nil, "unsupported type %s of column %s", c.Type, c.Name)
+	}
+	return metaCom.Column{Name: c.Name, Type: dataType}, nil
+}
+
+// getColumnID returns the ID of the non deleted column, or -1 if not found.
+func getColumnID(columns []metaCom.Column, name string) int {
+	for id, column := range columns {
+		if column.Name == name && !column.Deleted {
+			return id
+		}
+	}
+	return -1
+}
+
+// getColumnIDs returns the IDs of columns in the comma separated property value.
+func getColumnIDs(columns []metaCom.Column, property *tree.Property) ([]int, error) {
+	value, ok := property.Value.(string)
+	if !ok {
+		return nil, utils.StackError(nil, "invalid value %v of property %s", property.Value, property.Name)
+	}
+
+	var columnIDs []int
+	for _, name := range strings.Split(value, ",") {
+		columnID := getColumnID(columns, strings.TrimSpace(name))
+		if columnID < 0 {
+			return nil, utils.StackError(nil, "unknown column %s in property %s", name, property.Name)
+		}
+		columnIDs = append(columnIDs, columnID)
+	}
+	return columnIDs, nil
+}
+
+// getTableConfigNames returns json names of table configs by lower case names.
+func getTableConfigNames() map[string]string {
+	names := make(map[string]string)
+	configType := reflect.TypeOf(metaCom.TableConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		name := strings.Split(configType.Field(i).Tag.Get("json"), ",")[0]
+		names[strings.ToLower(name)] = name
+	}
+	return names
+}
@@ -0,0 +1,137 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+)
+
+var _ = ginkgo.Describe("SQL DDL", func() {
+	logger := common.NewLoggerFactory().GetDefaultLogger()
+
+	var mutator *metaMocks.TableSchemaMutator
+	ginkgo.BeforeEach(func() {
+		mutator = &metaMocks.TableSchemaMutator{}
+	})
+
+	// whether the last executed statement is applied.
+	var applied bool
+	execute := func(sql string) error {
+		statement, err := ParseStatement(sql, logger)
+		Ω(err).Should(BeNil())
+		applied, err = ExecuteDDL(statement.DDL, mutator, metastore.NewTableSchameValidator())
+		return err
+	}
+
+	table := metaCom.Table{
+		Name: "trips",
+		Columns: []metaCom.Column{
+			{Name: "request_at", Type: metaCom.Uint32},
+			{Name: "city_id", Type: metaCom.Int32},
+		},
+		PrimaryKeyColumns:    []int{1},
+		IsFactTable:          true,
+		ArchivingSortColumns: []int{1},
+		Config:               metastore.DefaultTableConfig,
+	}
+
+	ginkgo.It("creates table", func() {
+		mutator.On("ListTables").Return([]string{"drivers"}, nil)
+		mutator.On("CreateTable", mock.Anything).Return(nil).Once()
+		err := execute(`CREATE TABLE trips (request_at Uint32, city_id INT)
+			WITH (isFactTable = true, primaryKeyColumns = 'city_id', ArchivingSortColumns = 'city_id', batchSize = 1000,
+			allowMissingEventTime = true)`)
+		Ω(err).Should(BeNil())
+		Ω(applied).Should(BeTrue())
+
+		expected := table
+		expected.Config.BatchSize = 1000
+		expected.Config.AllowMissingEventTime = true
+		mutator.AssertCalled(ginkgo.GinkgoT(), "CreateTable", &expected)
+
+		Ω(execute(`CREATE TABLE IF NOT EXISTS drivers (id UUID) WITH (primaryKeyColumns = 'id')`)).Should(BeNil())
+		Ω(applied).Should(BeFalse())
+		Ω(execute(`CREATE TABLE drivers (id UUID) WITH (primaryKeyColumns = 'id')`)).Should(Equal(metastore.ErrTableAlreadyExist))
+		mutator.AssertNumberOfCalls(ginkgo.GinkgoT(), "CreateTable", 1)
+	})
+
+	ginkgo.It("validates table to create", func() {
+		mutator.On("ListTables").Return([]string{}, nil)
+		Ω(execute(`CREATE TABLE trips (id UUID)`)).Should(Equal(metastore.ErrMissingPrimaryKey))
+		Ω(execute(`CREATE TABLE trips (id VARCHAR) WITH (primaryKeyColumns = 'id')`)).ShouldNot(BeNil())
+		Ω(execute(`CREATE TABLE trips (id UUID) WITH (primaryKeyColumns = 'uuid')`)).ShouldNot(BeNil())
+		Ω(execute(`CREATE TABLE trips (id UUID) WITH (primaryKeyColumns = 'id', isFactTable = 1)`)).ShouldNot(BeNil())
+		Ω(execute(`CREATE TABLE trips (id UUID) WITH (primaryKeyColumns = 'id', batchSize = 'large')`)).ShouldNot(BeNil())
+		Ω(execute(`CREATE TABLE trips (id UUID) WITH (primaryKeyColumns = 'id', unknown = 1)`)).ShouldNot(BeNil())
+		Ω(execute(`CREATE TABLE trips (id UUID) WITH (primaryKeyColumns = 'id', isFactTable = true)`)).
+			Should(Equal(metastore.ErrMissingTimeColumn))
+		mutator.AssertNotCalled(ginkgo.GinkgoT(), "CreateTable", mock.Anything)
+	})
+
+	ginkgo.It("drops table", func() {
+		mutator.On("ListTables").Return([]string{"trips", "cities"}, nil)
+		mutator.On("GetTable", "trips").Return(&table, nil)
+		mutator.On("DeleteTable", "trips").Return(nil).Once()
+		Ω(execute(`DROP TABLE trips`)).Should(BeNil())
+		Ω(applied).Should(BeTrue())
+		Ω(execute(`DROP TABLE IF EXISTS drivers`)).Should(BeNil())
+		Ω(applied).Should(BeFalse())
+		Ω(execute(`DROP TABLE drivers`)).Should(Equal(metastore.ErrTableDoesNotExist))
+
+		// tables with invalid schemas are not dropped.
+		mutator.On("GetTable", "cities").Return(&metaCom.Table{
+			Name:    "cities",
+			Columns: []metaCom.Column{{Name: "id", Type: metaCom.Int32}},
+			Config:  metastore.DefaultTableConfig,
+		}, nil)
+		Ω(execute(`DROP TABLE cities`)).Should(Equal(metastore.ErrMissingPrimaryKey))
+		mutator.AssertNumberOfCalls(ginkgo.GinkgoT(), "DeleteTable", 1)
+	})
+
+	ginkgo.It("adds column", func() {
+		mutator.On("GetTable", "trips").Return(&table, nil)
+		mutator.On("AddColumn", "trips", metaCom.Column{Name: "fare", Type: metaCom.Float32}, false).Return(nil).Once()
+		Ω(execute(`ALTER TABLE trips ADD COLUMN fare REAL`)).Should(BeNil())
+		Ω(execute(`ALTER TABLE trips ADD COLUMN city_id Int16`)).Should(Equal(metastore.ErrDuplicatedColumnName))
+		mutator.AssertNumberOfCalls(ginkgo.GinkgoT(), "AddColumn", 1)
+		Ω(table.Columns).Should(HaveLen(2))
+	})
+
+	ginkgo.It("drops column", func() {
+		mutator.On("GetTable", "trips").Return(&table, nil)
+		Ω(execute(`ALTER TABLE trips DROP COLUMN fare`)).Should(Equal(metastore.ErrColumnDoesNotExist))
+		Ω(execute(`ALTER TABLE trips DROP COLUMN city_id`)).Should(Equal(metastore.ErrColumnDeleted))
+		mutator.AssertNotCalled(ginkgo.GinkgoT(), "DeleteColumn", mock.Anything, mock.Anything)
+
+		mutator.On("GetTable", "drivers").Return(&metaCom.Table{
+			Name: "drivers",
+			Columns: []metaCom.Column{
+				{Name: "id", Type: metaCom.UUID},
+				{Name: "city_id", Type: metaCom.Int32},
+			},
+			PrimaryKeyColumns: []int{0},
+			Config:            metastore.DefaultTableConfig,
+		}, nil)
+		mutator.On("DeleteColumn", "drivers", "city_id").Return(nil).Once()
+		Ω(execute(`ALTER TABLE drivers DROP COLUMN city_id`)).Should(BeNil())
+		Ω(table.Columns[1].Deleted).Should(BeFalse())
+	})
+})
@@ -0,0 +1,192 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uber/aresdb/memstore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/sql/tree"
+	"github.com/uber/aresdb/utils"
+)
+
+// ExecuteMetadataStatement returns the query context with results of the
+// metadata statement returned by ParseStatement, which are read from table
+// schemas of the memstore. Results have headers and matrixData like results of
+// non aggregation queries, errors are set to qc.Error.
+func ExecuteMetadataStatement(statement tree.IStatement, store memstore.MemStore) *AQLQueryContext {
+	qc := &AQLQueryContext{Query: &AQLQuery{}}
+
+	store.RLock()
+	defer store.RUnlock()
+	schemas := store.GetSchemas()
+
+	var schema *memstore.TableSchema
+	switch s := statement.(type) {
+	case *tree.ShowTables:
+		qc.Results, qc.Error = showTables(s, schemas)
+		return qc
+	case *tree.ShowColumns:
+		qc.Query.Table = s.Table
+	case *tree.ShowCreateTable:
+		qc.Query.Table = s.Table
+	default:
+		qc.Error = utils.StackError(nil, "unsupported metadata statement %T", statement)
+		return qc
+	}
+
+	if schema = schemas[qc.Query.Table]; schema == nil {
+		qc.Error = utils.StackError(nil, "unknown table %s", qc.Query.Table)
+		return qc
+	}
+	schema.RLock()
+	defer schema.RUnlock()
+
+	if _, ok := statement.(*tree.ShowColumns); ok {
+		qc.Results = showColumns(schema)
+	} else {
+		qc.Results = showCreateTable(&schema.Schema)
+	}
+	return qc
+}
+
+// showTables returns sorted names of tables matching the like pattern.
+func showTables(s *tree.ShowTables, schemas map[string]*memstore.TableSchema) (queryCom.AQLQueryResult, error) {
+	var re *regexp.Regexp
+	if s.LikePattern != "" {
+		escape := []rune(s.Escape)
+		if len(escape) > 1 {
+			return nil, utils.StackError(nil, "invalid escape %s of like pattern", s.Escape)
+		}
+		var escapeChar rune
+		if len(escape) == 1 {
+			escapeChar = escape[0]
+		}
+
+		var err error
+		if re, err = likePatternToRegexp(s.LikePattern, escapeChar); err != nil {
+			return nil, err
+		}
+	}
+
+	tables := make([]string, 0, len(schemas))
+	for table := range schemas {
+		if re == nil || re.MatchString(table) {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+
+	result := queryCom.AQLQueryResult{}
+	result.SetHeaders([]string{"table"})
+	result[queryCom.MatrixDataKey] = [][]interface{}{}
+	for i := range tables {
+		result.Append([]*string{&tables[i]})
+	}
+	return result, nil
+}
+
+// showColumns returns name, data type, whether in primary key and number of
+// enum cases of each column not deleted.
+func showColumns(schema *memstore.TableSchema) queryCom.AQLQueryResult {
+	result := queryCom.AQLQueryResult{}
+	result.SetHeaders([]string{"column", "type", "primaryKey", "enumCases"})
+	result[queryCom.MatrixDataKey] = [][]interface{}{}
+
+	primaryKeys := make(map[int]bool)
+	for _, columnID := range schema.Schema.PrimaryKeyColumns {
+		primaryKeys[columnID] = true
+	}
+	for columnID := range schema.Schema.Columns {
+		column := &schema.Schema.Columns[columnID]
+		if column.Deleted {
+			continue
+		}
+
+		primaryKey := strconv.FormatBool(primaryKeys[columnID])
+		var enumCases *float64
+		if column.IsEnumColumn() {
+			numCases := float64(len(schema.EnumDicts[column.Name].ReverseDict))
+			enumCases = &numCases
+		}
+		result.AppendWithMeasures([]*string{&column.Name, &column.Type, &primaryKey}, []*float64{enumCases})
+	}
+	return result
+}
+
+// showCreateTable returns the CREATE TABLE statement of the table. Deleted
+// columns and column options other than data types are not included, so
+// column IDs and options of the created table can be different.
+func showCreateTable(table *metaCom.Table) queryCom.AQLQueryResult {
+	columnNames := make([]string, 0, len(table.Columns))
+	for _, column := range table.Columns {
+		if !column.Deleted {
+			columnNames = append(columnNames, fmt.Sprintf("%s %s", quoteIdentifier(column.Name), column.Type))
+		}
+	}
+
+	properties := []string{fmt.Sprintf("%s = %t", propertyIsFactTable, table.IsFactTable)}
+	getColumnNames := func(columnIDs []int) string {
+		names := make([]string, len(columnIDs))
+		for i, columnID := range columnIDs {
+			names[i] = table.Columns[columnID].Name
+		}
+		return quoteString(strings.Join(names, ","))
+	}
+	properties = append(properties, fmt.Sprintf("%s = %s", propertyPrimaryKeyColumns, getColumnNames(table.PrimaryKeyColumns)))
+	if len(table.ArchivingSortColumns) > 0 {
+		properties = append(properties, fmt.Sprintf("%s = %s", propertyArchivingSortColumns, getColumnNames(table.ArchivingSortColumns)))
+	}
+
+	// table configs are named by their json names, zero values are omitted
+	// like json.
+	config := reflect.ValueOf(table.Config)
+	for i := 0; i < config.NumField(); i++ {
+		value := config.Field(i)
+		if value.Interface() == reflect.Zero(value.Type()).Interface() {
+			continue
+		}
+		name := strings.Split(config.Type().Field(i).Tag.Get("json"), ",")[0]
+		properties = append(properties, fmt.Sprintf("%s = %v", name, value.Interface()))
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "CREATE TABLE %s (\n  %s\n)\nWITH (\n  %s\n)", quoteIdentifier(table.Name),
+		strings.Join(columnNames, ",\n  "), strings.Join(properties, ",\n  "))
+	statement := buffer.String()
+
+	result := queryCom.AQLQueryResult{}
+	result.SetHeaders([]string{"createTable"})
+	result.Append([]*string{&statement})
+	return result
+}
+
+// quoteIdentifier returns the double quoted identifier.
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+// quoteString returns the single quoted string literal.
+func quoteString(str string) string {
+	return "'" + strings.Replace(str, "'", "''", -1) + "'"
+}
@@ -0,0 +1,146 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/memstore"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+	queryCom "github.com/uber/aresdb/query/common"
+)
+
+var _ = ginkgo.Describe("SQL metadata", func() {
+	logger := common.NewLoggerFactory().GetDefaultLogger()
+
+	table := metaCom.Table{
+		Name: "trips",
+		Columns: []metaCom.Column{
+			{Name: "request_at", Type: metaCom.Uint32},
+			{Name: "city_id", Type: metaCom.Int32},
+			{Name: "status", Type: metaCom.SmallEnum},
+			{Name: "fare", Type: metaCom.Float32},
+			{Name: "driver_id", Type: metaCom.UUID, Deleted: true},
+		},
+		PrimaryKeyColumns:    []int{1},
+		IsFactTable:          true,
+		ArchivingSortColumns: []int{1, 2},
+		Config:               metastore.DefaultTableConfig,
+	}
+	table.Config.AllowMissingEventTime = true
+
+	var memStore *memMocks.MemStore
+	ginkgo.BeforeEach(func() {
+		schema := memstore.NewTableSchema(&table)
+		schema.EnumDicts["status"] = memstore.EnumDict{ReverseDict: []string{"completed", "canceled"}}
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{
+			"trips":   schema,
+			"drivers": memstore.NewTableSchema(&metaCom.Table{Name: "drivers"}),
+			"cities":  memstore.NewTableSchema(&metaCom.Table{Name: "cities"}),
+		})
+	})
+
+	execute := func(sql string) *AQLQueryContext {
+		statement, err := ParseStatement(sql, logger)
+		Ω(err).Should(BeNil())
+		return ExecuteMetadataStatement(statement.Metadata, memStore)
+	}
+
+	ginkgo.It("SHOW TABLES should work", func() {
+		qc := execute(`SHOW TABLES`)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Results).Should(Equal(queryCom.AQLQueryResult{
+			"headers":    []string{"table"},
+			"matrixData": [][]interface{}{{"cities"}, {"drivers"}, {"trips"}},
+		}))
+
+		qc = execute(`SHOW TABLES LIKE '%r_p%'`)
+		Ω(qc.Results[queryCom.MatrixDataKey]).Should(Equal([][]interface{}{{"trips"}}))
+		qc = execute(`SHOW TABLES LIKE 'trips\_%' ESCAPE '\'`)
+		Ω(qc.Results[queryCom.MatrixDataKey]).Should(BeEmpty())
+
+		_, err := ParseStatement(`SHOW TABLES FROM ares`, logger)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("SHOW COLUMNS and DESCRIBE should work", func() {
+		expected := queryCom.AQLQueryResult{
+			"headers": []string{"column", "type", "primaryKey", "enumCases"},
+			"matrixData": [][]interface{}{
+				{"request_at", "Uint32", "false", nil},
+				{"city_id", "Int32", "true", nil},
+				{"status", "SmallEnum", "false", 2.0},
+				{"fare", "Float32", "false", nil},
+			},
+		}
+		for _, sql := range []string{`SHOW COLUMNS FROM trips`, `DESCRIBE trips`, `DESC trips`} {
+			qc := execute(sql)
+			Ω(qc.Error).Should(BeNil())
+			Ω(qc.Results).Should(Equal(expected))
+		}
+
+		qc := execute(`DESCRIBE unknown`)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Query.Table).Should(Equal("unknown"))
+	})
+
+	ginkgo.It("SHOW CREATE TABLE should work", func() {
+		qc := execute(`SHOW CREATE TABLE trips`)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Results[queryCom.HeadersKey]).Should(Equal([]string{"createTable"}))
+		createTable := qc.Results[queryCom.MatrixDataKey].([][]interface{})[0][0].(string)
+		Ω(createTable).Should(HavePrefix("CREATE TABLE \"trips\" (\n  \"request_at\" Uint32,\n  \"city_id\" Int32,"))
+		Ω(createTable).ShouldNot(ContainSubstring("driver_id"))
+
+		// the statement creates the same table except deleted columns.
+		statement, err := ParseStatement(createTable, logger)
+		Ω(err).Should(BeNil())
+		mutator := &metaMocks.TableSchemaMutator{}
+		mutator.On("ListTables").Return([]string{}, nil)
+		mutator.On("CreateTable", mock.Anything).Return(nil)
+		applied, err := ExecuteDDL(statement.DDL, mutator, metastore.NewTableSchameValidator())
+		Ω(err).Should(BeNil())
+		Ω(applied).Should(BeTrue())
+
+		expected := table
+		expected.Columns = table.Columns[:4]
+		mutator.AssertCalled(ginkgo.GinkgoT(), "CreateTable", &expected)
+	})
+
+	ginkgo.It("likePatternToRegexp should work", func() {
+		re, err := likePatternToRegexp("a%b_.", 0)
+		Ω(err).Should(BeNil())
+		Ω(re.String()).Should(Equal(`(?s)^a.*b.\.$`))
+
+		re, err = likePatternToRegexp("a!%!!", '!')
+		Ω(err).Should(BeNil())
+		Ω(re.MatchString("a%!")).Should(BeTrue())
+
+		re, err = likePatternToRegexp(`a\%`, 0)
+		Ω(err).Should(BeNil())
+		Ω(re.MatchString(`a\bc`)).Should(BeTrue())
+
+		_, err = likePatternToRegexp("a!", '!')
+		Ω(err).ShouldNot(BeNil())
+	})
+})
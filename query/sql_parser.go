@@ -88,7 +88,11 @@ type SQL2AqlContext struct {
 	// MapOrderBy is a mapping table. key=generateKey(...) value=arrayOfSortField
 	MapOrderBy map[int][]SortField
 	// MapLimit is a mapping table. key=generateKey(...) value=arrayOfLimit
-	MapLimit           map[int]int
+	MapLimit map[int]int
+	// MapHavingFilters is a mapping table. key=generateKey(...) value=arrayOfHavingFilter
+	MapHavingFilters map[int][]string
+	// MapGroupingSets is a mapping table. key=generateKey(...) value=arrayOfGroupingSet
+	MapGroupingSets    map[int][][]string
 	mapKey             int
 	timeNow            int64
 	timeFilter         TimeFilter
@@ -153,6 +157,38 @@ func (v *ASTBuilder) getQualifiedName(ctx antlrgen.IQualifiedNameContext) *tree.
 	return result
 }
 
+// getTableName returns the name of table or column in DDL statements, which
+// can not be qualified by catalog or schema.
+func (v *ASTBuilder) getTableName(ctx antlrgen.IQualifiedNameContext) string {
+	name := v.getQualifiedName(ctx)
+	if len(name.OriginalParts) != 1 {
+		location := v.getLocation(ctx)
+		panic(fmt.Errorf("qualified name %s not supported at (line:%d, col:%d)",
+			v.getText(ctx), location.Line, location.CharPosition))
+	}
+	return name.OriginalParts[0]
+}
+
+// getStringLiteral returns the value of the string literal.
+func (v *ASTBuilder) getStringLiteral(ctx antlrgen.ISql_stringContext) string {
+	str, ok := unquoteString(v.getText(ctx))
+	if !ok {
+		location := v.getLocation(ctx)
+		panic(fmt.Errorf("string %s not supported at (line:%d, col:%d)",
+			v.getText(ctx), location.Line, location.CharPosition))
+	}
+	return str
+}
+
+// unquoteString returns the value of single quoted string, and whether the
+// text is a single quoted string.
+func unquoteString(text string) (string, bool) {
+	if len(text) < 2 || !strings.HasPrefix(text, "'") || !strings.HasSuffix(text, "'") {
+		return "", false
+	}
+	return strings.Replace(text[1:len(text)-1], "''", "'", -1), true
+}
+
 // VisitTerminal visits the node
 func (v *ASTBuilder) VisitTerminal(node antlr.TerminalNode) interface{} { return nil }
 
@@ -491,10 +527,15 @@ func (v *ASTBuilder) VisitQuerySpecification(ctx *antlrgen.QuerySpecificationCon
 		v.SQL2AqlCtx.disableMainGroupBy = true
 	}
 
-	// handle having => not support in AQL
+	// handle having => havingFilter
 	if ctx.GetHaving() != nil {
-		location := v.getLocation(ctx.GetHaving())
-		panic(fmt.Errorf("having not yet supported at (line:%d, col:%d)", location.Line, location.CharPosition))
+		if levelQuery > 0 {
+			location := v.getLocation(ctx.GetHaving())
+			panic(fmt.Errorf("having is only supported in main query at (line:%d, col:%d)",
+				location.Line, location.CharPosition))
+		}
+		v.SQL2AqlCtx.MapHavingFilters[v.SQL2AqlCtx.mapKey] =
+			append(v.SQL2AqlCtx.MapHavingFilters[v.SQL2AqlCtx.mapKey], v.getText(ctx.GetHaving()))
 	}
 	v.setCtxLevels(v.SQL2AqlCtx, level, levelWith, levelQuery)
 	v.SQL2AqlCtx.exprOrigin = ExprOriginOthers
@@ -556,9 +597,38 @@ func (v *ASTBuilder) VisitGroupBy(ctx *antlrgen.GroupByContext) interface{} {
 
 	ctxArr := ctx.AllGroupingElement()
 	groupingElements := make([]tree.IGroupingElement, len(ctxArr))
+	// grouping sets of the group by clause are the cross product of grouping
+	// sets of each grouping element.
+	groupingSets := [][]string{{}}
+	hasGroupingSets := false
 	for i, c := range ctxArr {
 		v.setCtxLevels(v.SQL2AqlCtx, level, levelWith, levelQuery)
+		offset := len(v.SQL2AqlCtx.MapDimensions[v.SQL2AqlCtx.mapKey])
 		groupingElements[i], _ = v.Visit(c).(tree.IGroupingElement)
+
+		var sets [][]string
+		if g, ok := groupingElements[i].(*tree.GroupingSets); ok {
+			hasGroupingSets = true
+			sets = g.Sets
+		} else {
+			var set []string
+			for _, dim := range v.SQL2AqlCtx.MapDimensions[v.SQL2AqlCtx.mapKey][offset:] {
+				set = append(set, dim.name())
+			}
+			sets = [][]string{set}
+		}
+
+		var product [][]string
+		for _, prefix := range groupingSets {
+			for _, set := range sets {
+				product = append(product, append(append([]string{}, prefix...), set...))
+			}
+		}
+		groupingSets = product
+	}
+
+	if hasGroupingSets {
+		v.SQL2AqlCtx.MapGroupingSets[v.SQL2AqlCtx.mapKey] = groupingSets
 	}
 
 	groupBy := tree.NewGroupBy(
@@ -1011,7 +1081,9 @@ func (v *ASTBuilder) VisitFunctionCall(ctx *antlrgen.FunctionCallContext) interf
 			name, location.Line, location.CharPosition))
 	}
 
-	if exist := util.AggregateFunctions[name]; exist {
+	// window functions such as cumsum(sum(fare)) are computed over aggregate
+	// functions after aggregation.
+	if util.AggregateFunctions[name] || util.WindowFunctions[name] {
 		v.aggFuncExists = true
 	}
 
@@ -1062,7 +1134,7 @@ func (v *ASTBuilder) VisitDereference(ctx *antlrgen.DereferenceContext) interfac
 
 // VisitStatementDefault visits the node
 func (v *ASTBuilder) VisitStatementDefault(ctx *antlrgen.StatementDefaultContext) interface{} {
-	return v.VisitChildren(ctx)
+	return v.Visit(ctx.Query())
 }
 
 // VisitUse visits the node
@@ -1092,12 +1164,28 @@ func (v *ASTBuilder) VisitCreateTableAsSelect(ctx *antlrgen.CreateTableAsSelectC
 
 // VisitCreateTable visits the node
 func (v *ASTBuilder) VisitCreateTable(ctx *antlrgen.CreateTableContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitCreateTable: %s", ctx.GetText())
+
+	ctxArr := ctx.AllTableElement()
+	columns := make([]*tree.ColumnDefinition, len(ctxArr))
+	for i, c := range ctxArr {
+		columns[i] = v.Visit(c).(*tree.ColumnDefinition)
+	}
+
+	var properties []*tree.Property
+	if ctx.Properties() != nil {
+		properties = v.Visit(ctx.Properties()).([]*tree.Property)
+	}
+
+	return tree.NewCreateTable(v.getLocation(ctx), v.getTableName(ctx.QualifiedName()),
+		ctx.EXISTS() != nil, columns, properties)
 }
 
 // VisitDropTable visits the node
 func (v *ASTBuilder) VisitDropTable(ctx *antlrgen.DropTableContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitDropTable: %s", ctx.GetText())
+
+	return tree.NewDropTable(v.getLocation(ctx), v.getTableName(ctx.QualifiedName()), ctx.EXISTS() != nil)
 }
 
 // VisitInsertInto visits the node
@@ -1122,12 +1210,17 @@ func (v *ASTBuilder) VisitRenameColumn(ctx *antlrgen.RenameColumnContext) interf
 
 // VisitDropColumn visits the node
 func (v *ASTBuilder) VisitDropColumn(ctx *antlrgen.DropColumnContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitDropColumn: %s", ctx.GetText())
+
+	return tree.NewDropColumn(v.getLocation(ctx), v.getTableName(ctx.GetTableName()), v.getTableName(ctx.GetColumn()))
 }
 
 // VisitAddColumn visits the node
 func (v *ASTBuilder) VisitAddColumn(ctx *antlrgen.AddColumnContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitAddColumn: %s", ctx.GetText())
+
+	return tree.NewAddColumn(v.getLocation(ctx), v.getTableName(ctx.GetTableName()),
+		v.Visit(ctx.GetColumn()).(*tree.ColumnDefinition))
 }
 
 // VisitCreateView visits the node
@@ -1162,12 +1255,27 @@ func (v *ASTBuilder) VisitShowGrants(ctx *antlrgen.ShowGrantsContext) interface{
 
 // VisitExplain visits the node
 func (v *ASTBuilder) VisitExplain(ctx *antlrgen.ExplainContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitExplain: %s", ctx.GetText())
+
+	location := v.getLocation(ctx)
+	if ctx.ANALYZE() != nil || len(ctx.AllExplainOption()) > 0 {
+		panic(fmt.Errorf("EXPLAIN ANALYZE and explain options not supported yet at (line:%d, col:%d)",
+			location.Line, location.CharPosition))
+	}
+
+	query, ok := v.Visit(ctx.Statement()).(*tree.Query)
+	if !ok {
+		panic(fmt.Errorf("only query can be explained at (line:%d, col:%d)",
+			location.Line, location.CharPosition))
+	}
+	return tree.NewExplain(location, query)
 }
 
 // VisitShowCreateTable visits the node
 func (v *ASTBuilder) VisitShowCreateTable(ctx *antlrgen.ShowCreateTableContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitShowCreateTable: %s", ctx.GetText())
+
+	return tree.NewShowCreateTable(v.getLocation(ctx), v.getTableName(ctx.QualifiedName()))
 }
 
 // VisitShowCreateView visits the node
@@ -1177,7 +1285,22 @@ func (v *ASTBuilder) VisitShowCreateView(ctx *antlrgen.ShowCreateViewContext) in
 
 // VisitShowTables visits the node
 func (v *ASTBuilder) VisitShowTables(ctx *antlrgen.ShowTablesContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitShowTables: %s", ctx.GetText())
+
+	if ctx.QualifiedName() != nil {
+		location := v.getLocation(ctx.QualifiedName())
+		panic(fmt.Errorf("schema not supported at (line:%d, col:%d)",
+			location.Line, location.CharPosition))
+	}
+
+	var pattern, escape string
+	if ctx.GetPattern() != nil {
+		pattern = v.getStringLiteral(ctx.GetPattern())
+	}
+	if ctx.GetEscape() != nil {
+		escape = v.getStringLiteral(ctx.GetEscape())
+	}
+	return tree.NewShowTables(v.getLocation(ctx), pattern, escape)
 }
 
 // VisitShowSchemas visits the node
@@ -1192,7 +1315,9 @@ func (v *ASTBuilder) VisitShowCatalogs(ctx *antlrgen.ShowCatalogsContext) interf
 
 // VisitShowColumns visits the node
 func (v *ASTBuilder) VisitShowColumns(ctx *antlrgen.ShowColumnsContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitShowColumns: %s", ctx.GetText())
+
+	return tree.NewShowColumns(v.getLocation(ctx), v.getTableName(ctx.QualifiedName()))
 }
 
 // VisitShowStats visits the node
@@ -1257,27 +1382,60 @@ func (v *ASTBuilder) VisitDescribeOutput(ctx *antlrgen.DescribeOutputContext) in
 
 // VisitTableElement visits the node
 func (v *ASTBuilder) VisitTableElement(ctx *antlrgen.TableElementContext) interface{} {
-	return v.VisitChildren(ctx)
+	if ctx.LikeClause() != nil {
+		return v.Visit(ctx.LikeClause())
+	}
+	return v.Visit(ctx.ColumnDefinition())
 }
 
 // VisitColumnDefinition visits the node
 func (v *ASTBuilder) VisitColumnDefinition(ctx *antlrgen.ColumnDefinitionContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitColumnDefinition: %s", ctx.GetText())
+
+	name := v.Visit(ctx.Identifier()).(*tree.Identifier).Value
+	return tree.NewColumnDefinition(v.getLocation(ctx), name, v.Visit(ctx.Sqltype()).(string))
 }
 
 // VisitLikeClause visits the node
 func (v *ASTBuilder) VisitLikeClause(ctx *antlrgen.LikeClauseContext) interface{} {
-	return v.VisitChildren(ctx)
+	location := v.getLocation(ctx)
+	panic(fmt.Errorf("like clause not supported yet at (line:%d, col:%d)",
+		location.Line, location.CharPosition))
 }
 
 // VisitProperties visits the node
 func (v *ASTBuilder) VisitProperties(ctx *antlrgen.PropertiesContext) interface{} {
-	return v.VisitChildren(ctx)
+	ctxArr := ctx.AllProperty()
+	properties := make([]*tree.Property, len(ctxArr))
+	for i, c := range ctxArr {
+		properties[i] = v.Visit(c).(*tree.Property)
+	}
+	return properties
 }
 
 // VisitProperty visits the node
 func (v *ASTBuilder) VisitProperty(ctx *antlrgen.PropertyContext) interface{} {
-	return v.VisitChildren(ctx)
+	v.Logger.Debugf("VisitProperty: %s", ctx.GetText())
+
+	name := v.Visit(ctx.Identifier()).(*tree.Identifier).Value
+	text := v.getText(ctx.Expression())
+
+	// property values are literals of string, boolean or number.
+	var value interface{}
+	if str, ok := unquoteString(text); ok {
+		value = str
+	} else if lower := strings.ToLower(text); lower == "true" || lower == "false" {
+		value = lower == "true"
+	} else if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		value = i
+	} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+		value = f
+	} else {
+		location := v.getLocation(ctx.Expression())
+		panic(fmt.Errorf("invalid value %s of property %s at (line:%d, col:%d)",
+			text, name, location.Line, location.CharPosition))
+	}
+	return tree.NewProperty(v.getLocation(ctx), name, value)
 }
 
 // VisitQueryTermDefault visits the node
@@ -1316,8 +1474,18 @@ func (v *ASTBuilder) VisitSubquery(ctx *antlrgen.SubqueryContext) interface{} {
 
 // VisitRollup visits the node
 func (v *ASTBuilder) VisitRollup(ctx *antlrgen.RollupContext) interface{} {
-	location := v.getLocation(ctx)
-	panic(fmt.Errorf("rollup at (line:%d, col:%d) not supported yet", location.Line, location.CharPosition))
+	v.Logger.Debugf("VisitRollup: %s", ctx.GetText())
+
+	// ROLLUP(a, b) is grouping sets (a, b), (a) and ().
+	names := v.addGroupingSetDimensions(ctx.AllQualifiedName())
+	sets := make([][]string, 0, len(names)+1)
+	for i := len(names); i >= 0; i-- {
+		sets = append(sets, names[:i])
+	}
+
+	groupingSets := tree.NewGroupingSets(v.getLocation(ctx), sets)
+	groupingSets.SetValue(fmt.Sprintf("GroupingSets: (%s)", v.getText(ctx.BaseParserRuleContext)))
+	return groupingSets
 }
 
 // VisitCube visits the node
@@ -1328,8 +1496,43 @@ func (v *ASTBuilder) VisitCube(ctx *antlrgen.CubeContext) interface{} {
 
 // VisitMultipleGroupingSets visits the node
 func (v *ASTBuilder) VisitMultipleGroupingSets(ctx *antlrgen.MultipleGroupingSetsContext) interface{} {
-	location := v.getLocation(ctx)
-	panic(fmt.Errorf("multipleGroupingSets at (line:%d, col:%d) not supported yet", location.Line, location.CharPosition))
+	v.Logger.Debugf("VisitMultipleGroupingSets: %s", ctx.GetText())
+
+	ctxArr := ctx.AllGroupingSet()
+	sets := make([][]string, len(ctxArr))
+	for i, c := range ctxArr {
+		sets[i] = v.addGroupingSetDimensions(c.(*antlrgen.GroupingSetContext).AllQualifiedName())
+	}
+
+	groupingSets := tree.NewGroupingSets(v.getLocation(ctx), sets)
+	groupingSets.SetValue(fmt.Sprintf("GroupingSets: (%s)", v.getText(ctx.BaseParserRuleContext)))
+	return groupingSets
+}
+
+// addGroupingSetDimensions adds columns of a grouping set into dimensions if
+// they are not there yet, and returns the names of the dimensions.
+func (v *ASTBuilder) addGroupingSetDimensions(ctxArr []antlrgen.IQualifiedNameContext) []string {
+	names := make([]string, len(ctxArr))
+	for i, c := range ctxArr {
+		alias, expr := v.lookupSQLExpr(v.SQL2AqlCtx, v.SQL2AqlCtx.mapKey, v.getText(c))
+		dim := Dimension{
+			Alias: alias,
+			Expr:  expr,
+		}
+		names[i] = dim.name()
+
+		found := false
+		for _, existing := range v.SQL2AqlCtx.MapDimensions[v.SQL2AqlCtx.mapKey] {
+			if existing.name() == names[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.SQL2AqlCtx.MapDimensions[v.SQL2AqlCtx.mapKey] = append(v.SQL2AqlCtx.MapDimensions[v.SQL2AqlCtx.mapKey], dim)
+		}
+	}
+	return names
 }
 
 // VisitGroupingExpressions visits the node
@@ -1649,7 +1852,12 @@ func (v *ASTBuilder) VisitNormalForm(ctx *antlrgen.NormalFormContext) interface{
 
 // VisitSqltype visits the node
 func (v *ASTBuilder) VisitSqltype(ctx *antlrgen.SqltypeContext) interface{} {
-	return v.VisitChildren(ctx)
+	if ctx.BaseType() == nil || len(ctx.AllTypeParameter()) > 0 {
+		location := v.getLocation(ctx)
+		panic(fmt.Errorf("type %s not supported at (line:%d, col:%d)",
+			v.getText(ctx), location.Line, location.CharPosition))
+	}
+	return v.Visit(ctx.BaseType())
 }
 
 // VisitTypeParameter visits the node
@@ -1659,7 +1867,12 @@ func (v *ASTBuilder) VisitTypeParameter(ctx *antlrgen.TypeParameterContext) inte
 
 // VisitBaseType visits the node
 func (v *ASTBuilder) VisitBaseType(ctx *antlrgen.BaseTypeContext) interface{} {
-	return v.VisitChildren(ctx)
+	if ctx.Identifier() == nil {
+		location := v.getLocation(ctx)
+		panic(fmt.Errorf("type %s not supported at (line:%d, col:%d)",
+			v.getText(ctx), location.Line, location.CharPosition))
+	}
+	return v.Visit(ctx.Identifier()).(*tree.Identifier).Value
 }
 
 // VisitWhenClause visits the node
@@ -1899,6 +2112,7 @@ func (v *ASTBuilder) mergeWithOrSubQueries() {
 		v.aql.Sorts = v.SQL2AqlCtx.MapOrderBy[0]
 	}
 	v.aql.Filters = v.SQL2AqlCtx.MapRowFilters[0]
+	v.aql.HavingFilters = v.SQL2AqlCtx.MapHavingFilters[0]
 	v.aql.TimeFilter = v.SQL2AqlCtx.timeFilter
 	v.aql.Timezone = v.SQL2AqlCtx.timezone
 	v.aql.Limit = v.SQL2AqlCtx.MapLimit[0]
@@ -1970,18 +2184,23 @@ func (v *ASTBuilder) GetAQL() *AQLQuery {
 		}
 
 		v.aql = &AQLQuery{
-			Table:      table,
-			Joins:      joins,
-			Measures:   v.SQL2AqlCtx.MapMeasures[0],
-			Dimensions: v.SQL2AqlCtx.MapDimensions[0],
-			Filters:    v.SQL2AqlCtx.MapRowFilters[0],
-			TimeFilter: v.SQL2AqlCtx.timeFilter,
-			Timezone:   v.SQL2AqlCtx.timezone,
-			Now:        v.SQL2AqlCtx.timeNow,
-			Limit:      v.SQL2AqlCtx.MapLimit[0],
-			Sorts:      v.SQL2AqlCtx.MapOrderBy[0],
+			Table:         table,
+			Joins:         joins,
+			Measures:      v.SQL2AqlCtx.MapMeasures[0],
+			Dimensions:    v.SQL2AqlCtx.MapDimensions[0],
+			Filters:       v.SQL2AqlCtx.MapRowFilters[0],
+			HavingFilters: v.SQL2AqlCtx.MapHavingFilters[0],
+			GroupingSets:  v.SQL2AqlCtx.MapGroupingSets[0],
+			TimeFilter:    v.SQL2AqlCtx.timeFilter,
+			Timezone:      v.SQL2AqlCtx.timezone,
+			Now:           v.SQL2AqlCtx.timeNow,
+			Limit:         v.SQL2AqlCtx.MapLimit[0],
+			Sorts:         v.SQL2AqlCtx.MapOrderBy[0],
 		}
 	} else {
+		if len(v.SQL2AqlCtx.MapGroupingSets) > 0 {
+			panic(fmt.Errorf("grouping sets in subquery/withQuery not supported yet"))
+		}
 		v.aql = &AQLQuery{
 			SupportingMeasures:   make([]Measure, 0, defaultSliceCap),
 			SupportingDimensions: make([]Dimension, 0, defaultSliceCap),
@@ -2292,8 +2511,34 @@ func (v *ASTBuilder) hasORInPath(node *antlr.BaseParserRuleContext) tree.Logical
 	return op
 }
 
-// Parse parses input sql
-func Parse(sql string, logger common.Logger) (aql *AQLQuery, err error) {
+// SQLStatement is a parsed SQL statement.
+type SQLStatement struct {
+	// Query of the statement.
+	Query *AQLQuery
+	// Whether the query should be explained instead of executed.
+	Explain bool
+	// Schema change statement of CREATE TABLE, DROP TABLE and ALTER TABLE,
+	// Query is nil for such statement.
+	DDL tree.IStatement
+	// Metadata statement of SHOW TABLES, SHOW COLUMNS (DESCRIBE) and SHOW
+	// CREATE TABLE, Query is nil for such statement.
+	Metadata tree.IStatement
+}
+
+// Parse parses input sql query
+func Parse(sql string, logger common.Logger) (*AQLQuery, error) {
+	statement, err := ParseStatement(sql, logger)
+	if err != nil {
+		return nil, err
+	}
+	if statement.Explain || statement.DDL != nil || statement.Metadata != nil {
+		return nil, fmt.Errorf("not a query")
+	}
+	return statement.Query, nil
+}
+
+// ParseStatement parses input sql statement
+func ParseStatement(sql string, logger common.Logger) (statement *SQLStatement, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			var ok bool
@@ -2304,8 +2549,15 @@ func Parse(sql string, logger common.Logger) (aql *AQLQuery, err error) {
 		}
 	}()
 
+	// OVER clauses are not in the grammar, so window functions are rewritten
+	// into window measures before parsing.
+	rewrittenSQL, windowSpecs, err := rewriteWindowFunctions(sql)
+	if err != nil {
+		return nil, err
+	}
+
 	// Setup the input sql
-	is := util.NewCaseChangingStream(antlr.NewInputStream(sql), true)
+	is := util.NewCaseChangingStream(antlr.NewInputStream(rewrittenSQL), true)
 
 	// Create the Lexer
 	lexer := antlrgen.NewSqlBaseLexer(is)
@@ -2316,11 +2568,7 @@ func Parse(sql string, logger common.Logger) (aql *AQLQuery, err error) {
 
 	// Finally parse the sql
 	p.GetInterpreter().SetPredictionMode(antlr.PredictionModeSLL)
-	parseTree, ok := p.Query().(*antlrgen.QueryContext)
-	if !ok {
-		err = fmt.Errorf("not a query")
-		return nil, err
-	}
+	parseTree := p.Statement()
 
 	// Construct ASTBuilder
 	v := &ASTBuilder{
@@ -2335,18 +2583,39 @@ func Parse(sql string, logger common.Logger) (aql *AQLQuery, err error) {
 			MapRowFilters:      make(map[int][]string),
 			MapOrderBy:         make(map[int][]SortField),
 			MapLimit:           make(map[int]int),
+			MapHavingFilters:   make(map[int][]string),
+			MapGroupingSets:    make(map[int][][]string),
 		},
 	}
-	node := v.VisitQuery(parseTree)
-	if _, ok := node.(*tree.Query); ok {
-		aql = v.GetAQL()
-		aql.SQLQuery = sql
-		aqlJSON, _ := json.Marshal(aql)
-		logger.Infof("convert SQL:\n%v\nto AQL:\n%v", sql, string(aqlJSON))
+	switch node := v.Visit(parseTree).(type) {
+	case *tree.Query:
+		statement = &SQLStatement{}
+	case *tree.Explain:
+		statement = &SQLStatement{Explain: true}
+	case *tree.CreateTable, *tree.DropTable, *tree.AddColumn, *tree.DropColumn:
+		logger.Infof("parsed SQL schema change:\n%v", sql)
+		return &SQLStatement{DDL: node.(tree.IStatement)}, nil
+	case *tree.ShowTables, *tree.ShowColumns, *tree.ShowCreateTable:
+		return &SQLStatement{Metadata: node.(tree.IStatement)}, nil
+	default:
+		err = fmt.Errorf("not a query")
+		return nil, err
+	}
+
+	aql := v.GetAQL()
+	aql.SQLQuery = sql
+	aqlJSON, _ := json.Marshal(aql)
+	logger.Infof("convert SQL:\n%v\nto AQL:\n%v", sql, string(aqlJSON))
+	statement.Query = aql
+
+	// supporting measures are referenced by derived measures and computed after
+	// aggregation, while supporting dimensions are not supported.
+	if len(aql.SupportingDimensions) > 0 {
+		err = fmt.Errorf("supporting dimensions not supported yet")
+		return
 	}
 
-	if len(aql.SupportingDimensions) > 0 || len(aql.SupportingMeasures) > 0 {
-		err = fmt.Errorf("sub query not supported yet")
+	if err = validateWindowSpecs(windowSpecs, aql.Dimensions); err != nil {
 		return
 	}
 
@@ -18,6 +18,7 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/query/sql/tree"
 )
 
 var _ = ginkgo.Describe("SQL Parser", func() {
@@ -50,6 +51,226 @@ var _ = ginkgo.Describe("SQL Parser", func() {
 		runTest(sqls, res, logger)
 	})
 
+	ginkgo.It("parse having filters should work", func() {
+		sqls := []string{
+			`SELECT count(*) AS trips, sum(fare)
+			FROM trips
+			GROUP BY status
+			HAVING trips > 100 AND sum(fare) > 1000`,
+		}
+		res := AQLQuery{
+			Table:         "trips",
+			Measures:      []Measure{{Alias: "trips", Expr: "count(*)"}, {Expr: "sum(fare)"}},
+			Dimensions:    []Dimension{{Expr: "status"}},
+			HavingFilters: []string{"trips > 100 AND sum(fare) > 1000"},
+		}
+		runTest(sqls, res, logger)
+	})
+
+	ginkgo.It("parse window measures should work", func() {
+		sqls := []string{
+			`SELECT cumsum(count(*)) AS total_trips, moving_avg(sum(fare), 7)
+			FROM trips
+			GROUP BY aql_time_bucket_day(request_at, "minute", America/New_York)`,
+		}
+		res := AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Alias: "total_trips", Expr: "cumsum(count(*))"},
+				{Expr: "moving_avg(sum(fare), 7)"},
+			},
+			Dimensions: []Dimension{{Expr: "request_at", TimeBucketizer: "day", TimeUnit: "minute"}},
+			Timezone:   "America/New_York",
+		}
+		runTest(sqls, res, logger)
+
+		_, err := Parse(`SELECT cumsum(count(*)) FROM trips`, logger)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("parse window functions with OVER clauses should work", func() {
+		sqls := []string{
+			`SELECT sum(count(*)) OVER (PARTITION BY city_id ORDER BY request_at) AS total_trips,
+				avg(sum(fare)) OVER (PARTITION BY city_id ORDER BY request_at ASC ROWS 6 PRECEDING),
+				rate(count(*)) OVER (PARTITION BY city_id ORDER BY request_at)
+			FROM trips
+			GROUP BY city_id, aql_time_bucket_day(request_at, "minute", America/New_York)`,
+			`SELECT sum(count(*)) over (partition by city_id order by request_at rows between unbounded preceding and current row) AS total_trips,
+				avg(sum(fare)) OVER (PARTITION BY city_id ORDER BY request_at ROWS BETWEEN 6 PRECEDING AND CURRENT ROW),
+				rate(count(*)) OVER (PARTITION BY city_id ORDER BY request_at)
+			FROM trips
+			GROUP BY city_id, aql_time_bucket_day(request_at, "minute", America/New_York)`,
+		}
+		res := AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Alias: "total_trips", Expr: "cumsum(count(*))"},
+				{Expr: "moving_avg(sum(fare), 7)"},
+				{Expr: "rate(count(*))"},
+			},
+			Dimensions: []Dimension{
+				{Expr: "city_id"},
+				{Expr: "request_at", TimeBucketizer: "day", TimeUnit: "minute"},
+			},
+			Timezone: "America/New_York",
+		}
+		runTest(sqls, res, logger)
+
+		for _, sql := range []string{
+			// ORDER BY is required.
+			`SELECT sum(count(*)) OVER () FROM trips GROUP BY city_id`,
+			// unsupported functions.
+			`SELECT max(count(*)) OVER (ORDER BY request_at) FROM trips GROUP BY city_id`,
+			// unsupported frames.
+			`SELECT sum(count(*)) OVER (ORDER BY request_at ROWS 6 PRECEDING) FROM trips GROUP BY city_id`,
+			`SELECT avg(count(*)) OVER (ORDER BY request_at) FROM trips GROUP BY city_id`,
+			`SELECT rate(count(*)) OVER (ORDER BY request_at ROWS UNBOUNDED PRECEDING) FROM trips GROUP BY city_id`,
+			// no function call before OVER.
+			`SELECT count OVER (ORDER BY request_at) FROM trips GROUP BY city_id`,
+			// not partitioned by the other dimensions.
+			`SELECT sum(count(*)) OVER (ORDER BY request_at) FROM trips
+			GROUP BY city_id, aql_time_bucket_day(request_at, "minute", America/New_York)`,
+			`SELECT sum(count(*)) OVER (PARTITION BY status ORDER BY request_at) FROM trips
+			GROUP BY city_id, aql_time_bucket_day(request_at, "minute", America/New_York)`,
+			`SELECT sum(count(*)) OVER (PARTITION BY city_id, request_at ORDER BY request_at) FROM trips
+			GROUP BY city_id, aql_time_bucket_day(request_at, "minute", America/New_York)`,
+			// not ordered by the time dimension in ascending order.
+			`SELECT sum(count(*)) OVER (PARTITION BY city_id ORDER BY city_id) FROM trips
+			GROUP BY city_id, aql_time_bucket_day(request_at, "minute", America/New_York)`,
+			`SELECT sum(count(*)) OVER (PARTITION BY city_id ORDER BY request_at DESC) FROM trips
+			GROUP BY city_id, aql_time_bucket_day(request_at, "minute", America/New_York)`,
+		} {
+			_, err := Parse(sql, logger)
+			Ω(err).ShouldNot(BeNil())
+		}
+
+		// OVER is not reserved.
+		aql, err := Parse(`SELECT count(*) FROM trips WHERE over > 1 GROUP BY over`, logger)
+		Ω(err).Should(BeNil())
+		Ω(aql.Filters).Should(Equal([]string{"over > 1"}))
+		Ω(aql.Dimensions).Should(Equal([]Dimension{{Expr: "over"}}))
+	})
+
+	ginkgo.It("parse rollup and grouping sets should work", func() {
+		sqls := []string{
+			`SELECT count(*)
+			FROM trips
+			GROUP BY ROLLUP(city_id, product_id)`,
+		}
+		res := AQLQuery{
+			Table:        "trips",
+			Measures:     []Measure{{Expr: "count(*)"}},
+			Dimensions:   []Dimension{{Expr: "city_id"}, {Expr: "product_id"}},
+			GroupingSets: [][]string{{"city_id", "product_id"}, {"city_id"}, {}},
+		}
+		runTest(sqls, res, logger)
+
+		sqls = []string{
+			`SELECT count(*)
+			FROM trips
+			GROUP BY status, GROUPING SETS ((city_id), ())`,
+		}
+		res = AQLQuery{
+			Table:        "trips",
+			Measures:     []Measure{{Expr: "count(*)"}},
+			Dimensions:   []Dimension{{Expr: "status"}, {Expr: "city_id"}},
+			GroupingSets: [][]string{{"status", "city_id"}, {"status"}},
+		}
+		runTest(sqls, res, logger)
+	})
+
+	ginkgo.It("parse explain should work", func() {
+		statement, err := ParseStatement(`EXPLAIN SELECT count(*) FROM trips`, logger)
+		Ω(err).Should(BeNil())
+		Ω(statement.Explain).Should(BeTrue())
+		Ω(statement.Query.Table).Should(Equal("trips"))
+		Ω(statement.Query.Measures).Should(HaveLen(1))
+
+		statement, err = ParseStatement(`SELECT count(*) FROM trips`, logger)
+		Ω(err).Should(BeNil())
+		Ω(statement.Explain).Should(BeFalse())
+
+		_, err = Parse(`EXPLAIN SELECT count(*) FROM trips`, logger)
+		Ω(err).ShouldNot(BeNil())
+		_, err = ParseStatement(`EXPLAIN ANALYZE SELECT count(*) FROM trips`, logger)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("parse schema change statements should work", func() {
+		statement, err := ParseStatement(`CREATE TABLE IF NOT EXISTS trips (request_at Uint32, city_id INTEGER, status SmallEnum)
+			WITH (isFactTable = true, primaryKeyColumns = 'city_id', batchSize = 1000, allowMissingEventTime = FALSE)`, logger)
+		Ω(err).Should(BeNil())
+		Ω(statement.Query).Should(BeNil())
+		createTable := statement.DDL.(*tree.CreateTable)
+		Ω(createTable.Name).Should(Equal("trips"))
+		Ω(createTable.NotExists).Should(BeTrue())
+		Ω(createTable.Columns).Should(HaveLen(3))
+		Ω(createTable.Columns[1].Name).Should(Equal("city_id"))
+		Ω(createTable.Columns[1].Type).Should(Equal("INTEGER"))
+		Ω(createTable.Properties).Should(HaveLen(4))
+		Ω(createTable.Properties[0].Value).Should(Equal(true))
+		Ω(createTable.Properties[1].Value).Should(Equal("city_id"))
+		Ω(createTable.Properties[2].Value).Should(Equal(int64(1000)))
+		Ω(createTable.Properties[3].Value).Should(Equal(false))
+
+		statement, err = ParseStatement(`DROP TABLE trips`, logger)
+		Ω(err).Should(BeNil())
+		dropTable := statement.DDL.(*tree.DropTable)
+		Ω(dropTable.Name).Should(Equal("trips"))
+		Ω(dropTable.Exists).Should(BeFalse())
+
+		statement, err = ParseStatement(`ALTER TABLE trips ADD COLUMN fare Float32`, logger)
+		Ω(err).Should(BeNil())
+		addColumn := statement.DDL.(*tree.AddColumn)
+		Ω(addColumn.Table).Should(Equal("trips"))
+		Ω(addColumn.Column.Name).Should(Equal("fare"))
+		Ω(addColumn.Column.Type).Should(Equal("Float32"))
+
+		statement, err = ParseStatement(`ALTER TABLE trips DROP COLUMN fare`, logger)
+		Ω(err).Should(BeNil())
+		dropColumn := statement.DDL.(*tree.DropColumn)
+		Ω(dropColumn.Table).Should(Equal("trips"))
+		Ω(dropColumn.Column).Should(Equal("fare"))
+
+		_, err = Parse(`DROP TABLE trips`, logger)
+		Ω(err).ShouldNot(BeNil())
+		_, err = ParseStatement(`DROP TABLE db.trips`, logger)
+		Ω(err).ShouldNot(BeNil())
+		_, err = ParseStatement(`CREATE TABLE trips (city_id ARRAY<INTEGER>)`, logger)
+		Ω(err).ShouldNot(BeNil())
+		_, err = ParseStatement(`CREATE TABLE trips (LIKE other)`, logger)
+		Ω(err).ShouldNot(BeNil())
+		_, err = ParseStatement(`CREATE TABLE trips (city_id INTEGER) WITH (batchSize = 1 + 1)`, logger)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("parse metadata statements should work", func() {
+		statement, err := ParseStatement(`SHOW TABLES LIKE 'trip''s%' ESCAPE '!'`, logger)
+		Ω(err).Should(BeNil())
+		Ω(statement.Query).Should(BeNil())
+		showTables := statement.Metadata.(*tree.ShowTables)
+		Ω(showTables.LikePattern).Should(Equal("trip's%"))
+		Ω(showTables.Escape).Should(Equal("!"))
+
+		statement, err = ParseStatement(`SHOW COLUMNS IN trips`, logger)
+		Ω(err).Should(BeNil())
+		Ω(statement.Metadata.(*tree.ShowColumns).Table).Should(Equal("trips"))
+
+		statement, err = ParseStatement(`SHOW CREATE TABLE "trips"`, logger)
+		Ω(err).Should(BeNil())
+		Ω(statement.Metadata.(*tree.ShowCreateTable).Table).Should(Equal("trips"))
+
+		statement, err = ParseStatement(`DESCRIBE trips`, logger)
+		Ω(err).Should(BeNil())
+		Ω(statement.Metadata.(*tree.ShowColumns).Table).Should(Equal("trips"))
+
+		_, err = Parse(`DESCRIBE trips`, logger)
+		Ω(err).ShouldNot(BeNil())
+		// prepared statements are not supported.
+		_, err = ParseStatement(`DESCRIBE OUTPUT trips`, logger)
+		Ω(err).ShouldNot(BeNil())
+	})
+
 	ginkgo.It("parse dimensions should work", func() {
 		sqls := []string{
 			`SELECT status AS trip_status, count(*) 
@@ -355,13 +576,7 @@ var _ = ginkgo.Describe("SQL Parser", func() {
 			},
 			Timezone: "America/New_York",
 		}
-		for _, sql := range sqls {
-			actual, err := Parse(sql, logger)
-			Ω(err).ShouldNot(BeNil())
-			Ω(err.Error()).Should(Equal("sub query not supported yet"))
-			res.SQLQuery = sql
-			Ω(*actual).Should(BeEquivalentTo(res))
-		}
+		runTest(sqls, res, logger)
 	})
 
 	ginkgo.It("With RECURSIVE is not allowed", func() {
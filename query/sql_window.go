@@ -0,0 +1,332 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/uber/aresdb/query/sql/antlrgen"
+	"github.com/uber/aresdb/query/sql/util"
+)
+
+// Frames of OVER clauses supported, INTEGER_VALUE is the number of preceding
+// rows.
+var (
+	unboundedFrames = [][]int{
+		{antlrgen.SqlBaseLexerROWS, antlrgen.SqlBaseLexerUNBOUNDED, antlrgen.SqlBaseLexerPRECEDING},
+		{antlrgen.SqlBaseLexerRANGE, antlrgen.SqlBaseLexerUNBOUNDED, antlrgen.SqlBaseLexerPRECEDING},
+		{antlrgen.SqlBaseLexerROWS, antlrgen.SqlBaseLexerBETWEEN, antlrgen.SqlBaseLexerUNBOUNDED,
+			antlrgen.SqlBaseLexerPRECEDING, antlrgen.SqlBaseLexerAND, antlrgen.SqlBaseLexerCURRENT, antlrgen.SqlBaseLexerROW},
+		{antlrgen.SqlBaseLexerRANGE, antlrgen.SqlBaseLexerBETWEEN, antlrgen.SqlBaseLexerUNBOUNDED,
+			antlrgen.SqlBaseLexerPRECEDING, antlrgen.SqlBaseLexerAND, antlrgen.SqlBaseLexerCURRENT, antlrgen.SqlBaseLexerROW},
+	}
+	precedingFrames = [][]int{
+		{antlrgen.SqlBaseLexerROWS, antlrgen.SqlBaseLexerINTEGER_VALUE, antlrgen.SqlBaseLexerPRECEDING},
+		{antlrgen.SqlBaseLexerROWS, antlrgen.SqlBaseLexerBETWEEN, antlrgen.SqlBaseLexerINTEGER_VALUE,
+			antlrgen.SqlBaseLexerPRECEDING, antlrgen.SqlBaseLexerAND, antlrgen.SqlBaseLexerCURRENT, antlrgen.SqlBaseLexerROW},
+	}
+)
+
+// windowSpec is the PARTITION BY and ORDER BY of an OVER clause, which are
+// checked against the dimensions of the query after parsing.
+type windowSpec struct {
+	function     string
+	line, column int
+	partitionBy  []string
+	orderBy      []string
+}
+
+// rewriteWindowFunctions rewrites window functions with OVER clauses, which
+// are not in the grammar, into window measures over aggregate functions:
+//   sum(m) OVER (ORDER BY t [ROWS UNBOUNDED PRECEDING]) => cumsum(m)
+//   avg(m) OVER (ORDER BY t ROWS N PRECEDING) => moving_avg(m, N+1)
+//   rate(m) OVER (ORDER BY t) => rate(m)
+// Window measures are always ordered by the time dimension and partitioned by
+// the other dimensions, PARTITION BY and ORDER BY of OVER clauses are returned
+// to be checked by validateWindowSpecs. OVER is a non reserved word, it's left
+// as is unless it follows a function call and a window specification follows.
+func rewriteWindowFunctions(sql string) (string, []windowSpec, error) {
+	lexer := antlrgen.NewSqlBaseLexer(util.NewCaseChangingStream(antlr.NewInputStream(sql), true))
+	lexer.RemoveErrorListeners()
+	var tokens []antlr.Token
+	for token := lexer.NextToken(); token.GetTokenType() != antlr.TokenEOF; token = lexer.NextToken() {
+		if token.GetChannel() == antlr.TokenDefaultChannel {
+			tokens = append(tokens, token)
+		}
+	}
+
+	// positions of tokens are in runes.
+	runes := []rune(sql)
+	var buffer bytes.Buffer
+	var specs []windowSpec
+	last := 0
+	for i := 0; i < len(tokens); i++ {
+		over := tokens[i]
+		if over.GetTokenType() != antlrgen.SqlBaseLexerOVER ||
+			i+1 >= len(tokens) || tokens[i+1].GetTokenType() != antlrgen.SqlBaseLexerT__1 {
+			continue
+		}
+
+		// the function call before OVER.
+		argsStart := -1
+		if i > 0 && tokens[i-1].GetTokenType() == antlrgen.SqlBaseLexerT__2 {
+			argsStart = matchParenthesis(tokens, i-1, -1)
+		}
+		if argsStart < 1 || tokens[argsStart-1].GetStart() < last {
+			return "", nil, fmt.Errorf("expect function call before OVER at (line:%d, col:%d)",
+				over.GetLine(), over.GetColumn())
+		}
+		name := tokens[argsStart-1]
+		function := strings.ToLower(name.GetText())
+		args := strings.TrimSpace(string(runes[tokens[argsStart].GetStop()+1 : tokens[i-1].GetStart()]))
+
+		// the window specification after OVER.
+		specEnd := matchParenthesis(tokens, i+1, 1)
+		if specEnd < 0 {
+			return "", nil, fmt.Errorf("expect window specification after OVER at (line:%d, col:%d)",
+				over.GetLine(), over.GetColumn())
+		}
+
+		spec := windowSpec{function: function, line: name.GetLine(), column: name.GetColumn()}
+		frame, err := parseWindowSpec(runes, tokens[i+2:specEnd], &spec)
+		if err == nil {
+			var window string
+			window, err = getWindowCall(function, args, frame)
+			buffer.WriteString(string(runes[last:name.GetStart()]))
+			buffer.WriteString(window)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%s at (line:%d, col:%d)", err.Error(), name.GetLine(), name.GetColumn())
+		}
+		specs = append(specs, spec)
+		last = tokens[specEnd].GetStop() + 1
+		i = specEnd
+	}
+
+	if last == 0 {
+		return sql, nil, nil
+	}
+	buffer.WriteString(string(runes[last:]))
+	return buffer.String(), specs, nil
+}
+
+// parseWindowSpec parses PARTITION BY and ORDER BY of the window specification
+// into spec and returns the window frame following them.
+func parseWindowSpec(runes []rune, tokens []antlr.Token, spec *windowSpec) ([]antlr.Token, error) {
+	i := 0
+	if matchTokenTypes(tokens, i, antlrgen.SqlBaseLexerPARTITION, antlrgen.SqlBaseLexerBY) {
+		var items [][]antlr.Token
+		items, i = splitWindowItems(tokens, i+2, antlrgen.SqlBaseLexerORDER)
+		for _, item := range items {
+			spec.partitionBy = append(spec.partitionBy, getTokensText(runes, item))
+		}
+	}
+
+	if !matchTokenTypes(tokens, i, antlrgen.SqlBaseLexerORDER, antlrgen.SqlBaseLexerBY) {
+		return nil, fmt.Errorf("expect ORDER BY in OVER clause of window function %s", spec.function)
+	}
+	var items [][]antlr.Token
+	items, i = splitWindowItems(tokens, i+2, antlrgen.SqlBaseLexerROWS, antlrgen.SqlBaseLexerRANGE)
+	for _, item := range items {
+		// window measures are computed in ascending order of time.
+		if len(item) > 1 && item[len(item)-1].GetTokenType() == antlrgen.SqlBaseLexerASC {
+			item = item[:len(item)-1]
+		}
+		for _, token := range item {
+			switch token.GetTokenType() {
+			case antlrgen.SqlBaseLexerDESC, antlrgen.SqlBaseLexerNULLS:
+				return nil, fmt.Errorf("only ascending ORDER BY is supported in OVER clause of window function %s",
+					spec.function)
+			}
+		}
+		spec.orderBy = append(spec.orderBy, getTokensText(runes, item))
+	}
+	return tokens[i:], nil
+}
+
+// splitWindowItems splits tokens from start by top level commas until any of
+// the stop token types at top level. The items and the index of the stop
+// token are returned.
+func splitWindowItems(tokens []antlr.Token, start int, stopTypes ...int) ([][]antlr.Token, int) {
+	var items [][]antlr.Token
+	itemStart, depth := start, 0
+	i := start
+	for ; i < len(tokens); i++ {
+		tokenType := tokens[i].GetTokenType()
+		if depth == 0 {
+			stopped := false
+			for _, stopType := range stopTypes {
+				stopped = stopped || tokenType == stopType
+			}
+			if stopped {
+				break
+			}
+		}
+		switch tokenType {
+		case antlrgen.SqlBaseLexerT__1:
+			depth++
+		case antlrgen.SqlBaseLexerT__2:
+			depth--
+		case antlrgen.SqlBaseLexerT__3:
+			if depth == 0 {
+				items = append(items, tokens[itemStart:i])
+				itemStart = i + 1
+			}
+		}
+	}
+	return append(items, tokens[itemStart:i]), i
+}
+
+// getTokensText returns the sql text of the tokens.
+func getTokensText(runes []rune, tokens []antlr.Token) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	return string(runes[tokens[0].GetStart() : tokens[len(tokens)-1].GetStop()+1])
+}
+
+// matchTokenTypes returns whether tokens from the index match the token types.
+func matchTokenTypes(tokens []antlr.Token, index int, types ...int) bool {
+	if index+len(types) > len(tokens) {
+		return false
+	}
+	for i, tokenType := range types {
+		if tokens[index+i].GetTokenType() != tokenType {
+			return false
+		}
+	}
+	return true
+}
+
+// validateWindowSpecs checks that window functions are ordered by the time
+// dimension and partitioned by the other dimensions, matched by expressions or
+// aliases of the dimensions, as window measures are computed so.
+func validateWindowSpecs(specs []windowSpec, dimensions []Dimension) error {
+	for _, spec := range specs {
+		if len(spec.orderBy) != 1 || !matchDimension(spec.orderBy[0], dimensions, true) {
+			return fmt.Errorf("expect ORDER BY the time dimension in OVER clause of window function %s at (line:%d, col:%d)",
+				spec.function, spec.line, spec.column)
+		}
+
+		partitioned := make(map[string]bool)
+		for _, item := range spec.partitionBy {
+			if !matchDimension(item, dimensions, false) {
+				return fmt.Errorf("expect PARTITION BY dimensions other than the time dimension in OVER clause "+
+					"of window function %s, got %s at (line:%d, col:%d)", spec.function, item, spec.line, spec.column)
+			}
+			partitioned[item] = true
+		}
+		numDims := 0
+		for _, dim := range dimensions {
+			if dim.TimeBucketizer == "" {
+				numDims++
+			}
+		}
+		if len(partitioned) != numDims {
+			return fmt.Errorf("expect PARTITION BY all dimensions other than the time dimension in OVER clause "+
+				"of window function %s at (line:%d, col:%d)", spec.function, spec.line, spec.column)
+		}
+	}
+	return nil
+}
+
+// matchDimension returns whether the item matches the expression or alias of
+// any time dimension if isTime, or any other dimension if not.
+func matchDimension(item string, dimensions []Dimension, isTime bool) bool {
+	for _, dim := range dimensions {
+		if (dim.TimeBucketizer != "") == isTime && (item == dim.Expr || item == dim.Alias && dim.Alias != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// getWindowCall returns the window measure call of the function with the
+// window frame.
+func getWindowCall(function, args string, frame []antlr.Token) (string, error) {
+	if args == "" {
+		return "", fmt.Errorf("expect an argument for window function %s", function)
+	}
+
+	switch function {
+	case sumCallName:
+		if len(frame) == 0 || matchFrame(frame, unboundedFrames) != nil {
+			return fmt.Sprintf("%s(%s)", cumsumCallName, args), nil
+		}
+	case avgCallName:
+		if preceding := matchFrame(frame, precedingFrames); preceding != nil {
+			rows, err := strconv.Atoi(preceding.GetText())
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s(%s, %d)", movingAvgCallName, args, rows+1), nil
+		}
+	case rateCallName:
+		if len(frame) == 0 {
+			return fmt.Sprintf("%s(%s)", rateCallName, args), nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported window function %s", function)
+	}
+	return "", fmt.Errorf("unsupported window frame of window function %s", function)
+}
+
+// matchFrame returns the first token of the frame if the frame matches any of
+// the frames by token types, or nil if not matched. For frames with number of
+// preceding rows, the INTEGER_VALUE token is returned instead.
+func matchFrame(frame []antlr.Token, frames [][]int) antlr.Token {
+	for _, types := range frames {
+		if len(types) != len(frame) {
+			continue
+		}
+		var matched antlr.Token = frame[0]
+		for i, tokenType := range types {
+			if frame[i].GetTokenType() != tokenType {
+				matched = nil
+				break
+			}
+			if tokenType == antlrgen.SqlBaseLexerINTEGER_VALUE {
+				matched = frame[i]
+			}
+		}
+		if matched != nil {
+			return matched
+		}
+	}
+	return nil
+}
+
+// matchParenthesis returns the index of the parenthesis matching the one at
+// the index, searching forward if step is 1 or backward if step is -1. -1 is
+// returned if not matched.
+func matchParenthesis(tokens []antlr.Token, index, step int) int {
+	depth := 0
+	for i := index; i >= 0 && i < len(tokens); i += step {
+		switch tokens[i].GetTokenType() {
+		case antlrgen.SqlBaseLexerT__1:
+			depth += step
+		case antlrgen.SqlBaseLexerT__2:
+			depth -= step
+		}
+		if depth == 0 {
+			return i
+		}
+	}
+	return -1
+}
@@ -42,6 +42,7 @@ const (
 	dimEvalTiming                           = "dimEval"
 	measureEvalTiming                       = "measureEval"
 	hllEvalTiming                           = "hllEval"
+	percentileEvalTiming                    = "percentileEval"
 	sortEvalTiming                          = "sortEval"
 	reduceEvalTiming                        = "reduceEval"
 	expandEvalTiming                        = "expandEval"
@@ -120,6 +121,46 @@ type oopkQueryStats struct {
 	NumTransferCalls int `json:"tranCalls"`
 }
 
+// QueryStats stores the execution stats of a query, which are returned with
+// the query results when requested. Unlike the debug stats above, they are
+// always collected without synchronizing cuda streams, so times are wall
+// times in milliseconds and transfer time may overlap with kernel time of
+// the previous batch.
+type QueryStats struct {
+	// Device the query is executed on, -1 if it's not executed on any device,
+	// e.g. its results are served from the result cache.
+	Device int `json:"device"`
+	// Number of live and archive batches processed on device.
+	LiveBatches    int `json:"liveBatches"`
+	ArchiveBatches int `json:"archiveBatches"`
+	// Number of live batches skipped by min and max values of filtered columns,
+	// and number of empty archive batches skipped.
+	LiveBatchesSkipped    int `json:"liveBatchesSkipped"`
+	ArchiveBatchesSkipped int `json:"archiveBatchesSkipped"`
+	// Number of archive batches processed with no row left after prefilter
+	// slicing, which are counted in ArchiveBatches as well.
+	ArchiveBatchesPrefiltered int `json:"archiveBatchesPrefiltered"`
+	// Number of rows in all processed batches.
+	RowsScanned int `json:"rowsScanned"`
+	// Bytes of input data transferred from host to device.
+	BytesTransferred int `json:"bytesTransferred"`
+
+	CompileTime float64 `json:"compileTime"`
+	// Time of loading and transferring input data and transferring results back
+	// to host.
+	TransferTime float64 `json:"transferTime"`
+	// Time of executing kernels of all batches.
+	KernelTime      float64 `json:"kernelTime"`
+	PostprocessTime float64 `json:"postprocessTime"`
+
+	ResultCacheHit bool `json:"resultCacheHit"`
+}
+
+// millisecondsSince returns the time elapsed since start in milliseconds.
+func millisecondsSince(start time.Time) float64 {
+	return utils.Now().Sub(start).Seconds() * 1000
+}
+
 // NumRows implements the utils.TableDataSource for stats.
 func (stats oopkQueryStats) NumRows() int {
 	return len(stats.stageStats)
@@ -179,4 +179,11 @@ var _ = ginkgo.Describe("Stats", func() {
 			NumTransferCalls: 0,
 		}))
 	})
+
+	ginkgo.It("millisecondsSince should work", func() {
+		utils.SetClockImplementation(func() time.Time {
+			return time.Unix(10, 0)
+		})
+		Ω(millisecondsSince(time.Unix(9, 500000000))).Should(Equal(500.0))
+	})
 })
@@ -19,6 +19,7 @@ import (
 	"github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -94,7 +95,7 @@ func (qc *AQLQueryContext) buildTimeDimensionExpr(timeBucketizerString string, t
 		_, fromOffset := qc.fromTime.Time.Zone()
 		_, toOffset := qc.toTime.Time.Zone()
 		if fromOffset != toOffset {
-			offsetDiff := fromOffset - toOffset
+			offsetDiff := toOffset - fromOffset
 			switchTs, err := utils.CalculateDSTSwitchTs(qc.fromTime.Time.Unix(), qc.toTime.Time.Unix(), qc.fixedTimezone)
 			if err != nil {
 				return nil, err
@@ -173,6 +174,16 @@ func (qc *AQLQueryContext) buildTimeDimensionExpr(timeBucketizerString string, t
 	return bucketizerExpr, nil
 }
 
+// isRecurringTimeBucketizer returns whether the time bucketizer is recurring,
+// e.g. hour of day or day of month.
+func isRecurringTimeBucketizer(tbStr string) bool {
+	if _, ok := irregularRecurringBucketizer2Functor[tbStr]; ok {
+		return true
+	}
+	tb, err := getRegularRecurringTimeBucketizer(tbStr)
+	return err == nil && tb != nil
+}
+
 // getRegularRecurringTimeBucketizer converts a time bucketizer string to a regularRecurringTimeBucketizer struct.
 // Nil means it does not match.
 func getRegularRecurringTimeBucketizer(tbStr string) (*regularRecurringTimeBucketizer, error) {
@@ -297,3 +308,105 @@ func parseIrregularTimeBucketizer(timeBucketizerString string, timeColumn expr.E
 	}
 	return nil
 }
+
+// processFillGaps validates dimensions with FillGaps specified. Gaps can only be
+// filled for at most one dimension with regular time bucketizer of aggregation
+// query with time filter.
+func (qc *AQLQueryContext) processFillGaps() {
+	found := false
+	for _, dim := range qc.Query.Dimensions {
+		if dim.FillGaps == "" {
+			continue
+		}
+
+		if dim.FillGaps != fillGapsWithNull && dim.FillGaps != fillGapsWithZero {
+			qc.Error = utils.StackError(nil, "fillGaps should be either %s or %s, got: %s",
+				fillGapsWithNull, fillGapsWithZero, dim.FillGaps)
+			return
+		}
+
+		if found {
+			qc.Error = utils.StackError(nil, "fillGaps can only be specified for one dimension")
+			return
+		}
+		found = true
+
+		if qc.isNonAggregationQuery {
+			qc.Error = utils.StackError(nil, "fillGaps is not supported for non aggregation query")
+			return
+		}
+
+		if qc.timezoneTable.tableColumn != "" {
+			qc.Error = utils.StackError(nil, "fillGaps is not supported for timezone column")
+			return
+		}
+
+		if dim.TimeBucketizer == "" {
+			qc.Error = utils.StackError(nil, "fillGaps requires time bucketizer for dimension: %s", dim.name())
+			return
+		}
+
+		if qc.fromTime == nil || qc.toTime == nil {
+			qc.Error = utils.StackError(nil, "fillGaps requires time filter")
+			return
+		}
+
+		if _, err := qc.getTimeBuckets(dim.TimeBucketizer); err != nil {
+			qc.Error = utils.StackError(err, "failed to fill gaps for dimension: %s", dim.name())
+			return
+		}
+	}
+}
+
+// getTimeBuckets returns all bucket values of the regular time bucketizer
+// within the time filter in ascending order. Bucket values are computed in the
+// same way as the time dimension expression built by buildTimeDimensionExpr so
+// that they match with dimension values of the query result.
+func (qc *AQLQueryContext) getTimeBuckets(timeBucketizerString string) ([]int64, error) {
+	timeBucket, err := common.ParseRegularTimeBucketizer(timeBucketizerString)
+	if err != nil {
+		return nil, utils.StackError(err, "only regular time bucketizer is supported, got: %s", timeBucketizerString)
+	}
+	bucketInSeconds := int64(timeBucket.Size * common.BucketSizeToseconds[timeBucket.Unit])
+
+	from, to := qc.fromTime.Time.Unix(), qc.toTime.Time.Unix()
+	if (to-from)/bucketInSeconds >= maxFillGapsBuckets {
+		return nil, utils.StackError(nil, "too many time buckets to fill, max: %d", maxFillGapsBuckets)
+	}
+
+	// timestamps in [start, end) are shifted by the same offset before bucketizing.
+	type tsRange struct {
+		start, end, offset int64
+	}
+	ranges := []tsRange{{start: from, end: to}}
+	if qc.fixedTimezone.String() != time.UTC.String() {
+		_, fromOffset := qc.fromTime.Time.Zone()
+		_, toOffset := qc.toTime.Time.Zone()
+		ranges[0].offset = int64(fromOffset)
+		if fromOffset != toOffset && qc.dstswitch > 0 {
+			ranges = []tsRange{
+				{start: from, end: qc.dstswitch, offset: int64(fromOffset)},
+				{start: qc.dstswitch, end: to, offset: int64(toOffset)},
+			}
+		}
+	}
+
+	var buckets []int64
+	seen := make(map[int64]bool)
+	for _, r := range ranges {
+		if r.start >= r.end {
+			continue
+		}
+		first, last := r.start+r.offset, r.end-1+r.offset
+		for bucket := first - first%bucketInSeconds; bucket <= last; bucket += bucketInSeconds {
+			if !seen[bucket] {
+				seen[bucket] = true
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i] < buckets[j]
+	})
+	return buckets, nil
+}
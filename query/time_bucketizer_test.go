@@ -301,8 +301,8 @@ var _ = ginkgo.Describe("Time Bucketizer", func() {
 				"Op": "*",
 				"LHS": {
 				  "Val": 0,
-				  "Int": 3600,
-				  "Expr": "3600",
+				  "Int": -3600,
+				  "Expr": "-3600",
 				  "ExprType": "Signed"
 				},
 				"RHS": {
@@ -338,4 +338,88 @@ var _ = ginkgo.Describe("Time Bucketizer", func() {
 		  "ExprType": "Unknown"
 		}`))
 	})
+
+	ginkgo.It("applies offset of the timezone after DST switch timestamp", func() {
+		// switch from PDT to PST on 2017-11-05 and from PST to PDT on 2018-03-11.
+		for _, timeFilter := range []TimeFilter{
+			{From: "1509772380", To: "1509882360"},
+			{From: "1520668800", To: "1520841600"},
+		} {
+			qc = &AQLQueryContext{
+				Query: &AQLQuery{
+					Table:      "trips",
+					Measures:   []Measure{{Expr: "count()"}},
+					TimeFilter: timeFilter,
+					Dimensions: []Dimension{{Expr: "requested_at", TimeBucketizer: "hour"}},
+					Timezone:   "America/Los_Angeles",
+				},
+			}
+			qc.processTimezone()
+			Ω(qc.Error).Should(BeNil())
+			qc.parseExprs()
+			Ω(qc.Error).Should(BeNil())
+
+			_, fromOffset := qc.fromTime.Time.Zone()
+			_, toOffset := qc.toTime.Time.Zone()
+			Ω(fromOffset).ShouldNot(Equal(toOffset))
+			offsets := qc.Query.Dimensions[0].expr.(*expr.BinaryExpr).LHS.(*expr.BinaryExpr).RHS.(*expr.BinaryExpr)
+			offset := offsets.LHS.(*expr.NumberLiteral).Int
+			offsetDiff := offsets.RHS.(*expr.BinaryExpr).LHS.(*expr.NumberLiteral).Int
+			Ω(offset).Should(Equal(fromOffset))
+			Ω(offset + offsetDiff).Should(Equal(toOffset))
+		}
+	})
+
+	ginkgo.It("fills gaps across DST switch timestamp", func() {
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "trips",
+				Measures: []Measure{
+					{Expr: "count()"},
+				},
+				TimeFilter: TimeFilter{
+					From: "1509772380",
+					To:   "1509882360",
+				},
+				Dimensions: []Dimension{{Expr: "requested_at", TimeBucketizer: "hour", FillGaps: "zero"}},
+				Timezone:   "America/Los_Angeles",
+			},
+		}
+		qc.processTimezone()
+		Ω(qc.Error).Should(BeNil())
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.processFillGaps()
+		Ω(qc.Error).Should(BeNil())
+
+		buckets, err := qc.getTimeBuckets("hour")
+		Ω(err).Should(BeNil())
+		// 1am to 2am appears twice in local time but is bucketized only once.
+		Ω(buckets).Should(HaveLen(30))
+		Ω(buckets[0]).Should(Equal(int64(1509746400)))
+		for i := 1; i < len(buckets); i++ {
+			Ω(buckets[i] - buckets[i-1]).Should(Equal(int64(3600)))
+		}
+
+		qc.Query.Dimensions[0].FillGaps = "one"
+		qc.processFillGaps()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.Dimensions[0].FillGaps = "null"
+		qc.Query.Dimensions[0].TimeBucketizer = "day of week"
+		qc.processFillGaps()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.Query.Dimensions[0].TimeBucketizer = "1m"
+		qc.toTime = &alignedTime{qc.fromTime.Time.Add(30 * 24 * time.Hour), "d"}
+		qc.processFillGaps()
+		Ω(qc.Error).ShouldNot(BeNil())
+
+		qc.Error = nil
+		qc.fromTime = nil
+		qc.processFillGaps()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
 })
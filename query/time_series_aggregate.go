@@ -62,6 +62,11 @@ var UnaryExprTypeToCFunctorType = map[expr.Token]C.enum_UnaryFunctorType{
 	expr.GET_MONTH_OF_YEAR:   C.GetMonthOfYear,
 	expr.GET_QUARTER_OF_YEAR: C.GetQuarterOfYear,
 	expr.GET_HLL_VALUE:       C.GetHLLValue,
+	expr.ABS:                 C.Abs,
+	expr.CEIL:                C.Ceil,
+	expr.SQRT:                C.Sqrt,
+	expr.LN:                  C.Ln,
+	expr.LOG10:               C.Log10,
 }
 
 // BinaryExprTypeToCFunctorType maps from binary operator to C BinaryFunctorType
@@ -84,6 +89,13 @@ var BinaryExprTypeToCFunctorType = map[expr.Token]C.enum_BinaryFunctorType{
 	expr.BITWISE_XOR: C.BitwiseXor,
 	expr.FLOOR:       C.Floor,
 	expr.CONVERT_TZ:  C.Plus,
+	expr.IF_TRUE:     C.IfTrue,
+	expr.IF_NOT_TRUE: C.IfNotTrue,
+	expr.COALESCE:    C.Coalesce,
+	expr.POW:         C.Pow,
+	expr.ROUND:       C.Round,
+	expr.GREATEST:    C.Greatest,
+	expr.LEAST:       C.Least,
 	// TODO: expr.BITWISE_LEFT_SHIFT ?
 	// TODO: expr.BITWISE_RIGHT_SHIFT ?
 }
@@ -364,13 +376,15 @@ func (bc *oopkBatchContext) filterAction(functorType uint32, stream unsafe.Point
 	}
 }
 
-func (bc *oopkBatchContext) makeWriteToMeasureVectorAction(aggFunc C.enum_AggregateFunction, outputWidthInByte int) rootAction {
+// makeWriteToMeasureVectorAction writes the measure values of current batch to the measure vector
+// starting at measureOffset, which is the start offset of the measure in measureVectorD.
+func (bc *oopkBatchContext) makeWriteToMeasureVectorAction(aggFunc C.enum_AggregateFunction, measureOffset, outputWidthInByte int) rootAction {
 	return func(functorType uint32, stream unsafe.Pointer, device int, inputs []C.InputVector, exp expr.Expr) {
 		// If current batch size is already 0, short circuit to avoid issuing a noop cuda call.
 		if bc.size <= 0 {
 			return
 		}
-		measureVector := utils.MemAccess(bc.measureVectorD[0].getPointer(), bc.resultSize*outputWidthInByte)
+		measureVector := utils.MemAccess(bc.measureVectorD[0].getPointer(), measureOffset+bc.resultSize*outputWidthInByte)
 		// write measure out to measureVectorD[1] for hll query
 		if aggFunc == C.AGGR_HLL {
 			measureVector = bc.measureVectorD[1].getPointer()
@@ -658,17 +672,37 @@ func (bc *oopkBatchContext) sortByKey(numDims common.DimCountsPerDimWidth, strea
 	})
 }
 
-func (bc *oopkBatchContext) reduceByKey(numDims common.DimCountsPerDimWidth, valueWidth int, aggFunc C.enum_AggregateFunction, stream unsafe.Pointer,
+// reduceByKey reduces all measures over the sorted keys. Reducing each measure
+// over the same sorted keys generates the same output keys. Percentile measures
+// are not reduced as their values are added into sketches before reduce, keys
+// are reduced alone if there is no other measure.
+func (bc *oopkBatchContext) reduceByKey(numDims common.DimCountsPerDimWidth, measures []OOPKMeasure, stream unsafe.Pointer,
 	device int) {
 	inputKeys := makeDimensionColumnVector(
 		bc.dimensionVectorD[0].getPointer(), bc.hashVectorD[0].getPointer(), bc.dimIndexVectorD[0].getPointer(), numDims, bc.resultCapacity)
 	outputKeys := makeDimensionColumnVector(
 		bc.dimensionVectorD[1].getPointer(), bc.hashVectorD[1].getPointer(), bc.dimIndexVectorD[1].getPointer(), numDims, bc.resultCapacity)
-	inputValues, outputValues := (*C.uint8_t)(bc.measureVectorD[0].getPointer()), (*C.uint8_t)(bc.measureVectorD[1].getPointer())
-	bc.resultSize = int(doCGoCall(func() C.CGoCallResHandle {
-		return C.Reduce(inputKeys, inputValues, outputKeys, outputValues, (C.int)(valueWidth), (C.int)(bc.resultSize+bc.size), aggFunc,
-			stream, C.int(device))
-	}))
+	inputLength := bc.resultSize + bc.size
+	keysReduced := false
+	for i, measureOffset := range getMeasureStartOffsets(measures, bc.resultCapacity) {
+		if measures[i].IsPercentile {
+			continue
+		}
+		inputValues := (*C.uint8_t)(utils.MemAccess(bc.measureVectorD[0].getPointer(), measureOffset))
+		outputValues := (*C.uint8_t)(utils.MemAccess(bc.measureVectorD[1].getPointer(), measureOffset))
+		valueWidth, aggFunc := measures[i].MeasureBytes, measures[i].AggregateType
+		bc.resultSize = int(doCGoCall(func() C.CGoCallResHandle {
+			return C.Reduce(inputKeys, inputValues, outputKeys, outputValues, (C.int)(valueWidth), (C.int)(inputLength), aggFunc,
+				stream, C.int(device))
+		}))
+		keysReduced = true
+	}
+
+	if !keysReduced {
+		bc.resultSize = int(doCGoCall(func() C.CGoCallResHandle {
+			return C.Reduce(inputKeys, nil, outputKeys, nil, 0, (C.int)(inputLength), 0, stream, C.int(device))
+		}))
+	}
 }
 
 func (bc *oopkBatchContext) expand(numDims common.DimCountsPerDimWidth, lenWanted int, stream unsafe.Pointer, device int) {
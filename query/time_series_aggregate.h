@@ -102,6 +102,11 @@ enum UnaryFunctorType {
   GetMonthOfYear,
   GetQuarterOfYear,
   GetHLLValue,
+  Abs,
+  Ceil,
+  Sqrt,
+  Ln,
+  Log10,
 };
 
 // All supported binary functor types.
@@ -123,6 +128,13 @@ enum BinaryFunctorType {
   BitwiseOr,
   BitwiseXor,
   Floor,
+  IfTrue,
+  IfNotTrue,
+  Coalesce,
+  Pow,
+  Round,
+  Greatest,
+  Least,
 };
 
 // RecordID
@@ -276,8 +288,8 @@ typedef struct {
 } DimensionOutputVector;
 
 // MeasureOutputVector is used as output vector of measure transformation.
-// Right now we have one measure per query but we may have multiple measures
-// in future.
+// For queries with multiple measures, each measure is transformed into its
+// own output vector.
 typedef struct {
   // Where to write the values
   uint32_t *Values;
@@ -513,6 +525,8 @@ CGoCallResHandle Sort(DimensionColumnVector keys,
 // write the unique keys to outputKeys and aggregation results to outputValues.
 // It returns number of unique keys as result also. Notice outputKeys and
 // outputValues should be preallocated by caller.
+// If inputValues is null, only the keys are reduced and outputValues,
+// valueBytes and aggFunc are ignored.
 CGoCallResHandle Reduce(DimensionColumnVector inputKeys,
                         uint8_t *inputValues,
                         DimensionColumnVector outputKeys,
@@ -0,0 +1,199 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+	"sort"
+	"strings"
+)
+
+// measureWindow is a window function applied to the values of a measure
+// ordered by the time dimension within each series of rows with the same
+// values of other dimensions.
+type measureWindow struct {
+	function string
+	// number of buckets to average over for moving_avg.
+	size int
+}
+
+// isWindowCall returns whether the expression is a window function call.
+func isWindowCall(e expr.Expr) bool {
+	call, ok := e.(*expr.Call)
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(call.Name) {
+	case cumsumCallName, movingAvgCallName, rateCallName:
+		return true
+	}
+	return false
+}
+
+// parseMeasureWindow parses the window function call of the measure and
+// returns the window and the expression it applies to.
+func parseMeasureWindow(call *expr.Call, measure *Measure) (*measureWindow, expr.Expr, error) {
+	window := &measureWindow{function: strings.ToLower(call.Name)}
+	numArgs := 1
+	if window.function == movingAvgCallName {
+		numArgs = 2
+	}
+	if len(call.Args) != numArgs {
+		return nil, nil, utils.StackError(nil, "%s requires %d argument(s), got %d in measure %s",
+			window.function, numArgs, len(call.Args), measure.Expr)
+	}
+
+	if window.function == movingAvgCallName {
+		size, ok := call.Args[1].(*expr.NumberLiteral)
+		if !ok || size.Val != float64(size.Int) || size.Int <= 0 {
+			return nil, nil, utils.StackError(nil,
+				"the number of buckets of %s should be a positive integer in measure %s", movingAvgCallName, measure.Expr)
+		}
+		window.size = size.Int
+	}
+	return window, call.Args[0], nil
+}
+
+// processMeasureWindows validates window measures, which can only be used in
+// aggregation queries with exactly one dimension bucketized by a non recurring
+// time bucketizer.
+func (qc *AQLQueryContext) processMeasureWindows() {
+	if !qc.hasMeasureWindows() {
+		return
+	}
+
+	if qc.isNonAggregationQuery {
+		qc.Error = utils.StackError(nil, "window measures are not supported for non aggregation query")
+		return
+	}
+
+	var numTimeDims int
+	for _, dim := range qc.Query.Dimensions {
+		if dim.TimeBucketizer == "" {
+			continue
+		}
+		numTimeDims++
+		if isRecurringTimeBucketizer(dim.TimeBucketizer) {
+			qc.Error = utils.StackError(nil,
+				"window measures are not supported for recurring time bucketizer: %s", dim.TimeBucketizer)
+			return
+		}
+	}
+
+	if numTimeDims != 1 {
+		qc.Error = utils.StackError(nil, "window measures require exactly one time dimension, got %d", numTimeDims)
+	}
+}
+
+// hasMeasureWindows returns whether any measure of the query is a window measure.
+func (qc *AQLQueryContext) hasMeasureWindows() bool {
+	return qc.Query != nil && qc.Query.measureWindows != nil
+}
+
+// getMeasureWindowDimIndex returns the index of the time dimension window
+// measures are ordered by.
+func (qc *AQLQueryContext) getMeasureWindowDimIndex() int {
+	for dimIndex, dim := range qc.Query.Dimensions {
+		if dim.TimeBucketizer != "" {
+			return dimIndex
+		}
+	}
+	return -1
+}
+
+// applyMeasureWindows replaces values of window measures of the rows with the
+// results of the window functions. Rows are partitioned by values of dimensions
+// other than the time dimension and ordered by the time dimension, the order of
+// rows is not changed. moving_avg averages over the last N rows of the series,
+// which are N buckets only if there is no gap, e.g. when gaps are filled.
+func (qc *AQLQueryContext) applyMeasureWindows(rows []aggregatedRow) {
+	dimIndex := qc.getMeasureWindowDimIndex()
+	var seriesKeys []string
+	series := make(map[string][]int)
+	for i, row := range rows {
+		key := getDimValuesKey(row.dimValues, dimIndex)
+		if _, ok := series[key]; !ok {
+			seriesKeys = append(seriesKeys, key)
+		}
+		series[key] = append(series[key], i)
+	}
+
+	for _, key := range seriesKeys {
+		indexes := series[key]
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return compareDimensionValues(rows[indexes[i]].dimValues[dimIndex], rows[indexes[j]].dimValues[dimIndex]) < 0
+		})
+
+		for measureIndex, window := range qc.Query.measureWindows {
+			if window == nil {
+				continue
+			}
+			values := make([]*float64, len(indexes))
+			for i, rowIndex := range indexes {
+				values[i] = rows[rowIndex].measureValues[measureIndex]
+			}
+			for i, value := range window.apply(values) {
+				rows[indexes[i]].measureValues[measureIndex] = value
+			}
+		}
+	}
+}
+
+// apply returns the results of the window function over values ordered by
+// time. Null values are skipped by cumsum and moving_avg. rate is the change
+// relative to the previous value, null if either value is null or the previous
+// value is zero.
+func (w *measureWindow) apply(values []*float64) []*float64 {
+	results := make([]*float64, len(values))
+	switch w.function {
+	case cumsumCallName:
+		var sum *float64
+		for i, value := range values {
+			if value != nil {
+				v := *value
+				if sum != nil {
+					v += *sum
+				}
+				sum = &v
+			}
+			results[i] = sum
+		}
+	case movingAvgCallName:
+		for i := range values {
+			var sum float64
+			var count int
+			for j := i; j >= 0 && j > i-w.size; j-- {
+				if values[j] != nil {
+					sum += *values[j]
+					count++
+				}
+			}
+			if count > 0 {
+				v := sum / float64(count)
+				results[i] = &v
+			}
+		}
+	case rateCallName:
+		for i := 1; i < len(values); i++ {
+			current, previous := values[i], values[i-1]
+			if current != nil && previous != nil && *previous != 0 {
+				v := (*current - *previous) / *previous
+				results[i] = &v
+			}
+		}
+	}
+	return results
+}
@@ -86,6 +86,8 @@ const (
 	QueryLiveBytesTransferred
 	QueryArchiveBytesTransferred
 	QueryRowsReturned
+	QueryResultCacheHit
+	QueryRejected
 	RecordsOutOfRetention
 	SnapshotTimingTotal
 	SnapshotTimingLoad
@@ -195,6 +197,8 @@ const (
 	scopeNameQueryBatchProcessed             = "batch_processed"
 	scopeNameQueryBytesTransferred           = "bytes_transferred"
 	scopeNameQueryRowsReturned               = "rows_returned"
+	scopeNameQueryResultCacheHit             = "result_cache_hit"
+	scopeNameQueryRejected                   = "query_rejected"
 	scopeNameRecordsOutOfRetention           = "records_out_of_retention"
 	scopeNameTimezoneLookupTableCreationTime = "timezone_lookup_table_creation_time"
 	scopeNameRedoLogFileCorrupt              = "redo_log_file_corrupt"
@@ -704,6 +708,20 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentQuery,
 		},
 	},
+	QueryResultCacheHit: {
+		name:       scopeNameQueryResultCacheHit,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QueryRejected: {
+		name:       scopeNameQueryRejected,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
 	RecordsOutOfRetention: {
 		name:       scopeNameRecordsOutOfRetention,
 		metricType: Counter,